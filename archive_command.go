@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db/mongo/backup"
+	"github.com/script-development/RT-CV/helpers/crypto"
+)
+
+const archiveUsage = `Usage:
+  rt-cv archive export [flags] <backup file> <archive file>
+  rt-cv archive import <archive file>
+
+export decrypts a backup file and converts it into a tar archive with a MongoDB extended JSON file per collection.
+This works offline, only the backup key is needed. Use - as archive file to write the archive to stdout.
+
+import replaces the collections in the database with the collections in the archive.
+The database is configured using the same env variables as the server.
+When using the file database (FILE_DB_PATH) the server must be stopped first, the command refuses to run while the server uses the database.
+`
+
+const archiveExitCodes = `
+Exit codes:
+  0  success
+  1  the export or import failed
+  2  invalid arguments
+  3  the backup file cannot be read with the backup keys
+`
+
+// archiveCommand runs the archive subcommand and returns the exit code
+func archiveCommand(args []string) int {
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	key := flags.String("key", "", "the key used to decrypt the backup, defaults to $MONGODB_BACKUP_KEY, the keys in $MONGODB_BACKUP_OLD_KEYS are also tried")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), archiveUsage)
+		fmt.Fprint(flags.Output(), "\nFlags:\n")
+		flags.PrintDefaults()
+		fmt.Fprint(flags.Output(), archiveExitCodes)
+	}
+
+	if len(args) == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	action := args[0]
+	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+
+	switch {
+	case action == "export" && flags.NArg() == 2:
+		loadEnv()
+		options := backup.StartScheduleOptionsFromEnv()
+		if *key != "" {
+			options.BackupEncryptionKey = *key
+		}
+		keyring, err := options.Keyring()
+		if err != nil {
+			log.WithError(err).Error("Invalid backup key")
+			return exitUsage
+		}
+		return exportArchive(flags.Arg(0), flags.Arg(1), keyring)
+	case action == "import" && flags.NArg() == 1:
+		loadEnv()
+		return importArchive(flags.Arg(0))
+	default:
+		flags.Usage()
+		return exitUsage
+	}
+}
+
+func exportArchive(backupPath, archivePath string, keyring *crypto.Keyring) int {
+	backupFile, err := os.Open(backupPath)
+	if err != nil {
+		log.WithError(err).Error("Unable to open the backup file")
+		return exitFailed
+	}
+	defer backupFile.Close()
+
+	if archivePath == "-" {
+		_, err = backup.ExportArchive(backupFile, keyring, os.Stdout)
+		return archiveExitCode(err)
+	}
+
+	// Write to a temp file first so a failed export does not leave a half written archive behind
+	archiveFile, err := os.CreateTemp(filepath.Dir(archivePath), "."+filepath.Base(archivePath)+".*.tmp")
+	if err != nil {
+		log.WithError(err).Error("Unable to create the archive file")
+		return exitFailed
+	}
+	defer os.Remove(archiveFile.Name())
+
+	counts, err := backup.ExportArchive(backupFile, keyring, archiveFile)
+	if err == nil {
+		err = archiveFile.Close()
+	} else {
+		archiveFile.Close()
+	}
+	if err == nil {
+		err = os.Rename(archiveFile.Name(), archivePath)
+	}
+	if err != nil {
+		return archiveExitCode(err)
+	}
+
+	printCollectionCounts(os.Stdout, counts)
+	return exitOK
+}
+
+func importArchive(archivePath string) int {
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		log.WithError(err).Error("Unable to open the archive file")
+		return exitFailed
+	}
+	defer archiveFile.Close()
+
+	dbConn, useTestingDB := connectToDB()
+	if useTestingDB {
+		log.Error("Importing an archive is not supported in the testing database")
+		return exitUsage
+	}
+
+	counts, err := backup.ImportArchive(dbConn, archiveFile)
+	if err != nil {
+		return archiveExitCode(err)
+	}
+
+	printCollectionCounts(os.Stdout, counts)
+	return exitOK
+}
+
+// archiveExitCode logs the error and returns the matching exit code
+func archiveExitCode(err error) int {
+	if err != nil {
+		log.WithError(err).Error("Archive failed")
+	}
+	return exitCodeOf(err)
+}
+
+func printCollectionCounts(out io.Writer, counts map[string]uint64) {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tDOCUMENTS")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%d\n", name, counts[name])
+	}
+	w.Flush()
+}
@@ -14,36 +14,70 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var errScopeOutsideKeyScope = errors.New("the scope of the key must be within the scope of your own key")
+
 var routeGetScraperKeys = routeBuilder.R{
 	Description: "Get all scraper keys from the database",
 	Res:         []models.APIKey{},
 	Fn: func(c *fiber.Ctx) error {
-		keys, err := models.GetScraperAPIKeys(ctx.Get(c).DBConn)
+		conn := ctx.Get(c).DBConn
+		keys, err := models.GetScraperAPIKeys(conn)
 		if err != nil {
 			return err
 		}
+		if db.TenantOf(conn) != nil {
+			// Scraper keys are shared between tenants, tenants can see them but not the secret key
+			for idx := range keys {
+				keys[idx].Key = ""
+			}
+		}
 		return c.JSON(keys)
 	},
 }
 
 var routeGetKeys = routeBuilder.R{
-	Description: "get all api keys from the database",
-	Res:         []models.APIKey{},
+	Description: "get all api keys from the database.\n\n" + listPaginationDescription +
+		" Keys can be sorted on id and name.",
+	Res: []models.APIKey{},
 	Fn: func(c *fiber.Ctx) error {
-		keys, err := models.GetAPIKeys(ctx.Get(c).DBConn)
+		page, err := parseListPage(c, keySortFields)
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+
+		dbConn := ctx.Get(c).DBConn
+		total, err := models.CountAPIKeys(dbConn)
+		if err != nil {
+			return err
+		}
+		keys, err := models.GetAPIKeys(dbConn, page.findOptions(keySortFields))
 		if err != nil {
 			return err
 		}
+		page.setHeaders(c, len(keys), total)
 		return c.JSON(keys)
 	},
 }
 
+// keySortFields maps the fields api keys can be sorted on to their database fields
+var keySortFields = map[string]string{
+	"id":   "_id",
+	"name": "name",
+}
+
 type apiKeyModifyCreateData struct {
-	Enabled *bool              `json:"enabled"`
-	Name    *string            `json:"name"`
-	Domains []string           `json:"domains"`
-	Key     *string            `json:"key"`
-	Roles   *models.APIKeyRole `json:"roles"`
+	Enabled *bool               `json:"enabled"`
+	Name    *string             `json:"name"`
+	Domains []string            `json:"domains"`
+	Key     *string             `json:"key"`
+	Roles   *models.APIKeyRole  `json:"roles"`
+	Scope   *models.APIKeyScope `json:"scope" description:"limits the resources the key can access, set to null or leave out to not limit the key, to remove the scope of an existing key set removeScope to true"`
+
+	// RemoveScope is only used when updating a key
+	RemoveScope bool `json:"removeScope"`
+
+	TenantID     *primitive.ObjectID `json:"tenantId" description:"the tenant the key belongs to, can only be set by keys without a tenant, keys created by a key with a tenant always belong to the same tenant"`
+	RemoveTenant bool                `json:"removeTenant" description:"only used when updating a key, makes the key shared between all tenants"`
 }
 
 var routeCreateKey = routeBuilder.R{
@@ -100,7 +134,30 @@ var routeCreateKey = routeBuilder.R{
 			newAPIKey.Roles = *body.Roles
 		}
 
-		err = ctx.Get(c).DBConn.Insert(newAPIKey)
+		err = body.Scope.Validate()
+		if err != nil {
+			return err
+		}
+		if !ctx.Get(c).Key.Scope.Contains(body.Scope) {
+			return ErrorRes(c, fiber.StatusForbidden, errScopeOutsideKeyScope)
+		}
+		newAPIKey.Scope = body.Scope
+
+		conn := ctx.Get(c).DBConn
+		err = applyTenantChange(conn, &newAPIKey.T, body.TenantID, false)
+		if err != nil {
+			return err
+		}
+		if tenantID := db.TenantOf(conn); tenantID != nil {
+			// Keys created by a tenant key always belong to the same tenant
+			newAPIKey.TenantID = tenantID
+		}
+		err = newAPIKey.ValidateTenant()
+		if err != nil {
+			return err
+		}
+
+		err = conn.Insert(newAPIKey)
 		if err != nil {
 			return err
 		}
@@ -192,12 +249,39 @@ var routeUpdateKey = routeBuilder.R{
 			apiKey.Roles = *body.Roles
 		}
 
+		scopeChanged := false
+		if body.RemoveScope {
+			scopeChanged = apiKey.Scope != nil
+			apiKey.Scope = nil
+		} else if body.Scope != nil {
+			err = body.Scope.Validate()
+			if err != nil {
+				return err
+			}
+			scopeChanged = true
+			apiKey.Scope = body.Scope
+		}
+		// A key with a scope can only modify keys that stay within its own scope, otherwise it could take over keys with more access
+		if !ctx.Key.Scope.Contains(apiKey.Scope) {
+			return ErrorRes(c, fiber.StatusForbidden, errScopeOutsideKeyScope)
+		}
+
+		previousTenant := apiKey.TenantID
+		err = applyTenantChange(ctx.DBConn, &apiKey.T, body.TenantID, body.RemoveTenant)
+		if err != nil {
+			return err
+		}
+		err = apiKey.ValidateTenant()
+		if err != nil {
+			return err
+		}
+
 		err = ctx.DBConn.UpdateByID(apiKey)
 		if err != nil {
 			return err
 		}
 
-		if keyChanged {
+		if keyChanged || scopeChanged || body.Roles != nil || !db.SameTenant(previousTenant, apiKey.TenantID) {
 			ctx.Auth.RemoveKeyCache(apiKey.ID.Hex())
 		}
 
@@ -4,18 +4,31 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/apex/log"
 	"github.com/gofiber/fiber/v2"
 	"github.com/script-development/RT-CV/controller/ctx"
+	"github.com/script-development/RT-CV/db"
 	"github.com/script-development/RT-CV/helpers/auth"
 	"github.com/script-development/RT-CV/helpers/routeBuilder"
 	"github.com/script-development/RT-CV/models"
 )
 
-var errAuthMissingRoles = errors.New("you do not have auth roles required to access this route")
+var (
+	errAuthMissingRoles = errors.New("you do not have auth roles required to access this route")
+	errAuthReadOnly     = errors.New("your key has a read only scope and cannot modify server state")
+	errOutsideKeyScope  = errors.New("this resource is outside of the scope of your key")
+)
+
+// requiresAuth checks if the request is authenticated with a key that has one of the required roles
+// requiredAccess tells what the route does with the resources it touches, see models.APIKeyAccess
+func requiresAuth(requiredRoles models.APIKeyRole, requiredAccess ...models.APIKeyAccess) routeBuilder.M {
+	var access models.APIKeyAccess
+	for _, entry := range requiredAccess {
+		access |= entry
+	}
 
-func requiresAuth(requiredRoles models.APIKeyRole) routeBuilder.M {
 	tags := []routeBuilder.Tag{
 		{
 			Name:        "Auth all route",
@@ -36,16 +49,31 @@ func requiresAuth(requiredRoles models.APIKeyRole) routeBuilder.M {
 		})
 	}
 
+	for _, entry := range access.ConvertToAPIAccesses() {
+		accessStr := strconv.FormatUint(uint64(entry.Access), 10)
+		tags = append(tags, routeBuilder.Tag{
+			Name: "Auth Access " + entry.Slug,
+			Description: fmt.Sprintf(
+				"route access id %s, description: %s",
+				accessStr,
+				entry.Description,
+			),
+		})
+	}
+
 	return routeBuilder.M{
 		Tags: tags,
 		Fn: func(c *fiber.Ctx) error {
 			ctx := ctx.Get(c)
 			// Check if the auth header is already checked earlier in the request
-			// If true we only have to check if the roles match
+			// If true we only have to check if the roles and scope match
 			if ctx.Key != nil {
 				if requiredRoles != 0 && !ctx.Key.Roles.ContainsSome(requiredRoles) {
 					return ErrorRes(c, 401, errAuthMissingRoles)
 				}
+				if access.Contains(models.APIKeyAccessWrite) && !ctx.Key.Scope.CanWrite() {
+					return ErrorRes(c, fiber.StatusForbidden, errAuthReadOnly)
+				}
 				return c.Next()
 			}
 
@@ -58,7 +86,14 @@ func requiresAuth(requiredRoles models.APIKeyRole) routeBuilder.M {
 				return ErrorRes(c, fiber.StatusBadRequest, auth.ErrNoAuthHeader)
 			}
 
-			key, err := ctx.Auth.Valid(authorizationValue)
+			var key *models.APIKey
+			var err error
+			if strings.HasPrefix(authorizationValue, "Bearer ") {
+				// Dashboard user session
+				key, ctx.Session, err = ctx.Auth.ValidSession(strings.TrimPrefix(authorizationValue, "Bearer "))
+			} else {
+				key, err = ctx.Auth.Valid(authorizationValue)
+			}
 			if err != nil {
 				return ErrorRes(c, fiber.StatusUnauthorized, err)
 			}
@@ -67,13 +102,28 @@ func requiresAuth(requiredRoles models.APIKeyRole) routeBuilder.M {
 			if requiredRoles != 0 && !key.Roles.ContainsSome(requiredRoles) {
 				return ErrorRes(c, fiber.StatusForbidden, errAuthMissingRoles)
 			}
+			if access.Contains(models.APIKeyAccessWrite) && !key.Scope.CanWrite() {
+				return ErrorRes(c, fiber.StatusForbidden, errAuthReadOnly)
+			}
 
-			*ctx.Logger = *ctx.Logger.WithFields(log.Fields{
-				"api_key_id": key.ID.Hex(),
-				"domains":    key.Domains,
-			})
+			if ctx.Session != nil {
+				*ctx.Logger = *ctx.Logger.WithFields(log.Fields{
+					"user_id":    key.ID.Hex(),
+					"session_id": ctx.Session.ID.Hex(),
+				})
+			} else {
+				*ctx.Logger = *ctx.Logger.WithFields(log.Fields{
+					"api_key_id": key.ID.Hex(),
+					"domains":    key.Domains,
+				})
+			}
 
 			ctx.Key = key
+			if key.TenantID != nil {
+				// All queries made on behalf of this key are limited to the tenant of the key
+				ctx.DBConn = db.WithTenant(ctx.DBConn, *key.TenantID)
+				*ctx.Logger = *ctx.Logger.WithField("tenant_id", key.TenantID.Hex())
+			}
 
 			return c.Next()
 		},
@@ -5,8 +5,12 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/script-development/RT-CV/db"
 	"github.com/script-development/RT-CV/helpers/routeBuilder"
+	"github.com/script-development/RT-CV/mock"
+	"github.com/script-development/RT-CV/models"
 	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestRouteGetKeyInfo(t *testing.T) {
@@ -25,3 +29,151 @@ func TestRouteGetKeyInfo(t *testing.T) {
 		NotEqual(t, strings.ToLower(key), "key", "the key property should re-appear in the result data")
 	}
 }
+
+func TestAuthKeyScope(t *testing.T) {
+	app := newTestingRouter(t)
+
+	scopedKey := &models.APIKey{
+		M:       db.NewM(),
+		Name:    "Scoped key",
+		Enabled: true,
+		Domains: []string{"werk.nl"},
+		Key:     "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee",
+		Roles:   models.APIKeyRoleInformationObtainer | models.APIKeyRoleController,
+		Scope: &models.APIKeyScope{
+			ReadOnly:   true,
+			ProfileIDs: []primitive.ObjectID{mock.Profile1.ID},
+		},
+	}
+	err := app.db.Insert(scopedKey)
+	NoError(t, err)
+	app.ChangeAuthKey(scopedKey)
+
+	// Only the profiles within the scope should be listed
+	_, body := app.MakeRequest(routeBuilder.Get, "/api/v1/profiles", TestReqOpts{})
+	profiles := []models.Profile{}
+	err = json.Unmarshal(body, &profiles)
+	NoError(t, err)
+	Len(t, profiles, 1)
+	Equal(t, mock.Profile1.ID, profiles[0].ID)
+
+	res, _ := app.MakeRequest(routeBuilder.Get, "/api/v1/profiles/"+mock.Profile1.ID.Hex(), TestReqOpts{})
+	Equal(t, 200, res.StatusCode)
+	res, _ = app.MakeRequest(routeBuilder.Get, "/api/v1/profiles/"+mock.Profile2.ID.Hex(), TestReqOpts{})
+	Equal(t, 403, res.StatusCode)
+
+	// A read only key should not be able to modify state
+	res, body = app.MakeRequest(routeBuilder.Delete, "/api/v1/profiles/"+mock.Profile1.ID.Hex(), TestReqOpts{})
+	Equal(t, 403, res.StatusCode)
+	Equal(t, `{"error":"`+errAuthReadOnly.Error()+`"}`, string(body))
+
+	// Only admins can access the keys
+	res, _ = app.MakeRequest(routeBuilder.Get, "/api/v1/keys", TestReqOpts{})
+	Equal(t, 403, res.StatusCode)
+
+	app.ChangeAuthKey(mock.DashboardKey)
+	res, _ = app.MakeRequest(routeBuilder.Get, "/api/v1/keys", TestReqOpts{})
+	Equal(t, 200, res.StatusCode)
+}
+
+func TestScopedAdminKeyCannotWidenScopes(t *testing.T) {
+	app := newTestingRouter(t)
+
+	scope := &models.APIKeyScope{ProfileIDs: []primitive.ObjectID{mock.Profile1.ID}}
+	scopedAdmin := &models.APIKey{
+		M:       db.NewM(),
+		Name:    "Scoped admin key",
+		Enabled: true,
+		Domains: []string{"werk.nl"},
+		Key:     "ffffffffffffffffffffffffffffffff",
+		Roles:   models.APIKeyRoleAdmin,
+		Scope:   scope,
+	}
+	err := app.db.Insert(scopedAdmin)
+	NoError(t, err)
+	app.ChangeAuthKey(scopedAdmin)
+
+	createBody := func(scope *models.APIKeyScope) []byte {
+		body, err := json.Marshal(IMap{
+			"name":    "new key",
+			"domains": []string{"werk.nl"},
+			"key":     "gggggggggggggggggggggggggggggggg",
+			"roles":   models.APIKeyRoleInformationObtainer,
+			"scope":   scope,
+		})
+		NoError(t, err)
+		return body
+	}
+
+	res, body := app.MakeRequest(routeBuilder.Post, "/api/v1/keys", TestReqOpts{Body: createBody(nil)})
+	Equal(t, 403, res.StatusCode, string(body))
+	res, body = app.MakeRequest(routeBuilder.Post, "/api/v1/keys", TestReqOpts{Body: createBody(&models.APIKeyScope{
+		ProfileIDs: []primitive.ObjectID{mock.Profile2.ID},
+	})})
+	Equal(t, 403, res.StatusCode, string(body))
+	res, body = app.MakeRequest(routeBuilder.Post, "/api/v1/keys", TestReqOpts{Body: createBody(scope)})
+	Equal(t, 200, res.StatusCode, string(body))
+
+	// Keys outside of the scope, like the unscoped keys, cannot be modified
+	res, body = app.MakeRequest(routeBuilder.Put, "/api/v1/keys/"+mock.Key3.ID.Hex(), TestReqOpts{Body: []byte(`{"name": "taken over"}`)})
+	Equal(t, 403, res.StatusCode, string(body))
+	res, body = app.MakeRequest(routeBuilder.Put, "/api/v1/keys/"+scopedAdmin.ID.Hex(), TestReqOpts{Body: []byte(`{"removeScope": true}`)})
+	Equal(t, 403, res.StatusCode, string(body))
+	key, err := models.GetAPIKey(app.db, mock.Key3.ID)
+	NoError(t, err)
+	Equal(t, mock.Key3.Name, key.Name)
+}
+
+func TestScopedAdminKeyCannotWidenUsers(t *testing.T) {
+	app := newTestingRouter(t)
+
+	scope := &models.APIKeyScope{ProfileIDs: []primitive.ObjectID{mock.Profile1.ID}}
+	scopedAdmin := &models.APIKey{
+		M:       db.NewM(),
+		Name:    "Scoped admin key",
+		Enabled: true,
+		Domains: []string{"werk.nl"},
+		Key:     "ffffffffffffffffffffffffffffffff",
+		Roles:   models.APIKeyRoleAdmin | models.APIKeyRoleDashboard,
+		Scope:   scope,
+	}
+	err := app.db.Insert(scopedAdmin)
+	NoError(t, err)
+	app.ChangeAuthKey(scopedAdmin)
+
+	createBody := func(roles models.APIKeyRole, scope *models.APIKeyScope) []byte {
+		body, err := json.Marshal(IMap{
+			"username": "bob",
+			"password": "a-very-good-password",
+			"roles":    roles,
+			"scope":    scope,
+		})
+		NoError(t, err)
+		return body
+	}
+
+	// Users without a scope, with a wider scope or with more roles would give the key more access by logging in as the user
+	res, body := app.MakeRequest(routeBuilder.Post, "/api/v1/users", TestReqOpts{Body: createBody(models.APIKeyRoleDashboard, nil)})
+	Equal(t, 403, res.StatusCode, string(body))
+	res, body = app.MakeRequest(routeBuilder.Post, "/api/v1/users", TestReqOpts{Body: createBody(models.APIKeyRoleDashboard, &models.APIKeyScope{
+		ProfileIDs: []primitive.ObjectID{mock.Profile2.ID},
+	})})
+	Equal(t, 403, res.StatusCode, string(body))
+	res, body = app.MakeRequest(routeBuilder.Post, "/api/v1/users", TestReqOpts{Body: createBody(models.APIKeyRoleAll, scope)})
+	Equal(t, 403, res.StatusCode, string(body))
+
+	res, body = app.MakeRequest(routeBuilder.Post, "/api/v1/users", TestReqOpts{Body: createBody(models.APIKeyRoleDashboard, scope)})
+	Equal(t, 200, res.StatusCode, string(body))
+	user := models.DashboardUser{}
+	err = json.Unmarshal(body, &user)
+	NoError(t, err)
+
+	res, body = app.MakeRequest(routeBuilder.Put, "/api/v1/users/"+user.ID.Hex(), TestReqOpts{Body: []byte(`{"removeScope": true}`)})
+	Equal(t, 403, res.StatusCode, string(body))
+
+	// Invalid input is a client error
+	res, body = app.MakeRequest(routeBuilder.Put, "/api/v1/users/"+user.ID.Hex(), TestReqOpts{Body: []byte(`{"password": "short"}`)})
+	Equal(t, 400, res.StatusCode, string(body))
+	res, body = app.MakeRequest(routeBuilder.Post, "/api/v1/users", TestReqOpts{Body: []byte(`{"username": "carol"}`)})
+	Equal(t, 400, res.StatusCode, string(body))
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	ctxPkg "github.com/script-development/RT-CV/controller/ctx"
+	"github.com/script-development/RT-CV/db/mongo/backup"
+	"github.com/script-development/RT-CV/helpers/routeBuilder"
+)
+
+var errBackupsNotEnabled = errors.New("backups are not enabled on this server")
+
+// middlewareRequiresBackups only allows the request if backups are enabled
+func middlewareRequiresBackups() routeBuilder.M {
+	return routeBuilder.M{
+		Fn: func(c *fiber.Ctx) error {
+			if ctxPkg.Get(c).Backups == nil {
+				return ErrorRes(c, fiber.StatusNotFound, errBackupsNotEnabled)
+			}
+			return c.Next()
+		},
+	}
+}
+
+var routeGetBackups = routeBuilder.R{
+	Description: "get all backups in the backup storage, newest first",
+	Res:         []backup.CatalogEntry{},
+	Fn: func(c *fiber.Ctx) error {
+		backups, err := ctxPkg.Get(c).Backups.Catalog()
+		if err != nil {
+			return err
+		}
+		return c.JSON(backups)
+	},
+}
+
+var routeCreateBackup = routeBuilder.R{
+	Description: "start creating a new backup, " +
+		"the backup is created in the background and the progress can be followed using the backup status route",
+	Res: backup.RunStatus{},
+	Fn: func(c *fiber.Ctx) error {
+		status, err := ctxPkg.Get(c).Backups.Trigger()
+		if err == backup.ErrBackupRunning {
+			return ErrorRes(c, fiber.StatusConflict, err)
+		} else if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusAccepted).JSON(status)
+	},
+}
+
+var routeGetBackupStatus = routeBuilder.R{
+	Description: "get the status of the last backup run, null if no backup was created since the server started",
+	Res:         backup.RunStatus{},
+	Fn: func(c *fiber.Ctx) error {
+		return c.JSON(ctxPkg.Get(c).Backups.Status())
+	},
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/script-development/RT-CV/db/filedb"
+	"github.com/script-development/RT-CV/db/mongo/backup"
+	"github.com/script-development/RT-CV/db/testingdb"
+	"github.com/script-development/RT-CV/helpers/routeBuilder"
+	"github.com/script-development/RT-CV/models"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestBackupsNotEnabled(t *testing.T) {
+	app := newTestingRouter(t)
+
+	res, body := app.MakeRequest(routeBuilder.Get, `/api/v1/backups`, TestReqOpts{})
+	Equal(t, 404, res.StatusCode, string(body))
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/backups`, TestReqOpts{})
+	Equal(t, 404, res.StatusCode, string(body))
+}
+
+func TestBackups(t *testing.T) {
+	store, err := filedb.NewStore(t.TempDir())
+	NoError(t, err)
+	conn := testingdb.NewDBWithStore(store)
+	conn.RegisterEntries(&models.Backup{}, &models.BackupCatalogEntry{})
+
+	scheduler, err := backup.NewScheduler(conn, backup.StartScheduleOptions{
+		BackupEncryptionKey: "0123456789abcdef",
+		Storage:             backup.StorageLocal,
+		LocalPath:           t.TempDir(),
+	})
+	NoError(t, err)
+	app := newTestingRouterWithBackups(t, scheduler)
+
+	res, body := app.MakeRequest(routeBuilder.Get, `/api/v1/backups/status`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(body))
+	Equal(t, "null", string(body))
+
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/backups`, TestReqOpts{})
+	Equal(t, 202, res.StatusCode, string(body))
+
+	status := backup.RunStatus{}
+	Eventually(t, func() bool {
+		_, body = app.MakeRequest(routeBuilder.Get, `/api/v1/backups/status`, TestReqOpts{})
+		err = json.Unmarshal(body, &status)
+		return err == nil && !status.Running
+	}, 5*time.Second, 10*time.Millisecond)
+	Equal(t, backup.TriggerManual, status.Trigger)
+	Equal(t, "", status.Error)
+
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/backups`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(body))
+	backups := []backup.CatalogEntry{}
+	err = json.Unmarshal(body, &backups)
+	NoError(t, err)
+	if Len(t, backups, 1) {
+		Equal(t, status.Backup, backups[0].Name)
+		NotNil(t, backups[0].CreatedAt)
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/script-development/RT-CV/controller/ctx"
 	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/mongo/backup"
 	"github.com/script-development/RT-CV/helpers/auth"
+	"github.com/script-development/RT-CV/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // InsertData adds the profiles to every route
-func InsertData(dbConn db.Connection) fiber.Handler {
+// backups and cvHistory can be nil if they are not enabled
+func InsertData(dbConn db.Connection, backups *backup.Scheduler, cvHistory *models.CVHistory) fiber.Handler {
 	authHelper := auth.NewHelper(dbConn)
 
 	// Pre define loggerEntity so we only take once memory
@@ -19,6 +22,14 @@ func InsertData(dbConn db.Connection) fiber.Handler {
 	}
 
 	matcherProfilesCache := &ctx.MatcherProfilesCache{}
+	err := matcherProfilesCache.WatchProfiles(dbConn)
+	if err != nil {
+		log.WithError(err).Warn("unable to watch for profile changes, changes made by other instances are only visible after the profiles cache expires")
+	}
+	err = matcherProfilesCache.WatchTree(dbConn)
+	if err != nil {
+		log.WithError(err).Warn("unable to watch for matcher tree changes, changes made by other instances are only visible after the profiles cache expires")
+	}
 
 	return func(c *fiber.Ctx) error {
 		requestID := primitive.NewObjectID()
@@ -30,6 +41,8 @@ func InsertData(dbConn db.Connection) fiber.Handler {
 			Logger:               loggerEntity.WithField("request_id", requestID.Hex()),
 			DBConn:               dbConn,
 			MatcherProfilesCache: matcherProfilesCache,
+			Backups:              backups,
+			CVHistory:            cvHistory,
 		}))
 
 		return c.Next()
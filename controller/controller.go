@@ -26,7 +26,22 @@ func Routes(app *fiber.App, appVersion string, testing bool) {
 			b.Get(`/cv`, routeGetCvSchema)
 		})
 
-		b.Get(`/auth/keyinfo`, routeGetKeyInfo, requiresAuth(0))
+		b.Group(`/auth`, func(b *routeBuilder.Router) {
+			b.Get(`/keyinfo`, routeGetKeyInfo, requiresAuth(0))
+			b.Post(`/login`, routeLogin)
+			b.Post(`/refresh`, routeRefreshSession)
+
+			// Routes that can only be used with a dashboard user session
+			b.Group(``, func(b *routeBuilder.Router) {
+				b.Get(`/me`, routeGetMyUser)
+				b.Post(`/logout`, routeLogout)
+				b.Get(`/sessions`, routeGetMySessions)
+				b.Delete(`/sessions/:sessionID`, routeRevokeMySession)
+				b.Post(`/totp/setup`, routeSetupTOTP)
+				b.Post(`/totp/enable`, routeEnableTOTP)
+				b.Post(`/totp/disable`, routeDisableTOTP)
+			}, requiresAuth(0), middlewareBindMyUser())
+		})
 
 		b.Group(`/scraper`, func(b *routeBuilder.Router) {
 			b.Post(`/scanCV`, routeScraperScanCV)
@@ -35,9 +50,9 @@ func Routes(app *fiber.App, appVersion string, testing bool) {
 
 		b.Group(`/scraperUsers/:scraperKeyID`, func(b *routeBuilder.Router) {
 			b.Get(``, routeGetScraperUsers)
-			b.Patch(``, routePatchScraperUser, requiresAuth(models.APIKeyRoleAdmin|models.APIKeyRoleDashboard|models.APIKeyRoleController))
-			b.Delete(``, routeDeleteScraperUser, requiresAuth(models.APIKeyRoleAdmin|models.APIKeyRoleDashboard|models.APIKeyRoleController))
-			b.Patch(`/setPublicKey`, routeSetPublicKeyForScraperUsers)
+			b.Patch(``, routePatchScraperUser, requiresAuth(models.APIKeyRoleAdmin|models.APIKeyRoleDashboard|models.APIKeyRoleController, models.APIKeyAccessWrite))
+			b.Delete(``, routeDeleteScraperUser, requiresAuth(models.APIKeyRoleAdmin|models.APIKeyRoleDashboard|models.APIKeyRoleController, models.APIKeyAccessWrite))
+			b.Patch(`/setPublicKey`, routeSetPublicKeyForScraperUsers, requiresAuth(0, models.APIKeyAccessWrite))
 		}, middlewareBindKey("scraperKeyID"), requiresAuth(models.APIKeyRoleAll))
 
 		b.Group(`/profiles`, func(b *routeBuilder.Router) {
@@ -46,50 +61,105 @@ func Routes(app *fiber.App, appVersion string, testing bool) {
 				b.Get(`count`, routeGetProfilesCount)
 				b.Get(``, routeAllProfiles)
 				b.Post(`query`, routeQueryProfiles)
-				b.Get(`/:profile`, routeGetProfile, middlewareBindProfile())
+				b.Post(`export`, routeExportProfiles)
+				b.Get(`/deleted`, routeGetDeletedProfiles)
+				b.Group(`/:profile`, func(b *routeBuilder.Router) {
+					b.Get(``, routeGetProfile)
+					b.Get(`/revisions`, routeGetProfileRevisions)
+					b.Get(`/revisions/:revision`, routeGetProfileRevision)
+				}, middlewareBindProfile())
 			}, requiresAuth(models.APIKeyRoleInformationObtainer|models.APIKeyRoleDashboard))
 
+			// Simulations test profiles against up to thousands of CVs so they are limited to the keys that can change profiles
+			b.Group(``, func(b *routeBuilder.Router) {
+				b.Post(`simulate`, routeSimulateProfile)
+				b.Group(`/:profile`, func(b *routeBuilder.Router) {
+					b.Post(`/simulate`, routeSimulateProfileChanges)
+				}, middlewareBindProfile())
+			}, requiresAuth(models.APIKeyRoleController|models.APIKeyRoleDashboard), middlewareRequiresCVHistory())
+
 			// Profile routes that require the controller role
 			b.Group(``, func(b *routeBuilder.Router) {
 				b.Post(``, routeCreateProfile, requiresAuth(models.APIKeyRoleController))
+				b.Post(`import`, routeImportProfiles, requiresAuth(models.APIKeyRoleController))
+				b.Post(`/deleted/:profile/restore`, routeRestoreProfile, requiresAuth(models.APIKeyRoleController))
 				b.Group(`/:profile`, func(b *routeBuilder.Router) {
 					b.Put(``, routeModifyProfile, requiresAuth(models.APIKeyRoleController))
 					b.Delete(``, routeDeleteProfile, requiresAuth(models.APIKeyRoleController))
+					b.Post(`/revisions/:revision/rollback`, routeRollbackProfile, requiresAuth(models.APIKeyRoleController))
 				}, middlewareBindProfile())
-			}, requiresAuth(models.APIKeyRoleController|models.APIKeyRoleDashboard))
-		}, requiresAuth(0))
+			}, requiresAuth(models.APIKeyRoleController|models.APIKeyRoleDashboard, models.APIKeyAccessWrite))
+		}, requiresAuth(0, models.APIKeyAccessProfiles))
 
 		b.Group(`/keys`, func(b *routeBuilder.Router) {
 			b.Get(``, routeGetKeys)
 			b.Get(`/scrapers`, routeGetScraperKeys)
-			b.Post(``, routeCreateKey)
+			b.Post(``, routeCreateKey, requiresAuth(0, models.APIKeyAccessWrite))
 			b.Group(`/:keyID`, func(b *routeBuilder.Router) {
 				b.Get(``, routeGetKey)
-				b.Put(``, routeUpdateKey)
-				b.Delete(``, routeDeleteKey)
+				b.Put(``, routeUpdateKey, requiresAuth(0, models.APIKeyAccessWrite))
+				b.Delete(``, routeDeleteKey, requiresAuth(0, models.APIKeyAccessWrite))
 			}, middlewareBindKey("keyID"))
-		}, requiresAuth(models.APIKeyRoleDashboard))
+		}, requiresAuth(models.APIKeyRoleAdmin))
+
+		b.Group(`/users`, func(b *routeBuilder.Router) {
+			b.Get(``, routeGetDashboardUsers)
+			b.Post(``, routeCreateDashboardUser, requiresAuth(0, models.APIKeyAccessWrite))
+			b.Group(`/:userID`, func(b *routeBuilder.Router) {
+				b.Get(``, routeGetDashboardUser)
+				b.Put(``, routeUpdateDashboardUser, requiresAuth(0, models.APIKeyAccessWrite))
+				b.Delete(``, routeDeleteDashboardUser, requiresAuth(0, models.APIKeyAccessWrite))
+				b.Get(`/sessions`, routeGetDashboardUserSessions)
+				b.Delete(`/sessions`, routeRevokeDashboardUserSessions, requiresAuth(0, models.APIKeyAccessWrite))
+			}, middlewareBindDashboardUser("userID"))
+		}, requiresAuth(models.APIKeyRoleAdmin))
+
+		b.Get(`/auditLog`, routeGetAuditLog, requiresAuth(models.APIKeyRoleAdmin))
+
+		b.Group(`/tenants`, func(b *routeBuilder.Router) {
+			b.Get(``, routeGetTenants)
+			b.Post(``, routeCreateTenant, requiresAuth(0, models.APIKeyAccessWrite))
+			b.Group(`/:tenantID`, func(b *routeBuilder.Router) {
+				b.Get(``, routeGetTenant)
+				b.Put(``, routeUpdateTenant, requiresAuth(0, models.APIKeyAccessWrite))
+				b.Delete(``, routeDeleteTenant, requiresAuth(0, models.APIKeyAccessWrite))
+			}, middlewareBindTenant("tenantID"))
+		}, requiresAuth(models.APIKeyRoleAdmin), middlewareRequiresNoTenant())
+
+		b.Group(`/backups`, func(b *routeBuilder.Router) {
+			b.Get(``, routeGetBackups)
+			b.Post(``, routeCreateBackup, requiresAuth(0, models.APIKeyAccessWrite))
+			b.Get(`/status`, routeGetBackupStatus)
+		}, requiresAuth(models.APIKeyRoleAdmin), middlewareRequiresNoTenant(), middlewareRequiresBackups())
 
 		b.Group(`/onMatchHooks`, func(b *routeBuilder.Router) {
 			b.Get(``, routeGetOnMatchHooks)
-			b.Post(``, routeCreateOnMatchHooks)
+			b.Post(``, routeCreateOnMatchHooks, requiresAuth(0, models.APIKeyAccessWrite))
 			b.Group(`/:hookID`, func(b *routeBuilder.Router) {
-				b.Delete(``, routeDeleteOnMatchHook)
-				b.Put(``, routeUpdateOnMatchHook)
+				b.Delete(``, routeDeleteOnMatchHook, requiresAuth(0, models.APIKeyAccessWrite))
+				b.Put(``, routeUpdateOnMatchHook, requiresAuth(0, models.APIKeyAccessWrite))
 				b.Post(`/testMatch`, routeTestOnMatchHook)
 				b.Post(`/testList`, routeTestOnListHook)
 			}, middlewareBindHook())
-		}, requiresAuth(models.APIKeyRoleController|models.APIKeyRoleInformationObtainer|models.APIKeyRoleDashboard))
+		}, requiresAuth(models.APIKeyRoleController|models.APIKeyRoleInformationObtainer|models.APIKeyRoleDashboard, models.APIKeyAccessHooks))
 
 		b.Group(`/matcherTree`, func(b *routeBuilder.Router) {
 			b.Get(``, routeGetMatcherTree)
-			b.Post(`/addLeaf`, routeAddMatcherLeaf)
+			b.Post(`/addLeaf`, routeAddMatcherLeaf, requiresAuth(0, models.APIKeyAccessWrite))
 			b.Post(`/search`, routeSearchMatcherLeaf)
+			b.Get(`/export`, routeExportMatcherTree)
+			b.Post(`/import`, routeImportMatcherTree, requiresAuth(0, models.APIKeyAccessWrite))
+			b.Get(`/integrity`, routeGetMatcherTreeIntegrity)
+			b.Post(`/integrity`, routeRepairMatcherTreeIntegrity, requiresAuth(0, models.APIKeyAccessWrite))
 			b.Group(`/:id`, func(b *routeBuilder.Router) {
 				b.Get(``, routeGetMatcherTree)
-				b.Put(``, routePutMatcherBranch)
-				b.Delete(``, routeDeleteMatcherBranch)
-				b.Post(`/addLeaf`, routeAddMatcherLeaf)
+				b.Put(``, routePutMatcherBranch, requiresAuth(0, models.APIKeyAccessWrite))
+				b.Delete(``, routeDeleteMatcherBranch, requiresAuth(0, models.APIKeyAccessWrite))
+				b.Post(`/addLeaf`, routeAddMatcherLeaf, requiresAuth(0, models.APIKeyAccessWrite))
+				b.Get(`/export`, routeExportMatcherTree)
+				b.Post(`/import`, routeImportMatcherTree, requiresAuth(0, models.APIKeyAccessWrite))
+				b.Put(`/move`, routeMoveMatcherBranch, requiresAuth(0, models.APIKeyAccessWrite))
+				b.Post(`/merge`, routeMergeMatcherBranches, requiresAuth(0, models.APIKeyAccessWrite))
 			})
 		}, requiresAuth(models.APIKeyRoleController|models.APIKeyRoleInformationObtainer|models.APIKeyRoleDashboard))
 	})
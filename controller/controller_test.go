@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/script-development/RT-CV/db/mongo/backup"
 	"github.com/script-development/RT-CV/db/testingdb"
 	"github.com/script-development/RT-CV/helpers/auth"
 	"github.com/script-development/RT-CV/helpers/routeBuilder"
@@ -24,12 +25,24 @@ type testingRouter struct {
 }
 
 func newTestingRouter(t *testing.T) *testingRouter {
+	return newTestingRouterWithBackups(t, nil)
+}
+
+func newTestingRouterWithBackups(t *testing.T, backups *backup.Scheduler) *testingRouter {
+	return newTestingRouterWithServices(t, backups, nil)
+}
+
+func newTestingRouterWithCVHistory(t *testing.T, cvHistory *models.CVHistory) *testingRouter {
+	return newTestingRouterWithServices(t, nil, cvHistory)
+}
+
+func newTestingRouterWithServices(t *testing.T, backups *backup.Scheduler, cvHistory *models.CVHistory) *testingRouter {
 	db := mock.NewMockDB()
 
 	app := fiber.New(fiber.Config{
 		ErrorHandler: FiberErrorHandler,
 	})
-	app.Use(InsertData(db))
+	app.Use(InsertData(db, backups, cvHistory))
 	Routes(app, "TESTING", true)
 
 	return &testingRouter{
@@ -2,13 +2,16 @@ package ctx
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/gofiber/fiber/v2"
 	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/mongo/backup"
 	"github.com/script-development/RT-CV/helpers/auth"
 	"github.com/script-development/RT-CV/models"
+	"github.com/script-development/RT-CV/models/matcher"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -21,12 +24,17 @@ type Ctx struct {
 	RequestID            primitive.ObjectID
 	Profile              *models.Profile
 	Auth                 *auth.Helper
-	Key                  *models.APIKey // The key used to make the request
+	Key                  *models.APIKey  // The key used to make the request, if the request was made using a session this key represends the dashboard user
+	Session              *models.Session // The session used to make the request, nil if the request was made using an api key
 	APIKeyFromParam      *models.APIKey
+	DashboardUser        *models.DashboardUser
 	Logger               *log.Entry
 	DBConn               db.Connection
 	MatcherProfilesCache *MatcherProfilesCache
 	OnMatchHook          *models.OnMatchHook
+	Tenant               *models.Tenant
+	Backups              *backup.Scheduler // nil if backups are not enabled
+	CVHistory            *models.CVHistory // nil if the cv history is not enabled
 }
 
 // Set sets the request context
@@ -40,28 +48,39 @@ func Get(c *fiber.Ctx) *Ctx {
 }
 
 // GetOrGenMatcherProfilesCache returns the cached profiles or creates a new cache content
-func (c *Ctx) GetOrGenMatcherProfilesCache() (*MatcherProfilesCache, error) {
-	if c.MatcherProfilesCache.ScanProfiles != nil && c.MatcherProfilesCache.ListProfiles != nil && c.MatcherProfilesCache.InsertionTime.Add(time.Hour*24).After(time.Now()) {
-		return c.MatcherProfilesCache, nil
+func (c *Ctx) GetOrGenMatcherProfilesCache() (*MatcherProfiles, error) {
+	cache := c.MatcherProfilesCache
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	if cache.profiles != nil && cache.profiles.InsertionTime.Add(time.Hour*24).After(time.Now()) {
+		return cache.profiles, nil
 	}
 
 	// Update the cache
 	c.Logger.Info("updating the profiles cache")
-	scanProfiles, err := models.GetActualMatchActiveProfiles(c.DBConn)
+	// The cache is shared between all tenants so we query the profiles of all tenants
+	conn := db.WithoutTenant(c.DBConn)
+	scanProfiles, err := models.GetActualMatchActiveProfiles(conn)
 	if err != nil {
 		return nil, err
 	}
-	listProfiles, err := models.GetListsProfiles(c.DBConn)
+	listProfiles, err := models.GetListsProfiles(conn)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := matcher.NewTree(conn)
 	if err != nil {
 		return nil, err
 	}
 
-	*c.MatcherProfilesCache = MatcherProfilesCache{
+	cache.profiles = &MatcherProfiles{
 		ScanProfiles:  profilesListToPtrs(scanProfiles),
 		ListProfiles:  profilesListToPtrs(listProfiles),
+		Tree:          tree,
 		InsertionTime: time.Now(),
 	}
-	return c.MatcherProfilesCache, nil
+	return cache.profiles, nil
 }
 
 func profilesListToPtrs(in []models.Profile) []*models.Profile {
@@ -72,14 +91,141 @@ func profilesListToPtrs(in []models.Profile) []*models.Profile {
 	return out
 }
 
-// MatcherProfilesCache contains the matcher profiles cache
+// MatcherProfilesCache caches the profiles used by the matcher
+// It is shared between all requests
 type MatcherProfilesCache struct {
+	m sync.Mutex
+
+	// profiles is nil when the cache needs to be (re)loaded
+	profiles *MatcherProfiles
+
+	// watching is true when the cache is kept up to date using the profile changes from the database
+	watching bool
+}
+
+// MatcherProfiles contains the cached profiles used by the matcher
+// The contents are never modified, changes to the cache result in a new MatcherProfiles
+type MatcherProfiles struct {
 	InsertionTime time.Time
 	ScanProfiles  []*models.Profile
 	ListProfiles  []*models.Profile
+
+	// Tree is the matcher tree used to expand the desired professions of the profiles
+	// The profiles cache the expanded professions so they are reloaded together with the tree
+	Tree *matcher.Tree
 }
 
 // ResetMatcherProfilesCache sets the profiles cache to an empty object
+// If the cache is kept up to date by watching the profile changes this does nothing
 func (c *Ctx) ResetMatcherProfilesCache() {
-	*c.MatcherProfilesCache = MatcherProfilesCache{}
+	cache := c.MatcherProfilesCache
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	if !cache.watching {
+		cache.profiles = nil
+	}
+}
+
+// ResetMatcherTreeCache reloads the profiles and the matcher tree the next time they are used
+// Unlike ResetMatcherProfilesCache this also resets the cache if profile changes are watched as the profile watcher doesn't see tree changes
+func (c *Ctx) ResetMatcherTreeCache() {
+	c.MatcherProfilesCache.resetTree()
+}
+
+func (cache *MatcherProfilesCache) resetTree() {
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	cache.profiles = nil
+}
+
+// WatchTree reloads the profiles and the matcher tree when the tree changes
+// This also picks up changes made by other instances of RT-CV using the same database
+func (cache *MatcherProfilesCache) WatchTree(conn db.Connection) error {
+	_, err := matcher.WatchTree(db.WithoutTenant(conn), cache.resetTree)
+	return err
+}
+
+// WatchProfiles keeps the cache up to date by applying the profile changes of the database
+// This also picks up changes made by other instances of RT-CV using the same database
+func (cache *MatcherProfilesCache) WatchProfiles(conn db.Connection) error {
+	_, err := db.WithoutTenant(conn).Watch(&models.Profile{}, cache.applyProfileChange)
+	if err != nil {
+		return err
+	}
+
+	cache.m.Lock()
+	cache.watching = true
+	cache.m.Unlock()
+	return nil
+}
+
+// applyProfileChange updates the cached profiles without reloading all of them
+func (cache *MatcherProfilesCache) applyProfileChange(change db.Change) {
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	if cache.profiles == nil {
+		// The next time the cache is used it is loaded with the latest profiles
+		return
+	}
+
+	cached := cache.profiles.findProfile(change.ID)
+	var scanProfile, listProfile *models.Profile
+	if change.Entry != nil {
+		profile := change.Entry.(*models.Profile)
+		if cached != nil && models.ProfileUserFieldsEqual(cached, profile) {
+			// Only the fields managed by RT-CV changed (for example the match count), the matcher doesn't use these
+			return
+		}
+		if profile.IsActualMatchActive() {
+			scanProfile = profile
+		}
+		if profile.IsListsProfile() {
+			listProfile = profile
+		}
+	}
+	if cached == nil && scanProfile == nil && listProfile == nil {
+		// The profile was not cached and still isn't used by the matcher
+		return
+	}
+
+	cache.profiles = &MatcherProfiles{
+		InsertionTime: cache.profiles.InsertionTime,
+		ScanProfiles:  replaceProfile(cache.profiles.ScanProfiles, change.ID, scanProfile),
+		ListProfiles:  replaceProfile(cache.profiles.ListProfiles, change.ID, listProfile),
+		Tree:          cache.profiles.Tree,
+	}
+}
+
+// findProfile returns the cached profile with id or nil if the profile is not cached
+func (profiles *MatcherProfiles) findProfile(id primitive.ObjectID) *models.Profile {
+	for _, list := range [][]*models.Profile{profiles.ScanProfiles, profiles.ListProfiles} {
+		for _, profile := range list {
+			if profile.ID == id {
+				return profile
+			}
+		}
+	}
+	return nil
+}
+
+// replaceProfile returns a copy of profiles where the profile with id is replaced by replacement
+// If replacement is nil the profile is removed, if the profile is not yet in profiles the replacement is added
+func replaceProfile(profiles []*models.Profile, id primitive.ObjectID, replacement *models.Profile) []*models.Profile {
+	res := make([]*models.Profile, 0, len(profiles)+1)
+	replaced := false
+	for _, profile := range profiles {
+		if profile.ID != id {
+			res = append(res, profile)
+		} else if replacement != nil && !replaced {
+			res = append(res, replacement)
+			replaced = true
+		}
+	}
+	if replacement != nil && !replaced {
+		res = append(res, replacement)
+	}
+	return res
 }
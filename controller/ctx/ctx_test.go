@@ -0,0 +1,136 @@
+package ctx
+
+import (
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	"github.com/script-development/RT-CV/models"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMatcherProfilesCacheWatchProfiles(t *testing.T) {
+	conn := testingdb.NewDB()
+	scanProfile := &models.Profile{
+		M:                  db.NewM(),
+		Active:             true,
+		DesiredProfessions: []models.ProfileProfession{{Name: "developer"}},
+	}
+	err := conn.Insert(scanProfile)
+	NoError(t, err)
+
+	cache := &MatcherProfilesCache{}
+	err = cache.WatchProfiles(conn)
+	NoError(t, err)
+
+	c := &Ctx{
+		Logger:               log.WithField("test", true),
+		DBConn:               conn,
+		MatcherProfilesCache: cache,
+	}
+	profiles, err := c.GetOrGenMatcherProfilesCache()
+	NoError(t, err)
+	Len(t, profiles.ScanProfiles, 1)
+	Len(t, profiles.ListProfiles, 0)
+	insertionTime := profiles.InsertionTime
+
+	// Resetting the cache does nothing as the cache is kept up to date
+	c.ResetMatcherProfilesCache()
+
+	listsProfile := &models.Profile{
+		M:            db.NewM(),
+		Active:       true,
+		ListsAllowed: true,
+		Zipcodes:     []models.ProfileDutchZipcode{{From: 1000, To: 2000}},
+	}
+	err = conn.Insert(listsProfile)
+	NoError(t, err)
+
+	updatedProfiles, err := c.GetOrGenMatcherProfilesCache()
+	NoError(t, err)
+	Equal(t, insertionTime, updatedProfiles.InsertionTime, "the cache should not be reloaded")
+	Len(t, updatedProfiles.ScanProfiles, 1)
+	Len(t, updatedProfiles.ListProfiles, 1)
+	Len(t, profiles.ListProfiles, 0, "earlier obtained profiles should not change")
+
+	// Deactivating a profile removes it from the cache
+	_, err = conn.UpdateMany(&models.Profile{}, bson.M{"_id": scanProfile.ID}, bson.M{"$set": bson.M{"active": false}})
+	NoError(t, err)
+	updatedProfiles, err = c.GetOrGenMatcherProfilesCache()
+	NoError(t, err)
+	Len(t, updatedProfiles.ScanProfiles, 0)
+
+	// A profile can move from one list to the other
+	listsProfile.ListsAllowed = false
+	listsProfile.Educations = []models.ProfileEducation{{Name: "school"}}
+	err = conn.UpdateByID(listsProfile)
+	NoError(t, err)
+	updatedProfiles, err = c.GetOrGenMatcherProfilesCache()
+	NoError(t, err)
+	Len(t, updatedProfiles.ScanProfiles, 1)
+	Len(t, updatedProfiles.ListProfiles, 0)
+
+	err = conn.DeleteByID(&models.Profile{}, listsProfile.ID)
+	NoError(t, err)
+	updatedProfiles, err = c.GetOrGenMatcherProfilesCache()
+	NoError(t, err)
+	Len(t, updatedProfiles.ScanProfiles, 0)
+	Equal(t, insertionTime, updatedProfiles.InsertionTime)
+}
+
+func TestResetMatcherProfilesCacheWithoutWatching(t *testing.T) {
+	c := &Ctx{
+		Logger:               log.WithField("test", true),
+		DBConn:               testingdb.NewDB(),
+		MatcherProfilesCache: &MatcherProfilesCache{},
+	}
+	profiles, err := c.GetOrGenMatcherProfilesCache()
+	NoError(t, err)
+
+	c.ResetMatcherProfilesCache()
+	reloadedProfiles, err := c.GetOrGenMatcherProfilesCache()
+	NoError(t, err)
+	NotSame(t, profiles, reloadedProfiles)
+}
+
+func TestMatcherProfilesCacheIgnoresManagedFieldChanges(t *testing.T) {
+	conn := testingdb.NewDB()
+	profile := &models.Profile{
+		M:                  db.NewM(),
+		Active:             true,
+		DesiredProfessions: []models.ProfileProfession{{Name: "developer"}},
+	}
+	err := conn.Insert(profile)
+	NoError(t, err)
+
+	cache := &MatcherProfilesCache{}
+	err = cache.WatchProfiles(conn)
+	NoError(t, err)
+
+	c := &Ctx{
+		Logger:               log.WithField("test", true),
+		DBConn:               conn,
+		MatcherProfilesCache: cache,
+	}
+	profiles, err := c.GetOrGenMatcherProfilesCache()
+	NoError(t, err)
+	Len(t, profiles.ScanProfiles, 1)
+
+	// Storing the schedule status doesn't change the profile for the matcher so the cache is kept as is
+	_, err = conn.UpdateMany(&models.Profile{}, bson.M{"_id": profile.ID}, bson.M{"$set": bson.M{"lastScheduleStatus": models.ProfileStatusActive}})
+	NoError(t, err)
+	updatedProfiles, err := c.GetOrGenMatcherProfilesCache()
+	NoError(t, err)
+	Same(t, profiles, updatedProfiles)
+
+	// Changes made by users still update the cache
+	_, err = conn.UpdateMany(&models.Profile{}, bson.M{"_id": profile.ID}, bson.M{"$set": bson.M{"name": "updated"}})
+	NoError(t, err)
+	updatedProfiles, err = c.GetOrGenMatcherProfilesCache()
+	NoError(t, err)
+	NotSame(t, profiles, updatedProfiles)
+	Len(t, updatedProfiles.ScanProfiles, 1)
+	Equal(t, "updated", updatedProfiles.ScanProfiles[0].Name)
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/script-development/RT-CV/controller/ctx"
+	"github.com/script-development/RT-CV/db"
 	"github.com/script-development/RT-CV/helpers/routeBuilder"
 	"github.com/script-development/RT-CV/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -55,6 +57,9 @@ var routeScraperListCVs = routeBuilder.R{
 			return c.JSON(RouteScraperListCVsResp{})
 		}
 
+		// The tenant of every matched profile, used to only send a tenant's matches to that tenant's hooks
+		profileTenants := map[primitive.ObjectID]*primitive.ObjectID{}
+
 		hookData := CVListsHookData{
 			KeyID:            reqCtx.Key.ID,
 			KeyName:          reqCtx.Key.Name,
@@ -63,6 +68,15 @@ var routeScraperListCVs = routeBuilder.R{
 			ProfilesMatchCVs: map[primitive.ObjectID][]string{},
 		}
 
+		// The cache is kept for a long time so check the schedule of the profiles every time
+		now := time.Now()
+		listProfiles := []*models.Profile{}
+		for _, profile := range profilesCache.ListProfiles {
+			if profile.IsScheduledActive(now) {
+				listProfiles = append(listProfiles, profile)
+			}
+		}
+
 		for _, cv := range body.CVs {
 			if cv.PersonalDetails.Zip == "" {
 				continue
@@ -75,11 +89,12 @@ var routeScraperListCVs = routeBuilder.R{
 			cvRef := cv.ReferenceNumber
 
 			cvMatch := false
-			for _, profile := range profilesCache.ListProfiles {
+			for _, profile := range listProfiles {
 				for _, zipCode := range profile.Zipcodes {
 					if zipCode.IsWithinCithAndArea(cvZip) {
 						cvMatch = true
 						hookData.ProfilesMatchCVs[profile.ID] = append(hookData.ProfilesMatchCVs[profile.ID], cvRef)
+						profileTenants[profile.ID] = profile.TenantID
 						break
 					}
 				}
@@ -94,13 +109,8 @@ var routeScraperListCVs = routeBuilder.R{
 			return c.JSON(RouteScraperListCVsResp{})
 		}
 
-		dataForHook, err := json.Marshal(hookData)
-		if err != nil {
-			return err
-		}
-
-		go func(dataForHook []byte) {
-			hooks, err := models.GetOnMatchHooks(reqCtx.DBConn, models.GetOnMatchHooksProps{
+		go func() {
+			hooks, err := models.GetOnMatchHooks(db.WithoutTenant(reqCtx.DBConn), models.GetOnMatchHooksProps{
 				AllowDisabled:    false,
 				ExpectAtLeastOne: true,
 			})
@@ -115,11 +125,54 @@ var routeScraperListCVs = routeBuilder.R{
 
 			reqCtx.Logger.Info("Sending matched cv lists to hook")
 
+			// The hook data differs per tenant so we cache the marshaled data per tenant
+			dataPerTenant := map[primitive.ObjectID][]byte{}
 			for _, hook := range hooks {
+				tenantKey := primitive.NilObjectID
+				if hook.TenantID != nil {
+					tenantKey = *hook.TenantID
+				}
+
+				dataForHook, ok := dataPerTenant[tenantKey]
+				if !ok {
+					tenantHookData := hookData.forTenant(hook.TenantID, profileTenants)
+					if len(tenantHookData.CVs) > 0 {
+						dataForHook, err = json.Marshal(tenantHookData)
+						if err != nil {
+							reqCtx.Logger.WithError(err).Error("creating hook data failed")
+							return
+						}
+					}
+					dataPerTenant[tenantKey] = dataForHook
+				}
+				if dataForHook == nil {
+					// None of the matched profiles belong to the tenant of this hook
+					continue
+				}
+
 				hook.CallAndLogResult(bytes.NewReader(dataForHook), models.DataKindList, reqCtx.Logger)
 			}
-		}(dataForHook)
+		}()
 
 		return c.JSON(RouteScraperListCVsResp{})
 	},
 }
+
+// forTenant returns a copy of the hook data that only contains the matches of profiles of the tenant
+func (d CVListsHookData) forTenant(tenantID *primitive.ObjectID, profileTenants map[primitive.ObjectID]*primitive.ObjectID) CVListsHookData {
+	res := d
+	res.CVs = map[string]models.CV{}
+	res.ProfilesMatchCVs = map[primitive.ObjectID][]string{}
+
+	for profileID, cvRefs := range d.ProfilesMatchCVs {
+		if !db.SameTenant(profileTenants[profileID], tenantID) {
+			continue
+		}
+		res.ProfilesMatchCVs[profileID] = cvRefs
+		for _, cvRef := range cvRefs {
+			res.CVs[cvRef] = d.CVs[cvRef]
+		}
+	}
+
+	return res
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"sync"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/gofiber/fiber/v2"
@@ -48,6 +49,14 @@ var routeScraperScanCV = routeBuilder.R{
 			)
 		}
 
+		if ctx.CVHistory != nil {
+			err = ctx.CVHistory.AddInBackground(ctx.DBConn, ctx.Key.ID, body.CV, time.Now())
+			if err != nil {
+				// The cv history is only used for simulations so we can continue matching
+				ctx.Logger.WithError(err).Error("unable to add the cv to the cv history")
+			}
+		}
+
 		// Get the profiles we can use for matching
 		// If they are not cached yet or the cache it outdated, set the cache
 		profilesCache, err := ctx.GetOrGenMatcherProfilesCache()
@@ -57,7 +66,8 @@ var routeScraperScanCV = routeBuilder.R{
 		profiles := profilesCache.ScanProfiles
 
 		// Try to match a profile to a CV
-		matchedProfiles := match.Match(ctx.Key.ID, ctx.RequestID, profiles, body.CV)
+		matchedProfiles := match.Match(ctx.Key.ID, ctx.RequestID, profilesCache.Tree, profiles, body.CV)
+		matchedProfiles = reserveMatches(ctx.DBConn, ctx.Logger, matchedProfiles)
 
 		resp := RouteScraperScanCVRes{Success: true}
 		if len(matchedProfiles) == 0 {
@@ -79,6 +89,23 @@ var routeScraperScanCV = routeBuilder.R{
 	},
 }
 
+// reserveMatches counts the matches towards the match budget of their profile and drops the matches of profiles that used up their budget
+func reserveMatches(conn db.Connection, logger *log.Entry, matchedProfiles []match.FoundMatch) []match.FoundMatch {
+	now := time.Now()
+	res := []match.FoundMatch{}
+	for _, matchedProfile := range matchedProfiles {
+		reserved, err := models.ReserveProfileMatch(conn, &matchedProfile.Profile, now)
+		if err != nil {
+			logger.WithError(err).WithField("profile", matchedProfile.Profile.ID.Hex()).Error("unable to count match towards the match budget of the profile")
+			continue
+		}
+		if reserved {
+			res = append(res, matchedProfile)
+		}
+	}
+	return res
+}
+
 // MatchesProcessor is a struct that contains a list of matches to be processed in the background
 //
 // To register a match to be processed call (*MatchesProcessor).AppendMatchesToProcess
@@ -156,7 +183,7 @@ func (args ProcessMatches) Process() {
 		return
 	}
 
-	hooks, err := models.GetOnMatchHooks(args.DBConn, models.GetOnMatchHooksProps{
+	hooks, err := models.GetOnMatchHooks(db.WithoutTenant(args.DBConn), models.GetOnMatchHooksProps{
 		AllowDisabled:    false,
 		ExpectAtLeastOne: true,
 	})
@@ -165,18 +192,47 @@ func (args ProcessMatches) Process() {
 		return
 	}
 
-	hookData, err := json.Marshal(HookMatchedCVData{
-		MatchedProfiles: args.MatchedProfiles,
-		CV:              args.CV,
-		KeyID:           args.KeyID,
-		KeyName:         args.KeyName,
-	})
-	if err != nil {
-		args.Logger.WithError(err).Error("creating hook data failed")
-		return
-	}
-
+	// A tenant only receives the matches made on its own profiles so the hook data is created per tenant
+	hookDataPerTenant := map[primitive.ObjectID][]byte{}
 	for _, hook := range hooks {
+		tenantKey := primitive.NilObjectID
+		if hook.TenantID != nil {
+			tenantKey = *hook.TenantID
+		}
+
+		hookData, ok := hookDataPerTenant[tenantKey]
+		if !ok {
+			matchedProfiles := args.matchesOfTenant(hook.TenantID)
+			if len(matchedProfiles) > 0 {
+				hookData, err = json.Marshal(HookMatchedCVData{
+					MatchedProfiles: matchedProfiles,
+					CV:              args.CV,
+					KeyID:           args.KeyID,
+					KeyName:         args.KeyName,
+				})
+				if err != nil {
+					args.Logger.WithError(err).Error("creating hook data failed")
+					return
+				}
+			}
+			hookDataPerTenant[tenantKey] = hookData
+		}
+		if hookData == nil {
+			// None of the matched profiles belong to the tenant of this hook
+			continue
+		}
+
 		hook.CallAndLogResult(bytes.NewBuffer(hookData), models.DataKindMatch, &args.Logger)
 	}
 }
+
+// matchesOfTenant returns the matches made on profiles of the tenant
+func (args ProcessMatches) matchesOfTenant(tenantID *primitive.ObjectID) []match.FoundMatch {
+	res := []match.FoundMatch{}
+	for _, matchedProfile := range args.MatchedProfiles {
+		if db.SameTenant(matchedProfile.Matches.TenantID, tenantID) {
+			res = append(res, matchedProfile)
+		}
+	}
+	return res
+}
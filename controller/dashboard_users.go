@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	ctxPkg "github.com/script-development/RT-CV/controller/ctx"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/routeBuilder"
+	"github.com/script-development/RT-CV/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var routeGetDashboardUsers = routeBuilder.R{
+	Description: "get all dashboard users",
+	Res:         []models.DashboardUser{},
+	Fn: func(c *fiber.Ctx) error {
+		users, err := models.GetDashboardUsers(ctxPkg.Get(c).DBConn)
+		if err != nil {
+			return err
+		}
+		return c.JSON(users)
+	},
+}
+
+type dashboardUserModifyCreateData struct {
+	Username    *string             `json:"username"`
+	Password    *string             `json:"password"`
+	Roles       *models.APIKeyRole  `json:"roles"`
+	Scope       *models.APIKeyScope `json:"scope" description:"limits the resources the user can access, set to null or leave out to not limit the user, to remove the scope of an existing user set removeScope to true"`
+	Disabled    *bool               `json:"disabled"`
+	RemoveScope bool                `json:"removeScope" description:"only used when updating a user"`
+	DisableTOTP bool                `json:"disableTOTP" description:"only used when updating a user, can be used to reset the second factor of a user that lost access to its authenticator app"`
+
+	TenantID     *primitive.ObjectID `json:"tenantId" description:"the tenant the user belongs to, can only be set by keys without a tenant, users created by a key with a tenant always belong to the same tenant"`
+	RemoveTenant bool                `json:"removeTenant" description:"only used when updating a user"`
+}
+
+var errUserOutsideKeyScope = errors.New("the roles and scope of the user must be within the roles and scope of your own key")
+
+// dashboardUserError is an error of the request made to create or update a dashboard user
+type dashboardUserError struct {
+	status int
+	err    error
+}
+
+// Error implements the error interface
+func (e dashboardUserError) Error() string {
+	return e.err.Error()
+}
+
+func badDashboardUser(err error) error {
+	return dashboardUserError{status: fiber.StatusBadRequest, err: err}
+}
+
+// dashboardUserErrorRes responds with the status of a dashboardUserError, other errors are returned as is
+func dashboardUserErrorRes(c *fiber.Ctx, err error) error {
+	userErr := dashboardUserError{}
+	if errors.As(err, &userErr) {
+		return ErrorRes(c, userErr.status, userErr.err)
+	}
+	return err
+}
+
+// applyToUser applies the changes to the user, the user must stay within the roles and scope of key
+func (data *dashboardUserModifyCreateData) applyToUser(conn db.Connection, key *models.APIKey, user *models.DashboardUser, isCreate bool) error {
+	if data.Username != nil {
+		username := models.NormalizeUsername(*data.Username)
+		if len(username) == 0 {
+			return badDashboardUser(errors.New("username cannot be empty"))
+		}
+		if username != user.Username {
+			// Usernames are unique over all tenants
+			_, err := models.GetDashboardUserByUsername(db.WithoutTenant(conn), username)
+			if err == nil {
+				return badDashboardUser(errors.New("username already taken"))
+			} else if err != mongo.ErrNoDocuments {
+				return err
+			}
+		}
+		user.Username = username
+	} else if isCreate {
+		return badDashboardUser(errors.New("username is required"))
+	}
+
+	if data.Password != nil {
+		err := user.SetPassword(*data.Password)
+		if err != nil {
+			return badDashboardUser(err)
+		}
+	} else if isCreate {
+		return badDashboardUser(errors.New("password is required"))
+	}
+
+	if data.Roles != nil {
+		if !data.Roles.Valid() {
+			return badDashboardUser(errors.New("roles are invalid"))
+		}
+		user.Roles = *data.Roles
+	} else if isCreate {
+		user.Roles = models.APIKeyRoleDashboard
+	}
+
+	if data.RemoveScope {
+		user.Scope = nil
+	} else if data.Scope != nil {
+		err := data.Scope.Validate()
+		if err != nil {
+			return badDashboardUser(err)
+		}
+		user.Scope = data.Scope
+	}
+
+	// A key can only create and modify users that stay within its own roles and scope, otherwise it could login as the user to get more access
+	if !key.Roles.ContainsAll(user.Roles) || !key.Scope.Contains(user.Scope) {
+		return dashboardUserError{status: fiber.StatusForbidden, err: errUserOutsideKeyScope}
+	}
+
+	if data.Disabled != nil {
+		user.Disabled = *data.Disabled
+	}
+
+	err := applyTenantChange(conn, &user.T, data.TenantID, data.RemoveTenant)
+	if err != nil {
+		return err
+	}
+
+	if data.DisableTOTP {
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+	}
+
+	return nil
+}
+
+var routeCreateDashboardUser = routeBuilder.R{
+	Description: "create a new dashboard user, if roles is not set the user gets the dashboard role.\n\n" +
+		"The roles and scope of the user must be within the roles and scope of the key used to make this request.",
+	Body: dashboardUserModifyCreateData{},
+	Res:  models.DashboardUser{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+
+		body := dashboardUserModifyCreateData{}
+		err := c.BodyParser(&body)
+		if err != nil {
+			return err
+		}
+
+		user := models.DashboardUser{M: db.NewM()}
+		err = body.applyToUser(ctx.DBConn, ctx.Key, &user, true)
+		if err != nil {
+			return dashboardUserErrorRes(c, err)
+		}
+
+		err = ctx.DBConn.Insert(&user)
+		if err != nil {
+			return err
+		}
+
+		addAuditLogEntry(c, models.AuditLogEntry{
+			Action: models.AuditActionUserCreated,
+			UserID: &user.ID,
+		})
+
+		return c.JSON(user)
+	},
+}
+
+var routeGetDashboardUser = routeBuilder.R{
+	Description: "get a dashboard user",
+	Res:         models.DashboardUser{},
+	Fn: func(c *fiber.Ctx) error {
+		return c.JSON(ctxPkg.Get(c).DashboardUser)
+	},
+}
+
+var routeUpdateDashboardUser = routeBuilder.R{
+	Description: "update a dashboard user, all fields are optional.\n\n" +
+		"The roles and scope of the user must stay within the roles and scope of the key used to make this request.",
+	Body: dashboardUserModifyCreateData{},
+	Res:  models.DashboardUser{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+		user := ctx.DashboardUser
+
+		body := dashboardUserModifyCreateData{}
+		err := c.BodyParser(&body)
+		if err != nil {
+			return err
+		}
+
+		err = body.applyToUser(ctx.DBConn, ctx.Key, user, false)
+		if err != nil {
+			return dashboardUserErrorRes(c, err)
+		}
+
+		err = ctx.DBConn.UpdateByID(user)
+		if err != nil {
+			return err
+		}
+		ctx.Auth.RemoveUserSessionsCache(user.ID)
+
+		if body.Password != nil || user.Disabled {
+			// Make sure someone that might know the old password can't stay logged in
+			_, err = models.RevokeUserSessions(ctx.DBConn, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		addAuditLogEntry(c, models.AuditLogEntry{
+			Action: models.AuditActionUserUpdated,
+			UserID: &user.ID,
+		})
+
+		return c.JSON(user)
+	},
+}
+
+var routeDeleteDashboardUser = routeBuilder.R{
+	Description: "delete a dashboard user, this also revokes all sessions of the user",
+	Res:         models.DashboardUser{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+		user := ctx.DashboardUser
+
+		_, err := models.RevokeUserSessions(ctx.DBConn, user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = ctx.DBConn.DeleteByID(&models.DashboardUser{}, user.ID)
+		if err != nil {
+			return err
+		}
+		ctx.Auth.RemoveUserSessionsCache(user.ID)
+
+		addAuditLogEntry(c, models.AuditLogEntry{
+			Action:  models.AuditActionUserDeleted,
+			UserID:  &user.ID,
+			Message: "deleted user " + user.Username,
+		})
+
+		return c.JSON(user)
+	},
+}
+
+var routeGetDashboardUserSessions = routeBuilder.R{
+	Description: "get the active sessions of a dashboard user",
+	Res:         []models.Session{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+		sessions, err := models.GetUserSessions(ctx.DBConn, ctx.DashboardUser.ID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(sessions)
+	},
+}
+
+var routeRevokeDashboardUserSessions = routeBuilder.R{
+	Description: "revoke all sessions of a dashboard user",
+	Res:         []models.Session{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+		user := ctx.DashboardUser
+
+		sessions, err := models.RevokeUserSessions(ctx.DBConn, user.ID)
+		if err != nil {
+			return err
+		}
+		ctx.Auth.RemoveUserSessionsCache(user.ID)
+
+		for _, session := range sessions {
+			session := session
+			addAuditLogEntry(c, models.AuditLogEntry{
+				Action:    models.AuditActionSessionRevoked,
+				UserID:    &user.ID,
+				SessionID: &session.ID,
+			})
+		}
+
+		return c.JSON(sessions)
+	},
+}
+
+var routeGetAuditLog = routeBuilder.R{
+	Description: "get the audit log, use the userId query parameter to only get the entries of a specific dashboard user",
+	Res:         []models.AuditLogEntry{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+
+		var userID *primitive.ObjectID
+		if userIDParam := c.Query("userId"); userIDParam != "" {
+			parsedUserID, err := primitive.ObjectIDFromHex(userIDParam)
+			if err != nil {
+				return err
+			}
+			userID = &parsedUserID
+		}
+
+		entries, err := models.GetAuditLog(ctx.DBConn, userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(entries)
+	},
+}
+
+func middlewareBindDashboardUser(urlParamName string) routeBuilder.M {
+	return routeBuilder.M{
+		Fn: func(c *fiber.Ctx) error {
+			userID, err := primitive.ObjectIDFromHex(c.Params(urlParamName))
+			if err != nil {
+				return err
+			}
+
+			ctx := ctxPkg.Get(c)
+			user, err := models.GetDashboardUser(ctx.DBConn, userID)
+			if err != nil {
+				return err
+			}
+			ctx.DashboardUser = &user
+
+			return c.Next()
+		},
+	}
+}
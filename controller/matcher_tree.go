@@ -1,12 +1,13 @@
 package controller
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
-	"os"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/script-development/RT-CV/controller/ctx"
+	"github.com/script-development/RT-CV/db"
 	"github.com/script-development/RT-CV/helpers/routeBuilder"
 	"github.com/script-development/RT-CV/models/matcher"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -26,39 +27,13 @@ var routeGetMatcherTree = routeBuilder.R{
 			branchID = &id
 		}
 
-		resp := c.Response()
-		resp.Header.SetContentType(fiber.MIMEApplicationJSON)
-
-		fileInfo, file, err := matcher.ObtainCachedTree(branchID)
-		if err == nil {
-			// Yay we have a cache hit, lets return the cached response
-			resp.SetBodyStream(file, int(fileInfo.Size()))
-			return nil
-		}
-
-		ctx := ctx.Get(c)
-
-		if os.IsNotExist(err) {
-			ctx.Logger.Info("matcher tree is not cached (yet) for this id")
-		} else {
-			ctx.Logger.WithError(err).Warn("obtaining cached matcher tree failed, falling back to database")
-		}
-
-		// deep := c.Context().QueryArgs().GetUintOrZero("deep")
-		tree, err := (&matcher.Tree{}).GetBranch(ctx.DBConn, branchID)
-		if err != nil {
-			return err
-		}
-
-		jsonTree, err := json.Marshal(tree)
+		jsonTree, err := (&matcher.Tree{}).GetBranchJSON(ctx.Get(c).DBConn, branchID)
 		if err != nil {
-			return err
-		}
-		err = matcher.CacheTree(branchID, jsonTree)
-		if err != nil {
-			return err
+			return matcherTreeErrorRes(c, err)
 		}
 
+		resp := c.Response()
+		resp.Header.SetContentType(fiber.MIMEApplicationJSON)
 		resp.SetBodyRaw(jsonTree)
 		return nil
 	},
@@ -70,23 +45,37 @@ type SearchMatcherLeafsRequest struct {
 }
 
 var routeSearchMatcherLeaf = routeBuilder.R{
-	Description: "search for a matcher leaf",
-	Res:         []matcher.SearchResult{},
-	Body:        SearchMatcherLeafsRequest{},
+	Description: "search for a matcher leaf, titles with small typos are also found. The results are ranked by how well they match the search and the number of sub branches, every result contains the path from the root of the tree to the branch.\n\n" +
+		"The results can be paginated using the limit and cursor query parameters, by default 10 results are returned. " +
+		"The cursor for the next page is returned in the X-Next-Cursor header and the total number of results in the X-Total-Count header.",
+	Res:  []matcher.SearchResult{},
+	Body: SearchMatcherLeafsRequest{},
 	Fn: func(c *fiber.Ctx) error {
+		page, err := parseListPage(c, map[string]string{})
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+		if page.Limit == 0 {
+			page.Limit = matcher.DefaultSearchLimit
+		}
+
 		body := SearchMatcherLeafsRequest{}
-		err := c.BodyParser(&body)
+		err = c.BodyParser(&body)
 		if err != nil {
 			return err
 		}
 
 		ctx := ctx.Get(c)
 
-		matches, err := (&matcher.Tree{}).Search(ctx.Logger, ctx.DBConn, body.Search)
+		matches, total, err := (&matcher.Tree{}).Search(ctx.DBConn, body.Search, matcher.SearchOptions{
+			Offset: page.Offset,
+			Limit:  page.Limit,
+		})
 		if err != nil {
 			return err
 		}
 
+		page.setHeaders(c, len(matches), uint64(total))
 		return c.JSON(matches)
 	},
 }
@@ -117,10 +106,11 @@ var routeAddMatcherLeaf = routeBuilder.R{
 		}
 		tree, err := (&matcher.Tree{}).GetBranch(ctx.DBConn, idParam)
 		if err != nil {
-			return err
+			return matcherTreeErrorRes(c, err)
 		}
 
 		defer matcher.NukeCache()
+		defer ctx.ResetMatcherTreeCache()
 
 		_, err = tree.AddLeaf(ctx.DBConn, body, true /* deep != 1*/)
 		if err != nil {
@@ -153,10 +143,11 @@ var routePutMatcherBranch = routeBuilder.R{
 		// deep := c.Context().QueryArgs().GetUintOrZero("deep")
 		tree, err := (&matcher.Tree{}).GetBranch(ctx.DBConn, &id)
 		if err != nil {
-			return err
+			return matcherTreeErrorRes(c, err)
 		}
 
 		defer matcher.NukeCache()
+		defer ctx.ResetMatcherTreeCache()
 
 		err = tree.Update(ctx.DBConn, body)
 		if err != nil {
@@ -185,36 +176,40 @@ var routeDeleteMatcherBranch = routeBuilder.R{
 
 		ctx := ctx.Get(c)
 
-		// Firstly lets remove the parents as if this fails the database isn't broken and if the remaining code fails at least the data won't show up annymore
-		parents, err := matcher.FindParents(ctx.DBConn, id)
-		if err != nil {
-			return err
-		}
-		for _, parent := range parents {
-			for idx, branchID := range parent.Branches {
-				if branchID == id {
-					parent.Branches = append(parent.Branches[:idx], parent.Branches[idx+1:]...)
-					break
-				}
-			}
-			err = ctx.DBConn.UpdateByID(&parent)
-			if err != nil {
-				return err
-			}
-		}
-
 		// delete the branch and all it's child branches
 		branchIDs, err := (&matcher.Tree{}).GetIDsForBranch(ctx.DBConn, id)
 		if err != nil {
-			return err
+			return matcherTreeErrorRes(c, err)
 		}
 		if len(branchIDs) == 0 {
-			return errors.New("branch not found")
+			return matcher.ErrBranchNotFound
 		}
 
 		defer matcher.NukeCache()
+		defer ctx.ResetMatcherTreeCache()
+
+		var parents []matcher.Branch
+		err = ctx.DBConn.WithTransaction(func(tx db.Connection) error {
+			// Unlink the branch from its parents and remove the branches together so we never end up with parents referencing removed branches
+			parents, err = matcher.FindParents(tx, id)
+			if err != nil {
+				return err
+			}
+			for _, parent := range parents {
+				for idx, branchID := range parent.Branches {
+					if branchID == id {
+						parent.Branches = append(parent.Branches[:idx], parent.Branches[idx+1:]...)
+						break
+					}
+				}
+				err = tx.UpdateByID(&parent)
+				if err != nil {
+					return err
+				}
+			}
 
-		err = ctx.DBConn.DeleteByID(&matcher.Branch{}, branchIDs...)
+			return tx.DeleteByID(&matcher.Branch{}, branchIDs...)
+		})
 		if err != nil {
 			return err
 		}
@@ -225,3 +220,216 @@ var routeDeleteMatcherBranch = routeBuilder.R{
 		})
 	},
 }
+
+// optionalBranchIDParam returns the id route parameter, nil is returned for routes without an id that target the root of the tree
+func optionalBranchIDParam(c *fiber.Ctx) (*primitive.ObjectID, error) {
+	idParam := c.Params("id")
+	if idParam == "" {
+		return nil, nil
+	}
+	id, err := primitive.ObjectIDFromHex(idParam)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// transferFormat returns the format query parameter of the import and export routes
+func transferFormat(c *fiber.Ctx) (csv bool, err error) {
+	switch c.Query("format", "json") {
+	case "json":
+		return false, nil
+	case "csv":
+		return true, nil
+	default:
+		return false, errors.New("unknown format, expected json or csv")
+	}
+}
+
+var routeExportMatcherTree = routeBuilder.R{
+	Description: "export the matcher tree or a spesific branch and its sub branches\n" +
+		"By default the tree is exported in a nested json format, use ?format=csv to export it in the csv format with the columns id, parent, kind and titles",
+	Res: []matcher.TreeNode{},
+	Fn: func(c *fiber.Ctx) error {
+		branchID, err := optionalBranchIDParam(c)
+		if err != nil {
+			return err
+		}
+		asCSV, err := transferFormat(c)
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+
+		ctx := ctx.Get(c)
+
+		nodes, err := (&matcher.Tree{}).Export(ctx.DBConn, branchID)
+		if err != nil {
+			return matcherTreeErrorRes(c, err)
+		}
+
+		if !asCSV {
+			return c.JSON(nodes)
+		}
+		c.Response().Header.SetContentType("text/csv; charset=utf-8")
+		return matcher.WriteCSV(c, nodes)
+	},
+}
+
+var routeImportMatcherTree = routeBuilder.R{
+	Description: "import branches into the root of the matcher tree or into a spesific branch\n" +
+		"The body uses the same format as the export route, use ?format=csv to import a csv file.\n" +
+		"Branches are matched on their titles with the existing branches of the same parent so the same data can be imported multiple times, " +
+		"matched branches get the missing titles and new branches are added",
+	Body: []matcher.TreeNode{},
+	Res:  matcher.ImportResult{},
+	Fn: func(c *fiber.Ctx) error {
+		branchID, err := optionalBranchIDParam(c)
+		if err != nil {
+			return err
+		}
+		asCSV, err := transferFormat(c)
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+
+		nodes := []matcher.TreeNode{}
+		if asCSV {
+			nodes, err = matcher.ReadCSV(bytes.NewReader(c.Body()))
+		} else {
+			err = json.Unmarshal(c.Body(), &nodes)
+		}
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+
+		ctx := ctx.Get(c)
+
+		result, err := (&matcher.Tree{}).Import(ctx.DBConn, branchID, nodes)
+		if errors.Is(err, matcher.ErrBranchNotFound) {
+			return ErrorRes(c, fiber.StatusNotFound, err)
+		}
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+		if result.Added > 0 || result.Updated > 0 {
+			ctx.ResetMatcherTreeCache()
+		}
+
+		return c.JSON(result)
+	},
+}
+
+// matcherTreeErrorRes returns the matching error response for errors of the matcher tree methods
+func matcherTreeErrorRes(c *fiber.Ctx, err error) error {
+	if errors.Is(err, matcher.ErrBranchNotFound) {
+		return ErrorRes(c, fiber.StatusNotFound, err)
+	}
+	if errors.Is(err, matcher.ErrCycle) {
+		return ErrorRes(c, fiber.StatusBadRequest, err)
+	}
+	return err
+}
+
+// MoveMatcherBranchProps is the body of the move matcher branch route
+type MoveMatcherBranchProps struct {
+	ParentID *primitive.ObjectID `json:"parentId" description:"the new parent of the branch, null to move the branch to the root of the tree"`
+}
+
+var routeMoveMatcherBranch = routeBuilder.R{
+	Description: "move a branch and its sub branches to another parent, the branch is removed from all its current parents",
+	Body:        MoveMatcherBranchProps{},
+	Res:         matcher.Branch{},
+	Fn: func(c *fiber.Ctx) error {
+		id, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return err
+		}
+
+		body := MoveMatcherBranchProps{}
+		err = c.BodyParser(&body)
+		if err != nil {
+			return err
+		}
+
+		ctx := ctx.Get(c)
+
+		err = matcher.MoveBranch(ctx.DBConn, id, body.ParentID)
+		if err != nil {
+			return matcherTreeErrorRes(c, err)
+		}
+		ctx.ResetMatcherTreeCache()
+
+		branch, err := (&matcher.Tree{}).GetBranch(ctx.DBConn, &id)
+		if err != nil {
+			return err
+		}
+		return c.JSON(branch)
+	},
+}
+
+// MergeMatcherBranchesProps is the body of the merge matcher branches route
+type MergeMatcherBranchesProps struct {
+	BranchIDs []primitive.ObjectID `json:"branchIds" description:"the branches to merge into the branch of the url"`
+}
+
+var routeMergeMatcherBranches = routeBuilder.R{
+	Description: "merge branches into a spesific branch\n" +
+		"The titles and sub branches of the merged branches are added to the branch, the merged branches are removed and the profiles linked to them are linked to the branch",
+	Body: MergeMatcherBranchesProps{},
+	Res:  matcher.Branch{},
+	Fn: func(c *fiber.Ctx) error {
+		id, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return err
+		}
+
+		body := MergeMatcherBranchesProps{}
+		err = c.BodyParser(&body)
+		if err != nil {
+			return err
+		}
+
+		ctx := ctx.Get(c)
+
+		err = matcher.MergeBranches(ctx.DBConn, id, body.BranchIDs)
+		if err != nil {
+			return matcherTreeErrorRes(c, err)
+		}
+		ctx.ResetMatcherTreeCache()
+
+		branch, err := (&matcher.Tree{}).GetBranch(ctx.DBConn, &id)
+		if err != nil {
+			return err
+		}
+		return c.JSON(branch)
+	},
+}
+
+var routeGetMatcherTreeIntegrity = routeBuilder.R{
+	Description: "check the matcher tree for references to branches that do not exist, cycles, orphans and duplicate titles",
+	Res:         matcher.IntegrityReport{},
+	Fn: func(c *fiber.Ctx) error {
+		report, err := matcher.CheckIntegrity(ctx.Get(c).DBConn, false)
+		if err != nil {
+			return err
+		}
+		return c.JSON(report)
+	},
+}
+
+var routeRepairMatcherTreeIntegrity = routeBuilder.R{
+	Description: "check the matcher tree like the get route and repair the found problems",
+	Res:         matcher.IntegrityReport{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctx.Get(c)
+
+		report, err := matcher.CheckIntegrity(ctx.DBConn, true)
+		if err != nil {
+			return err
+		}
+		if !report.Ok() {
+			ctx.ResetMatcherTreeCache()
+		}
+		return c.JSON(report)
+	},
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/script-development/RT-CV/helpers/routeBuilder"
+	"github.com/script-development/RT-CV/models/matcher"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestMatcherTreeImportExport(t *testing.T) {
+	app := newTestingRouter(t)
+
+	csvTree := "id,parent,kind,titles\n" +
+		"1,,sector,Transport\n" +
+		"2,1,job,Vrachtwagenchauffeur|Truckchauffeur\n"
+
+	res, body := app.MakeRequest(routeBuilder.Post, `/api/v1/matcherTree/import?format=csv`, TestReqOpts{Body: []byte(csvTree)})
+	Equal(t, 200, res.StatusCode, string(body))
+	result := matcher.ImportResult{}
+	err := json.Unmarshal(body, &result)
+	NoError(t, err)
+	Equal(t, 2, result.Added)
+
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/matcherTree/export?format=csv`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(body))
+	Equal(t, csvTree, string(body))
+
+	transportID := result.Changes[0].BranchID.Hex()
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/matcherTree/`+transportID+`/import`, TestReqOpts{
+		Body: []byte(`[{"titles": ["Truckchauffeur"], "titleKind": 0}, {"titles": ["Piloot"], "titleKind": 0}]`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+	result = matcher.ImportResult{}
+	err = json.Unmarshal(body, &result)
+	NoError(t, err)
+	Equal(t, 1, result.Added)
+	Equal(t, 1, result.Unchanged)
+
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/matcherTree/`+transportID+`/export`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(body))
+	nodes := []matcher.TreeNode{}
+	err = json.Unmarshal(body, &nodes)
+	NoError(t, err)
+	if Len(t, nodes, 1) {
+		Len(t, nodes[0].Branches, 2)
+	}
+
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/matcherTree/000000000000000000000000/export`, TestReqOpts{})
+	Equal(t, 404, res.StatusCode, string(body))
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/matcherTree/export?format=xml`, TestReqOpts{})
+	Equal(t, 400, res.StatusCode, string(body))
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/matcherTree/import`, TestReqOpts{Body: []byte(`[{"titles": []}]`)})
+	Equal(t, 400, res.StatusCode, string(body))
+}
+
+func TestMatcherTreeMoveMergeIntegrity(t *testing.T) {
+	app := newTestingRouter(t)
+
+	res, body := app.MakeRequest(routeBuilder.Post, `/api/v1/matcherTree/import`, TestReqOpts{
+		Body: []byte(`[
+			{"titles": ["Transport"], "titleKind": 1, "branches": [{"titles": ["Chauffeur"], "titleKind": 0}]},
+			{"titles": ["Logistiek"], "titleKind": 1, "branches": [{"titles": ["Driver"], "titleKind": 0}]}
+		]`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+	result := matcher.ImportResult{}
+	err := json.Unmarshal(body, &result)
+	NoError(t, err)
+	transportID := result.Changes[0].BranchID.Hex()
+	chauffeurID := result.Changes[1].BranchID.Hex()
+	logisticsID := result.Changes[2].BranchID.Hex()
+	driverID := result.Changes[3].BranchID.Hex()
+
+	// A branch cannot be moved into its own sub branches
+	res, body = app.MakeRequest(routeBuilder.Put, `/api/v1/matcherTree/`+transportID+`/move`, TestReqOpts{
+		Body: []byte(`{"parentId": "` + chauffeurID + `"}`),
+	})
+	Equal(t, 400, res.StatusCode, string(body))
+
+	res, body = app.MakeRequest(routeBuilder.Put, `/api/v1/matcherTree/`+driverID+`/move`, TestReqOpts{
+		Body: []byte(`{"parentId": "` + transportID + `"}`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/matcherTree/`+transportID+`/merge`, TestReqOpts{
+		Body: []byte(`{"branchIds": ["` + logisticsID + `"]}`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+	branch := matcher.Branch{}
+	err = json.Unmarshal(body, &branch)
+	NoError(t, err)
+	Equal(t, []string{"Transport", "Logistiek"}, branch.Titles)
+	Len(t, branch.Branches, 2)
+
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/matcherTree/`+logisticsID, TestReqOpts{})
+	Equal(t, 404, res.StatusCode, string(body))
+
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/matcherTree/integrity`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(body))
+	report := matcher.IntegrityReport{}
+	err = json.Unmarshal(body, &report)
+	NoError(t, err)
+	True(t, report.Ok(), string(body))
+}
+
+func TestMatcherTreeSearch(t *testing.T) {
+	app := newTestingRouter(t)
+
+	res, body := app.MakeRequest(routeBuilder.Post, `/api/v1/matcherTree/import`, TestReqOpts{
+		Body: []byte(`[
+			{"titles": ["Chauffeur"], "titleKind": 0, "branches": [{"titles": ["Vrachtwagenchauffeur"], "titleKind": 0}]},
+			{"titles": ["Taxi chauffeur"], "titleKind": 0}
+		]`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/matcherTree/search?limit=2`, TestReqOpts{
+		Body: []byte(`{"search": "chauffeur"}`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+	Equal(t, "3", res.Header.Get("X-Total-Count"))
+	results := []matcher.SearchResult{}
+	err := json.Unmarshal(body, &results)
+	NoError(t, err)
+	if Len(t, results, 2) {
+		Equal(t, "Chauffeur", results[0].Title)
+		Equal(t, "Taxi chauffeur", results[1].Title)
+	}
+
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/matcherTree/search?limit=2&cursor=`+res.Header.Get("X-Next-Cursor"), TestReqOpts{
+		Body: []byte(`{"search": "chauffeur"}`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+	Empty(t, res.Header.Get("X-Next-Cursor"))
+	results = []matcher.SearchResult{}
+	err = json.Unmarshal(body, &results)
+	NoError(t, err)
+	if Len(t, results, 1) {
+		Equal(t, "Vrachtwagenchauffeur", results[0].Title)
+	}
+}
@@ -21,27 +21,53 @@ import (
 )
 
 var routeGetOnMatchHooks = routeBuilder.R{
-	Description: "Get the entries for what to do on a match",
-	Res:         []models.OnMatchHook{},
+	Description: "Get the entries for what to do on a match.\n\n" + listPaginationDescription +
+		" Hooks can be sorted on id and url.",
+	Res: []models.OnMatchHook{},
 	Fn: func(c *fiber.Ctx) error {
+		page, err := parseListPage(c, hookSortFields)
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+
+		ctx := ctx.Get(c)
+		filter := bson.M{}
+		if ctx.Key.Scope.RestrictsHooks() {
+			filter["_id"] = bson.M{"$in": ctx.Key.Scope.HookIDs}
+		}
+
+		total, err := ctx.DBConn.Count(&models.OnMatchHook{}, filter)
+		if err != nil {
+			return err
+		}
 		results := []models.OnMatchHook{}
-		err := ctx.Get(c).DBConn.Find(&models.OnMatchHook{}, &results, nil)
+		err = ctx.DBConn.Find(&models.OnMatchHook{}, &results, filter, page.findOptions(hookSortFields))
 		if err != nil {
 			return err
 		}
+		page.setHeaders(c, len(results), total)
 		return c.JSON(results)
 	},
 }
 
+// hookSortFields maps the fields hooks can be sorted on to their database fields
+var hookSortFields = map[string]string{
+	"id":  "_id",
+	"url": "url",
+}
+
 // CreateOrUpdateOnMatchHookRequestData contains the post data for creating and modifiying a OnMatchHook
 type CreateOrUpdateOnMatchHookRequestData struct {
 	Disabled   *bool           `json:"disabled"`
 	Method     *string         `json:"method"`
 	URL        *string         `json:"url"`
 	AddHeaders []models.Header `json:"addHeaders"`
+
+	TenantID     *primitive.ObjectID `json:"tenantId" description:"the tenant the hook belongs to, can only be set by keys without a tenant, a hook only receives matches of profiles of the same tenant"`
+	RemoveTenant bool                `json:"removeTenant" description:"only used when updating a hook"`
 }
 
-func (data *CreateOrUpdateOnMatchHookRequestData) applyToHook(hook *models.OnMatchHook, isCreate bool) error {
+func (data *CreateOrUpdateOnMatchHookRequestData) applyToHook(conn db.Connection, hook *models.OnMatchHook, isCreate bool) error {
 	if data.Disabled != nil {
 		hook.Disabled = *data.Disabled
 	}
@@ -85,7 +111,7 @@ func (data *CreateOrUpdateOnMatchHookRequestData) applyToHook(hook *models.OnMat
 		hook.AddHeaders = []models.Header{}
 	}
 
-	return nil
+	return applyTenantChange(conn, &hook.T, data.TenantID, data.RemoveTenant)
 }
 
 var routeCreateOnMatchHooks = routeBuilder.R{
@@ -100,11 +126,16 @@ var routeCreateOnMatchHooks = routeBuilder.R{
 		}
 		ctx := ctx.Get(c)
 
+		if ctx.Key.Scope.RestrictsHooks() {
+			// A key limited to specific hooks would not be able to access the newly created hook
+			return ErrorRes(c, fiber.StatusForbidden, errors.New("your key is limited to specific hooks and cannot create new ones"))
+		}
+
 		hook := models.OnMatchHook{
 			M:     db.NewM(),
 			KeyID: ctx.Key.ID,
 		}
-		err = body.applyToHook(&hook, true)
+		err = body.applyToHook(ctx.DBConn, &hook, true)
 		if err != nil {
 			return err
 		}
@@ -143,7 +174,7 @@ var routeUpdateOnMatchHook = routeBuilder.R{
 		}
 		ctx := ctx.Get(c)
 
-		err = body.applyToHook(ctx.OnMatchHook, false)
+		err = body.applyToHook(ctx.DBConn, ctx.OnMatchHook, false)
 		if err != nil {
 			return err
 		}
@@ -254,6 +285,10 @@ func middlewareBindHook() routeBuilder.M {
 				return err
 			}
 			ctx := ctx.Get(c)
+			if !ctx.Key.Scope.HookAllowed(hookID) {
+				return ErrorRes(c, fiber.StatusForbidden, errOutsideKeyScope)
+			}
+
 			hook := models.OnMatchHook{}
 			query := bson.M{"_id": hookID}
 			args := db.FindOptions{NoDefaultFilters: true}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxListLimit is the max number of items a list route returns in one page
+const maxListLimit = 1000
+
+// listPaginationDescription is added to the description of list routes
+const listPaginationDescription = "The results can be paginated using the limit and cursor query parameters, " +
+	"the cursor for the next page is returned in the X-Next-Cursor header when there might be more results and the total number of results is returned in the X-Total-Count header. " +
+	"The sort query parameter sorts the results, prefix the field with a - for descending order."
+
+// listPage contains the ?limit=&cursor=&sort= query parameters of a list route
+type listPage struct {
+	// Sort is the field to sort on as exposed by the api, prefixed with a - for descending order
+	Sort string
+	// Limit is the max number of items returned, 0 means no limit
+	Limit int
+	// Offset is the number of items to skip, obtained from the cursor
+	Offset int
+}
+
+// listCursor is the content of the opaque cursor send to the client
+type listCursor struct {
+	Offset int    `json:"o"`
+	Sort   string `json:"s,omitempty"`
+}
+
+// parseListPage parses the pagination query parameters
+// sortFields maps the fields that can be sorted on to their database field names
+func parseListPage(c *fiber.Ctx, sortFields map[string]string) (listPage, error) {
+	page := listPage{Sort: c.Query("sort")}
+
+	if page.Sort != "" {
+		if _, ok := sortFields[strings.TrimPrefix(page.Sort, "-")]; !ok {
+			return page, fmt.Errorf("sort must be one of %s optionally prefixed with a -", strings.Join(sortOptions(sortFields), ", "))
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		page.Limit, err = strconv.Atoi(limit)
+		if err != nil || page.Limit < 1 || page.Limit > maxListLimit {
+			return page, fmt.Errorf("limit must be a number between 1 and %d", maxListLimit)
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		cursorJSON, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return page, errors.New("invalid cursor")
+		}
+		parsedCursor := listCursor{}
+		err = json.Unmarshal(cursorJSON, &parsedCursor)
+		if err != nil || parsedCursor.Offset < 0 {
+			return page, errors.New("invalid cursor")
+		}
+		if parsedCursor.Sort != page.Sort {
+			return page, errors.New("cursor was created for another sort order")
+		}
+		page.Offset = parsedCursor.Offset
+	}
+
+	return page, nil
+}
+
+// sortOptions returns the sortable fields in a stable order for error messages
+func sortOptions(sortFields map[string]string) []string {
+	options := make([]string, 0, len(sortFields))
+	for field := range sortFields {
+		options = append(options, field)
+	}
+	sort.Strings(options)
+	return options
+}
+
+// findOptions converts the page into database find options
+// Ties are sorted by id so pages are stable
+func (p listPage) findOptions(sortFields map[string]string) db.FindOptions {
+	if p.Sort == "" && p.Limit == 0 && p.Offset == 0 {
+		// Nothing is requested, keep the natural order of the results
+		return db.FindOptions{}
+	}
+
+	direction := 1
+	if strings.HasPrefix(p.Sort, "-") {
+		direction = -1
+	}
+
+	sortOrder := bson.D{}
+	if field, ok := sortFields[strings.TrimPrefix(p.Sort, "-")]; ok && field != "_id" {
+		sortOrder = append(sortOrder, bson.E{Key: field, Value: direction})
+	}
+	sortOrder = append(sortOrder, bson.E{Key: "_id", Value: direction})
+
+	return db.FindOptions{
+		Limit: int64(p.Limit),
+		Skip:  int64(p.Offset),
+		Sort:  sortOrder,
+	}
+}
+
+// setHeaders sets the X-Total-Count and X-Next-Cursor headers
+func (p listPage) setHeaders(c *fiber.Ctx, returned int, total uint64) {
+	c.Set("X-Total-Count", strconv.FormatUint(total, 10))
+
+	nextOffset := p.Offset + returned
+	if p.Limit == 0 || returned < p.Limit || uint64(nextOffset) >= total {
+		return
+	}
+	cursorJSON, _ := json.Marshal(listCursor{Offset: nextOffset, Sort: p.Sort})
+	c.Set("X-Next-Cursor", base64.RawURLEncoding.EncodeToString(cursorJSON))
+}
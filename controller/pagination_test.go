@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/routeBuilder"
+	"github.com/script-development/RT-CV/models"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestListPagination(t *testing.T) {
+	app := newTestingRouter(t)
+
+	keyNames := func(body []byte) []string {
+		keys := []models.APIKey{}
+		err := json.Unmarshal(body, &keys)
+		NoError(t, err)
+		names := []string{}
+		for _, key := range keys {
+			names = append(names, key.Name)
+		}
+		return names
+	}
+
+	res, body := app.MakeRequest(routeBuilder.Get, `/api/v1/keys?sort=name&limit=3`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode)
+	Equal(t, []string{"Information obtainer key", "Key with all roles", "Scraper key"}, keyNames(body))
+	Equal(t, "4", res.Header.Get("X-Total-Count"))
+	cursor := res.Header.Get("X-Next-Cursor")
+	NotEmpty(t, cursor)
+
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/keys?sort=name&limit=3&cursor=`+cursor, TestReqOpts{})
+	Equal(t, 200, res.StatusCode)
+	Equal(t, []string{"System login key"}, keyNames(body))
+	Empty(t, res.Header.Get("X-Next-Cursor"))
+
+	// Disabled keys are not listed so they should not be counted
+	err := app.db.Insert(&models.APIKey{M: db.NewM(), Name: "Disabled key", Enabled: false, Roles: models.APIKeyRoleScraper})
+	NoError(t, err)
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/keys?sort=name&limit=4`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode)
+	Len(t, keyNames(body), 4)
+	Equal(t, "4", res.Header.Get("X-Total-Count"))
+	Empty(t, res.Header.Get("X-Next-Cursor"))
+
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/keys?sort=-name&limit=1`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode)
+	Equal(t, []string{"System login key"}, keyNames(body))
+
+	invalidRequests := []struct {
+		name  string
+		route string
+	}{
+		{"unknown sort field", `/api/v1/keys?sort=key`},
+		{"limit too low", `/api/v1/keys?limit=0`},
+		{"limit too high", `/api/v1/keys?limit=100000`},
+		{"limit not a number", `/api/v1/keys?limit=ten`},
+		{"invalid cursor", `/api/v1/keys?cursor=not-a-cursor`},
+		{"cursor of other sort order", `/api/v1/keys?sort=-name&cursor=` + cursor},
+		{"hooks unknown sort field", `/api/v1/onMatchHooks?sort=method`},
+		{"profiles unknown sort field", `/api/v1/profiles?sort=email`},
+	}
+	for _, s := range invalidRequests {
+		t.Run(s.name, func(t *testing.T) {
+			res, _ := app.MakeRequest(routeBuilder.Get, s.route, TestReqOpts{})
+			Equal(t, 400, res.StatusCode)
+		})
+	}
+
+	t.Run("profiles", func(t *testing.T) {
+		res, body := app.MakeRequest(routeBuilder.Get, `/api/v1/profiles?sort=-name&limit=1`, TestReqOpts{})
+		Equal(t, 200, res.StatusCode)
+		profiles := []models.Profile{}
+		err := json.Unmarshal(body, &profiles)
+		NoError(t, err)
+		Len(t, profiles, 1)
+		Equal(t, "Mock profile 2", profiles[0].Name)
+		Equal(t, "2", res.Header.Get("X-Total-Count"))
+
+		res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/profiles?sort=-name&limit=1&cursor=`+res.Header.Get("X-Next-Cursor"), TestReqOpts{})
+		Equal(t, 200, res.StatusCode)
+		err = json.Unmarshal(body, &profiles)
+		NoError(t, err)
+		Len(t, profiles, 1)
+		Equal(t, "Mock profile 1", profiles[0].Name)
+		Empty(t, res.Header.Get("X-Next-Cursor"))
+	})
+}
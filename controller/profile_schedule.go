@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/models"
+)
+
+// StartProfileScheduler checks every minute if profiles are activated or paused by their schedule or match budget
+// The status changes are send to the on match hooks of the tenant of the profile
+func StartProfileScheduler(dbConn db.Connection) {
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		checkProfileSchedules(dbConn, time.Now())
+		for now := range ticker.C {
+			checkProfileSchedules(dbConn, now)
+		}
+	}()
+}
+
+func checkProfileSchedules(dbConn db.Connection, now time.Time) {
+	events, err := models.CheckProfileSchedules(dbConn, now)
+	if err != nil {
+		log.WithError(err).Error("checking the profile schedules failed")
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	hooks, err := models.GetOnMatchHooks(db.WithoutTenant(dbConn), models.GetOnMatchHooksProps{
+		AllowDisabled:    false,
+		ExpectAtLeastOne: true,
+	})
+	if err != nil {
+		log.WithError(err).Error("Finding on match hooks failed")
+		return
+	}
+
+	logger := log.WithField("kind", "profileStatus")
+	for _, event := range events {
+		logger.WithField("profile", event.ProfileID.Hex()).Infof("profile status changed from %s to %s", event.PreviousStatus, event.Status)
+		sendProfileStatusEvent(hooks, event, logger)
+	}
+}
+
+// sendProfileStatusEvent sends event to the hooks of the tenant of the profile
+func sendProfileStatusEvent(hooks []models.OnMatchHook, event models.ProfileStatusEvent, logger *log.Entry) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.WithError(err).Error("creating hook data failed")
+		return
+	}
+
+	for _, hook := range hooks {
+		if !db.SameTenant(event.TenantID, hook.TenantID) {
+			continue
+		}
+		hook.CallAndLogResult(bytes.NewReader(data), models.DataKindProfileStatus, logger)
+	}
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"errors"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gofiber/fiber/v2"
+	ctxPkg "github.com/script-development/RT-CV/controller/ctx"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/match"
+	"github.com/script-development/RT-CV/helpers/routeBuilder"
+	"github.com/script-development/RT-CV/models"
+	"github.com/script-development/RT-CV/models/matcher"
+)
+
+var errCVHistoryNotEnabled = errors.New("the cv history is not enabled on this server, profiles can only be simulated if the cv history is enabled")
+
+// StartCVHistoryPruner removes the expired CVs from the cv history every hour
+// MongoDB also removes them by itself but the other databases do not
+func StartCVHistoryPruner(dbConn db.Connection, cvHistory *models.CVHistory) {
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		pruneCVHistory(dbConn, cvHistory, time.Now())
+		for now := range ticker.C {
+			pruneCVHistory(dbConn, cvHistory, now)
+		}
+	}()
+}
+
+func pruneCVHistory(dbConn db.Connection, cvHistory *models.CVHistory, now time.Time) {
+	_, err := cvHistory.Prune(dbConn, now)
+	if err != nil {
+		log.WithError(err).Error("removing the expired CVs from the cv history failed")
+	}
+}
+
+// middlewareRequiresCVHistory only allows the request if the cv history is enabled
+func middlewareRequiresCVHistory() routeBuilder.M {
+	return routeBuilder.M{
+		Fn: func(c *fiber.Ctx) error {
+			if ctxPkg.Get(c).CVHistory == nil {
+				return ErrorRes(c, fiber.StatusNotFound, errCVHistoryNotEnabled)
+			}
+			return c.Next()
+		},
+	}
+}
+
+// simulationData returns the matcher tree and the CVs of the cv history to simulate profiles with
+func simulationData(ctx *ctxPkg.Ctx) (*matcher.Tree, []models.HistoricCV, error) {
+	profilesCache, err := ctx.GetOrGenMatcherProfilesCache()
+	if err != nil {
+		return nil, nil, err
+	}
+	cvs, err := ctx.CVHistory.CVs(ctx.DBConn, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	return profilesCache.Tree, cvs, nil
+}
+
+const simulationDescription = "The profile is tested against the recently scanned CVs kept by the cv history as if it was active while the CVs where scanned, " +
+	"its active state, schedule and match budget are ignored. Nothing is stored and no hooks are called. " +
+	"The cv history is opt-in, if it's not enabled on this server the response has status 404."
+
+var routeSimulateProfile = routeBuilder.R{
+	Description: "test a draft profile against the recently scanned CVs to see how many matches it would have made.\n\n" +
+		simulationDescription + "\n\n" + profileValidationDescription,
+	Res:  match.SimulationResult{},
+	Body: models.Profile{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+
+		var profile models.Profile
+		err := c.BodyParser(&profile)
+		if err != nil {
+			return err
+		}
+
+		err = profile.Validate(ctx.DBConn, matcher.FindMissingBranches)
+		if err != nil {
+			return profileValidationErrorRes(c, err)
+		}
+
+		tree, cvs, err := simulationData(ctx)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(match.Simulate(tree, profile, cvs))
+	},
+}
+
+var routeSimulateProfileChanges = routeBuilder.R{
+	Description: "compare the matches of the current version of a profile with the matches it would have made with the changes of the body.\n\n" +
+		"The body is equal to the body of the modify profile route, the profile itself is not modified. " +
+		simulationDescription + "\n\n" + profileValidationDescription,
+	Res:  match.SimulationComparison{},
+	Body: UpdateProfileReq{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+
+		var body UpdateProfileReq
+		err := c.BodyParser(&body)
+		if err != nil {
+			return err
+		}
+
+		// The fields of the profile are replaced and not modified so a shallow copy is enough to keep the current version
+		edited := *ctx.Profile
+		err = body.apply(&edited)
+		if err != nil {
+			return err
+		}
+		err = edited.ValidateChanges(ctx.DBConn, matcher.FindMissingBranches, ctx.Profile)
+		if err != nil {
+			return profileValidationErrorRes(c, err)
+		}
+
+		tree, cvs, err := simulationData(ctx)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(match.Compare(tree, *ctx.Profile, edited, cvs))
+	},
+}
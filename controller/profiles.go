@@ -1,51 +1,175 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/apex/log"
 	"github.com/gofiber/fiber/v2"
 	ctxPkg "github.com/script-development/RT-CV/controller/ctx"
 	"github.com/script-development/RT-CV/db"
 	"github.com/script-development/RT-CV/helpers/routeBuilder"
 	"github.com/script-development/RT-CV/models"
+	"github.com/script-development/RT-CV/models/matcher"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var routeAllProfiles = routeBuilder.R{
-	Description: "get all profiles stored in the database",
-	Res:         []models.Profile{},
+	Description: "get all profiles stored in the database.\n\n" + listPaginationDescription +
+		" Profiles can be sorted on id, name, yearsSinceWork and yearsSinceEducation.",
+	Res: []models.Profile{},
 	Fn: func(c *fiber.Ctx) error {
-		profiles, err := models.GetProfiles(ctxPkg.Get(c).DBConn, nil)
+		return sendProfilesPage(c, nil)
+	},
+}
+
+// profileSortFields maps the fields profiles can be sorted on to their database fields
+var profileSortFields = models.ProfileSortFields()
+
+// sendProfilesPage responds with the requested page of the profiles matching filter
+func sendProfilesPage(c *fiber.Ctx, filter primitive.M) error {
+	page, err := parseListPage(c, profileSortFields)
+	if err != nil {
+		return ErrorRes(c, fiber.StatusBadRequest, err)
+	}
+
+	ctx := ctxPkg.Get(c)
+	if ctx.Key.Scope.RestrictsProfiles() {
+		// The scope can only be applied after fetching the profiles so we paginate in memory
+		// Otherwise pages would be partially empty
+		profiles, err := models.GetProfiles(ctx.DBConn, filter)
 		if err != nil {
 			return err
 		}
+		profiles = ctx.Key.Scope.FilterProfiles(profiles)
+		total := uint64(len(profiles))
+		profiles = models.ProfileQueryPage{Sort: page.Sort, Limit: page.Limit, Offset: page.Offset}.Apply(profiles)
+		page.setHeaders(c, len(profiles), total)
 		return c.JSON(profiles)
-	},
+	}
+
+	total, err := ctx.DBConn.Count(&models.Profile{}, filter)
+	if err != nil {
+		return err
+	}
+	profiles, err := models.GetProfiles(ctx.DBConn, filter, page.findOptions(profileSortFields))
+	if err != nil {
+		return err
+	}
+	page.setHeaders(c, len(profiles), total)
+	return c.JSON(profiles)
 }
 
-const exampleQuery = `{"labels": {"key": "value", "other_key": {"$exists": true}}}`
+const exampleQuery = `{"labels.key": "value", "labels.other_key": {"$exists": true}, "$or": [{"name": {"$contains": "developer"}}, {"yearsSinceWork": {"$lte": 3}}]}`
 
 var routeQueryProfiles = routeBuilder.R{
 	Description: strings.Join([]string{
-		"make a MongoDB query directly against the database.",
-		"For more info about mongodb queries you can take a look at: https://www.mongodb.com/docs/manual/tutorial/query-documents/#std-label-read-operations-query-argument",
+		"search for profiles using a query that looks like a MongoDB filter.",
+		"Only a limited set of fields and operators can be used:",
+		"- fields: id, tenantId, name, active, listsAllowed, allowedScrapers, mustDesiredProfession, desiredProfessions.name, yearsSinceWork, mustExpProfession, professionExperienced.name, mustDriversLicense, driversLicenses.name, mustEducationFinished, mustEducation, yearsSinceEducation, educations.name, zipCodes.from, zipCodes.to, onMatch.sendMail.email and labels.<key>",
+		"- operators: $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists, $contains (case insensitive text search), $and, $or and $nor",
 		"An example query would be something like: `" + exampleQuery + "`",
-		"Note that you can't filter for the _id field",
+		listPaginationDescription + " Profiles can be sorted on id, name, yearsSinceWork and yearsSinceEducation.",
 	}, "\n\n"),
 	Body: primitive.M{},
 	Res:  []models.Profile{},
 	Fn: func(c *fiber.Ctx) error {
-		body := primitive.M{}
-		err := c.BodyParser(&body)
+		query, err := models.ParseProfileQuery(c.Body())
 		if err != nil {
-			return err
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+
+		return sendProfilesPage(c, query.Filter())
+	},
+}
+
+var routeExportProfiles = routeBuilder.R{
+	Description: strings.Join([]string{
+		"export the profiles matching a query, the body uses the same query format as the query route and an empty body exports all profiles.",
+		"By default the profiles are exported as a json array, use ?format=csv to export them in the csv format used by the import route.",
+	}, "\n\n"),
+	Body: primitive.M{},
+	Res:  []models.Profile{},
+	Fn: func(c *fiber.Ctx) error {
+		asCSV, err := transferFormat(c)
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+		query, err := models.ParseProfileQuery(c.Body())
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
 		}
 
-		profiles, err := models.GetProfiles(ctxPkg.Get(c).DBConn, body)
+		ctx := ctxPkg.Get(c)
+		profiles, err := models.GetProfiles(ctx.DBConn, query.Filter(), db.FindOptions{Sort: primitive.D{{Key: "_id", Value: 1}}})
 		if err != nil {
 			return err
 		}
-		return c.JSON(profiles)
+		profiles = ctx.Key.Scope.FilterProfiles(profiles)
+
+		if !asCSV {
+			return c.JSON(profiles)
+		}
+		c.Response().Header.SetContentType("text/csv; charset=utf-8")
+		return models.WriteProfilesCSV(c, profiles)
+	},
+}
+
+var routeImportProfiles = routeBuilder.R{
+	Description: strings.Join([]string{
+		"create or update many profiles at once.",
+		"The body is a json array of profiles or, with ?format=csv, a csv file with a header row. " +
+			"The csv columns are externalId, name, active, listsAllowed, allowedScrapers, mustDesiredProfession, desiredProfessions, yearsSinceWork, mustExpProfession, professionExperienced, " +
+			"mustDriversLicense, driversLicenses, mustEducationFinished, mustEducation, yearsSinceEducation, educations, zipCodes, sendMail, activeFrom, activeUntil, activeWindows, maxMatchesPerDay, maxMatchesTotal and labels. " +
+			"Only externalId and name are required. Lists are separated by a |, zip codes are formatted as from-to and activeWindows and labels are json.",
+		"Profiles are matched with existing profiles of the same tenant on the " + models.ProfileExternalIDLabel + " label, matched profiles are replaced and the others are created.",
+		"All profiles are validated before anything is saved, if one of them is invalid nothing is imported and the response has status 400 with the errors of every invalid row. " +
+			"If another request saved a profile with one of the external ids at the same time the response has status 409.",
+	}, "\n\n"),
+	Body: []models.Profile{},
+	Res:  models.ProfileImportResult{},
+	Fn: func(c *fiber.Ctx) error {
+		asCSV, err := transferFormat(c)
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+
+		profiles := []models.Profile{}
+		if asCSV {
+			profiles, err = models.ReadProfilesCSV(bytes.NewReader(c.Body()))
+		} else {
+			err = json.Unmarshal(c.Body(), &profiles)
+		}
+		if err != nil {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+
+		ctx := ctxPkg.Get(c)
+		var authorKeyID *primitive.ObjectID
+		if ctx.Key != nil {
+			keyID := ctx.Key.ID
+			authorKeyID = &keyID
+		}
+		result, err := models.ImportProfiles(ctx.DBConn, matcher.FindMissingBranches, authorKeyID, ctx.Key.Scope, profiles)
+		if errors.Is(err, models.ErrProfileImportInvalid) {
+			return c.Status(fiber.StatusBadRequest).JSON(result)
+		}
+		if errors.Is(err, models.ErrProfileImportTooLarge) {
+			return ErrorRes(c, fiber.StatusBadRequest, err)
+		}
+		if err != nil {
+			return profileWriteErrorRes(c, err)
+		}
+
+		if result.Created > 0 || result.Updated > 0 {
+			ctx.ResetMatcherProfilesCache()
+		}
+		return c.JSON(result)
 	},
 }
 
@@ -63,6 +187,23 @@ var routeGetProfilesCount = routeBuilder.R{
 	Fn: func(c *fiber.Ctx) error {
 		ctx := ctxPkg.Get(c)
 
+		if ctx.Key.Scope.RestrictsProfiles() {
+			// The key can only see a part of the profiles so we cannot use the count query
+			profiles, err := models.GetProfiles(ctx.DBConn, nil)
+			if err != nil {
+				return err
+			}
+			usableProfiles, err := models.GetActualMatchActiveProfiles(ctx.DBConn)
+			if err != nil {
+				return err
+			}
+
+			return c.JSON(RouteGetProfilesCountRes{
+				Total:  uint64(len(ctx.Key.Scope.FilterProfiles(profiles))),
+				Usable: uint64(len(ctx.Key.Scope.FilterProfiles(usableProfiles))),
+			})
+		}
+
 		profilesCount, err := models.GetProfilesCount(ctx.DBConn)
 		if err != nil {
 			return err
@@ -98,6 +239,10 @@ func middlewareBindProfile() routeBuilder.M {
 				return err
 			}
 
+			if !ctx.Key.Scope.ProfileAllowed(&profile) {
+				return ErrorRes(c, fiber.StatusForbidden, errOutsideKeyScope)
+			}
+
 			ctx.Profile = &profile
 
 			return c.Next()
@@ -114,7 +259,7 @@ var routeGetProfile = routeBuilder.R{
 }
 
 var routeCreateProfile = routeBuilder.R{
-	Description: "create a new profile that can match scraped CVs",
+	Description: "create a new profile that can match scraped CVs.\n\n" + profileValidationDescription,
 	Res:         models.Profile{},
 	Body:        models.Profile{},
 	Fn: func(c *fiber.Ctx) error {
@@ -126,18 +271,38 @@ var routeCreateProfile = routeBuilder.R{
 			return err
 		}
 
-		err = profile.ValidateCreateNewProfile(ctx.DBConn)
+		err = profile.Validate(ctx.DBConn, matcher.FindMissingBranches)
 		if err != nil {
-			return err
+			return profileValidationErrorRes(c, err)
+		}
+
+		if profile.TenantID != nil && db.TenantOf(ctx.DBConn) == nil {
+			err = models.CheckTenantExists(ctx.DBConn, *profile.TenantID)
+			if err != nil {
+				return err
+			}
 		}
 
 		// Set the ID of the profile
 		profile.M = db.NewM()
+		// These fields are maintained by RT-CV
+		profile.MatchCount = models.ProfileMatchCount{}
+		profile.LastScheduleStatus = nil
+
+		if !ctx.Key.Scope.ProfileAllowed(&profile) {
+			return ErrorRes(c, fiber.StatusForbidden, errOutsideKeyScope)
+		}
 
 		// Save the profile to the database
-		err = ctx.DBConn.Insert(&profile)
+		err = ctx.DBConn.WithTransaction(func(tx db.Connection) error {
+			err := tx.Insert(&profile)
+			if err != nil {
+				return err
+			}
+			return addProfileRevision(c, tx, models.ProfileRevisionCreated, nil, profile)
+		})
 		if err != nil {
-			return err
+			return profileWriteErrorRes(c, err)
 		}
 
 		// Invalidate profiles cache
@@ -174,15 +339,121 @@ type UpdateProfileReq struct {
 
 	ListsAllowed *bool `json:"listsAllowed"`
 
+	ActiveFrom       *time.Time                   `json:"activeFrom" description:"if set to 0001-01-01T00:00:00Z the active from date is removed"`
+	ActiveUntil      *time.Time                   `json:"activeUntil" description:"if set to 0001-01-01T00:00:00Z the active until date is removed"`
+	ActiveWindows    []models.ProfileActiveWindow `json:"activeWindows" description:"if null/undefined this value won't be updated, if empty array the profile can match at any time"`
+	MaxMatchesPerDay *int                         `json:"maxMatchesPerDay"`
+	MaxMatchesTotal  *int                         `json:"maxMatchesTotal"`
+
 	Lables map[string]any `json:"labels" description:"custom labels that can be used by API users to identify profiles, the key needs to be a string and the value can be anything"`
 
 	OnMatch *models.ProfileOnMatch `json:"onMatch"`
 }
 
+// apply updates profile with the fields set in the request
+func (body UpdateProfileReq) apply(profile *models.Profile) error {
+	if body.Name != nil {
+		profile.Name = *body.Name
+	}
+	if body.Active != nil {
+		profile.Active = *body.Active
+	}
+	if body.AllowedScrapers != nil {
+		allowedScrapersIDs := make([]primitive.ObjectID, len(body.AllowedScrapers))
+		for idx, id := range body.AllowedScrapers {
+			bsonID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return err
+			}
+			allowedScrapersIDs[idx] = bsonID
+		}
+		profile.AllowedScrapers = allowedScrapersIDs
+	}
+	if body.MustDesiredProfession != nil {
+		profile.MustDesiredProfession = *body.MustDesiredProfession
+	}
+	if body.DesiredProfessions != nil {
+		profile.DesiredProfessions = body.DesiredProfessions
+	}
+
+	if body.YearsSinceWork != nil {
+		if *body.YearsSinceWork == 0 {
+			profile.YearsSinceWork = nil
+		} else {
+			profile.YearsSinceWork = &*body.YearsSinceWork
+		}
+	}
+	if body.MustExpProfession != nil {
+		profile.MustExpProfession = *body.MustExpProfession
+	}
+	if body.ProfessionExperienced != nil {
+		profile.ProfessionExperienced = body.ProfessionExperienced
+	}
+	if body.MustDriversLicense != nil {
+		profile.MustDriversLicense = *body.MustDriversLicense
+	}
+	if body.DriversLicenses != nil {
+		profile.DriversLicenses = body.DriversLicenses
+	}
+	if body.MustEducationFinished != nil {
+		profile.MustEducationFinished = *body.MustEducationFinished
+	}
+	if body.MustEducation != nil {
+		profile.MustEducation = *body.MustEducation
+	}
+	if body.YearsSinceEducation != nil {
+		if *body.YearsSinceEducation == 0 {
+			profile.YearsSinceEducation = nil
+		} else {
+			profile.YearsSinceEducation = &*body.YearsSinceEducation
+		}
+	}
+	if body.Educations != nil {
+		profile.Educations = body.Educations
+	}
+	if body.Zipcodes != nil {
+		profile.Zipcodes = body.Zipcodes
+	}
+	if body.OnMatch != nil {
+		profile.OnMatch = *body.OnMatch
+	}
+	if body.Lables != nil {
+		profile.Lables = body.Lables
+	}
+	if body.ListsAllowed != nil {
+		profile.ListsAllowed = *body.ListsAllowed
+	}
+	if body.ActiveFrom != nil {
+		if body.ActiveFrom.IsZero() {
+			profile.ActiveFrom = nil
+		} else {
+			profile.ActiveFrom = body.ActiveFrom
+		}
+	}
+	if body.ActiveUntil != nil {
+		if body.ActiveUntil.IsZero() {
+			profile.ActiveUntil = nil
+		} else {
+			profile.ActiveUntil = body.ActiveUntil
+		}
+	}
+	if body.ActiveWindows != nil {
+		profile.ActiveWindows = body.ActiveWindows
+	}
+	if body.MaxMatchesPerDay != nil {
+		profile.MaxMatchesPerDay = *body.MaxMatchesPerDay
+	}
+	if body.MaxMatchesTotal != nil {
+		profile.MaxMatchesTotal = *body.MaxMatchesTotal
+	}
+	return nil
+}
+
 var routeModifyProfile = routeBuilder.R{
 	Description: strings.Join([]string{
 		"modify an existing profile.",
 		"All the top level body fields are optional thus you only have to provide the fields you want to update.",
+		profileValidationDescription + " Problems the profile already had before the edit, like a link to a removed matcher tree branch, do not block the edit.",
 	}, "\n\n"),
 	Res:  models.Profile{},
 	Body: UpdateProfileReq{},
@@ -195,108 +466,258 @@ var routeModifyProfile = routeBuilder.R{
 			return err
 		}
 
-		if body.Name != nil {
-			ctx.Profile.Name = *body.Name
+		// The fields of the profile are replaced and not modified so a shallow copy is enough to keep the old version
+		oldProfile := *ctx.Profile
+
+		err = body.apply(ctx.Profile)
+		if err != nil {
+			return err
 		}
-		if body.Active != nil {
-			ctx.Profile.Active = *body.Active
+		err = ctx.Profile.ValidateChanges(ctx.DBConn, matcher.FindMissingBranches, &oldProfile)
+		if err != nil {
+			return profileValidationErrorRes(c, err)
 		}
-		if body.AllowedScrapers != nil {
-			allowedScrapersIDs := make([]primitive.ObjectID, len(body.AllowedScrapers))
-			for idx, id := range body.AllowedScrapers {
-				bsonID, err := primitive.ObjectIDFromHex(id)
-				if err != nil {
-					return err
-				}
-				allowedScrapersIDs[idx] = bsonID
-			}
 
-			err = models.CheckAPIKeysExists(ctx.DBConn, allowedScrapersIDs)
+		// Make sure the key cannot move the profile outside of its own scope, for example by changing the labels
+		if !ctx.Key.Scope.ProfileAllowed(ctx.Profile) {
+			return ErrorRes(c, fiber.StatusForbidden, errOutsideKeyScope)
+		}
+
+		err = ctx.DBConn.WithTransaction(func(tx db.Connection) error {
+			err := models.UpdateProfileFields(tx, ctx.Profile)
 			if err != nil {
 				return err
 			}
-			ctx.Profile.AllowedScrapers = allowedScrapersIDs
-		}
-		if body.MustDesiredProfession != nil {
-			ctx.Profile.MustDesiredProfession = *body.MustDesiredProfession
-		}
-		if body.DesiredProfessions != nil {
-			ctx.Profile.DesiredProfessions = body.DesiredProfessions
+			return addProfileRevision(c, tx, models.ProfileRevisionUpdated, &oldProfile, *ctx.Profile)
+		})
+		if err != nil {
+			return profileWriteErrorRes(c, err)
 		}
 
-		if body.YearsSinceWork != nil {
-			if *body.YearsSinceWork == 0 {
-				ctx.Profile.YearsSinceWork = nil
-			} else {
-				ctx.Profile.YearsSinceWork = &*body.YearsSinceWork
+		// Invalidate profiles cache
+		ctx.ResetMatcherProfilesCache()
+
+		return c.JSON(ctx.Profile)
+	},
+}
+
+var routeDeleteProfile = routeBuilder.R{
+	Description: "Delete a profile stored in the database.\n\n" +
+		"The profile stops matching immediately but can be restored within 30 days using the restore route.",
+	Res: models.Profile{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+		err := ctx.DBConn.WithTransaction(func(tx db.Connection) error {
+			err := tx.DeleteByID(&models.Profile{}, ctx.Profile.ID)
+			if err != nil {
+				return err
 			}
+			return addProfileRevision(c, tx, models.ProfileRevisionDeleted, nil, *ctx.Profile)
+		})
+		if err != nil {
+			return err
 		}
-		if body.MustExpProfession != nil {
-			ctx.Profile.MustExpProfession = *body.MustExpProfession
-		}
-		if body.ProfessionExperienced != nil {
-			ctx.Profile.ProfessionExperienced = body.ProfessionExperienced
-		}
-		if body.MustDriversLicense != nil {
-			ctx.Profile.MustDriversLicense = *body.MustDriversLicense
-		}
-		if body.DriversLicenses != nil {
-			ctx.Profile.DriversLicenses = body.DriversLicenses
-		}
-		if body.MustEducationFinished != nil {
-			ctx.Profile.MustEducationFinished = *body.MustEducationFinished
-		}
-		if body.MustEducation != nil {
-			ctx.Profile.MustEducation = *body.MustEducation
-		}
-		if body.YearsSinceEducation != nil {
-			if *body.YearsSinceEducation == 0 {
-				ctx.Profile.YearsSinceEducation = nil
-			} else {
-				ctx.Profile.YearsSinceEducation = &*body.YearsSinceEducation
-			}
+
+		// Invalidate profiles cache
+		ctx.ResetMatcherProfilesCache()
+
+		return c.JSON(ctx.Profile)
+	},
+}
+
+const profileValidationDescription = "If the profile is invalid the response has status 400 and contains all validation errors of the profile as a list of {field, code, message} objects."
+
+// ProfileValidationErrorRes is the response of a request with an invalid profile
+type ProfileValidationErrorRes struct {
+	Error  string                         `json:"error"`
+	Errors models.ProfileValidationErrors `json:"errors"`
+}
+
+// profileValidationErrorRes responds with all validation errors of a profile, other errors are returned as is
+func profileValidationErrorRes(c *fiber.Ctx, err error) error {
+	validationErrors := models.ProfileValidationErrors{}
+	if errors.As(err, &validationErrors) {
+		return c.Status(fiber.StatusBadRequest).JSON(ProfileValidationErrorRes{
+			Error:  err.Error(),
+			Errors: validationErrors,
+		})
+	}
+	return err
+}
+
+// errProfileExternalIDTaken is returned if a profile gets the external id label of another profile
+var errProfileExternalIDTaken = errors.New("another profile already has the same " + models.ProfileExternalIDLabel + " label")
+
+// profileWriteErrorRes responds with a conflict if a profile could not be saved because another profile has the same external id, other errors are returned as is
+func profileWriteErrorRes(c *fiber.Ctx, err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrorRes(c, fiber.StatusConflict, errProfileExternalIDTaken)
+	}
+	return err
+}
+
+// addProfileRevision stores a new revision of profile made by the key of the request
+func addProfileRevision(c *fiber.Ctx, conn db.Connection, action models.ProfileRevisionAction, old *models.Profile, profile models.Profile) error {
+	var authorKeyID *primitive.ObjectID
+	if key := ctxPkg.Get(c).Key; key != nil {
+		keyID := key.ID
+		authorKeyID = &keyID
+	}
+	return models.AddProfileRevision(conn, action, authorKeyID, old, profile)
+}
+
+// StartProfileRevisionPruner removes the revisions of deleted profiles every hour once they can no longer be restored
+func StartProfileRevisionPruner(dbConn db.Connection) {
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		pruneProfileRevisions(dbConn, time.Now())
+		for now := range ticker.C {
+			pruneProfileRevisions(dbConn, now)
 		}
-		if body.Educations != nil {
-			ctx.Profile.Educations = body.Educations
+	}()
+}
+
+func pruneProfileRevisions(dbConn db.Connection, now time.Time) {
+	_, err := models.PruneProfileRevisions(dbConn, now)
+	if err != nil {
+		log.WithError(err).Error("removing the revisions of deleted profiles failed")
+	}
+}
+
+var routeGetProfileRevisions = routeBuilder.R{
+	Description: "get all revisions of a profile starting with the oldest, every change to a profile creates a new revision containing the changed fields and the full profile after the change",
+	Res:         []models.ProfileRevision{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+		revisions, err := models.GetProfileRevisions(ctx.DBConn, ctx.Profile.ID)
+		if err != nil {
+			return err
 		}
-		if body.Zipcodes != nil {
-			ctx.Profile.Zipcodes = body.Zipcodes
+		return c.JSON(revisions)
+	},
+}
+
+// getProfileRevisionParam returns the revision of the profile bound to the request defined by the revision url parameter
+func getProfileRevisionParam(c *fiber.Ctx) (models.ProfileRevision, error) {
+	revisionNr, err := strconv.Atoi(c.Params("revision"))
+	if err != nil {
+		return models.ProfileRevision{}, errors.New("revision must be a number")
+	}
+	ctx := ctxPkg.Get(c)
+	return models.GetProfileRevision(ctx.DBConn, ctx.Profile.ID, revisionNr)
+}
+
+var routeGetProfileRevision = routeBuilder.R{
+	Description: "get a single revision of a profile, the profile field contains the profile as it was after the revision",
+	Res:         models.ProfileRevision{},
+	Fn: func(c *fiber.Ctx) error {
+		revision, err := getProfileRevisionParam(c)
+		if err != nil {
+			return err
 		}
-		if body.OnMatch != nil {
-			ctx.Profile.OnMatch = *body.OnMatch
+		return c.JSON(revision)
+	},
+}
+
+var routeRollbackProfile = routeBuilder.R{
+	Description: "roll back a profile to how it was after a revision, this creates a new revision.\n\n" +
+		profileValidationDescription + " Like with edits only the problems the current profile does not already have block the roll back.",
+	Res: models.Profile{},
+	Fn: func(c *fiber.Ctx) error {
+		revision, err := getProfileRevisionParam(c)
+		if err != nil {
+			return err
 		}
-		if body.Lables != nil {
-			ctx.Profile.Lables = body.Lables
+
+		ctx := ctxPkg.Get(c)
+		profile := revision.Profile
+		// The matches made since the revision still count towards the match budget
+		profile.MatchCount = ctx.Profile.MatchCount
+		profile.LastScheduleStatus = ctx.Profile.LastScheduleStatus
+		// The revision might be invalid by now, for example because it links to a removed api key or matcher tree branch
+		err = profile.ValidateChanges(ctx.DBConn, matcher.FindMissingBranches, ctx.Profile)
+		if err != nil {
+			return profileValidationErrorRes(c, err)
 		}
-		if body.ListsAllowed != nil {
-			ctx.Profile.ListsAllowed = *body.ListsAllowed
+		if !ctx.Key.Scope.ProfileAllowed(&profile) {
+			return ErrorRes(c, fiber.StatusForbidden, errOutsideKeyScope)
 		}
 
-		err = ctx.DBConn.UpdateByID(ctx.Profile)
+		err = ctx.DBConn.WithTransaction(func(tx db.Connection) error {
+			err := models.UpdateProfileFields(tx, &profile)
+			if err != nil {
+				return err
+			}
+			return addProfileRevision(c, tx, models.ProfileRevisionRolledBack, ctx.Profile, profile)
+		})
 		if err != nil {
-			return err
+			return profileWriteErrorRes(c, err)
 		}
 
 		// Invalidate profiles cache
 		ctx.ResetMatcherProfilesCache()
 
-		return c.JSON(ctx.Profile)
+		return c.JSON(profile)
 	},
 }
 
-var routeDeleteProfile = routeBuilder.R{
-	Description: "Delete a profile stored in the database",
-	Res:         models.Profile{},
+var routeGetDeletedProfiles = routeBuilder.R{
+	Description: "get the profiles deleted in the last 30 days that can still be restored, the most recently deleted profile first.\n\n" +
+		"The profile field of the returned revisions contains the profile as it was before it was deleted.",
+	Res: []models.ProfileRevision{},
 	Fn: func(c *fiber.Ctx) error {
 		ctx := ctxPkg.Get(c)
-		err := ctx.DBConn.DeleteByID(&models.Profile{}, ctx.Profile.ID)
+		revisions, err := models.GetDeletedProfiles(ctx.DBConn)
+		if err != nil {
+			return err
+		}
+
+		allowedRevisions := []models.ProfileRevision{}
+		for _, revision := range revisions {
+			if ctx.Key.Scope.ProfileAllowed(&revision.Profile) {
+				allowedRevisions = append(allowedRevisions, revision)
+			}
+		}
+		return c.JSON(allowedRevisions)
+	},
+}
+
+var routeRestoreProfile = routeBuilder.R{
+	Description: "restore a profile deleted in the last 30 days",
+	Res:         models.Profile{},
+	Fn: func(c *fiber.Ctx) error {
+		profileID, err := primitive.ObjectIDFromHex(c.Params("profile"))
 		if err != nil {
 			return err
 		}
 
+		ctx := ctxPkg.Get(c)
+		revision, err := models.GetRestorableProfile(ctx.DBConn, profileID)
+		if err == models.ErrProfileNotRestorable {
+			return ErrorRes(c, fiber.StatusNotFound, err)
+		} else if err != nil {
+			return err
+		}
+
+		profile := revision.Profile
+		if !ctx.Key.Scope.ProfileAllowed(&profile) {
+			return ErrorRes(c, fiber.StatusForbidden, errOutsideKeyScope)
+		}
+
+		err = ctx.DBConn.WithTransaction(func(tx db.Connection) error {
+			err := tx.Insert(&profile)
+			if err != nil {
+				return err
+			}
+			return addProfileRevision(c, tx, models.ProfileRevisionRestored, nil, profile)
+		})
+		if err != nil {
+			return profileWriteErrorRes(c, err)
+		}
+
 		// Invalidate profiles cache
 		ctx.ResetMatcherProfilesCache()
 
-		return c.JSON(ctx.Profile)
+		return c.JSON(profile)
 	},
 }
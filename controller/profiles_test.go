@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/match"
 	"github.com/script-development/RT-CV/helpers/routeBuilder"
 	"github.com/script-development/RT-CV/mock"
 	"github.com/script-development/RT-CV/models"
@@ -88,6 +91,28 @@ func TestProfileRoutes(t *testing.T) {
 	Len(t, resProfiles, 1)
 }
 
+func TestRouteQueryProfiles(t *testing.T) {
+	app := newTestingRouter(t)
+
+	// Raw MongoDB operators are not allowed
+	res, _ := app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/query`, TestReqOpts{Body: []byte(`{"$where": "true"}`)})
+	Equal(t, 400, res.StatusCode)
+
+	res, body := app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/query?sort=-name&limit=1`, TestReqOpts{
+		Body: []byte(`{"name": {"$contains": "mock profile"}}`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+	Equal(t, "2", res.Header.Get("X-Total-Count"))
+	resProfiles := []models.Profile{}
+	err := json.Unmarshal(body, &resProfiles)
+	NoError(t, err)
+	Len(t, resProfiles, 1)
+	Equal(t, mock.Profile2.ID, resProfiles[0].ID)
+
+	res, _ = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/query?sort=email`, TestReqOpts{})
+	Equal(t, 400, res.StatusCode)
+}
+
 func TestRouteGetProfilesCount(t *testing.T) {
 	app := newTestingRouter(t)
 
@@ -158,7 +183,7 @@ func TestRouteUpdateProfile(t *testing.T) {
 		},
 		{
 			"Set MustDesiredProfession",
-			M{"mustDesiredProfession": true},
+			M{"mustDesiredProfession": true, "desiredProfessions": []models.ProfileProfession{{Name: "desired profession"}}},
 			func(t *testing.T, before, after models.Profile) {
 				Equal(t, true, after.MustDesiredProfession)
 			},
@@ -180,7 +205,7 @@ func TestRouteUpdateProfile(t *testing.T) {
 		},
 		{
 			"Set MustExpProfession",
-			M{"mustExpProfession": true},
+			M{"mustExpProfession": true, "professionExperienced": []models.ProfileProfession{{Name: "experienced profession"}}},
 			func(t *testing.T, before, after models.Profile) {
 				Equal(t, true, after.MustExpProfession)
 			},
@@ -194,28 +219,28 @@ func TestRouteUpdateProfile(t *testing.T) {
 		},
 		{
 			"Set MustDriversLicense",
-			M{"mustDriversLicense": true},
+			M{"mustDriversLicense": true, "driversLicenses": []models.ProfileDriversLicense{{Name: "B"}}},
 			func(t *testing.T, before, after models.Profile) {
 				Equal(t, true, after.MustDriversLicense)
 			},
 		},
 		{
 			"Set DriversLicenses",
-			M{"driversLicenses": []models.ProfileDriversLicense{{Name: "updated drivers license"}}},
+			M{"driversLicenses": []models.ProfileDriversLicense{{Name: "CE"}}},
 			func(t *testing.T, before, after models.Profile) {
-				Equal(t, []models.ProfileDriversLicense{{Name: "updated drivers license"}}, after.DriversLicenses)
+				Equal(t, []models.ProfileDriversLicense{{Name: "CE"}}, after.DriversLicenses)
 			},
 		},
 		{
 			"Set MustEducationFinished",
-			M{"mustEducationFinished": true},
+			M{"mustEducationFinished": true, "educations": []models.ProfileEducation{{Name: "education"}}},
 			func(t *testing.T, before, after models.Profile) {
 				Equal(t, true, after.MustEducationFinished)
 			},
 		},
 		{
 			"Set MustEducation",
-			M{"mustEducation": true},
+			M{"mustEducation": true, "educations": []models.ProfileEducation{{Name: "education"}}},
 			func(t *testing.T, before, after models.Profile) {
 				Equal(t, true, after.MustEducation)
 			},
@@ -285,3 +310,286 @@ func TestRouteUpdateProfile(t *testing.T) {
 		}
 	}
 }
+
+func TestProfileRevisions(t *testing.T) {
+	app := newTestingRouter(t)
+
+	body, err := json.Marshal(models.Profile{Name: "first name"})
+	NoError(t, err)
+	res, resBody := app.MakeRequest(routeBuilder.Post, `/api/v1/profiles`, TestReqOpts{Body: body})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	profile := models.Profile{}
+	err = json.Unmarshal(resBody, &profile)
+	NoError(t, err)
+	profileRoute := `/api/v1/profiles/` + profile.ID.Hex()
+
+	res, resBody = app.MakeRequest(routeBuilder.Put, profileRoute, TestReqOpts{Body: []byte(`{"name": "second name"}`)})
+	Equal(t, 200, res.StatusCode, string(resBody))
+
+	res, resBody = app.MakeRequest(routeBuilder.Get, profileRoute+`/revisions`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	revisions := []models.ProfileRevision{}
+	err = json.Unmarshal(resBody, &revisions)
+	NoError(t, err)
+	if Len(t, revisions, 2) {
+		Equal(t, models.ProfileRevisionCreated, revisions[0].Action)
+		Equal(t, mock.Key1.ID, *revisions[0].AuthorKeyID)
+		Equal(t, models.ProfileRevisionUpdated, revisions[1].Action)
+		Equal(t, []models.ProfileFieldChange{{Field: "name", Old: "first name", New: "second name"}}, revisions[1].Changes)
+	}
+
+	res, resBody = app.MakeRequest(routeBuilder.Get, profileRoute+`/revisions/1`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	revision := models.ProfileRevision{}
+	err = json.Unmarshal(resBody, &revision)
+	NoError(t, err)
+	Equal(t, "first name", revision.Profile.Name)
+
+	res, resBody = app.MakeRequest(routeBuilder.Post, profileRoute+`/revisions/1/rollback`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	res, resBody = app.MakeRequest(routeBuilder.Get, profileRoute, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	profile = models.Profile{}
+	err = json.Unmarshal(resBody, &profile)
+	NoError(t, err)
+	Equal(t, "first name", profile.Name)
+
+	// Revisions that are invalid by now, like revisions linked to a removed matcher tree branch, can't be rolled back to
+	revision.Profile.DesiredProfessions = []models.ProfileProfession{{Name: "Chauffeur", LeafId: primitive.NewObjectID()}}
+	err = app.db.UpdateByID(&revision)
+	NoError(t, err)
+	res, resBody = app.MakeRequest(routeBuilder.Post, profileRoute+`/revisions/1/rollback`, TestReqOpts{})
+	Equal(t, 400, res.StatusCode, string(resBody))
+
+	// Deleted profiles can be restored
+	res, resBody = app.MakeRequest(routeBuilder.Delete, profileRoute, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	res, _ = app.MakeRequest(routeBuilder.Get, profileRoute, TestReqOpts{})
+	NotEqual(t, 200, res.StatusCode)
+
+	res, resBody = app.MakeRequest(routeBuilder.Get, `/api/v1/profiles/deleted`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	revisions = []models.ProfileRevision{}
+	err = json.Unmarshal(resBody, &revisions)
+	NoError(t, err)
+	if Len(t, revisions, 1) {
+		Equal(t, profile.ID, revisions[0].ProfileID)
+		Equal(t, 4, revisions[0].Revision)
+	}
+
+	res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/deleted/`+profile.ID.Hex()+`/restore`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	res, resBody = app.MakeRequest(routeBuilder.Get, profileRoute, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(resBody))
+
+	res, resBody = app.MakeRequest(routeBuilder.Get, `/api/v1/profiles/deleted`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	Equal(t, "[]", string(resBody))
+	res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/deleted/`+profile.ID.Hex()+`/restore`, TestReqOpts{})
+	Equal(t, 404, res.StatusCode, string(resBody))
+}
+
+func TestProfileSchedule(t *testing.T) {
+	app := newTestingRouter(t)
+
+	body, err := json.Marshal(models.Profile{Name: "scheduled", MatchCount: models.ProfileMatchCount{Total: 10}})
+	NoError(t, err)
+	res, resBody := app.MakeRequest(routeBuilder.Post, `/api/v1/profiles`, TestReqOpts{Body: body})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	profile := models.Profile{}
+	err = json.Unmarshal(resBody, &profile)
+	NoError(t, err)
+	Equal(t, models.ProfileMatchCount{}, profile.MatchCount, "the match count should be set by RT-CV")
+	profileRoute := `/api/v1/profiles/` + profile.ID.Hex()
+
+	res, resBody = app.MakeRequest(routeBuilder.Put, profileRoute, TestReqOpts{Body: []byte(`{
+		"activeFrom": "2022-06-06T12:00:00Z",
+		"activeWindows": [{"weekdays": [1, 2], "from": "09:00", "to": "17:00"}],
+		"maxMatchesPerDay": 5
+	}`)})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	profile = models.Profile{}
+	err = json.Unmarshal(resBody, &profile)
+	NoError(t, err)
+	NotNil(t, profile.ActiveFrom)
+	Len(t, profile.ActiveWindows, 1)
+	Equal(t, 5, profile.MaxMatchesPerDay)
+
+	res, resBody = app.MakeRequest(routeBuilder.Put, profileRoute, TestReqOpts{Body: []byte(`{"activeFrom": "0001-01-01T00:00:00Z"}`)})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	profile = models.Profile{}
+	err = json.Unmarshal(resBody, &profile)
+	NoError(t, err)
+	Nil(t, profile.ActiveFrom)
+
+	res, resBody = app.MakeRequest(routeBuilder.Put, profileRoute, TestReqOpts{Body: []byte(`{"activeWindows": [{"from": "9", "to": "17:00"}]}`)})
+	Equal(t, 400, res.StatusCode, string(resBody))
+}
+
+func TestProfileImportExport(t *testing.T) {
+	app := newTestingRouter(t)
+
+	csvProfiles := "externalId,name,active,zipCodes\n" +
+		"customer-1,First import,true,1000-2000\n" +
+		"customer-2,Second import,false,\n"
+	res, resBody := app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/import?format=csv`, TestReqOpts{Body: []byte(csvProfiles)})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	result := models.ProfileImportResult{}
+	err := json.Unmarshal(resBody, &result)
+	NoError(t, err)
+	Equal(t, 2, result.Created)
+
+	res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/export`, TestReqOpts{Body: []byte(`{"labels.externalId": {"$in": ["customer-1", "customer-2"]}}`)})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	exported := []models.Profile{}
+	err = json.Unmarshal(resBody, &exported)
+	NoError(t, err)
+	Len(t, exported, 2)
+
+	// Importing the export as json should not change anything
+	res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/import`, TestReqOpts{Body: resBody})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	result = models.ProfileImportResult{}
+	err = json.Unmarshal(resBody, &result)
+	NoError(t, err)
+	Equal(t, 2, result.Unchanged)
+
+	res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/export?format=csv`, TestReqOpts{Body: []byte(`{"labels.externalId": "customer-1"}`)})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	Contains(t, string(resBody), "customer-1,First import,true")
+	NotContains(t, string(resBody), "customer-2")
+
+	res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/import`, TestReqOpts{Body: []byte(`[{"name": "", "labels": {"externalId": "customer-3"}}]`)})
+	Equal(t, 400, res.StatusCode, string(resBody))
+	result = models.ProfileImportResult{}
+	err = json.Unmarshal(resBody, &result)
+	NoError(t, err)
+	Equal(t, 1, result.Invalid)
+	Equal(t, "name: must be set", result.Rows[0].Error)
+	Equal(t, models.ProfileValidationErrors{{Field: "name", Code: models.ProfileValidationRequired, Message: "must be set"}}, result.Rows[0].Errors)
+
+	// Profiles cannot share an external id
+	app.db.RegisterEntries(&models.Profile{})
+	res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles`, TestReqOpts{Body: []byte(`{"name": "copy", "labels": {"externalId": "customer-1"}}`)})
+	Equal(t, 409, res.StatusCode, string(resBody))
+}
+
+func TestProfileValidationErrors(t *testing.T) {
+	app := newTestingRouter(t)
+
+	yearsSinceWork := -1
+	body, err := json.Marshal(models.Profile{
+		Name:               "invalid",
+		MustEducation:      true,
+		YearsSinceWork:     &yearsSinceWork,
+		DesiredProfessions: []models.ProfileProfession{{Name: "Chauffeur", LeafId: primitive.NewObjectID()}},
+		DriversLicenses:    []models.ProfileDriversLicense{{Name: "B"}, {Name: "XYZ"}},
+		Zipcodes:           []models.ProfileDutchZipcode{{From: 2000, To: 1000}},
+	})
+	NoError(t, err)
+	res, resBody := app.MakeRequest(routeBuilder.Post, `/api/v1/profiles`, TestReqOpts{Body: body})
+	Equal(t, 400, res.StatusCode, string(resBody))
+	errorRes := ProfileValidationErrorRes{}
+	err = json.Unmarshal(resBody, &errorRes)
+	NoError(t, err)
+	fields := map[string]models.ProfileValidationCode{}
+	for _, validationErr := range errorRes.Errors {
+		fields[validationErr.Field] = validationErr.Code
+	}
+	Equal(t, map[string]models.ProfileValidationCode{
+		"yearsSinceWork":               models.ProfileValidationOutOfRange,
+		"desiredProfessions[0].LeafId": models.ProfileValidationUnknown,
+		"driversLicenses[1].name":      models.ProfileValidationUnknown,
+		"educations":                   models.ProfileValidationRequired,
+		"zipCodes[0]":                  models.ProfileValidationInvalid,
+	}, fields)
+
+	res, resBody = app.MakeRequest(routeBuilder.Put, `/api/v1/profiles/`+mock.Profile1.ID.Hex(), TestReqOpts{Body: []byte(`{"name": " ", "zipCodes": [{"from": 100, "to": 2000}]}`)})
+	Equal(t, 400, res.StatusCode, string(resBody))
+	errorRes = ProfileValidationErrorRes{}
+	err = json.Unmarshal(resBody, &errorRes)
+	NoError(t, err)
+	Equal(t, models.ProfileValidationErrors{
+		{Field: "name", Code: models.ProfileValidationRequired, Message: "must be set"},
+		{Field: "zipCodes[0].from", Code: models.ProfileValidationOutOfRange, Message: "must be a number between 1000 and 9999"},
+	}, errorRes.Errors)
+
+	// A profile linked to a removed matcher tree branch can still be edited as long as the edit does not add new problems
+	profile := *mock.Profile2
+	profile.DesiredProfessions = []models.ProfileProfession{{Name: "Chauffeur", LeafId: primitive.NewObjectID()}}
+	err = app.db.UpdateByID(&profile)
+	NoError(t, err)
+	res, resBody = app.MakeRequest(routeBuilder.Put, `/api/v1/profiles/`+profile.ID.Hex(), TestReqOpts{Body: []byte(`{"active": false}`)})
+	Equal(t, 200, res.StatusCode, string(resBody))
+}
+
+func TestProfileSimulation(t *testing.T) {
+	app := newTestingRouter(t)
+	res, resBody := app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/simulate`, TestReqOpts{Body: []byte(`{"name": "draft"}`)})
+	Equal(t, 404, res.StatusCode, string(resBody))
+
+	cvHistory, err := models.NewCVHistory("this-is-a-long-enough-key", time.Hour, 100)
+	NoError(t, err)
+	app = newTestingRouterWithCVHistory(t, cvHistory)
+
+	scannedCVs := []models.CV{
+		{ReferenceNumber: "a", PreferredJobs: []string{"Software developer"}, PersonalDetails: models.PersonalDetails{Zip: "1500AB"}},
+		{ReferenceNumber: "b", PreferredJobs: []string{"Software developer"}, PersonalDetails: models.PersonalDetails{Zip: "9000AB"}},
+		{ReferenceNumber: "c", PreferredJobs: []string{"Chauffeur"}, PersonalDetails: models.PersonalDetails{Zip: "1500AB"}},
+	}
+	for _, cv := range scannedCVs {
+		body, err := json.Marshal(RouteScraperScanCVBody{CV: cv})
+		NoError(t, err)
+		res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/scraper/scanCV`, TestReqOpts{Body: body})
+		Equal(t, 200, res.StatusCode, string(resBody))
+	}
+	// The scanned CVs are added to the cv history in the background
+	Eventually(t, func() bool {
+		cvs, err := cvHistory.CVs(app.db, time.Now())
+		return err == nil && len(cvs) == len(scannedCVs)
+	}, time.Second, 10*time.Millisecond)
+
+	draft := models.Profile{
+		Name:                  "draft",
+		MustDesiredProfession: true,
+		DesiredProfessions:    []models.ProfileProfession{{Name: "Software developer"}},
+	}
+	body, err := json.Marshal(draft)
+	NoError(t, err)
+	res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/simulate`, TestReqOpts{Body: body})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	result := match.SimulationResult{}
+	err = json.Unmarshal(resBody, &result)
+	NoError(t, err)
+	Equal(t, 3, result.CVs)
+	Equal(t, 2, result.Matches)
+	ElementsMatch(t, []string{"a", "b"}, result.SampleReferenceNumbers)
+
+	res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/simulate`, TestReqOpts{Body: []byte(`{"name": ""}`)})
+	Equal(t, 400, res.StatusCode, string(resBody))
+
+	// Compare the edited version of an existing profile with the current version
+	draft.M = db.NewM()
+	err = app.db.Insert(&draft)
+	NoError(t, err)
+	profileRoute := `/api/v1/profiles/` + draft.ID.Hex()
+	res, resBody = app.MakeRequest(routeBuilder.Post, profileRoute+`/simulate`, TestReqOpts{Body: []byte(`{"zipCodes": [{"from": 1000, "to": 2000}]}`)})
+	Equal(t, 200, res.StatusCode, string(resBody))
+	comparison := match.SimulationComparison{}
+	err = json.Unmarshal(resBody, &comparison)
+	NoError(t, err)
+	Equal(t, 2, comparison.Current.Matches)
+	Equal(t, 1, comparison.Edited.Matches)
+	Equal(t, 1, comparison.OnlyCurrent)
+	Equal(t, []string{"b"}, comparison.SampleOnlyCurrent)
+	Equal(t, 0, comparison.OnlyEdited)
+
+	// The profile itself should not be modified
+	profile, err := models.GetProfile(app.db, draft.ID)
+	NoError(t, err)
+	Empty(t, profile.Zipcodes)
+	// Simulations require the controller role
+	app.ChangeAuthKey(mock.Key3)
+	res, resBody = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles/simulate`, TestReqOpts{Body: body})
+	Equal(t, 401, res.StatusCode, string(resBody))
+}
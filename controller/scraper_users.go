@@ -79,25 +79,30 @@ var routeDeleteScraperUser = routeBuilder.R{
 		}
 
 		scraperUsers := models.ScraperLoginUsers{}
-		err = ctx.DBConn.FindOne(&scraperUsers, bson.M{"scraperId": ctx.APIKeyFromParam.ID})
-		if err == mongo.ErrNoDocuments {
-			return errors.New("username not found")
-		}
+		err = ctx.DBConn.WithTransaction(func(tx db.Connection) error {
+			// The users are read and written in a transaction so concurrent changes to the same scraper are not lost
+			err := tx.FindOne(&scraperUsers, bson.M{"scraperId": ctx.APIKeyFromParam.ID})
+			if err == mongo.ErrNoDocuments {
+				return errors.New("username not found")
+			} else if err != nil {
+				return err
+			}
 
-		removedUser := false
-		for idx := len(scraperUsers.Users) - 1; idx >= 0; idx-- {
-			if scraperUsers.Users[idx].Username == body.Username {
-				// Remove the user
-				scraperUsers.Users = append(scraperUsers.Users[:idx], scraperUsers.Users[idx+1:]...)
-				removedUser = true
-				// Do not break here because if there are for some reason multiple users in the db with the same username we can remove them all
+			removedUser := false
+			for idx := len(scraperUsers.Users) - 1; idx >= 0; idx-- {
+				if scraperUsers.Users[idx].Username == body.Username {
+					// Remove the user
+					scraperUsers.Users = append(scraperUsers.Users[:idx], scraperUsers.Users[idx+1:]...)
+					removedUser = true
+					// Do not break here because if there are for some reason multiple users in the db with the same username we can remove them all
+				}
+			}
+			if !removedUser {
+				return errors.New("username not found")
 			}
-		}
-		if !removedUser {
-			return errors.New("username not found")
-		}
 
-		err = ctx.DBConn.UpdateByID(&scraperUsers)
+			return tx.UpdateByID(&scraperUsers)
+		})
 		if err != nil {
 			return err
 		}
@@ -136,39 +141,42 @@ var routePatchScraperUser = routeBuilder.R{
 		}
 
 		var alreadyExistingSet models.ScraperLoginUsers
-		err = ctx.DBConn.FindOne(&alreadyExistingSet, bson.M{"scraperId": ctx.APIKeyFromParam.ID})
-		if err == mongo.ErrNoDocuments {
-			// There are no scrapers users for this scraper yet thus also no public key used to encrypt the newly inserted user's password
-			return models.ErrScraperNoPublicKey
-		} else if err != nil {
-			return err
-		}
+		err = ctx.DBConn.WithTransaction(func(tx db.Connection) error {
+			// The users are read and written in a transaction so concurrent changes to the same scraper are not lost
+			err := tx.FindOne(&alreadyExistingSet, bson.M{"scraperId": ctx.APIKeyFromParam.ID})
+			if err == mongo.ErrNoDocuments {
+				// There are no scrapers users for this scraper yet thus also no public key used to encrypt the newly inserted user's password
+				return models.ErrScraperNoPublicKey
+			} else if err != nil {
+				return err
+			}
 
-		encryptedPassword, err := alreadyExistingSet.EncryptPassword(body.Password)
-		if err != nil {
-			return err
-		}
+			encryptedPassword, err := alreadyExistingSet.EncryptPassword(body.Password)
+			if err != nil {
+				return err
+			}
 
-		scraperUserUpdateInsert := models.ScraperLoginUser{
-			Username:          body.Username,
-			EncryptedPassword: encryptedPassword,
-		}
+			scraperUserUpdateInsert := models.ScraperLoginUser{
+				Username:          body.Username,
+				EncryptedPassword: encryptedPassword,
+			}
 
-		// Update or insert the existing set
-		// Check if the user already exists
-		insert := true
-		for idx, usr := range alreadyExistingSet.Users {
-			if usr.Username == body.Username {
-				insert = false
-				alreadyExistingSet.Users[idx] = scraperUserUpdateInsert
-				break
+			// Update or insert the existing set
+			// Check if the user already exists
+			insert := true
+			for idx, usr := range alreadyExistingSet.Users {
+				if usr.Username == body.Username {
+					insert = false
+					alreadyExistingSet.Users[idx] = scraperUserUpdateInsert
+					break
+				}
+			}
+			if insert {
+				alreadyExistingSet.Users = append(alreadyExistingSet.Users, scraperUserUpdateInsert)
 			}
-		}
-		if insert {
-			alreadyExistingSet.Users = append(alreadyExistingSet.Users, scraperUserUpdateInsert)
-		}
 
-		err = ctx.DBConn.UpdateByID(&alreadyExistingSet)
+			return tx.UpdateByID(&alreadyExistingSet)
+		})
 		if err != nil {
 			return err
 		}
@@ -214,34 +222,34 @@ var routeSetPublicKeyForScraperUsers = routeBuilder.R{
 		}
 
 		var resp models.ScraperLoginUsers
-		err = ctx.DBConn.FindOne(&resp, bson.M{"scraperId": ctx.APIKeyFromParam.ID})
-		if err == mongo.ErrNoDocuments {
-			resp = models.ScraperLoginUsers{
-				M:             db.NewM(),
-				ScraperID:     ctx.APIKeyFromParam.ID,
-				ScraperPubKey: body.PublicKey,
-				Users:         []models.ScraperLoginUser{},
-			}
-			err = ctx.DBConn.Insert(&resp)
-			if err != nil {
+		err = ctx.DBConn.WithTransaction(func(tx db.Connection) error {
+			// The users are read and written in a transaction so concurrent changes to the same scraper are not lost
+			err := tx.FindOne(&resp, bson.M{"scraperId": ctx.APIKeyFromParam.ID})
+			if err == mongo.ErrNoDocuments {
+				resp = models.ScraperLoginUsers{
+					M:             db.NewM(),
+					ScraperID:     ctx.APIKeyFromParam.ID,
+					ScraperPubKey: body.PublicKey,
+					Users:         []models.ScraperLoginUser{},
+				}
+				return tx.Insert(&resp)
+			} else if err != nil {
 				return err
 			}
-			return sendScraperLoginUsers(resp, ctx, c)
-		} else if err != nil {
-			return err
-		}
 
-		if resp.ScraperPubKey != body.PublicKey {
+			if resp.ScraperPubKey == body.PublicKey {
+				return nil
+			}
 			resp.ScraperPubKey = body.PublicKey
 
 			// The public key changed, now we cannot know the users anymore that are encrypted using the old key
 			// So we delete all the users
 			resp.Users = []models.ScraperLoginUser{}
 
-			err = ctx.DBConn.UpdateByID(&resp)
-			if err != nil {
-				return err
-			}
+			return tx.UpdateByID(&resp)
+		})
+		if err != nil {
+			return err
 		}
 
 		return sendScraperLoginUsers(resp, ctx, c)
@@ -0,0 +1,418 @@
+package controller
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	ctxPkg "github.com/script-development/RT-CV/controller/ctx"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/auth"
+	"github.com/script-development/RT-CV/helpers/routeBuilder"
+	"github.com/script-development/RT-CV/helpers/totp"
+	"github.com/script-development/RT-CV/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	errTOTPRequired    = errors.New("this user requires a totp code to login")
+	errTOTPInvalid     = errors.New("invalid totp code")
+	errSessionRequired = errors.New("this route can only be used with a dashboard user session")
+	errTOTPEnabled     = errors.New("totp is already enabled, disable it first to setup a new secret")
+	errTOTPSameState   = errors.New("totp is already in the requested state")
+	errTOTPNoSecret    = errors.New("no totp secret setup yet")
+)
+
+// dummyPasswordHash is used to compare passwords of unknown users
+// This makes sure logging in with an unknown username takes as long as with a known username
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// addAuditLogEntry adds an entry to the audit log
+// Failing to add the entry is logged but does not fail the request
+func addAuditLogEntry(c *fiber.Ctx, entry models.AuditLogEntry) {
+	ctx := ctxPkg.Get(c)
+	entry.IP = c.IP()
+	if entry.ActorID == nil && ctx.Key != nil {
+		actorID := ctx.Key.ID
+		entry.ActorID = &actorID
+	}
+	if entry.SessionID == nil && ctx.Session != nil {
+		sessionID := ctx.Session.ID
+		entry.SessionID = &sessionID
+	}
+
+	err := models.AddAuditLogEntry(ctx.DBConn, entry)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("unable to add audit log entry")
+	}
+}
+
+// RouteLoginBody is the request body of routeLogin
+type RouteLoginBody struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	TOTPCode string `json:"totpCode" description:"Only required if the user has enabled the totp second factor"`
+}
+
+// RouteLoginRes is the response of routeLogin and routeRefreshSession
+type RouteLoginRes struct {
+	Tokens auth.SessionTokens   `json:"tokens"`
+	User   models.DashboardUser `json:"user"`
+}
+
+var routeLogin = routeBuilder.R{
+	Description: "Login as a dashboard user, the returned access token can be used as \"Bearer {accessToken}\" in the Authorization header.\n\n" +
+		"After too many failed attempts for the same user or from the same ip the response has status 429 until the Retry-After header has passed.",
+	Body: RouteLoginBody{},
+	Res:  RouteLoginRes{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+
+		body := RouteLoginBody{}
+		err := c.BodyParser(&body)
+		if err != nil {
+			return err
+		}
+
+		// Failed attempts are counted per user and per ip to slow down guessing passwords and totp codes
+		username := models.NormalizeUsername(body.Username)
+		if retryAfter := ctx.Auth.AttemptRetryAfter(username, c.IP()); retryAfter > 0 {
+			return tooManyAttemptsRes(c, retryAfter)
+		}
+
+		user, err := models.GetDashboardUserByUsername(ctx.DBConn, body.Username)
+		if err == mongo.ErrNoDocuments {
+			_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(body.Password))
+			ctx.Auth.AttemptFailed(username, c.IP())
+			addAuditLogEntry(c, models.AuditLogEntry{
+				Action:  models.AuditActionLoginFailed,
+				Message: "unknown username " + models.NormalizeUsername(body.Username),
+			})
+			return ErrorRes(c, fiber.StatusUnauthorized, models.ErrInvalidCredentials)
+		} else if err != nil {
+			return err
+		}
+
+		if !user.CheckPassword(body.Password) {
+			ctx.Auth.AttemptFailed(username, c.IP())
+			addAuditLogEntry(c, models.AuditLogEntry{
+				Action:  models.AuditActionLoginFailed,
+				UserID:  &user.ID,
+				T:       user.T,
+				Message: "invalid password",
+			})
+			return ErrorRes(c, fiber.StatusUnauthorized, models.ErrInvalidCredentials)
+		}
+
+		if user.Disabled {
+			ctx.Auth.AttemptFailed(username, c.IP())
+			addAuditLogEntry(c, models.AuditLogEntry{
+				Action:  models.AuditActionLoginFailed,
+				UserID:  &user.ID,
+				T:       user.T,
+				Message: "user is disabled",
+			})
+			return ErrorRes(c, fiber.StatusUnauthorized, models.ErrInvalidCredentials)
+		}
+
+		if user.TOTPEnabled {
+			if body.TOTPCode == "" {
+				return ErrorRes(c, fiber.StatusUnauthorized, errTOTPRequired)
+			}
+			valid, err := useTOTPCode(ctx.DBConn, &user, body.TOTPCode)
+			if err != nil {
+				return err
+			}
+			if !valid {
+				ctx.Auth.AttemptFailed(username, c.IP())
+				addAuditLogEntry(c, models.AuditLogEntry{
+					Action:  models.AuditActionLoginFailed,
+					UserID:  &user.ID,
+					T:       user.T,
+					Message: "invalid totp code",
+				})
+				return ErrorRes(c, fiber.StatusUnauthorized, errTOTPInvalid)
+			}
+		}
+
+		ctx.Auth.AttemptSucceeded(username)
+
+		session, tokens, err := ctx.Auth.CreateSession(&user, c.IP(), c.Get("User-Agent"))
+		if err != nil {
+			return err
+		}
+
+		addAuditLogEntry(c, models.AuditLogEntry{
+			Action:    models.AuditActionLogin,
+			UserID:    &user.ID,
+			T:         user.T,
+			SessionID: &session.ID,
+			ActorID:   &user.ID,
+		})
+
+		return c.JSON(RouteLoginRes{Tokens: tokens, User: user})
+	},
+}
+
+// RouteRefreshSessionBody is the request body of routeRefreshSession
+type RouteRefreshSessionBody struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+var routeRefreshSession = routeBuilder.R{
+	Description: "Exchange a refresh token for a new access and refresh token, a refresh token can only be used once",
+	Body:        RouteRefreshSessionBody{},
+	Res:         RouteLoginRes{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+
+		body := RouteRefreshSessionBody{}
+		err := c.BodyParser(&body)
+		if err != nil {
+			return err
+		}
+
+		session, tokens, err := ctx.Auth.RefreshSession(body.RefreshToken)
+		if err == auth.ErrRefreshTokenInvalid && session != nil {
+			addAuditLogEntry(c, models.AuditLogEntry{
+				Action:    models.AuditActionSessionRevoked,
+				UserID:    &session.UserID,
+				SessionID: &session.ID,
+				Message:   "an already used refresh token was used again",
+			})
+			return ErrorRes(c, fiber.StatusUnauthorized, err)
+		} else if err == auth.ErrRefreshTokenInvalid || err == auth.ErrSessionRevoked {
+			return ErrorRes(c, fiber.StatusUnauthorized, err)
+		} else if err != nil {
+			return err
+		}
+
+		user, err := models.GetDashboardUser(ctx.DBConn, session.UserID)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(RouteLoginRes{Tokens: tokens, User: user})
+	},
+}
+
+var routeLogout = routeBuilder.R{
+	Description: "Revoke the session used to make this request",
+	Res:         models.Session{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+
+		err := ctx.Auth.RevokeSession(ctx.Session)
+		if err != nil {
+			return err
+		}
+
+		addAuditLogEntry(c, models.AuditLogEntry{
+			Action: models.AuditActionLogout,
+			UserID: &ctx.Session.UserID,
+		})
+
+		return c.JSON(ctx.Session)
+	},
+}
+
+var routeGetMyUser = routeBuilder.R{
+	Description: "Get the dashboard user of the session used to make this request",
+	Res:         models.DashboardUser{},
+	Fn: func(c *fiber.Ctx) error {
+		return c.JSON(ctxPkg.Get(c).DashboardUser)
+	},
+}
+
+var routeGetMySessions = routeBuilder.R{
+	Description: "Get the active sessions of the dashboard user of the session used to make this request",
+	Res:         []models.Session{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+		sessions, err := models.GetUserSessions(ctx.DBConn, ctx.DashboardUser.ID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(sessions)
+	},
+}
+
+var routeRevokeMySession = routeBuilder.R{
+	Description: "Revoke one of your own sessions, for example a session on a lost device",
+	Res:         models.Session{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+
+		sessionID, err := primitive.ObjectIDFromHex(c.Params("sessionID"))
+		if err != nil {
+			return err
+		}
+		session, err := models.GetSession(ctx.DBConn, sessionID)
+		if err != nil {
+			return err
+		}
+		if session.UserID != ctx.DashboardUser.ID {
+			return mongo.ErrNoDocuments
+		}
+
+		err = ctx.Auth.RevokeSession(&session)
+		if err != nil {
+			return err
+		}
+
+		addAuditLogEntry(c, models.AuditLogEntry{
+			Action:    models.AuditActionSessionRevoked,
+			UserID:    &session.UserID,
+			SessionID: &session.ID,
+			Message:   "revoked by the user itself",
+		})
+
+		return c.JSON(session)
+	},
+}
+
+// RouteSetupTOTPRes is the response of routeSetupTOTP
+type RouteSetupTOTPRes struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url" description:"otpauth:// url that can be shown as QR code to be scanned by an authenticator app"`
+}
+
+var routeSetupTOTP = routeBuilder.R{
+	Description: "Generate a new totp secret, the second factor is only required after it's enabled using /auth/totp/enable",
+	Res:         RouteSetupTOTPRes{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+		user := ctx.DashboardUser
+
+		if user.TOTPEnabled {
+			return ErrorRes(c, fiber.StatusBadRequest, errTOTPEnabled)
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return err
+		}
+		user.TOTPSecret = secret
+		err = ctx.DBConn.UpdateByID(user)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(RouteSetupTOTPRes{
+			Secret: secret,
+			URL:    totp.URL("RT-CV", user.Username, secret),
+		})
+	},
+}
+
+// RouteTOTPCodeBody is the request body of routeEnableTOTP and routeDisableTOTP
+type RouteTOTPCodeBody struct {
+	Code string `json:"code"`
+}
+
+func routeToggleTOTP(enable bool) routeBuilder.R {
+	description := "Disable the totp second factor"
+	action := models.AuditActionTOTPDisabled
+	if enable {
+		description = "Enable the totp second factor using the secret from /auth/totp/setup"
+		action = models.AuditActionTOTPEnabled
+	}
+
+	return routeBuilder.R{
+		Description: description + ", requires a valid code generated by the authenticator app",
+		Body:        RouteTOTPCodeBody{},
+		Res:         models.DashboardUser{},
+		Fn: func(c *fiber.Ctx) error {
+			ctx := ctxPkg.Get(c)
+			user := ctx.DashboardUser
+
+			body := RouteTOTPCodeBody{}
+			err := c.BodyParser(&body)
+			if err != nil {
+				return err
+			}
+
+			if user.TOTPEnabled == enable {
+				return ErrorRes(c, fiber.StatusBadRequest, errTOTPSameState)
+			}
+			if user.TOTPSecret == "" {
+				return ErrorRes(c, fiber.StatusBadRequest, errTOTPNoSecret)
+			}
+			if retryAfter := ctx.Auth.AttemptRetryAfter(user.Username, c.IP()); retryAfter > 0 {
+				return tooManyAttemptsRes(c, retryAfter)
+			}
+			valid, err := useTOTPCode(ctx.DBConn, user, body.Code)
+			if err != nil {
+				return err
+			}
+			if !valid {
+				ctx.Auth.AttemptFailed(user.Username, c.IP())
+				return ErrorRes(c, fiber.StatusBadRequest, errTOTPInvalid)
+			}
+			ctx.Auth.AttemptSucceeded(user.Username)
+
+			user.TOTPEnabled = enable
+			if !enable {
+				user.TOTPSecret = ""
+			}
+			err = ctx.DBConn.UpdateByID(user)
+			if err != nil {
+				return err
+			}
+
+			addAuditLogEntry(c, models.AuditLogEntry{
+				Action: action,
+				UserID: &user.ID,
+			})
+
+			return c.JSON(user)
+		},
+	}
+}
+
+// tooManyAttemptsRes responds with status 429 and tells the client when it can try again
+func tooManyAttemptsRes(c *fiber.Ctx, retryAfter time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	return ErrorRes(c, fiber.StatusTooManyRequests, auth.ErrTooManyAttempts)
+}
+
+// useTOTPCode checks the totp code of the user and marks it as used so it cannot be used again
+func useTOTPCode(conn db.Connection, user *models.DashboardUser, code string) (bool, error) {
+	step, valid := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !valid {
+		return false, nil
+	}
+	valid, err := models.UseTOTPStep(conn, user.ID, step)
+	if err != nil || !valid {
+		return false, err
+	}
+	user.TOTPLastStep = step
+	return true, nil
+}
+
+var routeEnableTOTP = routeToggleTOTP(true)
+var routeDisableTOTP = routeToggleTOTP(false)
+
+// middlewareBindMyUser sets the DashboardUser to the user of the session used to authenticate
+func middlewareBindMyUser() routeBuilder.M {
+	return routeBuilder.M{
+		Fn: func(c *fiber.Ctx) error {
+			ctx := ctxPkg.Get(c)
+			if ctx.Session == nil {
+				return ErrorRes(c, fiber.StatusBadRequest, errSessionRequired)
+			}
+
+			user, err := models.GetDashboardUser(ctx.DBConn, ctx.Session.UserID)
+			if err != nil {
+				return err
+			}
+			ctx.DashboardUser = &user
+
+			return c.Next()
+		},
+	}
+}
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/script-development/RT-CV/helpers/auth"
+	"github.com/script-development/RT-CV/helpers/routeBuilder"
+	"github.com/script-development/RT-CV/helpers/totp"
+	"github.com/script-development/RT-CV/models"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestDashboardUserSessions(t *testing.T) {
+	app := newTestingRouter(t)
+
+	// Create a user using the admin key
+	res, body := app.MakeRequest(routeBuilder.Post, `/api/v1/users`, TestReqOpts{
+		Body: []byte(`{"username": "Alice", "password": "a-very-good-password"}`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+	user := models.DashboardUser{}
+	err := json.Unmarshal(body, &user)
+	NoError(t, err)
+	Equal(t, "alice", user.Username)
+	Equal(t, models.APIKeyRoleDashboard, user.Roles)
+	NotContains(t, string(body), "passwordHash")
+
+	// Login with a wrong password
+	res, _ = app.MakeRequest(routeBuilder.Post, `/api/v1/auth/login`, TestReqOpts{
+		NoAuth: true,
+		Body:   []byte(`{"username": "alice", "password": "wrong password"}`),
+	})
+	Equal(t, 401, res.StatusCode)
+
+	login := func() RouteLoginRes {
+		res, body := app.MakeRequest(routeBuilder.Post, `/api/v1/auth/login`, TestReqOpts{
+			NoAuth: true,
+			Body:   []byte(`{"username": "alice", "password": "a-very-good-password"}`),
+		})
+		Equal(t, 200, res.StatusCode, string(body))
+		loginRes := RouteLoginRes{}
+		err := json.Unmarshal(body, &loginRes)
+		NoError(t, err)
+		return loginRes
+	}
+	loginRes := login()
+	Equal(t, user.ID, loginRes.User.ID)
+
+	// Use the session
+	app.authHeader = "Bearer " + loginRes.Tokens.AccessToken
+	res, body = app.MakeRequest(routeBuilder.Get, `/api/v1/auth/me`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(body))
+	res, _ = app.MakeRequest(routeBuilder.Get, `/api/v1/profiles`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode)
+
+	// A dashboard user without the admin role cannot manage users
+	res, _ = app.MakeRequest(routeBuilder.Get, `/api/v1/users`, TestReqOpts{})
+	Equal(t, 403, res.StatusCode)
+
+	// Refresh the session
+	refreshBody := []byte(`{"refreshToken": "` + loginRes.Tokens.RefreshToken + `"}`)
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/auth/refresh`, TestReqOpts{NoAuth: true, Body: refreshBody})
+	Equal(t, 200, res.StatusCode, string(body))
+	refreshRes := RouteLoginRes{}
+	err = json.Unmarshal(body, &refreshRes)
+	NoError(t, err)
+	NotEqual(t, loginRes.Tokens.RefreshToken, refreshRes.Tokens.RefreshToken)
+
+	// Re-using the old refresh token should revoke the session
+	res, _ = app.MakeRequest(routeBuilder.Post, `/api/v1/auth/refresh`, TestReqOpts{NoAuth: true, Body: refreshBody})
+	Equal(t, 401, res.StatusCode)
+	app.authHeader = "Bearer " + refreshRes.Tokens.AccessToken
+	res, _ = app.MakeRequest(routeBuilder.Get, `/api/v1/auth/me`, TestReqOpts{})
+	Equal(t, 401, res.StatusCode)
+
+	// Setup and enable totp
+	loginRes = login()
+	app.authHeader = "Bearer " + loginRes.Tokens.AccessToken
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/auth/totp/setup`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode, string(body))
+	setupRes := RouteSetupTOTPRes{}
+	err = json.Unmarshal(body, &setupRes)
+	NoError(t, err)
+	code, err := totp.Code(setupRes.Secret, time.Now())
+	NoError(t, err)
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/auth/totp/enable`, TestReqOpts{Body: []byte(`{"code": "` + code + `"}`)})
+	Equal(t, 200, res.StatusCode, string(body))
+
+	// Login now requires the totp code
+	res, _ = app.MakeRequest(routeBuilder.Post, `/api/v1/auth/login`, TestReqOpts{
+		NoAuth: true,
+		Body:   []byte(`{"username": "alice", "password": "a-very-good-password"}`),
+	})
+	Equal(t, 401, res.StatusCode)
+
+	// The code used to enable totp cannot be used again
+	res, _ = app.MakeRequest(routeBuilder.Post, `/api/v1/auth/login`, TestReqOpts{
+		NoAuth: true,
+		Body:   []byte(`{"username": "alice", "password": "a-very-good-password", "totpCode": "` + code + `"}`),
+	})
+	Equal(t, 401, res.StatusCode)
+
+	// The code of the next period is accepted to allow for clock drift
+	nextCode, err := totp.Code(setupRes.Secret, time.Now().Add(totp.Period))
+	NoError(t, err)
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/auth/login`, TestReqOpts{
+		NoAuth: true,
+		Body:   []byte(`{"username": "alice", "password": "a-very-good-password", "totpCode": "` + nextCode + `"}`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+
+	// Logout
+	res, _ = app.MakeRequest(routeBuilder.Post, `/api/v1/auth/logout`, TestReqOpts{})
+	Equal(t, 200, res.StatusCode)
+	res, _ = app.MakeRequest(routeBuilder.Get, `/api/v1/auth/me`, TestReqOpts{})
+	Equal(t, 401, res.StatusCode)
+
+	// The actions should be visible in the audit log
+	entries, err := models.GetAuditLog(app.db, &user.ID)
+	NoError(t, err)
+	actions := []models.AuditAction{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	Contains(t, actions, models.AuditActionUserCreated)
+	Contains(t, actions, models.AuditActionLoginFailed)
+	Contains(t, actions, models.AuditActionLogin)
+	Contains(t, actions, models.AuditActionSessionRevoked)
+	Contains(t, actions, models.AuditActionTOTPEnabled)
+	Contains(t, actions, models.AuditActionLogout)
+}
+
+func TestLoginThrottle(t *testing.T) {
+	app := newTestingRouter(t)
+
+	for _, username := range []string{"alice", "bob"} {
+		res, body := app.MakeRequest(routeBuilder.Post, `/api/v1/users`, TestReqOpts{
+			Body: []byte(`{"username": "` + username + `", "password": "a-very-good-password"}`),
+		})
+		Equal(t, 200, res.StatusCode, string(body))
+	}
+	login := func(username, password string) *http.Response {
+		res, _ := app.MakeRequest(routeBuilder.Post, `/api/v1/auth/login`, TestReqOpts{
+			NoAuth: true,
+			Body:   []byte(`{"username": "` + username + `", "password": "` + password + `"}`),
+		})
+		return res
+	}
+
+	for i := 0; i < auth.MaxFailedAttemptsPerUser; i++ {
+		Equal(t, 401, login("alice", "wrong password").StatusCode)
+	}
+
+	// After too many failed attempts even the right password is rejected
+	res := login("alice", "a-very-good-password")
+	Equal(t, 429, res.StatusCode)
+	NotEmpty(t, res.Header.Get("Retry-After"))
+
+	// Other users are only blocked once the ip has too many failed attempts
+	Equal(t, 200, login("bob", "a-very-good-password").StatusCode)
+	for i := auth.MaxFailedAttemptsPerUser; i < auth.MaxFailedAttemptsPerIP; i++ {
+		Equal(t, 401, login(fmt.Sprintf("unknown-%d", i), "wrong password").StatusCode)
+	}
+	Equal(t, 429, login("bob", "a-very-good-password").StatusCode)
+}
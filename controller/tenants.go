@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	ctxPkg "github.com/script-development/RT-CV/controller/ctx"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/routeBuilder"
+	"github.com/script-development/RT-CV/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errTenantKey = errors.New("keys that belong to a tenant cannot access this route")
+
+// middlewareRequiresNoTenant only allows keys that do not belong to a tenant
+func middlewareRequiresNoTenant() routeBuilder.M {
+	return routeBuilder.M{
+		Tags: []routeBuilder.Tag{{
+			Name:        "Auth No Tenant",
+			Description: "route can only be used by keys that do not belong to a tenant",
+		}},
+		Fn: func(c *fiber.Ctx) error {
+			if ctxPkg.Get(c).Key.TenantID != nil {
+				return ErrorRes(c, fiber.StatusForbidden, errTenantKey)
+			}
+			return c.Next()
+		},
+	}
+}
+
+// applyTenantChange changes the tenant of an entry
+// Only keys without a tenant can move entries between tenants, for keys with a tenant the db connection sets the tenant
+func applyTenantChange(conn db.Connection, entry *db.T, tenantID *primitive.ObjectID, removeTenant bool) error {
+	if tenantID == nil && !removeTenant {
+		return nil
+	}
+	if db.TenantOf(conn) != nil {
+		return errTenantKey
+	}
+
+	if removeTenant {
+		entry.TenantID = nil
+		return nil
+	}
+
+	err := models.CheckTenantExists(conn, *tenantID)
+	if err != nil {
+		return err
+	}
+	entry.TenantID = tenantID
+	return nil
+}
+
+var routeGetTenants = routeBuilder.R{
+	Description: "get all tenants",
+	Res:         []models.Tenant{},
+	Fn: func(c *fiber.Ctx) error {
+		tenants, err := models.GetTenants(ctxPkg.Get(c).DBConn)
+		if err != nil {
+			return err
+		}
+		return c.JSON(tenants)
+	},
+}
+
+type tenantModifyCreateData struct {
+	Name *string `json:"name"`
+}
+
+var routeCreateTenant = routeBuilder.R{
+	Description: "create a new tenant",
+	Body:        tenantModifyCreateData{},
+	Res:         models.Tenant{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+
+		body := tenantModifyCreateData{}
+		err := c.BodyParser(&body)
+		if err != nil {
+			return err
+		}
+		if body.Name == nil {
+			return errors.New("name is required")
+		}
+
+		tenant := models.Tenant{M: db.NewM(), Name: *body.Name}
+		err = tenant.Validate()
+		if err != nil {
+			return err
+		}
+
+		err = ctx.DBConn.Insert(&tenant)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(tenant)
+	},
+}
+
+var routeGetTenant = routeBuilder.R{
+	Description: "get a tenant",
+	Res:         models.Tenant{},
+	Fn: func(c *fiber.Ctx) error {
+		return c.JSON(ctxPkg.Get(c).Tenant)
+	},
+}
+
+var routeUpdateTenant = routeBuilder.R{
+	Description: "update a tenant",
+	Body:        tenantModifyCreateData{},
+	Res:         models.Tenant{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+		tenant := ctx.Tenant
+
+		body := tenantModifyCreateData{}
+		err := c.BodyParser(&body)
+		if err != nil {
+			return err
+		}
+		if body.Name != nil {
+			tenant.Name = *body.Name
+		}
+
+		err = tenant.Validate()
+		if err != nil {
+			return err
+		}
+
+		err = ctx.DBConn.UpdateByID(tenant)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(tenant)
+	},
+}
+
+var routeDeleteTenant = routeBuilder.R{
+	Description: "delete a tenant, this is only allowed if no api keys, profiles, on match hooks or dashboard users belong to the tenant anymore",
+	Res:         models.Tenant{},
+	Fn: func(c *fiber.Ctx) error {
+		ctx := ctxPkg.Get(c)
+		tenant := ctx.Tenant
+
+		err := models.CheckTenantIsEmpty(ctx.DBConn, tenant.ID)
+		if err != nil {
+			return ErrorRes(c, fiber.StatusConflict, err)
+		}
+
+		err = ctx.DBConn.DeleteByID(&models.Tenant{}, tenant.ID)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(tenant)
+	},
+}
+
+func middlewareBindTenant(urlParamName string) routeBuilder.M {
+	return routeBuilder.M{
+		Fn: func(c *fiber.Ctx) error {
+			tenantID, err := primitive.ObjectIDFromHex(c.Params(urlParamName))
+			if err != nil {
+				return err
+			}
+
+			ctx := ctxPkg.Get(c)
+			tenant, err := models.GetTenant(ctx.DBConn, tenantID)
+			if err != nil {
+				return err
+			}
+			ctx.Tenant = &tenant
+
+			return c.Next()
+		},
+	}
+}
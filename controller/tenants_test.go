@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/match"
+	"github.com/script-development/RT-CV/helpers/routeBuilder"
+	"github.com/script-development/RT-CV/mock"
+	"github.com/script-development/RT-CV/models"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTenantSeparation(t *testing.T) {
+	app := newTestingRouter(t)
+
+	// Create a tenant using the admin key
+	res, body := app.MakeRequest(routeBuilder.Post, `/api/v1/tenants`, TestReqOpts{
+		Body: []byte(`{"name": "Customer A"}`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+	tenant := models.Tenant{}
+	err := json.Unmarshal(body, &tenant)
+	NoError(t, err)
+
+	// Scraper keys are shared and cannot belong to a tenant
+	res, _ = app.MakeRequest(routeBuilder.Post, `/api/v1/keys`, TestReqOpts{
+		Body: []byte(`{"name": "scraper", "domains": ["*"], "key": "ffffffffffffffffffffffffffffffff", "roles": 1, "tenantId": "` + tenant.ID.Hex() + `"}`),
+	})
+	Equal(t, 500, res.StatusCode)
+
+	tenantKey := &models.APIKey{
+		M:       db.NewM(),
+		T:       db.T{TenantID: &tenant.ID},
+		Name:    "Customer A key",
+		Enabled: true,
+		Domains: []string{"*"},
+		Key:     "ffffffffffffffffffffffffffffffff",
+		Roles:   models.APIKeyRoleInformationObtainer | models.APIKeyRoleController | models.APIKeyRoleAdmin,
+	}
+	err = app.db.Insert(tenantKey)
+	NoError(t, err)
+	app.ChangeAuthKey(tenantKey)
+
+	// The tenant cannot see the profiles of others
+	_, body = app.MakeRequest(routeBuilder.Get, `/api/v1/profiles`, TestReqOpts{})
+	profiles := []models.Profile{}
+	err = json.Unmarshal(body, &profiles)
+	NoError(t, err)
+	Len(t, profiles, 0)
+
+	res, _ = app.MakeRequest(routeBuilder.Get, `/api/v1/profiles/`+mock.Profile1.ID.Hex(), TestReqOpts{})
+	Equal(t, 404, res.StatusCode)
+	res, _ = app.MakeRequest(routeBuilder.Delete, `/api/v1/profiles/`+mock.Profile1.ID.Hex(), TestReqOpts{})
+	Equal(t, 404, res.StatusCode)
+
+	// Profiles created by the tenant belong to the tenant
+	res, body = app.MakeRequest(routeBuilder.Post, `/api/v1/profiles`, TestReqOpts{
+		Body: []byte(`{"name": "Customer A profile"}`),
+	})
+	Equal(t, 200, res.StatusCode, string(body))
+	profile := models.Profile{}
+	err = json.Unmarshal(body, &profile)
+	NoError(t, err)
+	NotNil(t, profile.TenantID)
+	Equal(t, tenant.ID, *profile.TenantID)
+
+	_, body = app.MakeRequest(routeBuilder.Get, `/api/v1/profiles`, TestReqOpts{})
+	profiles = []models.Profile{}
+	err = json.Unmarshal(body, &profiles)
+	NoError(t, err)
+	Len(t, profiles, 1)
+
+	// The tenant only sees its own keys and cannot manage tenants
+	_, body = app.MakeRequest(routeBuilder.Get, `/api/v1/keys`, TestReqOpts{})
+	keys := []models.APIKey{}
+	err = json.Unmarshal(body, &keys)
+	NoError(t, err)
+	Len(t, keys, 1)
+	Equal(t, tenantKey.ID, keys[0].ID)
+
+	res, _ = app.MakeRequest(routeBuilder.Get, `/api/v1/tenants`, TestReqOpts{})
+	Equal(t, 403, res.StatusCode)
+
+	// A tenant cannot be removed while entries still belong to it
+	app.ChangeAuthKey(mock.DashboardKey)
+	res, _ = app.MakeRequest(routeBuilder.Delete, `/api/v1/tenants/`+tenant.ID.Hex(), TestReqOpts{})
+	Equal(t, 409, res.StatusCode)
+}
+
+func TestProcessMatchesOfTenant(t *testing.T) {
+	tenantA := db.NewM().ID
+	tenantB := db.NewM().ID
+
+	args := ProcessMatches{}
+	for _, tenantID := range []*primitive.ObjectID{nil, &tenantA, &tenantA} {
+		foundMatch := match.FoundMatch{}
+		foundMatch.Matches.TenantID = tenantID
+		args.MatchedProfiles = append(args.MatchedProfiles, foundMatch)
+	}
+
+	Len(t, args.matchesOfTenant(nil), 1)
+	Len(t, args.matchesOfTenant(&tenantA), 2)
+	Len(t, args.matchesOfTenant(&tenantB), 0)
+}
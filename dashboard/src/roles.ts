@@ -45,7 +45,7 @@ export function roleInfo(role: Roles): undefined | RoleInfo {
         case Roles.Admin:
             return {
                 title: 'Admin',
-                description: 'Currently unused role',
+                description: 'Can manage api keys and system settings',
             }
     }
 }
@@ -5,9 +5,18 @@ export interface ApiKey {
     id: string
     key: string
     roles: number
+    scope: null | ApiKeyScope
+    tenantId: null | string
     system: boolean
 }
 
+export interface ApiKeyScope {
+    readOnly: boolean
+    profileIds: Array<string> | null
+    profileLabels: { [key: string]: any } | null
+    hookIds: Array<string> | null
+}
+
 export interface Secret {
     id: string
     keyId: string
@@ -50,8 +59,14 @@ export interface DutchZipCode {
 export interface OnMatchHook {
     id: string
     keyId: string
+    tenantId: null | string
     disabled: boolean
     url: string
     method: string
     addHeaders: Array<{ key: string, value: Array<string> }>
 }
+
+export interface Tenant {
+    id: string
+    name: string
+}
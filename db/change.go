@@ -0,0 +1,26 @@
+package db
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ChangeKind describes what happened to an entry
+type ChangeKind uint8
+
+const (
+	// ChangeInsert is used when an entry is inserted
+	ChangeInsert ChangeKind = iota
+	// ChangeUpdate is used when an entry is updated or replaced
+	ChangeUpdate
+	// ChangeDelete is used when an entry is deleted
+	ChangeDelete
+)
+
+// Change is a change made to an entry in the database, send to the watchers of a collection
+type Change struct {
+	Kind       ChangeKind
+	Collection string
+	ID         primitive.ObjectID
+
+	// Entry contains the entry as it is after the change
+	// For deletes this is nil
+	Entry Entry
+}
@@ -10,12 +10,27 @@ import (
 type FindOptions struct {
 	// NoDefaultFilters does not include the default filters for the entry provided
 	NoDefaultFilters bool
+
+	// Limit limits the number of results, 0 means no limit
+	Limit int64
+
+	// Skip skips the first n results
+	Skip int64
+
+	// Sort sorts the results, the value of every field should be 1 for ascending or -1 for descending order
+	// For example: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}
+	Sort bson.D
+
+	// Projection limits the fields that are fetched, fields that are not fetched are left at their zero value
+	// Use 1 to include a field or 0 to exclude a field, including and excluding fields can't be mixed except for the _id field
+	// For example: bson.M{"name": 1}
+	Projection bson.M
 }
 
 // Connection is a abstract interface for a database connection
 // There are 2 main implementations of this:
 // - MongoConnection (For the MongoDB driver)
-// - TestConnection (For a fake temp database)
+// - TestConnection (For a fake temp database, or a database stored in files when used with a filedb.Store)
 type Connection interface {
 	// RegisterEntries tells the database to register the given entries
 	// In the case of the mongodb database this means we'll create a collection for each entry
@@ -41,6 +56,26 @@ type Connection interface {
 	// Count counts the number of documents in the database for the specific filter
 	// If filter is nil the number of all the documents is returned
 	Count(entry Entry, filter bson.M) (uint64, error)
+
+	// InsertMany inserts a slice of entries of the same collection, for example a []Profile or []*Profile
+	// Entries without an id get a new id
+	InsertMany(entries any) error
+
+	// UpdateMany applies update to all the entries matching filter and returns the number of matched entries
+	// update should only contain update operators, for example bson.M{"$set": bson.M{"active": false}}
+	UpdateMany(entry Entry, filter bson.M, update bson.M) (uint64, error)
+
+	// DeleteMany deletes all the entries matching filter and returns the number of deleted entries
+	DeleteMany(entry Entry, filter bson.M) (uint64, error)
+
+	// WithTransaction executes fn inside of a transaction
+	// If fn returns an error all writes made using tx are rolled back, otherwise they are committed
+	// Only use tx inside of fn, calling WithTransaction on tx executes fn within the same transaction
+	WithTransaction(fn func(tx Connection) error) error
+
+	// Watch calls fn for every insert, update and delete of an entry in the collection of entry
+	// Changes are delivered in the order they happened, the returned stop function stops watching
+	Watch(entry Entry, fn func(change Change)) (stop func(), err error)
 }
 
 // Entry are the functions required to put/get things in/from the database
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+)
+
+var entryType = reflect.TypeOf((*Entry)(nil)).Elem()
+
+// EntriesOf converts a slice of entries like a []Profile or []*Profile into a []Entry
+// For slices of structs the returned entries point into the original slice so changes like new ids are visible to the caller
+// Returns an error if entries is not a slice of entries or if the entries belong to different collections
+func EntriesOf(entries any) ([]Entry, error) {
+	entriesRefl := reflect.ValueOf(entries)
+	if entriesRefl.Kind() == reflect.Ptr {
+		entriesRefl = entriesRefl.Elem()
+	}
+	if entriesRefl.Kind() != reflect.Slice {
+		return nil, errors.New("entries must be a slice")
+	}
+
+	elemType := entriesRefl.Type().Elem()
+	elemIsPtr := elemType.Kind() == reflect.Ptr || elemType.Kind() == reflect.Interface
+	if !elemIsPtr && !reflect.PtrTo(elemType).Implements(entryType) || elemIsPtr && !elemType.Implements(entryType) {
+		return nil, errors.New("entries must be a slice of db entries")
+	}
+
+	res := make([]Entry, entriesRefl.Len())
+	for idx := range res {
+		item := entriesRefl.Index(idx)
+		if elemIsPtr {
+			if item.IsNil() {
+				return nil, errors.New("entries cannot contain nil values")
+			}
+		} else {
+			item = item.Addr()
+		}
+		res[idx] = item.Interface().(Entry)
+
+		if res[idx].CollectionName() != res[0].CollectionName() {
+			return nil, errors.New("all entries must belong to the same collection")
+		}
+	}
+	return res, nil
+}
+
+// NewEntryOfSameType returns a new empty entry with the same type as e
+func NewEntryOfSameType(e Entry) Entry {
+	return reflect.New(reflect.TypeOf(e).Elem()).Interface().(Entry)
+}
@@ -0,0 +1,239 @@
+package filedb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// collectionFileExt is the extension of the collection files
+// Every file contains the BSON documents of a collection written after each other, this is the same format as used by mongodump
+const collectionFileExt = ".bson"
+
+// Store keeps collections in files inside of a directory
+// Use it together with testingdb.NewDBWithStore to get a database that survives restarts without needing MongoDB
+//
+// Every write replaces the file of the collection so this is only suitable for small databases
+// A store locks its directory so only one process at the same time can use it
+type Store struct {
+	dir  string
+	lock *os.File
+}
+
+// lockFileName is the name of the file that is locked by the store that uses the directory
+const lockFileName = ".lock"
+
+// ErrLocked is returned when opening a directory that is used by another store, for example by a running RT-CV server
+var ErrLocked = errors.New("the file database is in use by another process, stop the RT-CV server that uses it and try again")
+
+// NewStore returns a store that keeps its files in dir, the directory is created if it does not exist
+// The directory is locked until the store is closed, ErrLocked is returned if another store uses the directory
+func NewStore(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(lock)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	s := &Store{dir: dir, lock: lock}
+	err = s.recover()
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("unable to recover from an interrupted write: %s", err.Error())
+	}
+	return s, nil
+}
+
+// Close releases the lock on the directory, the store should not be used after closing it
+func (s *Store) Close() error {
+	return s.lock.Close()
+}
+
+// Dir returns the directory of the store
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+func (s *Store) collectionPath(collection string) (string, error) {
+	if collection == "" || strings.ContainsAny(collection, `/\`) || strings.HasPrefix(collection, ".") {
+		return "", fmt.Errorf("invalid collection name %q", collection)
+	}
+	return filepath.Join(s.dir, collection+collectionFileExt), nil
+}
+
+// Load implements testingdb.Store
+func (s *Store) Load(collection string, newEntry func() db.Entry) ([]db.Entry, error) {
+	entries := []db.Entry{}
+	err := s.ReadCollection(collection, func(doc bson.Raw) error {
+		entry := newEntry()
+		err := bson.Unmarshal(doc, entry)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// Save implements testingdb.Store
+// Either all collections are saved or none of them
+func (s *Store) Save(collections map[string][]db.Entry) error {
+	renames := []fileRename{}
+	removeTemps := func() {
+		for _, rename := range renames {
+			os.Remove(rename.from)
+		}
+	}
+
+	for collection, entries := range collections {
+		path, err := s.collectionPath(collection)
+		if err != nil {
+			removeTemps()
+			return err
+		}
+
+		tempPath, err := writeTempFile(path, func(w io.Writer) error {
+			for _, entry := range entries {
+				doc, err := bson.Marshal(entry)
+				if err != nil {
+					return err
+				}
+				_, err = w.Write(doc)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			removeTemps()
+			return err
+		}
+		renames = append(renames, fileRename{from: tempPath, to: path})
+	}
+
+	err := s.commit(renames)
+	if err != nil {
+		removeTemps()
+	}
+	return err
+}
+
+// CollectionNames returns the names of the stored collections
+func (s *Store) CollectionNames() ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, collectionFileExt) || strings.HasPrefix(name, ".") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, collectionFileExt))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ReadCollection calls fn for every stored document of the collection
+// A collection without a file has no documents
+func (s *Store) ReadCollection(collection string, fn func(doc bson.Raw) error) error {
+	path, err := s.collectionPath(collection)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return readDocuments(bufio.NewReader(f), fn)
+}
+
+// readDocuments reads BSON documents written after each other from r
+func readDocuments(r io.Reader, fn func(doc bson.Raw) error) error {
+	for {
+		lengthBytes := make([]byte, 4)
+		_, err := io.ReadFull(r, lengthBytes)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read document length: %s", err.Error())
+		}
+
+		length := binary.LittleEndian.Uint32(lengthBytes)
+		if length < 5 {
+			return errors.New("invalid document length")
+		}
+
+		doc := make([]byte, length)
+		copy(doc, lengthBytes)
+		_, err = io.ReadFull(r, doc[4:])
+		if err != nil {
+			return fmt.Errorf("failed to read document: %s", err.Error())
+		}
+
+		raw := bson.Raw(doc)
+		err = raw.Validate()
+		if err != nil {
+			return err
+		}
+		err = fn(raw)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeTempFile writes a temporary file next to path and returns the path of the temporary file
+// The temporary file can be moved to path once it's fully written so path never contains a half written file
+func writeTempFile(path string, write func(w io.Writer) error) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+tempFileExt)
+	if err != nil {
+		return "", err
+	}
+
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
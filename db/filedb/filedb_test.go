@@ -0,0 +1,168 @@
+package filedb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type mockNote struct {
+	db.M `bson:",inline"`
+	Text string `bson:"text"`
+}
+
+func (*mockNote) CollectionName() string {
+	return "notes"
+}
+
+func TestStorePersistsData(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	NoError(t, err)
+
+	conn := testingdb.NewDBWithStore(store)
+	conn.RegisterEntries(&mockNote{})
+	note := &mockNote{M: db.NewM(), Text: "a"}
+	err = conn.Insert(note, &mockNote{M: db.NewM(), Text: "b"})
+	NoError(t, err)
+	_, err = conn.UpdateMany(&mockNote{}, bson.M{"text": "b"}, bson.M{"$set": bson.M{"text": "c"}})
+	NoError(t, err)
+	err = conn.WithTransaction(func(tx db.Connection) error {
+		return tx.DeleteByID(&mockNote{}, note.ID)
+	})
+	NoError(t, err)
+
+	// A new connection should see the same data
+	NoError(t, store.Close())
+	store, err = NewStore(dir)
+	NoError(t, err)
+	conn = testingdb.NewDBWithStore(store)
+	conn.RegisterEntries(&mockNote{})
+	notes := []mockNote{}
+	err = conn.Find(&mockNote{}, &notes, nil)
+	NoError(t, err)
+	Len(t, notes, 1)
+	Equal(t, "c", notes[0].Text)
+
+	names, err := store.CollectionNames()
+	NoError(t, err)
+	Equal(t, []string{"notes"}, names)
+
+	// No temp files should be left behind
+	files, err := os.ReadDir(dir)
+	NoError(t, err)
+	Len(t, files, 2)
+}
+
+func TestStoreLock(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	NoError(t, err)
+
+	_, err = NewStore(dir)
+	ErrorIs(t, err, ErrLocked)
+
+	NoError(t, store.Close())
+	store, err = NewStore(dir)
+	NoError(t, err)
+	NoError(t, store.Close())
+}
+
+func TestStoreSaveMultipleCollections(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	NoError(t, err)
+
+	note := &mockNote{M: db.NewM(), Text: "a"}
+	err = store.Save(map[string][]db.Entry{"notes": {note}, "other_notes": {note}})
+	NoError(t, err)
+
+	// A collection that can't be saved prevents the other collections from being saved
+	err = store.Save(map[string][]db.Entry{"notes": {}, "../notes": {note}})
+	Error(t, err)
+	notes, err := store.Load("notes", func() db.Entry { return &mockNote{} })
+	NoError(t, err)
+	Len(t, notes, 1)
+
+	// A commit interrupted after writing the journal is finished when the store is opened again
+	NoError(t, store.Close())
+	err = os.WriteFile(filepath.Join(dir, ".notes.bson.1.tmp"), []byte{}, 0600)
+	NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, ".other_notes.bson.1.tmp"), []byte{}, 0600)
+	NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, ".notes.bson.2.tmp"), []byte{}, 0600)
+	NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, journalFileName), []byte(`[
+		{"from": ".notes.bson.1.tmp", "to": "notes.bson"},
+		{"from": ".other_notes.bson.1.tmp", "to": "other_notes.bson"}
+	]`), 0600)
+	NoError(t, err)
+
+	store, err = NewStore(dir)
+	NoError(t, err)
+	for _, collection := range []string{"notes", "other_notes"} {
+		notes, err = store.Load(collection, func() db.Entry { return &mockNote{} })
+		NoError(t, err)
+		Len(t, notes, 0)
+	}
+
+	// The journal and the temp file of the interrupted write are removed
+	files, err := os.ReadDir(dir)
+	NoError(t, err)
+	Len(t, files, 3)
+}
+
+func TestStoreInvalidData(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	NoError(t, err)
+
+	_, err = store.Load("../notes", func() db.Entry { return &mockNote{} })
+	Error(t, err)
+
+	err = os.WriteFile(filepath.Join(store.Dir(), "notes.bson"), []byte{1, 2, 3, 4, 5, 6}, 0600)
+	NoError(t, err)
+	_, err = store.Load("notes", func() db.Entry { return &mockNote{} })
+	Error(t, err)
+}
+
+func TestRestore(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	NoError(t, err)
+	conn := testingdb.NewDBWithStore(store)
+	err = conn.Insert(&mockNote{M: db.NewM(), Text: "old"})
+	NoError(t, err)
+
+	doc, err := bson.Marshal(&mockNote{M: db.NewM(), Text: "restored"})
+	NoError(t, err)
+
+	// An aborted restore leaves the data untouched
+	restore := store.NewRestore()
+	err = restore.Insert("notes", doc)
+	NoError(t, err)
+	restore.Abort()
+	files, err := os.ReadDir(store.Dir())
+	NoError(t, err)
+	Len(t, files, 2)
+
+	restore = store.NewRestore()
+	err = restore.Insert("notes", doc)
+	NoError(t, err)
+	err = restore.Insert("notes", doc)
+	NoError(t, err)
+	err = restore.Commit()
+	NoError(t, err)
+
+	restored := 0
+	err = store.ReadCollection("notes", func(raw bson.Raw) error {
+		Equal(t, "restored", raw.Lookup("text").StringValue())
+		restored++
+		return nil
+	})
+	NoError(t, err)
+	Equal(t, 2, restored)
+}
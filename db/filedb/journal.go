@@ -0,0 +1,120 @@
+package filedb
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempFileExt is the extension of the temporary files written before they replace the file of a collection
+const tempFileExt = ".tmp"
+
+// journalFileName is the name of the file that lists the files to move when committing multiple collections
+// Once the journal is written the commit is final, if moving the files is interrupted it's finished when the store is opened again
+const journalFileName = ".commit"
+
+// fileRename moves a fully written temporary file to the file of a collection
+type fileRename struct {
+	from string
+	to   string
+}
+
+// journalEntry is a file rename as stored in the journal, the paths are relative to the directory of the store
+type journalEntry struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// commit moves the temporary files to the files of the collections
+// Either all files are moved or none of them, the temporary files are removed if the commit fails before the journal is written
+func (s *Store) commit(renames []fileRename) error {
+	removeTemps := func() {
+		for _, rename := range renames {
+			os.Remove(rename.from)
+		}
+	}
+
+	switch len(renames) {
+	case 0:
+		return nil
+	case 1:
+		err := os.Rename(renames[0].from, renames[0].to)
+		if err != nil {
+			removeTemps()
+		}
+		return err
+	}
+
+	journal := make([]journalEntry, len(renames))
+	for idx, rename := range renames {
+		journal[idx] = journalEntry{
+			From: filepath.Base(rename.from),
+			To:   filepath.Base(rename.to),
+		}
+	}
+
+	journalPath := filepath.Join(s.dir, journalFileName)
+	tempJournalPath, err := writeTempFile(journalPath, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(journal)
+	})
+	if err != nil {
+		removeTemps()
+		return err
+	}
+	err = os.Rename(tempJournalPath, journalPath)
+	if err != nil {
+		os.Remove(tempJournalPath)
+		removeTemps()
+		return err
+	}
+
+	return s.finishCommit(journal)
+}
+
+// finishCommit moves the files listed in the journal and removes the journal
+// Files that do not exist anymore are already moved by an earlier attempt
+func (s *Store) finishCommit(journal []journalEntry) error {
+	for _, entry := range journal {
+		err := os.Rename(filepath.Join(s.dir, entry.From), filepath.Join(s.dir, entry.To))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Remove(filepath.Join(s.dir, journalFileName))
+}
+
+// recover finishes an interrupted commit and removes the temporary files left behind by interrupted writes
+// Must only be called while holding the lock on the directory
+func (s *Store) recover() error {
+	journalBytes, err := os.ReadFile(filepath.Join(s.dir, journalFileName))
+	if err == nil {
+		journal := []journalEntry{}
+		err = json.Unmarshal(journalBytes, &journal)
+		if err != nil {
+			return err
+		}
+		err = s.finishCommit(journal)
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if !file.IsDir() && strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempFileExt) {
+			err = os.Remove(filepath.Join(s.dir, name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package filedb
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Restore replaces the collections of a store with restored documents
+// Documents are first written to temporary files, Commit replaces the collections with the temporary files
+// Collections that are not part of the restore are left untouched
+type Restore struct {
+	store *Store
+	files map[string]*restoreFile
+}
+
+type restoreFile struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// NewRestore starts a new restore of the store
+func (s *Store) NewRestore() *Restore {
+	return &Restore{
+		store: s,
+		files: map[string]*restoreFile{},
+	}
+}
+
+// Insert adds a document to the restored collection
+func (r *Restore) Insert(collection string, doc bson.Raw) error {
+	file, ok := r.files[collection]
+	if !ok {
+		path, err := r.store.collectionPath(collection)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+tempFileExt)
+		if err != nil {
+			return err
+		}
+		file = &restoreFile{path: path, f: f, w: bufio.NewWriter(f)}
+		r.files[collection] = file
+	}
+
+	_, err := file.w.Write(doc)
+	return err
+}
+
+// Commit replaces the collections with the restored collections
+// Either all collections are replaced or none of them
+func (r *Restore) Commit() error {
+	renames := []fileRename{}
+	for _, file := range r.files {
+		err := file.w.Flush()
+		if err == nil {
+			err = file.f.Sync()
+		}
+		if err != nil {
+			r.Abort()
+			return err
+		}
+		renames = append(renames, fileRename{from: file.f.Name(), to: file.path})
+	}
+
+	var closeErr error
+	for collection, file := range r.files {
+		err := file.f.Close()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+		delete(r.files, collection)
+	}
+	if closeErr != nil {
+		for _, rename := range renames {
+			os.Remove(rename.from)
+		}
+		return closeErr
+	}
+
+	// commit removes the temporary files itself if it fails
+	return r.store.commit(renames)
+}
+
+// Abort removes the temporary files of the collections that are not yet replaced
+func (r *Restore) Abort() {
+	for collection, file := range r.files {
+		file.f.Close()
+		os.Remove(file.f.Name())
+		delete(r.files, collection)
+	}
+}
@@ -0,0 +1,162 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a versioned change to the shape of the data in the database
+type Migration struct {
+	// Version orders the migrations, every migration needs an unique version
+	// Never change the version of a migration that might already be applied
+	Version uint
+	Name    string
+
+	// Up applies the migration and returns the number of affected entries
+	// Migrations should be idempotent as a migration that fails halfway is executed again on the next run
+	Up func(conn db.Connection) (affected uint64, err error)
+}
+
+// AppliedMigration is stored in the database for every applied migration
+type AppliedMigration struct {
+	db.M      `bson:",inline"`
+	Version   uint      `json:"version" bson:"version"`
+	Name      string    `json:"name" bson:"name"`
+	Affected  uint64    `json:"affected" bson:"affected"`
+	AppliedAt time.Time `json:"appliedAt" bson:"appliedAt"`
+}
+
+// CollectionName implements db.Entry
+func (*AppliedMigration) CollectionName() string {
+	return "migrations"
+}
+
+// Indexes implements db.Entry
+func (*AppliedMigration) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.M{"version": 1}, Options: options.Index().SetUnique(true)},
+	}
+}
+
+// Result contains the outcome of a single migration executed by Run
+type Result struct {
+	Version  uint
+	Name     string
+	Affected uint64
+}
+
+// Run applies the migrations that are not yet applied in order of their version
+// With dryRun set the migrations are executed without writing anything to the database, the results contain the entries that would be affected
+func Run(conn db.Connection, migrations []Migration, dryRun bool) ([]Result, error) {
+	migrations = append([]Migration{}, migrations...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for idx, migration := range migrations {
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %d has no Up function", migration.Version)
+		}
+		if idx > 0 && migrations[idx-1].Version == migration.Version {
+			return nil, fmt.Errorf("migration version %d is used more than once", migration.Version)
+		}
+	}
+
+	appliedMigrations := []AppliedMigration{}
+	err := conn.Find(&AppliedMigration{}, &appliedMigrations, nil)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[uint]bool{}
+	for _, appliedMigration := range appliedMigrations {
+		applied[appliedMigration.Version] = true
+	}
+
+	if dryRun {
+		conn = &dryRunConnection{Connection: conn}
+	}
+
+	results := []Result{}
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		logger := log.WithField("version", migration.Version).WithField("dry_run", dryRun)
+		logger.Infof("applying migration %s", migration.Name)
+
+		affected, err := migration.Up(conn)
+		if err != nil {
+			return results, fmt.Errorf("migration %d (%s) failed: %s", migration.Version, migration.Name, err.Error())
+		}
+
+		err = conn.Insert(&AppliedMigration{
+			M:         db.NewM(),
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Affected:  affected,
+			AppliedAt: time.Now(),
+		})
+		if err != nil {
+			return results, err
+		}
+
+		logger.Infof("applied migration %s, %d entries affected", migration.Name, affected)
+		results = append(results, Result{
+			Version:  migration.Version,
+			Name:     migration.Name,
+			Affected: affected,
+		})
+	}
+
+	return results, nil
+}
+
+// dryRunConnection wraps a connection and turns all write operations into no-ops
+// Bulk writes return the number of entries they would have affected
+type dryRunConnection struct {
+	db.Connection
+}
+
+// Insert implements db.Connection
+func (*dryRunConnection) Insert(...db.Entry) error {
+	return nil
+}
+
+// InsertMany implements db.Connection
+func (*dryRunConnection) InsertMany(entries any) error {
+	_, err := db.EntriesOf(entries)
+	return err
+}
+
+// UpdateByID implements db.Connection
+func (*dryRunConnection) UpdateByID(db.Entry) error {
+	return nil
+}
+
+// DeleteByID implements db.Connection
+func (*dryRunConnection) DeleteByID(db.Entry, ...primitive.ObjectID) error {
+	return nil
+}
+
+// UpdateMany implements db.Connection
+func (c *dryRunConnection) UpdateMany(e db.Entry, filter bson.M, _ bson.M) (uint64, error) {
+	return c.Connection.Count(e, filter)
+}
+
+// DeleteMany implements db.Connection
+func (c *dryRunConnection) DeleteMany(e db.Entry, filter bson.M) (uint64, error) {
+	return c.Connection.Count(e, filter)
+}
+
+// WithTransaction implements db.Connection
+func (c *dryRunConnection) WithTransaction(fn func(tx db.Connection) error) error {
+	return fn(c)
+}
@@ -0,0 +1,117 @@
+package migrations
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type migrationNote struct {
+	db.M `bson:",inline"`
+	Text string `bson:"text"`
+}
+
+func (*migrationNote) CollectionName() string {
+	return "notes"
+}
+
+func testMigrations(executed *[]uint) []Migration {
+	return []Migration{
+		{
+			Version: 2,
+			Name:    "update notes",
+			Up: func(conn db.Connection) (uint64, error) {
+				*executed = append(*executed, 2)
+				return conn.UpdateMany(&migrationNote{}, bson.M{"text": "old"}, bson.M{"$set": bson.M{"text": "new"}})
+			},
+		},
+		{
+			Version: 1,
+			Name:    "insert note",
+			Up: func(conn db.Connection) (uint64, error) {
+				*executed = append(*executed, 1)
+				return 1, conn.Insert(&migrationNote{M: db.NewM(), Text: "old"})
+			},
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	conn := testingdb.NewDB()
+	executed := []uint{}
+
+	results, err := Run(conn, testMigrations(&executed), false)
+	NoError(t, err)
+	Equal(t, []uint{1, 2}, executed)
+	Equal(t, []Result{
+		{Version: 1, Name: "insert note", Affected: 1},
+		{Version: 2, Name: "update notes", Affected: 1},
+	}, results)
+
+	count, err := conn.Count(&migrationNote{}, bson.M{"text": "new"})
+	NoError(t, err)
+	Equal(t, uint64(1), count)
+	count, err = conn.Count(&AppliedMigration{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(2), count)
+
+	// Already applied migrations are skipped
+	executed = []uint{}
+	migrations := append(testMigrations(&executed), Migration{
+		Version: 3,
+		Name:    "noop",
+		Up:      func(db.Connection) (uint64, error) { return 0, nil },
+	})
+	results, err = Run(conn, migrations, false)
+	NoError(t, err)
+	Empty(t, executed)
+	Equal(t, []Result{{Version: 3, Name: "noop"}}, results)
+}
+
+func TestRunDryRun(t *testing.T) {
+	conn := testingdb.NewDB()
+	err := conn.Insert(&migrationNote{M: db.NewM(), Text: "old"})
+	NoError(t, err)
+
+	executed := []uint{}
+	results, err := Run(conn, testMigrations(&executed), true)
+	NoError(t, err)
+	Equal(t, []uint{1, 2}, executed)
+	Equal(t, uint64(1), results[1].Affected)
+
+	// Nothing should be written to the database
+	count, err := conn.Count(&migrationNote{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(1), count)
+	count, err = conn.Count(&migrationNote{}, bson.M{"text": "new"})
+	NoError(t, err)
+	Equal(t, uint64(0), count)
+	count, err = conn.Count(&AppliedMigration{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(0), count)
+}
+
+func TestRunErrors(t *testing.T) {
+	conn := testingdb.NewDB()
+	noop := func(db.Connection) (uint64, error) { return 0, nil }
+
+	_, err := Run(conn, []Migration{{Version: 1, Up: noop}, {Version: 1, Up: noop}}, false)
+	Error(t, err)
+	_, err = Run(conn, []Migration{{Version: 1}}, false)
+	Error(t, err)
+
+	// A failing migration is not recorded so it is retried on the next run
+	results, err := Run(conn, []Migration{
+		{Version: 1, Name: "ok", Up: noop},
+		{Version: 2, Name: "fails", Up: func(db.Connection) (uint64, error) { return 0, errors.New("oops") }},
+	}, false)
+	Error(t, err)
+	Len(t, results, 1)
+	count, err := conn.Count(&AppliedMigration{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(1), count)
+}
@@ -0,0 +1,295 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/crypto"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+
+An archive is a portable version of a backup that can be read without RT-CV
+It's an uncompressed tar file with for every collection a <collection name>.json file
+Every line of a collection file is a document in the MongoDB canonical extended JSON format, the same format as mongoexport uses
+This means an archive can also be imported using: mongoimport --collection=<collection name> --file=<collection name>.json
+
+The archive ends with a manifest.json that contains the amount of documents per collection
+
+*/
+
+const (
+	archiveFormat       = "rt-cv-archive"
+	archiveVersion      = 1
+	archiveManifestName = "manifest.json"
+)
+
+type archiveManifest struct {
+	Format      string            `json:"format"`
+	Version     int               `json:"version"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	Collections map[string]uint64 `json:"collections"`
+}
+
+// ExportArchive decrypts a backup file using the keyring and writes it as an archive to w
+// Returns the amount of documents per collection
+func ExportArchive(backupFile io.Reader, keyring *crypto.Keyring, w io.Writer) (map[string]uint64, error) {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	writeFile := func(name string, data []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+
+	counts := map[string]uint64{}
+	currentCollection := ""
+	collectionData := bytes.NewBuffer(nil)
+	flush := func() error {
+		if currentCollection == "" {
+			return nil
+		}
+		err := writeFile(currentCollection+".json", collectionData.Bytes())
+		collectionData.Reset()
+		return err
+	}
+
+	// The documents of a collection are stored together in a backup so we only have to keep one collection in memory
+	var archiveErr error
+	err := readbackup(backupFile, keyring, func(collection string, doc bson.Raw, err error) {
+		if archiveErr != nil {
+			return
+		}
+		if err != nil {
+			archiveErr = fmt.Errorf("%w: %s", ErrInvalidBackup, err.Error())
+			return
+		}
+		if collection != currentCollection {
+			if counts[collection] > 0 {
+				archiveErr = fmt.Errorf("%w: documents of collection %s are not stored together", ErrInvalidBackup, collection)
+				return
+			}
+			archiveErr = flush()
+			currentCollection = collection
+		}
+
+		line, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			archiveErr = fmt.Errorf("unable to convert a document of collection %s to extended JSON: %s", collection, err.Error())
+			return
+		}
+		collectionData.Write(line)
+		collectionData.WriteByte('\n')
+		counts[collection]++
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBackup, err.Error())
+	}
+	if archiveErr == nil {
+		archiveErr = flush()
+	}
+	if archiveErr != nil {
+		return nil, archiveErr
+	}
+
+	manifest, err := json.MarshalIndent(archiveManifest{
+		Format:      archiveFormat,
+		Version:     archiveVersion,
+		CreatedAt:   now,
+		Collections: counts,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = writeFile(archiveManifestName, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, tw.Close()
+}
+
+// ReadArchive calls fn for every document in an archive
+// An error is returned if the amount of documents does not match the manifest of the archive
+func ReadArchive(archive io.Reader, fn func(collection string, doc bson.Raw) error) (map[string]uint64, error) {
+	tr := tar.NewReader(archive)
+	counts := map[string]uint64{}
+	var manifest *archiveManifest
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read archive: %s", err.Error())
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(header.Name)
+		if name == archiveManifestName {
+			manifest = &archiveManifest{}
+			err = json.NewDecoder(tr).Decode(manifest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid archive manifest: %s", err.Error())
+			}
+			if manifest.Format != archiveFormat || manifest.Version != archiveVersion {
+				return nil, fmt.Errorf("unsupported archive format %s version %d", manifest.Format, manifest.Version)
+			}
+			continue
+		}
+
+		collection := strings.TrimSuffix(name, ".json")
+		if collection == name || collection == "" || strings.ContainsAny(collection, `/\`) || strings.HasPrefix(collection, ".") {
+			return nil, fmt.Errorf("unexpected file %s in archive", header.Name)
+		}
+		if _, ok := counts[collection]; ok {
+			return nil, fmt.Errorf("collection %s is stored multiple times in the archive", collection)
+		}
+		counts[collection] = 0
+
+		lines := bufio.NewReader(tr)
+		for lineNr := 1; ; lineNr++ {
+			line, err := lines.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if len(bytes.TrimSpace(line)) > 0 {
+				doc := bson.D{}
+				parseErr := bson.UnmarshalExtJSON(line, false, &doc)
+				if parseErr != nil {
+					return nil, fmt.Errorf("%s line %d: %s", header.Name, lineNr, parseErr.Error())
+				}
+				rawDoc, parseErr := bson.Marshal(doc)
+				if parseErr != nil {
+					return nil, fmt.Errorf("%s line %d: %s", header.Name, lineNr, parseErr.Error())
+				}
+				counts[collection]++
+				if fn != nil {
+					err = fn(collection, rawDoc)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+	}
+
+	if manifest != nil {
+		for collection, expected := range manifest.Collections {
+			if counts[collection] != expected {
+				return nil, fmt.Errorf("archive is incomplete, expected %d documents in collection %s but found %d", expected, collection, counts[collection])
+			}
+		}
+		for collection := range counts {
+			if _, ok := manifest.Collections[collection]; !ok {
+				return nil, fmt.Errorf("collection %s is not in the archive manifest", collection)
+			}
+		}
+	}
+
+	return counts, nil
+}
+
+// ImportArchive replaces the collections in the database with the collections from an archive
+// Collections that are not in the archive are left untouched
+//
+// MongoDB and the file database store the documents as-is
+// Other connections like the in memory testing database need the entries of the collections in the archive to decode the documents
+func ImportArchive(dbConn db.Connection, archive io.Reader, entries ...db.Entry) (map[string]uint64, error) {
+	database, ok := supportedDatabase(dbConn)
+	if !ok {
+		return importArchiveEntries(dbConn, archive, entries)
+	}
+
+	restore, err := database.newRestore()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := ReadArchive(archive, restore.insert)
+	if err != nil {
+		restore.abort()
+		return nil, err
+	}
+	return counts, restore.commit()
+}
+
+// importArchiveEntries imports an archive by decoding the documents into entries and inserting them using dbConn
+func importArchiveEntries(dbConn db.Connection, archive io.Reader, entries []db.Entry) (map[string]uint64, error) {
+	entryTypes := map[string]reflect.Type{}
+	for _, entry := range entries {
+		entryTypes[entry.CollectionName()] = reflect.TypeOf(entry).Elem()
+	}
+
+	newEntry := func(collection string) (db.Entry, error) {
+		entryType, ok := entryTypes[collection]
+		if !ok {
+			return nil, fmt.Errorf("unknown collection %s in archive", collection)
+		}
+		return reflect.New(entryType).Interface().(db.Entry), nil
+	}
+
+	collections := map[string][]db.Entry{}
+	counts, err := ReadArchive(archive, func(collection string, doc bson.Raw) error {
+		entry, err := newEntry(collection)
+		if err != nil {
+			return err
+		}
+		err = bson.Unmarshal(doc, entry)
+		if err != nil {
+			return fmt.Errorf("unable to decode a document of collection %s: %s", collection, err.Error())
+		}
+		collections[collection] = append(collections[collection], entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Also make sure the empty collections are known so they are emptied
+	for collection := range counts {
+		_, err = newEntry(collection)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return counts, dbConn.WithTransaction(func(tx db.Connection) error {
+		for collection := range counts {
+			entry, _ := newEntry(collection)
+			_, err := tx.DeleteMany(entry, bson.M{})
+			if err != nil {
+				return err
+			}
+			if len(collections[collection]) > 0 {
+				err = tx.Insert(collections[collection]...)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
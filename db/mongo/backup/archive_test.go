@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/filedb"
+	"github.com/script-development/RT-CV/db/testingdb"
+	"github.com/script-development/RT-CV/models"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// exportTestArchive creates an archive from the backup created by restoreTestSetup
+func exportTestArchive(t *testing.T) (*testingdb.TestConnection, []byte) {
+	conn, options, name := restoreTestSetup(t)
+	storage, err := options.CreateStorage()
+	NoError(t, err)
+	backupFile, err := storage.Download(name)
+	if !NoError(t, err) {
+		t.FailNow()
+	}
+	defer backupFile.Close()
+
+	keyring, err := options.Keyring()
+	NoError(t, err)
+	archive := bytes.NewBuffer(nil)
+	counts, err := ExportArchive(backupFile, keyring, archive)
+	if !NoError(t, err) {
+		t.FailNow()
+	}
+	Equal(t, uint64(2), counts["tenants"])
+	Equal(t, uint64(1), counts["dashboardUsers"])
+	return conn, archive.Bytes()
+}
+
+func TestExportArchive(t *testing.T) {
+	conn, archive := exportTestArchive(t)
+
+	// Every collection is a extended JSON file
+	files := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		NoError(t, err)
+		data, err := io.ReadAll(tr)
+		NoError(t, err)
+		files[header.Name] = string(data)
+	}
+	Contains(t, files, archiveManifestName)
+	Contains(t, files, "dashboardUsers.json")
+	tenantLines := strings.Split(strings.TrimSpace(files["tenants.json"]), "\n")
+	if Len(t, tenantLines, 2) {
+		tenants, err := models.GetTenants(conn)
+		NoError(t, err)
+		Contains(t, tenantLines[0], `{"_id":{"$oid":"`+tenants[0].ID.Hex()+`"}`)
+	}
+
+	documents := 0
+	counts, err := ReadArchive(bytes.NewReader(archive), func(collection string, doc bson.Raw) error {
+		documents++
+		return doc.Validate()
+	})
+	NoError(t, err)
+	Equal(t, 3, documents)
+	Equal(t, map[string]uint64{"tenants": 2, "dashboardUsers": 1}, counts)
+}
+
+func TestExportArchiveWrongKey(t *testing.T) {
+	_, options, name := restoreTestSetup(t)
+	storage, err := options.CreateStorage()
+	NoError(t, err)
+	backupFile, err := storage.Download(name)
+	NoError(t, err)
+	defer backupFile.Close()
+
+	options.BackupEncryptionKey = "fedcba9876543210"
+	keyring, err := options.Keyring()
+	NoError(t, err)
+	_, err = ExportArchive(backupFile, keyring, io.Discard)
+	True(t, errors.Is(err, ErrInvalidBackup), err)
+}
+
+func TestImportArchive(t *testing.T) {
+	_, archive := exportTestArchive(t)
+
+	t.Run("file database", func(t *testing.T) {
+		store, err := filedb.NewStore(t.TempDir())
+		NoError(t, err)
+		conn := testingdb.NewDBWithStore(store)
+		conn.RegisterEntries(&models.Tenant{})
+		NoError(t, conn.Insert(&models.Tenant{M: db.NewM(), Name: "replaced"}))
+
+		_, err = ImportArchive(conn, bytes.NewReader(archive))
+		NoError(t, err)
+		ElementsMatch(t, []string{"a", "b"}, tenantNames(t, reopen(conn)))
+	})
+
+	t.Run("testing database", func(t *testing.T) {
+		conn := testingdb.NewDB()
+		NoError(t, conn.Insert(&models.Tenant{M: db.NewM(), Name: "replaced"}))
+
+		_, err := ImportArchive(conn, bytes.NewReader(archive), &models.Tenant{})
+		Error(t, err, "the dashboard users entry is not provided")
+
+		_, err = ImportArchive(conn, bytes.NewReader(archive), &models.Tenant{}, &models.DashboardUser{})
+		NoError(t, err)
+		ElementsMatch(t, []string{"a", "b"}, tenantNames(t, conn))
+		user := models.DashboardUser{}
+		NoError(t, conn.FindOne(&user, bson.M{"username": "admin"}))
+	})
+}
+
+func TestReadArchiveErrors(t *testing.T) {
+	archiveWith := func(files map[string]string) io.Reader {
+		buf := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(buf)
+		for name, data := range files {
+			NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}))
+			_, err := tw.Write([]byte(data))
+			NoError(t, err)
+		}
+		NoError(t, tw.Close())
+		return buf
+	}
+
+	// Archives without a manifest can be read, for example when created by hand from mongoexport files
+	counts, err := ReadArchive(archiveWith(map[string]string{
+		"tenants.json": `{"_id":{"$oid":"5f1f3e8f8f8f8f8f8f8f8f8f"},"name":"a"}` + "\n" + `{"name":"b","count":1}`,
+	}), nil)
+	NoError(t, err)
+	Equal(t, map[string]uint64{"tenants": 2}, counts)
+
+	for name, files := range map[string]map[string]string{
+		"invalid json":      {"tenants.json": `{"name":`},
+		"unexpected file":   {"tenants.txt": ``},
+		"path in name":      {"../tenants.json": `{}`},
+		"manifest mismatch": {"tenants.json": `{}`, archiveManifestName: `{"format":"rt-cv-archive","version":1,"collections":{"tenants":2}}`},
+		"unknown format":    {archiveManifestName: `{"format":"other","version":1}`},
+	} {
+		_, err = ReadArchive(archiveWith(files), nil)
+		Error(t, err, name)
+	}
+}
@@ -1,26 +1,38 @@
 package backup
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/apex/log"
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/script-development/RT-CV/db"
-	"github.com/script-development/RT-CV/db/mongo"
+	"github.com/script-development/RT-CV/helpers/crypto"
 	"github.com/script-development/RT-CV/models"
 )
 
 // StartScheduleOptions are the options required to run StartsSchedule
-// All fields are required to start the backup schedule
 type StartScheduleOptions struct {
 	// The key used to encrypt / decrypt the backup files
 	BackupEncryptionKey string
 
+	// Keys previously used to encrypt the backup files, these are only used to decrypt
+	OldBackupEncryptionKeys []string
+
+	// Storage selects where the backups are stored, StorageS3 (the default) or StorageLocal
+	Storage string
+
+	// Retention decides which backups are kept after creating a new backup
+	// Retention is opt-in, by default all backups are kept
+	Retention RetentionPolicy
+
+	// Local storage options
+	LocalPath string
+
 	// S3 connection options
 	S3Endpoint        string
 	S3AccessKeyID     string
@@ -33,76 +45,165 @@ type StartScheduleOptions struct {
 func StartScheduleOptionsFromEnv() StartScheduleOptions {
 	return StartScheduleOptions{
 		BackupEncryptionKey: os.Getenv("MONGODB_BACKUP_KEY"),
+		Storage:             strings.ToLower(os.Getenv("BACKUP_STORAGE")),
+		LocalPath:           os.Getenv("BACKUP_LOCAL_PATH"),
 		S3Endpoint:          os.Getenv("BACKUP_S3_ENDPOINT"),
 		S3AccessKeyID:       os.Getenv("BACKUP_S3_ACCESS_KEY_ID"),
 		S3SecretAccessKey:   os.Getenv("BACKUP_S3_SECRET_ACCESS_KEY"),
 		S3Bucket:            os.Getenv("BACKUP_S3_BUCKET"),
 		S3UseSSL:            strings.ToLower(os.Getenv("BACKUP_S3_USE_SSL")) == "true",
+		Retention: RetentionPolicy{
+			Daily:   retentionFromEnv("BACKUP_KEEP_DAILY"),
+			Weekly:  retentionFromEnv("BACKUP_KEEP_WEEKLY"),
+			Monthly: retentionFromEnv("BACKUP_KEEP_MONTHLY"),
+		},
+		OldBackupEncryptionKeys: oldKeysFromEnv(),
 	}
 }
 
-func (o *StartScheduleOptions) createS3Client(createBucketIfNotExist bool) *minio.Client {
-	s3Client, err := minio.New(o.S3Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(o.S3AccessKeyID, o.S3SecretAccessKey, ""),
-		Secure: o.S3UseSSL,
-	})
+// oldKeysFromEnv reads the comma separated old backup keys
+func oldKeysFromEnv() []string {
+	keys := []string{}
+	for _, key := range strings.Split(os.Getenv("MONGODB_BACKUP_OLD_KEYS"), ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Keyring returns the keyring with the current and old backup keys
+func (o StartScheduleOptions) Keyring() (*crypto.Keyring, error) {
+	oldKeys := make([][]byte, len(o.OldBackupEncryptionKeys))
+	for idx, key := range o.OldBackupEncryptionKeys {
+		oldKeys[idx] = []byte(key)
+	}
+	keyring, err := crypto.NewKeyring([]byte(o.BackupEncryptionKey), oldKeys...)
 	if err != nil {
-		log.WithError(err).Fatal("failed to create minio client")
+		return nil, fmt.Errorf("invalid backup keys, make sure you have set the MONGODB_BACKUP_KEY env variable: %s", err.Error())
 	}
+	return keyring, nil
+}
 
-	if createBucketIfNotExist {
-		bucketExists, err := s3Client.BucketExists(context.Background(), o.S3Bucket)
-		if err != nil {
-			log.WithError(err).Fatal("failed to check if backup bucket exists")
-		}
+// retentionFromEnv reads the amount of backups to keep from an env variable
+// Returns 0 if the env variable is not set or invalid so no backups are removed unless the retention is explicitly configured
+func retentionFromEnv(key string) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+	parsedValue, err := strconv.Atoi(value)
+	if err != nil || parsedValue < 0 {
+		log.WithField("value", value).Warnf("$%s is not a valid amount of backups, this period is not used by the retention policy", key)
+		return 0
+	}
+	return parsedValue
+}
 
-		if !bucketExists {
-			// Try to create the bucket if it doesn't exist yet
-			err = s3Client.MakeBucket(context.Background(), o.S3Bucket, minio.MakeBucketOptions{})
-			if err != nil {
-				log.WithError(err).Fatal("unable to create the bucket used to store backups")
-			}
-		}
+// CreateStorage creates the storage selected by the options
+func (o StartScheduleOptions) CreateStorage() (Storage, error) {
+	switch o.Storage {
+	case StorageS3, "":
+		return NewS3Storage(o)
+	case StorageLocal:
+		return NewLocalStorage(o.LocalPath)
+	default:
+		return nil, fmt.Errorf("unknown backup storage %q, expected %s or %s", o.Storage, StorageS3, StorageLocal)
 	}
+}
 
-	return s3Client
+// Scheduler creates the backups on a schedule or on demand
+// After every successful backup the retention policy is applied to the backup storage
+type Scheduler struct {
+	dbConn    db.Connection
+	storage   Storage
+	key       string
+	retention RetentionPolicy
+
+	m       sync.Mutex
+	running bool
+	lastRun *RunStatus
 }
 
-func unwrapMongoConn(dbConn db.Connection) *mongo.Connection {
-	conn, ok := dbConn.(*mongo.Connection)
-	if !ok {
-		log.Fatal("DB Connection is not a Mongo DB connection")
+// Triggers of a backup run
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// RunStatus describes a backup run
+type RunStatus struct {
+	Running    bool       `json:"running"`
+	Trigger    string     `json:"trigger" description:"What started the backup, schedule or manual"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+	Backup     string     `json:"backup" description:"The name of the created backup, empty if no backup was created"`
+	Pruned     []string   `json:"pruned" description:"The backups removed by the retention policy"`
+	Error      string     `json:"error"`
+}
+
+// ErrBackupRunning is returned when triggering a backup while another backup is being created
+var ErrBackupRunning = errors.New("a backup is already being created")
+
+// NewScheduler validates the options and creates a scheduler that is not yet started
+func NewScheduler(dbConn db.Connection, options StartScheduleOptions) (*Scheduler, error) {
+	// Make sure the database supports backups before starting the schedule
+	databaseOf(dbConn)
+
+	_, err := options.Keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := options.CreateStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Retention.Enabled() {
+		log.WithFields(log.Fields{
+			"daily":   options.Retention.Daily,
+			"weekly":  options.Retention.Weekly,
+			"monthly": options.Retention.Monthly,
+		}).Warn("the backup retention policy is enabled, backups that are not kept by the policy are removed from the backup storage after every backup")
 	}
-	return conn
+
+	return &Scheduler{
+		dbConn:    dbConn,
+		storage:   storage,
+		key:       options.BackupEncryptionKey,
+		retention: options.Retention,
+	}, nil
 }
 
 // StartsSchedule starts the cron job for creating the backups
 // The backupMasterKey is used to encrypt the backup files generated
-func StartsSchedule(dbConn db.Connection, options StartScheduleOptions, forceBackup bool) {
-	mongoDBConn := unwrapMongoConn(dbConn)
-
-	if len(options.BackupEncryptionKey) < 16 {
-		msg := "encryption key is too short, make sure you have set the MONGODB_BACKUP_KEY env variable"
-		log.Fatalf("Error initializing backup: " + msg)
+func StartsSchedule(dbConn db.Connection, options StartScheduleOptions, forceBackup bool) (*Scheduler, error) {
+	s, err := NewScheduler(dbConn, options)
+	if err != nil {
+		return nil, err
 	}
+	s.Start(forceBackup)
+	return s, nil
+}
 
-	s3Client := options.createS3Client(true)
-
-	// Check every 24 hours if we need to create a backup
+// Start checks every 24 hours if a backup is needed
+func (s *Scheduler) Start(forceBackup bool) {
 	ticker := time.NewTicker(24 * time.Hour)
 	go func() {
-		checkNeedBackup(s3Client, options.S3Bucket, mongoDBConn, options.BackupEncryptionKey, forceBackup)
+		s.checkNeedBackup(forceBackup)
 		for range ticker.C {
-			checkNeedBackup(s3Client, options.S3Bucket, mongoDBConn, options.BackupEncryptionKey, false)
+			s.checkNeedBackup(false)
 		}
 	}()
 }
 
-func checkNeedBackup(s3Client *minio.Client, bucketName string, dbConn *mongo.Connection, backupMasterKey string, force bool) {
+func (s *Scheduler) checkNeedBackup(force bool) {
 	if force {
 		log.Info("creating a new backup..")
 	} else {
-		needToCreateDB, err := models.NeedToCreateBackup(dbConn)
+		needToCreateDB, err := models.NeedToCreateBackup(s.dbConn)
 		if err != nil {
 			log.WithError(err).Error("Failed to check if backup is needed")
 			return
@@ -114,11 +215,114 @@ func checkNeedBackup(s3Client *minio.Client, bucketName string, dbConn *mongo.Co
 		log.Info("to long ago since last backup, creating a new backup..")
 	}
 
-	backupFile, err := CreateBackupFile(dbConn, backupMasterKey)
+	status, err := s.begin(TriggerSchedule)
 	if err != nil {
-		log.WithError(err).Error("Failed to create backup of database")
+		log.WithError(err).Warn("Skipping scheduled backup")
 		return
 	}
+	s.run(status)
+}
+
+// Trigger starts creating a backup in the background
+func (s *Scheduler) Trigger() (RunStatus, error) {
+	status, err := s.begin(TriggerManual)
+	if err != nil {
+		return RunStatus{}, err
+	}
+	go s.run(status)
+	return *status, nil
+}
+
+// Status returns the status of the last backup run, nil if no backup was made since startup
+func (s *Scheduler) Status() *RunStatus {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.lastRun == nil {
+		return nil
+	}
+	status := *s.lastRun
+	return &status
+}
+
+// begin marks the start of a new run, only one run can be active at the same time
+func (s *Scheduler) begin(trigger string) (*RunStatus, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.running {
+		return nil, ErrBackupRunning
+	}
+	s.running = true
+	s.lastRun = &RunStatus{
+		Running:   true,
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+		Pruned:    []string{},
+	}
+	return s.lastRun, nil
+}
+
+// run creates the backup and applies the retention policy
+func (s *Scheduler) run(status *RunStatus) {
+	name, err := CreateBackup(s.storage, s.dbConn, s.key)
+	pruned := []string{}
+	if err != nil {
+		log.WithError(err).Error("Failed to create backup")
+	} else {
+		log.Infof("stored backup file with name %s", name)
+
+		pruned, err = s.prune()
+		if err != nil {
+			log.WithError(err).Error("Failed to apply the backup retention policy")
+			err = fmt.Errorf("backup created but applying the retention policy failed: %s", err.Error())
+		} else if len(pruned) > 0 {
+			log.Infof("removed %d old backup(s)", len(pruned))
+		}
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	s.running = false
+	s.lastRun = &RunStatus{
+		Trigger:    status.Trigger,
+		StartedAt:  status.StartedAt,
+		FinishedAt: &now,
+		Backup:     name,
+		Pruned:     pruned,
+	}
+	if err != nil {
+		s.lastRun.Error = err.Error()
+	}
+}
+
+// prune removes the backups that are not kept by the retention policy
+func (s *Scheduler) prune() ([]string, error) {
+	backups, err := s.storage.List()
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := []string{}
+	for _, backup := range s.retention.Prune(backups) {
+		err = s.storage.Delete(backup.Name)
+		if err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, backup.Name)
+	}
+	return pruned, models.DeleteBackupCatalogEntries(s.dbConn, pruned)
+}
+
+// CreateBackup creates a backup of the database, stores it in the storage and adds it to the backup catalog
+// Returns the name of the stored backup
+func CreateBackup(storage Storage, dbConn db.Connection, backupMasterKey string) (string, error) {
+	backupFile, collections, err := createBackupFile(dbConn, backupMasterKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup of database: %s", err.Error())
+	}
 	defer func() {
 		backupFile.Close()
 		os.Remove(backupFile.Name())
@@ -126,28 +330,24 @@ func checkNeedBackup(s3Client *minio.Client, bucketName string, dbConn *mongo.Co
 
 	backupFileStat, err := backupFile.Stat()
 	if err != nil {
-		log.WithError(err).Error("Failed to get meta information about the created backup file")
-		return
+		return "", fmt.Errorf("failed to get meta information about the created backup file: %s", err.Error())
 	}
 
-	bucketFileName := fmt.Sprintf(
-		"/rt-cv-backups/%s.gz.aes",
-		time.Now().Format("2006-01-02--15-04"),
-	)
+	createdAt := time.Now()
+	name := createdAt.Format(backupNameLayout) + backupNameSuffix
+	err = storage.Upload(name, backupFile, backupFileStat.Size())
+	if err != nil {
+		return "", fmt.Errorf("failed to upload the backup file: %s", err.Error())
+	}
 
-	_, err = s3Client.PutObject(
-		context.Background(),
-		bucketName,
-		bucketFileName,
-		backupFile,
-		backupFileStat.Size(),
-		minio.PutObjectOptions{
-			ContentType: "binary/octet-stream",
-		},
-	)
+	err = addToCatalog(dbConn, models.BackupCatalogEntry{
+		M:           db.NewM(),
+		Name:        name,
+		CreatedAt:   createdAt,
+		Collections: collections,
+	})
 	if err != nil {
-		log.WithError(err).Error("Failed to upload backup file to S3")
-	} else {
-		log.Infof("uploaded backup file to S3 with name %s", bucketFileName)
+		return name, fmt.Errorf("backup stored but adding it to the backup catalog failed: %s", err.Error())
 	}
+	return name, nil
 }
@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"os"
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/filedb"
+	"github.com/script-development/RT-CV/db/testingdb"
+	"github.com/script-development/RT-CV/helpers/crypto"
+	"github.com/script-development/RT-CV/models"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFileDatabaseBackupAndRestore(t *testing.T) {
+	masterKey := "0123456789abcdef"
+
+	store, err := filedb.NewStore(t.TempDir())
+	NoError(t, err)
+	conn := testingdb.NewDBWithStore(store)
+	conn.RegisterEntries(&models.Tenant{})
+	err = conn.Insert(&models.Tenant{M: db.NewM(), Name: "a"}, &models.Tenant{M: db.NewM(), Name: "b"})
+	NoError(t, err)
+
+	backupFile, err := CreateBackupFile(conn, masterKey)
+	NoError(t, err)
+	defer func() {
+		backupFile.Close()
+		os.Remove(backupFile.Name())
+	}()
+
+	// Restore the backup into an empty store
+	restoreStore, err := filedb.NewStore(t.TempDir())
+	NoError(t, err)
+	restore, err := databaseOf(testingdb.NewDBWithStore(restoreStore)).newRestore()
+	NoError(t, err)
+	keyring, err := crypto.NewKeyring([]byte(masterKey))
+	NoError(t, err)
+	err = readbackup(backupFile, keyring, func(collection string, doc bson.Raw, err error) {
+		NoError(t, err)
+		NoError(t, restore.insert(collection, doc))
+	})
+	NoError(t, err)
+	err = restore.commit()
+	NoError(t, err)
+
+	restoredConn := testingdb.NewDBWithStore(restoreStore)
+	restoredConn.RegisterEntries(&models.Tenant{}, &models.Backup{})
+	count, err := restoredConn.Count(&models.Tenant{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(2), count)
+
+	// The time of the backup is stored in the database
+	count, err = conn.Count(&models.Backup{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(1), count)
+}
@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CatalogEntry describes a backup in the backup storage
+type CatalogEntry struct {
+	StoredBackup
+	CreatedAt   *time.Time        `json:"createdAt" description:"When the backup was created, null if the backup is not in the backup catalog"`
+	Collections map[string]uint64 `json:"collections" description:"The amount of documents per collection, null if the backup is not in the backup catalog"`
+}
+
+// addToCatalog adds a backup to the catalog, replacing an older entry with the same name
+func addToCatalog(dbConn db.Connection, entry models.BackupCatalogEntry) error {
+	_, err := dbConn.DeleteMany(&models.BackupCatalogEntry{}, bson.M{"name": entry.Name})
+	if err != nil {
+		return err
+	}
+	return dbConn.Insert(&entry)
+}
+
+// Catalog returns all backups in the storage, newest first
+// The backups in the storage are leading, the meta data of backups that are not in the catalog (for example because the catalog was restored from an older backup) is left empty
+func (s *Scheduler) Catalog() ([]CatalogEntry, error) {
+	backups, err := s.storage.List()
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := models.GetBackupCatalog(s.dbConn)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]CatalogEntry, len(backups))
+	for idx, backup := range backups {
+		entry := CatalogEntry{StoredBackup: backup}
+		catalogEntry, ok := catalog[backup.Name]
+		if ok {
+			createdAt := catalogEntry.CreatedAt
+			entry.CreatedAt = &createdAt
+			entry.Collections = catalogEntry.Collections
+		}
+		// List returns the backups sorted by name, the names start with the creation date
+		res[len(backups)-1-idx] = entry
+	}
+	return res, nil
+}
@@ -5,11 +5,9 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strings"
 
 	"github.com/apex/log"
-	"github.com/script-development/RT-CV/db/dbHelpers"
-	"github.com/script-development/RT-CV/db/mongo"
+	"github.com/script-development/RT-CV/db"
 	"github.com/script-development/RT-CV/helpers/crypto"
 	"github.com/script-development/RT-CV/helpers/numbers"
 	"github.com/script-development/RT-CV/models"
@@ -43,30 +41,24 @@ The {collection data} is as follows, every document is added like this:
 // CreateBackupFile creates a backup file from the database contents
 //
 // YOU NEED TO CLOSE THE RETURNED FILE
-func CreateBackupFile(dbConn *mongo.Connection, masterKey string) (*os.File, error) {
-	backupFile, err := createBackupWriter(masterKey, func(w io.Writer) error {
-		db := dbConn.GetDB()
+func CreateBackupFile(dbConn db.Connection, masterKey string) (*os.File, error) {
+	backupFile, _, err := createBackupFile(dbConn, masterKey)
+	return backupFile, err
+}
 
-		names, err := db.ListCollectionNames(dbHelpers.Ctx(), bson.M{})
+// createBackupFile creates a backup file and returns the amount of documents per collection in the backup
+func createBackupFile(dbConn db.Connection, masterKey string) (*os.File, map[string]uint64, error) {
+	database := databaseOf(dbConn)
+	collections := map[string]uint64{}
+	backupFile, err := createBackupWriter(masterKey, func(w io.Writer) error {
+		names, err := database.collectionNames()
 		if err != nil {
 			return err
 		}
 
 		for _, name := range names {
-			if strings.HasSuffix(name, "_RESTORE_TEMP") {
-				// Do not backup the restore temp collections
-				continue
-			}
-
-			ctx := dbHelpers.Ctx()
-			cursor, err := db.Collection(name).Find(ctx, bson.M{})
-			if err != nil {
-				return err
-			}
 			first := true
-			for cursor.Next(ctx) {
-				document := make(bson.Raw, len(cursor.Current))
-				copy(document, cursor.Current)
+			err = database.readCollection(name, func(document bson.Raw) error {
 				if first {
 					// Only write the name of the collection once we are sure
 					collectionNameData := append(numbers.UintToBytes(uint64(len(name)), 16), []byte(name)...)
@@ -81,27 +73,35 @@ func CreateBackupFile(dbConn *mongo.Connection, masterKey string) (*os.File, err
 
 				w.Write(numbers.UintToBytes(uint64(len(document)), 64))
 				w.Write(document)
+				collections[name]++
+				return nil
+			})
+			if err != nil {
+				return err
 			}
 			if !first {
 				// Write the last document byte
 				// Only write the last document identifier if there where actually documents in this collection
 				w.Write([]byte{1})
 			}
-			cursor.Close(ctx)
 		}
 
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	log.Info("validating generated backup file..")
 
 	// Validate the generated data
-	err = readbackup(backupFile, masterKey, nil)
+	keyring, err := crypto.NewKeyring([]byte(masterKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	err = readbackup(backupFile, keyring, nil)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to validate backup data: %s", err)
+		return nil, nil, fmt.Errorf("Failed to validate backup data: %s", err)
 	}
 
 	_, err = backupFile.Seek(0, 0)
@@ -109,7 +109,7 @@ func CreateBackupFile(dbConn *mongo.Connection, masterKey string) (*os.File, err
 		backupFile.Close()
 		os.Remove(backupFile.Name())
 
-		return nil, err
+		return nil, nil, err
 	}
 
 	err = models.SetLastBackupToNow(dbConn)
@@ -117,10 +117,10 @@ func CreateBackupFile(dbConn *mongo.Connection, masterKey string) (*os.File, err
 		backupFile.Close()
 		os.Remove(backupFile.Name())
 
-		return nil, err
+		return nil, nil, err
 	}
 
-	return backupFile, nil
+	return backupFile, collections, nil
 }
 
 // createBackupWriter creates all the writers and closes them correctly in order in case of a error
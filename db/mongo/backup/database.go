@@ -0,0 +1,249 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/dbHelpers"
+	"github.com/script-development/RT-CV/db/filedb"
+	"github.com/script-development/RT-CV/db/mongo"
+	"github.com/script-development/RT-CV/db/testingdb"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+)
+
+// database is a database that can be backed up and restored
+type database interface {
+	// collectionNames returns the names of the collections to backup
+	collectionNames() ([]string, error)
+
+	// readCollection calls fn for every document in the collection
+	readCollection(name string, fn func(doc bson.Raw) error) error
+
+	// newRestore starts a restore, the restored documents are only visible after a commit
+	newRestore() (restore, error)
+
+	// withTarget returns another database of the same kind
+	withTarget(target string) (database, error)
+
+	// close releases a database returned by withTarget
+	close() error
+}
+
+// restore writes restored documents to temporary collections
+type restore interface {
+	insert(collection string, doc bson.Raw) error
+
+	// commit replaces the real collections with the temporary collections
+	commit() error
+
+	// abort removes the temporary collections
+	abort()
+}
+
+// databaseOf returns the backup database for a database connection
+// Backups are supported for MongoDB and for the testing database with a file store
+func databaseOf(dbConn db.Connection) database {
+	database, ok := supportedDatabase(dbConn)
+	if !ok {
+		log.Fatal("DB Connection is not a Mongo DB connection or a file DB connection")
+	}
+	return database
+}
+
+// supportedDatabase returns the backup database for a database connection
+// Returns false if the database does not support backups
+func supportedDatabase(dbConn db.Connection) (database, bool) {
+	switch conn := dbConn.(type) {
+	case *mongo.Connection:
+		return &mongoDatabase{db: conn.GetDB()}, true
+	case *testingdb.TestConnection:
+		store, ok := conn.Store().(*filedb.Store)
+		if ok {
+			return &fileDatabase{store: store}, true
+		}
+	}
+	return nil, false
+}
+
+type mongoDatabase struct {
+	db *mongodb.Database
+}
+
+func (d *mongoDatabase) collectionNames() ([]string, error) {
+	names, err := d.db.ListCollectionNames(dbHelpers.Ctx(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	filteredNames := []string{}
+	for _, name := range names {
+		if strings.HasSuffix(name, "_RESTORE_TEMP") {
+			// Do not backup the restore temp collections
+			continue
+		}
+		filteredNames = append(filteredNames, name)
+	}
+	return filteredNames, nil
+}
+
+func (d *mongoDatabase) readCollection(name string, fn func(doc bson.Raw) error) error {
+	ctx := dbHelpers.Ctx()
+	cursor, err := d.db.Collection(name).Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		document := make(bson.Raw, len(cursor.Current))
+		copy(document, cursor.Current)
+		err = fn(document)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (d *mongoDatabase) withTarget(name string) (database, error) {
+	if name == "" || strings.ContainsAny(name, "/\\. \"$") {
+		return nil, fmt.Errorf("invalid database name %q", name)
+	}
+	return &mongoDatabase{db: d.db.Client().Database(name)}, nil
+}
+
+func (d *mongoDatabase) close() error {
+	// The target database shares the client with the live database
+	return nil
+}
+
+func (d *mongoDatabase) newRestore() (restore, error) {
+	collectionNamesList, err := d.db.ListCollectionNames(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	existingCollections := map[string]bool{}
+	for _, name := range collectionNamesList {
+		existingCollections[name] = true
+	}
+
+	return &mongoRestore{
+		db:                  d.db,
+		existingCollections: existingCollections,
+		tempCollections:     map[string]*mongodb.Collection{},
+	}, nil
+}
+
+type mongoRestore struct {
+	db                  *mongodb.Database
+	existingCollections map[string]bool
+	tempCollections     map[string]*mongodb.Collection
+}
+
+func (r *mongoRestore) insert(collectionName string, doc bson.Raw) error {
+	tempCollectionName := collectionName + "_RESTORE_TEMP"
+	tempCollection, ok := r.tempCollections[tempCollectionName]
+	if !ok {
+		if r.existingCollections[tempCollectionName] {
+			err := r.db.Collection(tempCollectionName).Drop(context.Background())
+			if err != nil {
+				return err
+			}
+		}
+
+		err := r.db.CreateCollection(context.Background(), tempCollectionName)
+		if err != nil {
+			return err
+		}
+		tempCollection = r.db.Collection(tempCollectionName)
+		r.tempCollections[tempCollectionName] = tempCollection
+	}
+
+	_, err := tempCollection.InsertOne(context.Background(), doc)
+	return err
+}
+
+func (r *mongoRestore) commit() error {
+	helperText := "If anything after this fails you can manually promote the temp collections to the " +
+		"real collections using the following command (you need to rename some_collection_name to the db collection names): " +
+		"db.some_collection_name_RESTORE_TEMP.renameCollection('some_collection_name', true)"
+
+	// How to manually restore:
+	// 1. Open the mongo shell/console
+	// 2. Run: use rt-cv
+	// 3. For every collection with the _RESTORE_TEMP suffix, run:
+	//      db.some_collection_name_RESTORE_TEMP.renameCollection('some_collection_name', true)
+
+	log.Info("Promoting the temp collections to the real collections.. " + helperText)
+
+	// Collections cannot be dropped within a transaction so instead we rename the temp collections
+	// A rename with dropTarget replaces the real collection atomically so a collection is never half restored
+	for tempCollectionName := range r.tempCollections {
+		collectionName := strings.TrimSuffix(tempCollectionName, "_RESTORE_TEMP")
+		err := r.db.Client().Database("admin").RunCommand(context.Background(), bson.D{
+			{Key: "renameCollection", Value: r.db.Name() + "." + tempCollectionName},
+			{Key: "to", Value: r.db.Name() + "." + collectionName},
+			{Key: "dropTarget", Value: true},
+		}).Err()
+		if err != nil {
+			log.WithError(err).WithField("collection", collectionName).Error("failed to promote the temp collection")
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *mongoRestore) abort() {
+	log.Info("trying to removing temp collections..")
+	for _, collection := range r.tempCollections {
+		collection.Drop(context.Background())
+	}
+}
+
+type fileDatabase struct {
+	store *filedb.Store
+}
+
+func (d *fileDatabase) collectionNames() ([]string, error) {
+	return d.store.CollectionNames()
+}
+
+func (d *fileDatabase) readCollection(name string, fn func(doc bson.Raw) error) error {
+	return d.store.ReadCollection(name, fn)
+}
+
+func (d *fileDatabase) withTarget(dir string) (database, error) {
+	store, err := filedb.NewStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &fileDatabase{store: store}, nil
+}
+
+func (d *fileDatabase) close() error {
+	return d.store.Close()
+}
+
+func (d *fileDatabase) newRestore() (restore, error) {
+	return &fileRestore{restore: d.store.NewRestore()}, nil
+}
+
+type fileRestore struct {
+	restore *filedb.Restore
+}
+
+func (r *fileRestore) insert(collection string, doc bson.Raw) error {
+	return r.restore.Insert(collection, doc)
+}
+
+func (r *fileRestore) commit() error {
+	return r.restore.Commit()
+}
+
+func (r *fileRestore) abort() {
+	r.restore.Abort()
+}
@@ -10,8 +10,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 )
 
-func readbackup(fileReader io.Reader, masterKey string, restoreMethod func(collection string, doc bson.Raw, err error)) error {
-	cryptoReader, err := crypto.NewEncryptReader([]byte(masterKey), fileReader)
+func readbackup(fileReader io.Reader, keyring *crypto.Keyring, restoreMethod func(collection string, doc bson.Raw, err error)) error {
+	cryptoReader, err := crypto.NewKeyringEncryptReader(keyring, fileReader)
 	if err != nil {
 		return err
 	}
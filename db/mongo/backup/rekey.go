@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/helpers/crypto"
+)
+
+// RekeyResult describes what Rekey did with a backup
+type RekeyResult struct {
+	Name string
+	// KeyID is the id of the key the backup was encrypted with, empty for backups created before key ids where added
+	KeyID string
+	// Rekeyed is true if the backup was (or in a dry run would be) re-encrypted with the current key
+	Rekeyed bool
+}
+
+// Rekey re-encrypts every backup in the storage that is not encrypted with the current key of the keyring
+// Backups are validated with the current key before they replace the original backup
+func Rekey(storage Storage, keyring *crypto.Keyring, dryRun bool) ([]RekeyResult, error) {
+	backups, err := storage.List()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []RekeyResult{}
+	for _, backup := range backups {
+		result, err := rekeyBackup(storage, keyring, backup.Name, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("backup %s: %w", backup.Name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func rekeyBackup(storage Storage, keyring *crypto.Keyring, name string, dryRun bool) (RekeyResult, error) {
+	result := RekeyResult{Name: name}
+
+	backupFile, err := storage.Download(name)
+	if err != nil {
+		return result, err
+	}
+	defer backupFile.Close()
+
+	header, err := crypto.ReadHeader(backupFile)
+	if err != nil {
+		return result, err
+	}
+	if header != nil {
+		result.KeyID = header.KeyID
+		if header.KeyID == keyring.Current().ID {
+			return result, nil
+		}
+	}
+	result.Rekeyed = true
+	if dryRun {
+		return result, nil
+	}
+
+	log.Infof("re-encrypting backup %s..", name)
+
+	_, err = backupFile.Seek(0, 0)
+	if err != nil {
+		return result, err
+	}
+
+	rekeyedFile, err := os.CreateTemp("", "rt-cv-rekey-*.gz.aes")
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		rekeyedFile.Close()
+		os.Remove(rekeyedFile.Name())
+	}()
+
+	err = crypto.Reencrypt(keyring, backupFile, rekeyedFile)
+	if err != nil {
+		return result, fmt.Errorf("%w: %s", ErrInvalidBackup, err.Error())
+	}
+
+	// Make sure the new backup is readable using only the current key before replacing the old backup
+	_, err = rekeyedFile.Seek(0, 0)
+	if err != nil {
+		return result, err
+	}
+	err = readbackup(rekeyedFile, keyring.WithoutOldKeys(), nil)
+	if err != nil {
+		return result, fmt.Errorf("validating the re-encrypted backup failed: %s", err.Error())
+	}
+
+	size, err := rekeyedFile.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = rekeyedFile.Seek(0, 0)
+	}
+	if err != nil {
+		return result, err
+	}
+	return result, storage.Upload(name, rekeyedFile, size)
+}
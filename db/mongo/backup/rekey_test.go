@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/script-development/RT-CV/helpers/crypto"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestRekey(t *testing.T) {
+	conn, options, name := restoreTestSetup(t)
+	storage, err := options.CreateStorage()
+	NoError(t, err)
+	oldKeyring, err := options.Keyring()
+	NoError(t, err)
+
+	// Rotate the key
+	options.OldBackupEncryptionKeys = []string{options.BackupEncryptionKey}
+	options.BackupEncryptionKey = "a-new-backup-key-for-testing"
+	keyring, err := options.Keyring()
+	NoError(t, err)
+
+	// The old backup can still be restored using the old key
+	_, err = Restore(conn, name, options, RestoreOptions{DryRun: true})
+	NoError(t, err)
+
+	results, err := Rekey(storage, keyring, true)
+	NoError(t, err)
+	Equal(t, []RekeyResult{{Name: name, KeyID: oldKeyring.Current().ID, Rekeyed: true}}, results)
+
+	results, err = Rekey(storage, keyring, false)
+	NoError(t, err)
+	Equal(t, []RekeyResult{{Name: name, KeyID: oldKeyring.Current().ID, Rekeyed: true}}, results)
+
+	// Now the backup is encrypted with the new key
+	results, err = Rekey(storage, keyring, false)
+	NoError(t, err)
+	Equal(t, []RekeyResult{{Name: name, KeyID: keyring.Current().ID, Rekeyed: false}}, results)
+
+	options.OldBackupEncryptionKeys = nil
+	_, err = Restore(conn, name, options, RestoreOptions{DryRun: true})
+	NoError(t, err)
+
+	// The old key can't read the backup anymore
+	_, err = Restore(conn, name, StartScheduleOptions{
+		BackupEncryptionKey: "0123456789abcdef",
+		Storage:             StorageLocal,
+		LocalPath:           options.LocalPath,
+	}, RestoreOptions{DryRun: true})
+	True(t, errors.Is(err, ErrInvalidBackup), err)
+	Contains(t, err.Error(), crypto.ErrUnknownKey.Error())
+}
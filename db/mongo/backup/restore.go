@@ -1,139 +1,239 @@
 package backup
 
 import (
-	"context"
-	"path"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
 
 	"github.com/apex/log"
-	"github.com/minio/minio-go/v7"
 	"github.com/script-development/RT-CV/db"
 	"go.mongodb.org/mongo-driver/bson"
-	mongodb "go.mongodb.org/mongo-driver/mongo"
 )
 
-// Restore restores a s3 backup to the mongodb database
-func Restore(dbConn db.Connection, backupFile string, options StartScheduleOptions) {
-	mongoDB := unwrapMongoConn(dbConn).GetDB()
-	s3Client := options.createS3Client(true)
+var (
+	// ErrInvalidBackup is returned when the backup file cannot be read, for example because the encryption key is wrong
+	ErrInvalidBackup = errors.New("invalid backup file")
 
-	log.Infof("Restoring backup %s from s3..", backupFile)
+	// ErrUnknownCollection is returned when restoring a collection that is not in the backup
+	ErrUnknownCollection = errors.New("collection is not in the backup")
+)
+
+// RestoreOptions changes what is restored from a backup
+type RestoreOptions struct {
+	// Collections limits the restore to these collections, if empty all collections in the backup are restored
+	Collections []string
+
+	// DryRun only reports the changes a restore would make without modifying the database
+	DryRun bool
+
+	// Target restores into another database instead of the database of the connection, for example to inspect a backup
+	// For MongoDB this is the name of a database on the same server, for the file database this is a directory
+	Target string
+}
+
+// RestoreReport describes the changes made by a restore
+type RestoreReport struct {
+	Backup      string
+	DryRun      bool
+	Collections []CollectionReport
+}
+
+// CollectionReport describes the changes a restore makes to a collection
+// Documents are matched on their _id
+type CollectionReport struct {
+	Name string
+
+	// Documents is the amount of documents in the backup
+	Documents uint64
+	// LiveDocuments is the amount of documents in the database before the restore
+	LiveDocuments uint64
 
-	_, err := s3Client.StatObject(context.Background(), options.S3Bucket, backupFile, minio.StatObjectOptions{})
+	Added     uint64
+	Changed   uint64
+	Removed   uint64
+	Unchanged uint64
+}
+
+// backupCollection contains a hash of every document in a collection of the backup by _id
+type backupCollection struct {
+	documents map[string][sha256.Size]byte
+}
+
+// documentKey returns a key that identifies a document
+func documentKey(doc bson.Raw) string {
+	id, err := doc.LookupErr("_id")
 	if err != nil {
-		backupFile = path.Join("/rt-cv-backups/", backupFile)
-		_, err2 := s3Client.StatObject(context.Background(), options.S3Bucket, backupFile, minio.StatObjectOptions{})
-		if err2 != nil {
-			log.WithError(err).Fatal("unable to get backup file from s3")
+		// Documents without an id can't be matched, use the full document instead
+		return "doc:" + string(doc)
+	}
+	return string(rune(id.Type)) + string(id.Value)
+}
+
+// Restore restores a backup from the backup storage to the database
+// The database should be a MongoDB database or a testing database with a file store
+func Restore(dbConn db.Connection, backupFile string, storageOptions StartScheduleOptions, options RestoreOptions) (RestoreReport, error) {
+	report := RestoreReport{
+		Backup:      backupFile,
+		DryRun:      options.DryRun,
+		Collections: []CollectionReport{},
+	}
+
+	database := databaseOf(dbConn)
+	if options.Target != "" {
+		var err error
+		database, err = database.withTarget(options.Target)
+		if err != nil {
+			return report, fmt.Errorf("unable to open the restore target: %s", err.Error())
 		}
+		defer database.close()
 	}
 
-	obj, err := s3Client.GetObject(context.Background(), options.S3Bucket, backupFile, minio.GetObjectOptions{})
+	keyring, err := storageOptions.Keyring()
 	if err != nil {
-		log.WithError(err).Fatal("failed to get the backup file from s3")
+		return report, err
 	}
 
-	log.Infof("Found backup file in s3, verifying it's content..")
-
-	err = readbackup(obj, options.BackupEncryptionKey, nil)
+	storage, err := storageOptions.CreateStorage()
 	if err != nil {
-		log.WithError(err).Fatal("failed to validate the backup file")
+		return report, fmt.Errorf("unable to open the backup storage: %s", err.Error())
 	}
 
-	log.Infof("Content of backup file is valid, inserting data into temp collection..")
+	log.Infof("Restoring backup %s..", backupFile)
 
-	collectionNamesList, err := mongoDB.ListCollectionNames(context.Background(), bson.M{})
+	obj, err := storage.Download(backupFile)
 	if err != nil {
-		log.WithError(err).Fatal("unable to get the list of collections currently in the database")
-	}
-	hasCollection := func(name string) bool {
-		for _, collectionName := range collectionNamesList {
-			if collectionName == name {
-				return true
-			}
-		}
-		return false
+		return report, fmt.Errorf("unable to get the backup file from the backup storage: %w", err)
 	}
+	defer obj.Close()
+
+	log.Infof("Found backup file, verifying it's content..")
 
-	restoreCollections := map[string]*mongodb.Collection{}
-	removeTempCollections := func() {
-		log.Info("trying to removing temp collections..")
-		for _, collection := range restoreCollections {
-			collection.Drop(context.Background())
+	selected := func(string) bool { return true }
+	if len(options.Collections) > 0 {
+		selectedCollections := map[string]bool{}
+		for _, name := range options.Collections {
+			selectedCollections[name] = true
 		}
+		selected = func(name string) bool { return selectedCollections[name] }
 	}
 
-	obj.Seek(0, 0)
-	err = readbackup(obj, options.BackupEncryptionKey, func(collectionName string, doc bson.Raw, err error) {
-		collectionNameToRestoreTo := collectionName + "_RESTORE_TEMP"
-		collectionToRestoreTo, ok := restoreCollections[collectionNameToRestoreTo]
-		if !ok {
-			if hasCollection(collectionNameToRestoreTo) {
-				err := mongoDB.Collection(collectionNameToRestoreTo).Drop(context.Background())
-				if err != nil {
-					log.WithError(err).Fatal("unable to drop earlier temp collection")
-				}
+	collections := map[string]*backupCollection{}
+	var documentErr error
+	err = readbackup(obj, keyring, func(collectionName string, doc bson.Raw, err error) {
+		if err != nil {
+			if documentErr == nil {
+				documentErr = err
 			}
+			return
+		}
+		if !selected(collectionName) {
+			return
+		}
+		collection, ok := collections[collectionName]
+		if !ok {
+			collection = &backupCollection{documents: map[string][sha256.Size]byte{}}
+			collections[collectionName] = collection
+		}
+		collection.documents[documentKey(doc)] = sha256.Sum256(doc)
+	})
+	if err == nil {
+		err = documentErr
+	}
+	if err != nil {
+		return report, fmt.Errorf("%w: %s", ErrInvalidBackup, err.Error())
+	}
 
-			err := mongoDB.CreateCollection(context.Background(), collectionNameToRestoreTo)
-			if err != nil {
-				removeTempCollections()
-				log.WithError(err).Fatalf("failed to create temporary collection for the %s collection", collectionNameToRestoreTo)
-			}
-			collectionToRestoreTo = mongoDB.Collection(collectionNameToRestoreTo)
-			restoreCollections[collectionNameToRestoreTo] = collectionToRestoreTo
+	for _, name := range options.Collections {
+		if collections[name] == nil {
+			return report, fmt.Errorf("%w: %s", ErrUnknownCollection, name)
 		}
+	}
+
+	log.Infof("Content of backup file is valid, comparing it with the database..")
 
-		_, err = collectionToRestoreTo.InsertOne(context.Background(), doc)
+	for name, collection := range collections {
+		collectionReport, err := diffCollection(database, name, collection)
 		if err != nil {
-			removeTempCollections()
-			log.WithError(err).
-				WithField("collection", collectionNameToRestoreTo).
-				Fatal("failed to insert a document into the temporary restore collection")
+			return report, fmt.Errorf("failed to compare collection %s with the database: %s", name, err.Error())
 		}
+		report.Collections = append(report.Collections, collectionReport)
+	}
+	sort.Slice(report.Collections, func(i, j int) bool {
+		return report.Collections[i].Name < report.Collections[j].Name
 	})
 
-	helperText := "If anything after this fails you can manually promote the temp collections to the " +
-		"real collections using the following command (you need to rename some_collection_name to the db collection names): " +
-		"db.some_collection_name_RESTORE_TEMP.renameCollection('some_collection_name', true)"
+	if options.DryRun {
+		return report, nil
+	}
 
-	// How to manually restore:
-	// 1. Open the mongo shell/console
-	// 2. Run: use rt-cv
-	// 3. For every collection with the _RESTORE_TEMP suffix, run:
-	//      db.some_collection_name_RESTORE_TEMP.renameCollection('some_collection_name', true)
+	log.Infof("Inserting data into temp collections..")
 
-	log.Info("Inserting temp data succeeded, doing the same on the real collections.. " + helperText)
+	restore, err := database.newRestore()
+	if err != nil {
+		return report, fmt.Errorf("unable to start restoring the backup: %s", err.Error())
+	}
 
-	restoredDocs := 0
+	_, err = obj.Seek(0, 0)
+	if err != nil {
+		return report, fmt.Errorf("failed to read the backup file again: %s", err.Error())
+	}
+	var insertErr error
+	err = readbackup(obj, keyring, func(collectionName string, doc bson.Raw, err error) {
+		if insertErr != nil || !selected(collectionName) {
+			return
+		}
+		insertErr = restore.insert(collectionName, doc)
+		if insertErr != nil {
+			insertErr = fmt.Errorf("failed to insert a document into the temporary restore collection %s: %s", collectionName, insertErr.Error())
+		}
+	})
+	if err == nil {
+		err = insertErr
+	}
+	if err != nil {
+		restore.abort()
+		return report, err
+	}
 
-	collections := map[string]*mongodb.Collection{}
+	log.Info("Inserting temp data succeeded, promoting the temp collections to the real collections..")
 
-	obj.Seek(0, 0)
-	err = readbackup(obj, options.BackupEncryptionKey, func(collectionName string, doc bson.Raw, err error) {
-		collection, ok := collections[collectionName]
+	err = restore.commit()
+	if err != nil {
+		return report, fmt.Errorf("failed to promote the temp collections: %s", err.Error())
+	}
+
+	return report, nil
+}
+
+// diffCollection compares a collection of the backup with the collection in the database
+func diffCollection(database database, name string, collection *backupCollection) (CollectionReport, error) {
+	report := CollectionReport{
+		Name:      name,
+		Documents: uint64(len(collection.documents)),
+	}
+
+	seen := map[string]bool{}
+	err := database.readCollection(name, func(doc bson.Raw) error {
+		report.LiveDocuments++
+		key := documentKey(doc)
+		backupHash, ok := collection.documents[key]
 		if !ok {
-			if hasCollection(collectionName) {
-				err = mongoDB.Collection(collectionName).Drop(context.Background())
-				if err != nil {
-					log.WithError(err).Fatal("unable to drop collection so we can re-create it and restore data")
-				}
-			}
-			err := mongoDB.CreateCollection(context.Background(), collectionName)
-			if err != nil {
-				log.WithError(err).Fatal("failed to create collection " + collection.Name())
-			}
-			collection = mongoDB.Collection(collectionName)
-			collections[collectionName] = collection
+			report.Removed++
+			return nil
 		}
-
-		restoredDocs++
-		_, err = collection.InsertOne(context.Background(), doc)
-		if err != nil {
-			log.WithError(err).WithField("collection", collectionName).Fatal("failed to insert a document")
+		seen[key] = true
+		if sha256.Sum256(doc) == backupHash {
+			report.Unchanged++
+		} else {
+			report.Changed++
 		}
+		return nil
 	})
+	if err != nil {
+		return report, err
+	}
 
-	removeTempCollections()
-
-	log.Infof("Restore successfull, restored %d documents over %d collections", restoredDocs, len(collections))
+	report.Added = report.Documents - uint64(len(seen))
+	return report, nil
 }
@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/filedb"
+	"github.com/script-development/RT-CV/db/testingdb"
+	"github.com/script-development/RT-CV/models"
+	. "github.com/stretchr/testify/assert"
+)
+
+// restoreTestSetup creates a backup of a database with 2 tenants and 1 dashboard user
+func restoreTestSetup(t *testing.T) (*testingdb.TestConnection, StartScheduleOptions, string) {
+	options := StartScheduleOptions{
+		BackupEncryptionKey: "0123456789abcdef",
+		Storage:             StorageLocal,
+		LocalPath:           t.TempDir(),
+	}
+	storage, err := options.CreateStorage()
+	if !NoError(t, err) {
+		t.FailNow()
+	}
+
+	store, err := filedb.NewStore(t.TempDir())
+	NoError(t, err)
+	conn := testingdb.NewDBWithStore(store)
+	conn.RegisterEntries(&models.Tenant{}, &models.DashboardUser{}, &models.Backup{}, &models.BackupCatalogEntry{})
+	err = conn.Insert(&models.Tenant{M: db.NewM(), Name: "a"}, &models.Tenant{M: db.NewM(), Name: "b"})
+	NoError(t, err)
+	err = conn.Insert(&models.DashboardUser{M: db.NewM(), Username: "admin"})
+	NoError(t, err)
+
+	name, err := CreateBackup(storage, conn, options.BackupEncryptionKey)
+	if !NoError(t, err) {
+		t.FailNow()
+	}
+	return conn, options, name
+}
+
+func tenantNames(t *testing.T, conn db.Connection) []string {
+	names := []string{}
+	tenants, err := models.GetTenants(conn)
+	NoError(t, err)
+	for _, tenant := range tenants {
+		names = append(names, tenant.Name)
+	}
+	return names
+}
+
+func reopen(conn *testingdb.TestConnection) *testingdb.TestConnection {
+	reopened := testingdb.NewDBWithStore(conn.Store())
+	reopened.RegisterEntries(&models.Tenant{}, &models.DashboardUser{}, &models.Backup{}, &models.BackupCatalogEntry{})
+	return reopened
+}
+
+func TestRestoreSelectedCollections(t *testing.T) {
+	conn, options, name := restoreTestSetup(t)
+
+	// Change the data after the backup
+	tenants, err := models.GetTenants(conn)
+	NoError(t, err)
+	tenants[0].Name = "changed"
+	NoError(t, conn.UpdateByID(&tenants[0]))
+	NoError(t, conn.DeleteByID(&models.Tenant{}, tenants[1].ID))
+	NoError(t, conn.Insert(&models.Tenant{M: db.NewM(), Name: "c"}))
+	NoError(t, conn.Insert(&models.DashboardUser{M: db.NewM(), Username: "new"}))
+
+	report, err := Restore(conn, name, options, RestoreOptions{Collections: []string{"tenants"}})
+	NoError(t, err)
+	False(t, report.DryRun)
+	Equal(t, []CollectionReport{{
+		Name:          "tenants",
+		Documents:     2,
+		LiveDocuments: 2,
+		Added:         1,
+		Changed:       1,
+		Removed:       1,
+	}}, report.Collections)
+
+	conn = reopen(conn)
+	ElementsMatch(t, []string{"a", "b"}, tenantNames(t, conn))
+
+	// The dashboard users are not restored
+	count, err := conn.Count(&models.DashboardUser{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(2), count)
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	conn, options, name := restoreTestSetup(t)
+	NoError(t, conn.Insert(&models.Tenant{M: db.NewM(), Name: "c"}))
+
+	report, err := Restore(conn, name, options, RestoreOptions{DryRun: true})
+	NoError(t, err)
+	True(t, report.DryRun)
+
+	collections := map[string]CollectionReport{}
+	for _, collection := range report.Collections {
+		collections[collection.Name] = collection
+	}
+	Equal(t, CollectionReport{Name: "tenants", Documents: 2, LiveDocuments: 3, Removed: 1, Unchanged: 2}, collections["tenants"])
+	Equal(t, CollectionReport{Name: "dashboardUsers", Documents: 1, LiveDocuments: 1, Unchanged: 1}, collections["dashboardUsers"])
+
+	// Nothing is changed
+	ElementsMatch(t, []string{"a", "b", "c"}, tenantNames(t, reopen(conn)))
+}
+
+func TestRestoreIntoTarget(t *testing.T) {
+	conn, options, name := restoreTestSetup(t)
+	NoError(t, conn.Insert(&models.Tenant{M: db.NewM(), Name: "c"}))
+
+	target := t.TempDir()
+	report, err := Restore(conn, name, options, RestoreOptions{Target: target, Collections: []string{"tenants"}})
+	NoError(t, err)
+	if Len(t, report.Collections, 1) {
+		Equal(t, uint64(0), report.Collections[0].LiveDocuments)
+		Equal(t, uint64(2), report.Collections[0].Added)
+	}
+
+	// The live database is not changed
+	ElementsMatch(t, []string{"a", "b", "c"}, tenantNames(t, reopen(conn)))
+
+	targetStore, err := filedb.NewStore(target)
+	NoError(t, err)
+	targetConn := testingdb.NewDBWithStore(targetStore)
+	targetConn.RegisterEntries(&models.Tenant{})
+	ElementsMatch(t, []string{"a", "b"}, tenantNames(t, targetConn))
+}
+
+func TestRestoreErrors(t *testing.T) {
+	conn, options, name := restoreTestSetup(t)
+
+	_, err := Restore(conn, "2000-01-01--00-00.gz.aes", options, RestoreOptions{})
+	True(t, errors.Is(err, os.ErrNotExist), err)
+
+	_, err = Restore(conn, name, options, RestoreOptions{Collections: []string{"tenants", "profiles"}})
+	True(t, errors.Is(err, ErrUnknownCollection), err)
+
+	wrongKeyOptions := options
+	wrongKeyOptions.BackupEncryptionKey = "fedcba9876543210"
+	_, err = Restore(conn, name, wrongKeyOptions, RestoreOptions{})
+	True(t, errors.Is(err, ErrInvalidBackup), err)
+
+	// Nothing is changed by the failed restores
+	ElementsMatch(t, []string{"a", "b"}, tenantNames(t, reopen(conn)))
+}
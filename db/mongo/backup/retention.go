@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupNameLayout is the time layout used in the names of the backups followed by backupNameSuffix
+const (
+	backupNameLayout = "2006-01-02--15-04"
+	backupNameSuffix = ".gz.aes"
+)
+
+// RetentionPolicy is a grandfather-father-son retention policy
+// For every period the newest backup of the latest N periods is kept, backups that are not kept by any of the periods are removed
+// A policy where all values are 0 keeps all backups
+type RetentionPolicy struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Enabled returns true if the policy removes backups
+func (p RetentionPolicy) Enabled() bool {
+	return p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0
+}
+
+// backupTime returns the creation time of a backup based on its name
+// Returns false for backups that are not created by RT-CV, these are never touched by the retention policy
+func backupTime(name string) (time.Time, bool) {
+	if !strings.HasSuffix(name, backupNameSuffix) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(backupNameLayout, strings.TrimSuffix(name, backupNameSuffix), time.Local)
+	return t, err == nil
+}
+
+// Prune returns the backups that should be removed according to the policy
+func (p RetentionPolicy) Prune(backups []StoredBackup) []StoredBackup {
+	if !p.Enabled() {
+		return nil
+	}
+
+	type datedBackup struct {
+		StoredBackup
+		time time.Time
+	}
+	dated := []datedBackup{}
+	for _, backup := range backups {
+		t, ok := backupTime(backup.Name)
+		if ok {
+			dated = append(dated, datedBackup{backup, t})
+		}
+	}
+	if len(dated) == 0 {
+		return nil
+	}
+
+	// Newest first
+	sort.SliceStable(dated, func(i, j int) bool {
+		return dated[i].time.After(dated[j].time)
+	})
+
+	keep := map[string]bool{
+		// Never remove the newest backup
+		dated[0].Name: true,
+	}
+	keepPeriods := func(limit int, period func(time.Time) string) {
+		seen := map[string]bool{}
+		for _, backup := range dated {
+			if len(seen) == limit {
+				return
+			}
+			key := period(backup.time)
+			if !seen[key] {
+				seen[key] = true
+				keep[backup.Name] = true
+			}
+		}
+	}
+	keepPeriods(p.Daily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepPeriods(p.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+	keepPeriods(p.Monthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+
+	prune := []StoredBackup{}
+	for _, backup := range dated {
+		if !keep[backup.Name] {
+			prune = append(prune, backup.StoredBackup)
+		}
+	}
+	return prune
+}
@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func storedBackupsAt(times ...time.Time) []StoredBackup {
+	backups := []StoredBackup{}
+	for _, t := range times {
+		backups = append(backups, StoredBackup{Name: t.Format(backupNameLayout) + backupNameSuffix})
+	}
+	return backups
+}
+
+func prunedNames(policy RetentionPolicy, backups []StoredBackup) []string {
+	names := []string{}
+	for _, backup := range policy.Prune(backups) {
+		names = append(names, backup.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	// A backup every day for 3 years, starting on a monday
+	start := time.Date(2020, 1, 6, 3, 0, 0, 0, time.Local)
+	times := []time.Time{}
+	for day := 0; day < 365*3; day++ {
+		times = append(times, start.AddDate(0, 0, day))
+	}
+	backups := storedBackupsAt(times...)
+
+	policy := RetentionPolicy{Daily: 7, Weekly: 4, Monthly: 12}
+	pruned := policy.Prune(backups)
+	kept := map[string]bool{}
+	for _, backup := range backups {
+		kept[backup.Name] = true
+	}
+	for _, backup := range pruned {
+		delete(kept, backup.Name)
+	}
+
+	// The 7 newest backups are kept
+	for _, backup := range backups[len(backups)-7:] {
+		True(t, kept[backup.Name], backup.Name)
+	}
+
+	// 7 daily + the newest of 4 weeks + the newest of 12 months, some of these are the same backups
+	LessOrEqual(t, len(kept), 7+4+12)
+	Greater(t, len(kept), 12)
+
+	// Every one of the last 12 months has a backup
+	months := map[string]bool{}
+	for name := range kept {
+		months[name[:7]] = true
+	}
+	Len(t, months, 12)
+
+	// The oldest backups are removed
+	False(t, kept[backups[0].Name])
+
+	// Applying the policy again does not remove more backups
+	remaining := []StoredBackup{}
+	for _, backup := range backups {
+		if kept[backup.Name] {
+			remaining = append(remaining, backup)
+		}
+	}
+	Len(t, policy.Prune(remaining), 0)
+}
+
+func TestRetentionPolicyKeepsUnknownAndNewest(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 30, 0, 0, time.Local)
+	backups := storedBackupsAt(now, now.Add(-time.Hour), now.AddDate(0, 0, -1))
+	backups = append(backups, StoredBackup{Name: "manual-backup.gz.aes"}, StoredBackup{Name: "notes.txt"})
+
+	// Only the newest backup of the day is kept
+	Equal(t, []string{"2022-06-15--11-30.gz.aes"}, prunedNames(RetentionPolicy{Daily: 2}, backups))
+
+	// The newest backup is never removed
+	Equal(t, []string{"2022-06-14--12-30.gz.aes", "2022-06-15--11-30.gz.aes"}, prunedNames(RetentionPolicy{Monthly: 1}, backups))
+
+	// A disabled policy keeps everything
+	Len(t, RetentionPolicy{}.Prune(backups), 0)
+}
+
+func TestRetentionPolicyFromEnv(t *testing.T) {
+	t.Setenv("BACKUP_KEEP_DAILY", "")
+	t.Setenv("BACKUP_KEEP_WEEKLY", "")
+	t.Setenv("BACKUP_KEEP_MONTHLY", "")
+
+	// The retention policy is opt-in
+	False(t, StartScheduleOptionsFromEnv().Retention.Enabled())
+
+	t.Setenv("BACKUP_KEEP_DAILY", "7")
+	t.Setenv("BACKUP_KEEP_WEEKLY", "invalid")
+	Equal(t, RetentionPolicy{Daily: 7}, StartScheduleOptionsFromEnv().Retention)
+}
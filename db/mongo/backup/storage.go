@@ -0,0 +1,259 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Storage is a place where backup files are kept
+type Storage interface {
+	// List returns the stored backups sorted by name
+	List() ([]StoredBackup, error)
+
+	// Upload stores a backup file under name, an existing backup with the same name is replaced
+	Upload(name string, file io.Reader, size int64) error
+
+	// Download opens a stored backup
+	// Returns an error that matches os.ErrNotExist if the backup does not exist
+	//
+	// YOU NEED TO CLOSE THE RETURNED FILE
+	Download(name string) (io.ReadSeekCloser, error)
+
+	// Delete removes a stored backup
+	Delete(name string) error
+}
+
+// StoredBackup describes a backup file in a Storage
+type StoredBackup struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+const (
+	// StorageS3 stores the backups in an S3 bucket
+	StorageS3 = "s3"
+	// StorageLocal stores the backups in a local directory
+	StorageLocal = "local"
+)
+
+// validBackupName checks that a backup name can't escape the storage location
+func validBackupName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid backup name %q", name)
+	}
+	return nil
+}
+
+// localStorage keeps the backups in a local directory, for example a mounted volume
+type localStorage struct {
+	dir string
+}
+
+// NewLocalStorage returns a storage that keeps the backups in dir, the directory is created if it does not exist
+func NewLocalStorage(dir string) (Storage, error) {
+	if dir == "" {
+		return nil, errors.New("no backup directory set")
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &localStorage{dir: dir}, nil
+}
+
+func (s *localStorage) List() ([]StoredBackup, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []StoredBackup{}
+	for _, file := range files {
+		if !file.Type().IsRegular() || validBackupName(file.Name()) != nil {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, StoredBackup{
+			Name:         file.Name(),
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+	}
+	return backups, nil
+}
+
+func (s *localStorage) Upload(name string, file io.Reader, size int64) error {
+	err := validBackupName(name)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file first so a failed upload never leaves a half written backup behind
+	tempFile, err := os.CreateTemp(s.dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	removeTemp := func() {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+	}
+
+	written, err := io.Copy(tempFile, file)
+	if err == nil && written != size {
+		err = fmt.Errorf("expected to write %d bytes but wrote %d bytes", size, written)
+	}
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if err != nil {
+		removeTemp()
+		return err
+	}
+
+	err = tempFile.Close()
+	if err == nil {
+		err = os.Rename(tempFile.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+	}
+	return err
+}
+
+func (s *localStorage) Download(name string) (io.ReadSeekCloser, error) {
+	err := validBackupName(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(s.dir, name))
+}
+
+func (s *localStorage) Delete(name string) error {
+	err := validBackupName(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+// s3BackupsDir is the directory inside of the bucket that contains the backups
+const s3BackupsDir = "/rt-cv-backups/"
+
+// s3Storage keeps the backups in an S3 bucket
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Storage returns a storage that keeps the backups in an S3 bucket, the bucket is created if it does not exist
+func NewS3Storage(options StartScheduleOptions) (Storage, error) {
+	client, err := minio.New(options.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(options.S3AccessKeyID, options.S3SecretAccessKey, ""),
+		Secure: options.S3UseSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create minio client: %s", err.Error())
+	}
+
+	bucketExists, err := client.BucketExists(context.Background(), options.S3Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if backup bucket exists: %s", err.Error())
+	}
+	if !bucketExists {
+		// Try to create the bucket if it doesn't exist yet
+		err = client.MakeBucket(context.Background(), options.S3Bucket, minio.MakeBucketOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the bucket used to store backups: %s", err.Error())
+		}
+	}
+
+	return &s3Storage{client: client, bucket: options.S3Bucket}, nil
+}
+
+// objectName returns the object name of a backup
+// For backwards compatibility the full object name, including the backups directory, is also accepted
+func (s *s3Storage) objectName(name string) (string, error) {
+	name = strings.TrimPrefix(name, s3BackupsDir)
+	err := validBackupName(name)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(s3BackupsDir, name), nil
+}
+
+func (s *s3Storage) List() ([]StoredBackup, error) {
+	backups := []StoredBackup{}
+	objects := s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: s3BackupsDir})
+	for object := range objects {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		backups = append(backups, StoredBackup{
+			Name:         strings.TrimPrefix(object.Key, s3BackupsDir),
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name < backups[j].Name
+	})
+	return backups, nil
+}
+
+func (s *s3Storage) Upload(name string, file io.Reader, size int64) error {
+	objectName, err := s.objectName(name)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(
+		context.Background(),
+		s.bucket,
+		objectName,
+		file,
+		size,
+		minio.PutObjectOptions{
+			ContentType: "binary/octet-stream",
+		},
+	)
+	return err
+}
+
+func (s *s3Storage) Download(name string) (io.ReadSeekCloser, error) {
+	objectName, err := s.objectName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.client.StatObject(context.Background(), s.bucket, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("backup %s: %w", name, os.ErrNotExist)
+		}
+		return nil, err
+	}
+
+	return s.client.GetObject(context.Background(), s.bucket, objectName, minio.GetObjectOptions{})
+}
+
+func (s *s3Storage) Delete(name string) error {
+	objectName, err := s.objectName(name)
+	if err != nil {
+		return err
+	}
+	return s.client.RemoveObject(context.Background(), s.bucket, objectName, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/filedb"
+	"github.com/script-development/RT-CV/db/testingdb"
+	"github.com/script-development/RT-CV/models"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestLocalStorage(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	NoError(t, err)
+
+	backups, err := storage.List()
+	NoError(t, err)
+	Len(t, backups, 0)
+
+	data := []byte("backup data")
+	err = storage.Upload("a.gz.aes", bytes.NewReader(data), int64(len(data)))
+	NoError(t, err)
+	err = storage.Upload("b.gz.aes", bytes.NewReader(data), int64(len(data)))
+	NoError(t, err)
+
+	backups, err = storage.List()
+	NoError(t, err)
+	if Len(t, backups, 2) {
+		Equal(t, "a.gz.aes", backups[0].Name)
+		Equal(t, int64(len(data)), backups[0].Size)
+		Equal(t, "b.gz.aes", backups[1].Name)
+	}
+
+	file, err := storage.Download("a.gz.aes")
+	NoError(t, err)
+	downloaded, err := io.ReadAll(file)
+	NoError(t, err)
+	Equal(t, data, downloaded)
+	NoError(t, file.Close())
+
+	err = storage.Delete("a.gz.aes")
+	NoError(t, err)
+	_, err = storage.Download("a.gz.aes")
+	True(t, errors.Is(err, os.ErrNotExist))
+
+	backups, err = storage.List()
+	NoError(t, err)
+	Len(t, backups, 1)
+}
+
+func TestLocalStorageErrors(t *testing.T) {
+	_, err := NewLocalStorage("")
+	Error(t, err)
+
+	storage, err := NewLocalStorage(t.TempDir())
+	NoError(t, err)
+
+	for _, name := range []string{"", "../a.gz.aes", "a/b.gz.aes", ".hidden"} {
+		err = storage.Upload(name, bytes.NewReader(nil), 0)
+		Error(t, err, name)
+		_, err = storage.Download(name)
+		Error(t, err, name)
+		err = storage.Delete(name)
+		Error(t, err, name)
+	}
+
+	// A partial upload should not leave a backup behind
+	err = storage.Upload("a.gz.aes", bytes.NewReader([]byte("abc")), 10)
+	Error(t, err)
+	backups, err := storage.List()
+	NoError(t, err)
+	Len(t, backups, 0)
+}
+
+func TestCreateStorage(t *testing.T) {
+	storage, err := StartScheduleOptions{Storage: StorageLocal, LocalPath: t.TempDir()}.CreateStorage()
+	NoError(t, err)
+	IsType(t, &localStorage{}, storage)
+
+	_, err = StartScheduleOptions{Storage: "ftp"}.CreateStorage()
+	Error(t, err)
+}
+
+func TestBackupCycleWithLocalStorage(t *testing.T) {
+	options := StartScheduleOptions{
+		BackupEncryptionKey: "0123456789abcdef",
+		Storage:             StorageLocal,
+		LocalPath:           t.TempDir(),
+	}
+	store, err := filedb.NewStore(t.TempDir())
+	NoError(t, err)
+	conn := testingdb.NewDBWithStore(store)
+	conn.RegisterEntries(&models.Tenant{}, &models.Backup{}, &models.BackupCatalogEntry{})
+	err = conn.Insert(&models.Tenant{M: db.NewM(), Name: "a"}, &models.Tenant{M: db.NewM(), Name: "b"})
+	NoError(t, err)
+
+	scheduler, err := NewScheduler(conn, options)
+	NoError(t, err)
+	scheduler.checkNeedBackup(true)
+
+	backups, err := scheduler.storage.List()
+	NoError(t, err)
+	if !Len(t, backups, 1) {
+		return
+	}
+
+	restoreStore, err := filedb.NewStore(t.TempDir())
+	NoError(t, err)
+	_, err = Restore(testingdb.NewDBWithStore(restoreStore), backups[0].Name, options, RestoreOptions{})
+	NoError(t, err)
+
+	restoredConn := testingdb.NewDBWithStore(restoreStore)
+	restoredConn.RegisterEntries(&models.Tenant{}, &models.Backup{}, &models.BackupCatalogEntry{})
+	tenants := []models.Tenant{}
+	err = restoredConn.Find(&models.Tenant{}, &tenants, nil)
+	NoError(t, err)
+	Len(t, tenants, 2)
+}
+
+func TestSchedulerTriggerAndCatalog(t *testing.T) {
+	options := StartScheduleOptions{
+		BackupEncryptionKey: "0123456789abcdef",
+		Storage:             StorageLocal,
+		LocalPath:           t.TempDir(),
+		Retention:           RetentionPolicy{Daily: 1},
+	}
+
+	store, err := filedb.NewStore(t.TempDir())
+	NoError(t, err)
+	conn := testingdb.NewDBWithStore(store)
+	conn.RegisterEntries(&models.Tenant{}, &models.Backup{}, &models.BackupCatalogEntry{})
+	err = conn.Insert(&models.Tenant{M: db.NewM(), Name: "a"})
+	NoError(t, err)
+
+	scheduler, err := NewScheduler(conn, options)
+	NoError(t, err)
+	Nil(t, scheduler.Status())
+
+	// An old backup that should be removed by the retention policy
+	oldBackup := "2000-01-01--00-00.gz.aes"
+	err = scheduler.storage.Upload(oldBackup, bytes.NewReader(nil), 0)
+	NoError(t, err)
+
+	status, err := scheduler.Trigger()
+	NoError(t, err)
+	True(t, status.Running)
+	Equal(t, TriggerManual, status.Trigger)
+
+	_, err = scheduler.Trigger()
+	Equal(t, ErrBackupRunning, err)
+
+	Eventually(t, func() bool {
+		return !scheduler.Status().Running
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lastRun := scheduler.Status()
+	Equal(t, "", lastRun.Error)
+	NotEqual(t, "", lastRun.Backup)
+	NotNil(t, lastRun.FinishedAt)
+	Equal(t, []string{oldBackup}, lastRun.Pruned)
+
+	catalog, err := scheduler.Catalog()
+	NoError(t, err)
+	if Len(t, catalog, 1) {
+		Equal(t, lastRun.Backup, catalog[0].Name)
+		NotNil(t, catalog[0].CreatedAt)
+		Equal(t, uint64(1), catalog[0].Collections["tenants"])
+	}
+}
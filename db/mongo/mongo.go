@@ -40,8 +40,22 @@ func ConnectToDB() db.Connection {
 
 	mongoConnection := client.Database(os.Getenv("MONGODB_DATABASE"))
 	log.Info("Connected to database")
+
+	// Transactions are only supported by replica sets and sharded clusters
+	serverInfo := bson.M{}
+	err = mongoConnection.RunCommand(dbHelpers.Ctx(), bson.D{{Key: "isMaster", Value: 1}}).Decode(&serverInfo)
+	if err != nil {
+		log.Fatal("Mongo server info lookup failed, error: " + err.Error())
+	}
+	_, isReplicaSet := serverInfo["setName"]
+	supportsTransactions := isReplicaSet || serverInfo["msg"] == "isdbgrid"
+	if !supportsTransactions {
+		log.Warn("The database is a standalone server, writes that should happen together are executed without a transaction")
+	}
+
 	var conn db.Connection = &Connection{
-		db: mongoConnection,
+		db:                   mongoConnection,
+		supportsTransactions: supportsTransactions,
 	}
 	return conn
 }
@@ -49,6 +63,20 @@ func ConnectToDB() db.Connection {
 // Connection is a connection to a mongo database
 type Connection struct {
 	db *mongo.Database
+
+	// supportsTransactions is false for standalone servers
+	supportsTransactions bool
+
+	// sessionCtx is set when the connection is used within a transaction
+	sessionCtx mongo.SessionContext
+}
+
+// context returns the context that should be used for database operations
+func (c *Connection) context() context.Context {
+	if c.sessionCtx != nil {
+		return c.sessionCtx
+	}
+	return dbHelpers.Ctx()
 }
 
 // FindOne finds a single entry based on the filter
@@ -63,7 +91,18 @@ func (c *Connection) FindOne(e db.Entry, filter bson.M, optionalOpts ...db.FindO
 		dbHelpers.MergeFilters(e.DefaultFindFilters(), filter)
 	}
 
-	res := c.collection(e).FindOne(dbHelpers.Ctx(), queryFilters)
+	findOpts := options.FindOne()
+	if opts.Skip > 0 {
+		findOpts.SetSkip(opts.Skip)
+	}
+	if len(opts.Sort) > 0 {
+		findOpts.SetSort(opts.Sort)
+	}
+	if len(opts.Projection) > 0 {
+		findOpts.SetProjection(opts.Projection)
+	}
+
+	res := c.collection(e).FindOne(c.context(), queryFilters, findOpts)
 	err := res.Err()
 	if err != nil {
 		return err
@@ -84,12 +123,26 @@ func (c *Connection) Find(e db.Entry, results any, filter bson.M, optionalOpts .
 		dbHelpers.MergeFilters(e.DefaultFindFilters(), filter)
 	}
 
-	cur, err := c.collection(e).Find(dbHelpers.Ctx(), queryFilters)
+	findOpts := options.Find()
+	if opts.Limit > 0 {
+		findOpts.SetLimit(opts.Limit)
+	}
+	if opts.Skip > 0 {
+		findOpts.SetSkip(opts.Skip)
+	}
+	if len(opts.Sort) > 0 {
+		findOpts.SetSort(opts.Sort)
+	}
+	if len(opts.Projection) > 0 {
+		findOpts.SetProjection(opts.Projection)
+	}
+
+	cur, err := c.collection(e).Find(c.context(), queryFilters, findOpts)
 	if err != nil {
 		return err
 	}
 	defer cur.Close(context.Background())
-	err = cur.All(dbHelpers.Ctx(), results)
+	err = cur.All(c.context(), results)
 
 	if errors.Is(err, mongo.ErrNoDocuments) {
 		return nil
@@ -109,7 +162,7 @@ func (c *Connection) Insert(e ...db.Entry) error {
 	case 0:
 		return nil
 	case 1:
-		_, err := c.collection(e[0]).InsertOne(dbHelpers.Ctx(), e[0])
+		_, err := c.collection(e[0]).InsertOne(c.context(), e[0])
 		return err
 	default:
 		// Convert e to a slice of any
@@ -119,7 +172,7 @@ func (c *Connection) Insert(e ...db.Entry) error {
 			eAsInterf = append(eAsInterf, entry)
 		}
 
-		_, err := c.collection(e[0]).InsertMany(dbHelpers.Ctx(), eAsInterf)
+		_, err := c.collection(e[0]).InsertMany(c.context(), eAsInterf)
 		return err
 	}
 }
@@ -131,7 +184,7 @@ func (c *Connection) UpdateByID(e db.Entry) error {
 		return errors.New("cannot update item without id")
 	}
 
-	_, err := c.collection(e).ReplaceOne(dbHelpers.Ctx(), bson.M{"_id": id}, e)
+	_, err := c.collection(e).ReplaceOne(c.context(), bson.M{"_id": id}, e)
 	return err
 }
 
@@ -150,11 +203,11 @@ func (c *Connection) DeleteByID(e db.Entry, ids ...primitive.ObjectID) error {
 	}
 
 	if len(ids) == 1 {
-		_, err := collection.DeleteOne(dbHelpers.Ctx(), bson.M{"_id": ids[0]})
+		_, err := collection.DeleteOne(c.context(), bson.M{"_id": ids[0]})
 		return err
 	}
 
-	_, err := collection.DeleteMany(dbHelpers.Ctx(), bson.M{"_id": bson.M{"$in": ids}})
+	_, err := collection.DeleteMany(c.context(), bson.M{"_id": bson.M{"$in": ids}})
 	return err
 }
 
@@ -163,13 +216,70 @@ func (c *Connection) Count(entry db.Entry, filter bson.M) (uint64, error) {
 	if filter == nil {
 		filter = bson.M{}
 	}
-	count, err := c.collection(entry).CountDocuments(dbHelpers.Ctx(), filter)
+	count, err := c.collection(entry).CountDocuments(c.context(), filter)
 	if err != nil {
 		return 0, err
 	}
 	return uint64(count), nil
 }
 
+// InsertMany inserts a slice of entries
+func (c *Connection) InsertMany(entries any) error {
+	parsedEntries, err := db.EntriesOf(entries)
+	if err != nil {
+		return err
+	}
+	return c.Insert(parsedEntries...)
+}
+
+// UpdateMany updates all entries matching the filter
+func (c *Connection) UpdateMany(e db.Entry, filter bson.M, update bson.M) (uint64, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	res, err := c.collection(e).UpdateMany(c.context(), filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(res.MatchedCount), nil
+}
+
+// DeleteMany deletes all entries matching the filter
+func (c *Connection) DeleteMany(e db.Entry, filter bson.M) (uint64, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	res, err := c.collection(e).DeleteMany(c.context(), filter)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(res.DeletedCount), nil
+}
+
+// WithTransaction executes fn inside of a transaction on a mongo session
+// Transactions require MongoDB to run as a replica set, on a standalone server fn is executed without a transaction
+func (c *Connection) WithTransaction(fn func(tx db.Connection) error) error {
+	if c.sessionCtx != nil || !c.supportsTransactions {
+		// We are already inside of a transaction or transactions are not supported
+		return fn(c)
+	}
+
+	session, err := c.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(dbHelpers.Ctx())
+
+	_, err = session.WithTransaction(dbHelpers.Ctx(), func(sessionCtx mongo.SessionContext) (any, error) {
+		return nil, fn(&Connection{
+			db:                   c.db,
+			supportsTransactions: true,
+			sessionCtx:           sessionCtx,
+		})
+	})
+	return err
+}
+
 func (c *Connection) collection(entry db.Entry) *mongo.Collection {
 	return c.db.Collection(entry.CollectionName())
 }
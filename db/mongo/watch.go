@@ -0,0 +1,120 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/dbHelpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// changeEvent contains the fields we use of a MongoDB change stream event
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument bson.Raw `bson:"fullDocument"`
+}
+
+// Watch watches a collection using a MongoDB change stream
+// Note that change streams require MongoDB to run as a replica set
+// If the change stream breaks it is resumed from the last received change
+func (c *Connection) Watch(e db.Entry, fn func(change db.Change)) (func(), error) {
+	ctx, cancel := context.WithCancel(dbHelpers.Ctx())
+	collection := c.collection(e)
+	pipeline := mongo.Pipeline{{{
+		Key:   "$match",
+		Value: bson.M{"operationType": bson.M{"$in": []string{"insert", "update", "replace", "delete"}}},
+	}}}
+	streamOpts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+
+	stream, err := collection.Watch(ctx, pipeline, streamOpts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			for stream.Next(ctx) {
+				event := changeEvent{}
+				err := stream.Decode(&event)
+				if err != nil {
+					log.WithError(err).WithField("collection", collection.Name()).Warn("unable to decode change stream event")
+					continue
+				}
+
+				change, err := event.toChange(e, collection.Name())
+				if err != nil {
+					log.WithError(err).WithField("collection", collection.Name()).Warn("unable to decode changed document")
+					continue
+				}
+				fn(change)
+			}
+
+			resumeToken := stream.ResumeToken()
+			streamErr := stream.Err()
+			stream.Close(dbHelpers.Ctx())
+			if ctx.Err() != nil {
+				// Watching was stopped
+				return
+			}
+
+			log.WithError(streamErr).WithField("collection", collection.Name()).Warn("change stream closed, resuming..")
+			for {
+				time.Sleep(time.Second)
+				if ctx.Err() != nil {
+					return
+				}
+				if resumeToken != nil {
+					streamOpts.SetResumeAfter(resumeToken)
+				}
+				stream, err = collection.Watch(ctx, pipeline, streamOpts)
+				if err == nil {
+					break
+				}
+				log.WithError(err).WithField("collection", collection.Name()).Warn("unable to resume change stream")
+			}
+		}
+	}()
+
+	return cancel, nil
+}
+
+func (event changeEvent) toChange(e db.Entry, collectionName string) (db.Change, error) {
+	change := db.Change{
+		Collection: collectionName,
+		ID:         event.DocumentKey.ID,
+	}
+
+	switch event.OperationType {
+	case "insert":
+		change.Kind = db.ChangeInsert
+	case "update", "replace":
+		change.Kind = db.ChangeUpdate
+	default:
+		change.Kind = db.ChangeDelete
+	}
+
+	if change.Kind != db.ChangeDelete {
+		if len(event.FullDocument) == 0 {
+			// The document was removed before we could lookup the full document
+			change.Kind = db.ChangeDelete
+			return change, nil
+		}
+
+		change.Entry = db.NewEntryOfSameType(e)
+		err := bson.Unmarshal(event.FullDocument, change.Entry)
+		if err != nil {
+			return change, err
+		}
+	}
+
+	return change, nil
+}
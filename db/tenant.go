@@ -0,0 +1,225 @@
+package db
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TenantEntry is an Entry that belongs to a tenant
+// Queries executed on a connection returned by WithTenant are automatically filtered on the tenant of the connection
+type TenantEntry interface {
+	Entry
+
+	// Get the tenantId field of the entry, nil means the entry is shared between all tenants
+	GetTenantID() *primitive.ObjectID
+
+	// Set the tenantId field of the entry
+	SetTenantID(*primitive.ObjectID)
+}
+
+// T is a struct that adds a tenantId field and implements the tenant functions of TenantEntry
+// To implement use:
+//
+//	type User struct {
+//	    M `bson:",inline"`
+//	    T `bson:",inline"`
+//
+//	    Username string
+//	}
+type T struct {
+	TenantID *primitive.ObjectID `bson:"tenantId,omitempty" json:"tenantId" description:"The tenant this entry belongs to, null if the entry is shared between all tenants"`
+}
+
+// GetTenantID implements TenantEntry
+func (t *T) GetTenantID() *primitive.ObjectID {
+	return t.TenantID
+}
+
+// SetTenantID implements TenantEntry
+func (t *T) SetTenantID(id *primitive.ObjectID) {
+	t.TenantID = id
+}
+
+// SameTenant returns true if both tenant ids are equal, nil is treated as its own tenant
+func SameTenant(a, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// ErrWrongTenant is returned when trying to modify an entry of another tenant
+var ErrWrongTenant = errors.New("entry belongs to another tenant")
+
+// tenantConnection wraps a connection and limits all queries on TenantEntry's to a single tenant
+type tenantConnection struct {
+	Connection
+	tenantID primitive.ObjectID
+}
+
+// WithTenant returns a connection where all queries on TenantEntry's are limited to the tenant
+// Entries that do not implement TenantEntry are not affected
+func WithTenant(conn Connection, tenantID primitive.ObjectID) Connection {
+	return &tenantConnection{
+		Connection: WithoutTenant(conn),
+		tenantID:   tenantID,
+	}
+}
+
+// WithoutTenant returns the underlying connection of a connection created with WithTenant
+// If the connection is not limited to a tenant the connection itself is returned
+// Only use this for processes that are not executed on behalf of a tenant, like matching
+func WithoutTenant(conn Connection) Connection {
+	tenantConn, ok := conn.(*tenantConnection)
+	if ok {
+		return tenantConn.Connection
+	}
+	return conn
+}
+
+// TenantOf returns the tenant a connection is limited to or nil if the connection is not limited to a tenant
+func TenantOf(conn Connection) *primitive.ObjectID {
+	tenantConn, ok := conn.(*tenantConnection)
+	if !ok {
+		return nil
+	}
+	tenantID := tenantConn.tenantID
+	return &tenantID
+}
+
+func (c *tenantConnection) filter(e Entry, filter bson.M) bson.M {
+	if _, ok := e.(TenantEntry); !ok {
+		return filter
+	}
+
+	// Copy the filter so we do not modify the filter of the caller
+	res := bson.M{}
+	for key, value := range filter {
+		res[key] = value
+	}
+	res["tenantId"] = c.tenantID
+	return res
+}
+
+func (c *tenantConnection) checkOwnership(e Entry, id primitive.ObjectID) error {
+	if _, ok := e.(TenantEntry); !ok {
+		return nil
+	}
+	err := c.Connection.FindOne(NewEntryOfSameType(e), c.filter(e, bson.M{"_id": id}), FindOptions{NoDefaultFilters: true})
+	if err == mongo.ErrNoDocuments {
+		return ErrWrongTenant
+	}
+	return err
+}
+
+// FindOne implements Connection
+func (c *tenantConnection) FindOne(result Entry, filter bson.M, opts ...FindOptions) error {
+	return c.Connection.FindOne(result, c.filter(result, filter), opts...)
+}
+
+// Find implements Connection
+func (c *tenantConnection) Find(e Entry, results any, filter bson.M, opts ...FindOptions) error {
+	return c.Connection.Find(e, results, c.filter(e, filter), opts...)
+}
+
+// Count implements Connection
+func (c *tenantConnection) Count(e Entry, filter bson.M) (uint64, error) {
+	return c.Connection.Count(e, c.filter(e, filter))
+}
+
+// Insert implements Connection
+func (c *tenantConnection) Insert(data ...Entry) error {
+	for _, entry := range data {
+		tenantEntry, ok := entry.(TenantEntry)
+		if ok {
+			tenantID := c.tenantID
+			tenantEntry.SetTenantID(&tenantID)
+		}
+	}
+	return c.Connection.Insert(data...)
+}
+
+// UpdateByID implements Connection
+func (c *tenantConnection) UpdateByID(data Entry) error {
+	err := c.checkOwnership(data, data.GetID())
+	if err != nil {
+		return err
+	}
+
+	tenantEntry, ok := data.(TenantEntry)
+	if ok {
+		tenantID := c.tenantID
+		tenantEntry.SetTenantID(&tenantID)
+	}
+	return c.Connection.UpdateByID(data)
+}
+
+// DeleteByID implements Connection
+func (c *tenantConnection) DeleteByID(e Entry, ids ...primitive.ObjectID) error {
+	for _, id := range ids {
+		err := c.checkOwnership(e, id)
+		if err != nil {
+			return err
+		}
+	}
+	return c.Connection.DeleteByID(e, ids...)
+}
+
+// InsertMany implements Connection
+func (c *tenantConnection) InsertMany(entries any) error {
+	parsedEntries, err := EntriesOf(entries)
+	if err != nil {
+		return err
+	}
+	return c.Insert(parsedEntries...)
+}
+
+// UpdateMany implements Connection
+func (c *tenantConnection) UpdateMany(e Entry, filter bson.M, update bson.M) (uint64, error) {
+	if _, ok := e.(TenantEntry); ok {
+		for _, fields := range update {
+			fieldsMap, ok := fields.(bson.M)
+			if !ok {
+				continue
+			}
+			if _, ok := fieldsMap["tenantId"]; ok {
+				return 0, ErrWrongTenant
+			}
+		}
+	}
+	return c.Connection.UpdateMany(e, c.filter(e, filter), update)
+}
+
+// DeleteMany implements Connection
+func (c *tenantConnection) DeleteMany(e Entry, filter bson.M) (uint64, error) {
+	return c.Connection.DeleteMany(e, c.filter(e, filter))
+}
+
+// WithTransaction implements Connection
+func (c *tenantConnection) WithTransaction(fn func(tx Connection) error) error {
+	return c.Connection.WithTransaction(func(tx Connection) error {
+		return fn(&tenantConnection{
+			Connection: tx,
+			tenantID:   c.tenantID,
+		})
+	})
+}
+
+// Watch implements Connection
+// Inserts and updates of entries of other tenants are not send to fn
+// Deletes are always send as we cannot know the tenant of a deleted entry
+func (c *tenantConnection) Watch(e Entry, fn func(change Change)) (func(), error) {
+	if _, ok := e.(TenantEntry); !ok {
+		return c.Connection.Watch(e, fn)
+	}
+
+	return c.Connection.Watch(e, func(change Change) {
+		if change.Entry != nil && !SameTenant(change.Entry.(TenantEntry).GetTenantID(), &c.tenantID) {
+			return
+		}
+		fn(change)
+	})
+}
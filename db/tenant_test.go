@@ -0,0 +1,116 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type tenantNote struct {
+	db.M `bson:",inline"`
+	db.T `bson:",inline"`
+	Text string
+}
+
+func (*tenantNote) CollectionName() string {
+	return "notes"
+}
+
+func TestWithTenant(t *testing.T) {
+	conn := testingdb.NewDB()
+	tenantA := primitive.NewObjectID()
+	tenantB := primitive.NewObjectID()
+
+	connA := db.WithTenant(conn, tenantA)
+	connB := db.WithTenant(conn, tenantB)
+	Equal(t, tenantA, *db.TenantOf(connA))
+	Nil(t, db.TenantOf(conn))
+	Equal(t, conn, db.WithoutTenant(connA))
+
+	noteA := &tenantNote{M: db.NewM(), Text: "a"}
+	err := connA.Insert(noteA)
+	NoError(t, err)
+	Equal(t, tenantA, *noteA.TenantID)
+
+	shared := &tenantNote{M: db.NewM(), Text: "shared"}
+	err = conn.Insert(shared)
+	NoError(t, err)
+
+	// Every tenant only sees its own entries
+	notes := []tenantNote{}
+	err = connA.Find(&tenantNote{}, &notes, nil)
+	NoError(t, err)
+	Len(t, notes, 1)
+	Equal(t, "a", notes[0].Text)
+
+	count, err := connB.Count(&tenantNote{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(0), count)
+
+	err = connB.FindOne(&tenantNote{}, bson.M{"_id": noteA.ID})
+	Equal(t, mongo.ErrNoDocuments, err)
+
+	// The connection without a tenant sees everything
+	count, err = conn.Count(&tenantNote{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(2), count)
+
+	// Entries of other tenants cannot be modified
+	err = connB.UpdateByID(&tenantNote{M: noteA.M, Text: "b"})
+	Equal(t, db.ErrWrongTenant, err)
+	err = connB.DeleteByID(&tenantNote{}, noteA.ID)
+	Equal(t, db.ErrWrongTenant, err)
+	err = connA.DeleteByID(&tenantNote{}, shared.ID)
+	Equal(t, db.ErrWrongTenant, err)
+
+	err = connA.DeleteByID(&tenantNote{}, noteA.ID)
+	NoError(t, err)
+}
+
+func TestWithTenantBulkOperations(t *testing.T) {
+	conn := testingdb.NewDB()
+	tenantA := primitive.NewObjectID()
+	connA := db.WithTenant(conn, tenantA)
+
+	err := conn.Insert(&tenantNote{M: db.NewM(), Text: "shared"})
+	NoError(t, err)
+	notes := []tenantNote{{Text: "a"}, {Text: "b"}}
+	err = connA.InsertMany(notes)
+	NoError(t, err)
+	Equal(t, tenantA, *notes[0].TenantID)
+
+	// Bulk updates and deletes are limited to the tenant
+	updated, err := connA.UpdateMany(&tenantNote{}, nil, bson.M{"$set": bson.M{"text": "updated"}})
+	NoError(t, err)
+	Equal(t, uint64(2), updated)
+	count, err := conn.Count(&tenantNote{}, bson.M{"text": "shared"})
+	NoError(t, err)
+	Equal(t, uint64(1), count)
+
+	_, err = connA.UpdateMany(&tenantNote{}, nil, bson.M{"$set": bson.M{"tenantId": nil}})
+	Equal(t, db.ErrWrongTenant, err)
+
+	deleted, err := connA.DeleteMany(&tenantNote{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(2), deleted)
+	count, err = conn.Count(&tenantNote{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(1), count)
+}
+
+func TestSameTenant(t *testing.T) {
+	a := primitive.NewObjectID()
+	aCopy := a
+	b := primitive.NewObjectID()
+
+	True(t, db.SameTenant(nil, nil))
+	True(t, db.SameTenant(&a, &aCopy))
+	False(t, db.SameTenant(&a, &b))
+	False(t, db.SameTenant(&a, nil))
+	False(t, db.SameTenant(nil, &b))
+}
@@ -0,0 +1,265 @@
+package testingdb
+
+import (
+	"context"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The conformance tests run the same queries against the testing database and MongoDB
+// MongoDB is only used if $MONGODB_TEST_URI is set, the queries are always checked against the recorded expectations
+//
+// To run them against MongoDB:
+//
+//	MONGODB_TEST_URI=mongodb://localhost:27017 go test ./db/testingdb -run TestConformance
+
+type conformanceItem struct {
+	Name string `bson:"name"`
+	Qty  int    `bson:"qty"`
+}
+
+type conformanceNested struct {
+	Value string `bson:"value"`
+}
+
+type conformanceDoc struct {
+	db.M   `bson:",inline"`
+	Name   string             `bson:"name"`
+	Age    *int               `bson:"age"`
+	Active bool               `bson:"active"`
+	Tags   []string           `bson:"tags"`
+	Scores []int              `bson:"scores"`
+	Items  []conformanceItem  `bson:"items"`
+	Nested *conformanceNested `bson:"nested"`
+}
+
+func (*conformanceDoc) CollectionName() string {
+	return "conformance"
+}
+
+func conformanceDocs() []*conformanceDoc {
+	age := func(age int) *int { return &age }
+
+	return []*conformanceDoc{
+		{
+			M:      db.NewM(),
+			Name:   "alice",
+			Age:    age(30),
+			Active: true,
+			Tags:   []string{"a", "b"},
+			Scores: []int{80, 90},
+			Items:  []conformanceItem{{Name: "pen", Qty: 5}, {Name: "book", Qty: 1}},
+			Nested: &conformanceNested{Value: "x"},
+		},
+		{
+			M:      db.NewM(),
+			Name:   "bob",
+			Age:    age(25),
+			Tags:   []string{"b", "c"},
+			Scores: []int{70},
+			Items:  []conformanceItem{{Name: "pen", Qty: 1}},
+		},
+		{
+			M:      db.NewM(),
+			Name:   "carol",
+			Active: true,
+			Tags:   []string{},
+			Nested: &conformanceNested{Value: "y"},
+		},
+		{
+			M:      db.NewM(),
+			Name:   "dave",
+			Age:    age(40),
+			Scores: []int{85, 86},
+			Items:  []conformanceItem{{Name: "book", Qty: 3}},
+		},
+	}
+}
+
+var conformanceCases = []struct {
+	name     string
+	filter   bson.M
+	expected []string
+}{
+	{"equal", bson.M{"name": "alice"}, []string{"alice"}},
+	{"bson.D operators", bson.M{"active": true, "age": bson.D{{Key: "$gte", Value: 30}}}, []string{"alice"}},
+	{"null", bson.M{"age": nil}, []string{"carol"}},
+	{"null list", bson.M{"scores": nil}, []string{"carol"}},
+	{"greater than skips null", bson.M{"age": bson.M{"$gt": 26}}, []string{"alice", "dave"}},
+	{"greater than string", bson.M{"name": bson.M{"$gt": "bob"}}, []string{"carol", "dave"}},
+	{"less than or equal string", bson.M{"name": bson.M{"$lte": "bob"}}, []string{"alice", "bob"}},
+	{"string range", bson.M{"name": bson.M{"$gte": "b", "$lt": "d"}}, []string{"bob", "carol"}},
+	{"list greater than string", bson.M{"tags": bson.M{"$gt": "b"}}, []string{"bob"}},
+	{"string does not compare to number", bson.M{"age": bson.M{"$gt": "1"}}, []string{}},
+	{"$in", bson.M{"age": bson.M{"$in": bson.A{25, 40}}}, []string{"bob", "dave"}},
+	{"$in null", bson.M{"age": bson.M{"$in": bson.A{nil}}}, []string{"carol"}},
+	{"$in regex", bson.M{"name": bson.M{"$in": bson.A{primitive.Regex{Pattern: "^d"}, "bob"}}}, []string{"bob", "dave"}},
+	{"$nin", bson.M{"age": bson.M{"$nin": bson.A{25, 30}}}, []string{"carol", "dave"}},
+	{"list contains", bson.M{"tags": "b"}, []string{"alice", "bob"}},
+	{"list $in", bson.M{"tags": bson.M{"$in": bson.A{"a", "c"}}}, []string{"alice", "bob"}},
+	{"list $nin", bson.M{"tags": bson.M{"$nin": bson.A{"b"}}}, []string{"carol", "dave"}},
+	{"list $ne", bson.M{"tags": bson.M{"$ne": "b"}}, []string{"carol", "dave"}},
+	{"list equal", bson.M{"tags": bson.A{"a", "b"}}, []string{"alice"}},
+	{"list equal order", bson.M{"tags": bson.A{"b", "a"}}, []string{}},
+	{"list $regex", bson.M{"tags": bson.M{"$regex": "^c"}}, []string{"bob"}},
+	{"$all", bson.M{"tags": bson.M{"$all": bson.A{"a", "b"}}}, []string{"alice"}},
+	{"$all single", bson.M{"tags": bson.M{"$all": bson.A{"b"}}}, []string{"alice", "bob"}},
+	{"$all $elemMatch", bson.M{"items": bson.M{"$all": bson.A{
+		bson.M{"$elemMatch": bson.M{"name": "pen"}},
+		bson.M{"$elemMatch": bson.M{"name": "book"}},
+	}}}, []string{"alice"}},
+	{"$size empty", bson.M{"tags": bson.M{"$size": 0}}, []string{"carol"}},
+	{"$size", bson.M{"tags": bson.M{"$size": 2}}, []string{"alice", "bob"}},
+	{"$size of structs", bson.M{"items": bson.M{"$size": 1}}, []string{"bob", "dave"}},
+	{"$not $size", bson.M{"tags": bson.M{"$not": bson.M{"$size": 2}}}, []string{"carol", "dave"}},
+	{"size at least", bson.M{"tags.1": bson.M{"$exists": true}}, []string{"alice", "bob"}},
+	{"size at most", bson.M{"tags.0": bson.M{"$exists": false}}, []string{"carol", "dave"}},
+	{"list index", bson.M{"scores.0": bson.M{"$gt": 80}}, []string{"dave"}},
+	{"list index into struct", bson.M{"items.1.name": "book"}, []string{"alice"}},
+	{"list operators on any entry", bson.M{"scores": bson.M{"$gte": 86, "$lt": 90}}, []string{"alice", "dave"}},
+	{"$elemMatch", bson.M{"scores": bson.M{"$elemMatch": bson.M{"$gte": 86, "$lt": 90}}}, []string{"dave"}},
+	{"$elemMatch structs", bson.M{"items": bson.M{"$elemMatch": bson.M{"name": "pen", "qty": bson.M{"$gt": 2}}}}, []string{"alice"}},
+	{"path into list of structs", bson.M{"items.name": "book", "items.qty": bson.M{"$gt": 4}}, []string{"alice"}},
+	{"$elemMatch on one entry", bson.M{"items": bson.M{"$elemMatch": bson.M{"name": "book", "qty": bson.M{"$gt": 4}}}}, []string{}},
+	{"path into list of structs $ne", bson.M{"items.name": bson.M{"$ne": "pen"}}, []string{"carol", "dave"}},
+	{"path into list of structs $exists", bson.M{"items.qty": bson.M{"$exists": false}}, []string{"carol"}},
+	{"nested", bson.M{"nested.value": "x"}, []string{"alice"}},
+	{"nested $exists", bson.M{"nested.value": bson.M{"$exists": false}}, []string{"bob", "dave"}},
+	{"$regex", bson.M{"name": bson.M{"$regex": "^[ab]"}}, []string{"alice", "bob"}},
+	{"$regex options", bson.M{"name": bson.M{"$regex": "^A", "$options": "i"}}, []string{"alice"}},
+	{"regex value", bson.M{"name": primitive.Regex{Pattern: "l"}}, []string{"alice", "carol"}},
+	{"$not $regex", bson.M{"name": bson.M{"$not": primitive.Regex{Pattern: "^a"}}}, []string{"bob", "carol", "dave"}},
+	{"$and", bson.M{"$and": bson.A{bson.M{"tags": "b"}, bson.M{"age": bson.M{"$lt": 30}}}}, []string{"bob"}},
+	{"$or", bson.M{"$or": bson.A{bson.M{"age": bson.M{"$lt": 26}}, bson.M{"tags": bson.M{"$size": 0}}}}, []string{"bob", "carol"}},
+	{"$nor", bson.M{"$nor": bson.A{bson.M{"active": true}, bson.M{"age": 40}}}, []string{"bob"}},
+}
+
+// conformanceErrorCases are filters rejected by MongoDB
+var conformanceErrorCases = []struct {
+	name   string
+	filter bson.M
+}{
+	{"$size with an operator", bson.M{"tags": bson.M{"$size": bson.M{"$gt": 1}}}},
+	{"$size not a whole number", bson.M{"tags": bson.M{"$size": 1.5}}},
+	{"$size inside of $or", bson.M{"$or": bson.A{bson.M{"tags": bson.M{"$size": "1"}}}}},
+	{"unknown operator", bson.M{"age": bson.M{"$between": bson.A{1, 2}}}},
+	{"$in without an array", bson.M{"age": bson.M{"$in": 25}}},
+	{"$or without an array", bson.M{"$or": bson.M{"age": 25}}},
+}
+
+func TestConformance(t *testing.T) {
+	docs := conformanceDocs()
+	idToName := map[primitive.ObjectID]string{}
+	entries := make([]db.Entry, len(docs))
+	for idx, doc := range docs {
+		idToName[doc.ID] = doc.Name
+		entries[idx] = doc
+	}
+
+	testDB := newTestDB(t)
+	err := testDB.Insert(entries...)
+	NoError(t, err)
+
+	var mongoCollection *mongo.Collection
+	if mongoURI := os.Getenv("MONGODB_TEST_URI"); mongoURI != "" && !useFileStore {
+		mongoCollection = conformanceMongoCollection(t, mongoURI, entries)
+	}
+
+	for _, testCase := range conformanceCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			expected := append([]string{}, testCase.expected...)
+			sort.Strings(expected)
+
+			results := []conformanceDoc{}
+			err := testDB.Find(&conformanceDoc{}, &results, testCase.filter)
+			NoError(t, err)
+			names := []string{}
+			for _, result := range results {
+				names = append(names, result.Name)
+			}
+			sort.Strings(names)
+			Equal(t, expected, names, "testing database")
+
+			if mongoCollection == nil {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cursor, err := mongoCollection.Find(ctx, testCase.filter)
+			NoError(t, err)
+			mongoResults := []conformanceDoc{}
+			err = cursor.All(ctx, &mongoResults)
+			NoError(t, err)
+			mongoNames := []string{}
+			for _, result := range mongoResults {
+				mongoNames = append(mongoNames, idToName[result.ID])
+			}
+			sort.Strings(mongoNames)
+			Equal(t, expected, mongoNames, "MongoDB")
+		})
+	}
+
+	for _, testCase := range conformanceErrorCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			results := []conformanceDoc{}
+			err := testDB.Find(&conformanceDoc{}, &results, testCase.filter)
+			Error(t, err, "testing database")
+			_, err = testDB.Count(&conformanceDoc{}, testCase.filter)
+			Error(t, err, "testing database")
+			_, err = testDB.UpdateMany(&conformanceDoc{}, testCase.filter, bson.M{"$set": bson.M{"active": true}})
+			Error(t, err, "testing database")
+			_, err = testDB.DeleteMany(&conformanceDoc{}, testCase.filter)
+			Error(t, err, "testing database")
+
+			if mongoCollection == nil {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cursor, err := mongoCollection.Find(ctx, testCase.filter)
+			if err == nil {
+				// MongoDB might only return the error when reading the results
+				err = cursor.All(ctx, &results)
+			}
+			Error(t, err, "MongoDB")
+		})
+	}
+}
+
+// conformanceMongoCollection inserts the entries into a collection of a temporary MongoDB database that is dropped after the test
+func conformanceMongoCollection(t *testing.T, uri string, entries []db.Entry) *mongo.Collection {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if !NoError(t, err) {
+		t.FailNow()
+	}
+	database := client.Database("rtcv_conformance_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	documents := make([]any, len(entries))
+	for idx, entry := range entries {
+		documents[idx] = entry
+	}
+	collection := database.Collection(entries[0].CollectionName())
+	_, err = collection.InsertMany(ctx, documents)
+	if !NoError(t, err) {
+		t.FailNow()
+	}
+	return collection
+}
@@ -10,16 +10,26 @@ func (c *TestConnection) Count(entry db.Entry, filter bson.M) (uint64, error) {
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	collection := c.getCollectionFromEntry(entry)
+	collection, err := c.getCollectionFromEntry(entry)
+	if err != nil {
+		return 0, err
+	}
 	if len(filter) == 0 {
 		// Take the easy route
 		return uint64(len(collection.data)), nil
 	}
 
-	itemsFilter := newFilter(filter)
+	itemsFilter, err := newFilter(filter)
+	if err != nil {
+		return 0, err
+	}
 	var count uint64
 	for _, item := range collection.data {
-		if itemsFilter.matches(item) {
+		matches, err := itemsFilter.matches(item)
+		if err != nil {
+			return 0, err
+		}
+		if matches {
 			count++
 		}
 	}
@@ -2,28 +2,111 @@ package testingdb
 
 import (
 	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // DeleteByID deletes a document by it's ID
 func (c *TestConnection) DeleteByID(entry db.Entry, ids ...primitive.ObjectID) error {
-	c.m.Lock()
-	defer c.m.Unlock()
-
 	if len(ids) == 0 {
 		return nil
 	}
 
-	collection := c.getCollectionFromEntry(entry)
-	for _, eID := range ids {
-		for i, collectionEntry := range collection.data {
-			if collectionEntry.GetID() == eID {
-				collection.data = append(collection.data[:i], collection.data[i+1:]...)
-				c.setCollection(collection)
-				break
-			}
-		}
+	c.m.Lock()
+	changes, err := c.unsafeDeleteByID(entry, ids)
+	c.m.Unlock()
+	if err != nil {
+		return err
 	}
 
+	c.notify(changes...)
 	return nil
 }
+
+// unsafeDeleteByID deletes the documents with the ids and returns the changes to notify the watchers about
+// Not thread safe
+func (c *TestConnection) unsafeDeleteByID(entry db.Entry, ids []primitive.ObjectID) ([]db.Change, error) {
+	collection, err := c.getCollectionFromEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	idsToDelete := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		idsToDelete[id] = true
+	}
+
+	// We create a new slice here so we do not modify the data of a transaction snapshot or the data of a failed write
+	newData := make([]db.Entry, 0, len(collection.data))
+	changes := []db.Change{}
+	for _, item := range collection.data {
+		id := item.GetID()
+		if idsToDelete[id] {
+			changes = append(changes, deleteChange(collection.name, id))
+		} else {
+			newData = append(newData, item)
+		}
+	}
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	collection.data = newData
+	return changes, c.setCollection(collection)
+}
+
+// DeleteMany deletes all documents matching the filter
+func (c *TestConnection) DeleteMany(entry db.Entry, filter bson.M) (uint64, error) {
+	c.m.Lock()
+	changes, err := c.unsafeDeleteMany(entry, filter)
+	c.m.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	c.notify(changes...)
+	return uint64(len(changes)), nil
+}
+
+// unsafeDeleteMany deletes all documents matching the filter and returns the changes to notify the watchers about
+// Not thread safe
+func (c *TestConnection) unsafeDeleteMany(entry db.Entry, filter bson.M) ([]db.Change, error) {
+	itemsFilter, err := newFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := c.getCollectionFromEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// We create a new slice here so we do not modify the data of a transaction snapshot
+	newData := make([]db.Entry, 0, len(collection.data))
+	changes := []db.Change{}
+	for _, item := range collection.data {
+		matches, err := itemsFilter.matches(item)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			changes = append(changes, deleteChange(collection.name, item.GetID()))
+		} else {
+			newData = append(newData, item)
+		}
+	}
+
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	collection.data = newData
+	return changes, c.setCollection(collection)
+}
+
+func deleteChange(collectionName string, id primitive.ObjectID) db.Change {
+	return db.Change{
+		Kind:       db.ChangeDelete,
+		Collection: collectionName,
+		ID:         id,
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"testing"
 
 	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func TestDelete(t *testing.T) {
-	testDB := NewDB()
+	testDB := newTestDB(t)
 
 	mockData := NewMockuser()
 	Equal(t, "users", mockData.CollectionName())
@@ -30,3 +31,27 @@ func TestDelete(t *testing.T) {
 	documentsCount, _ = testDB.Count(mockData, nil)
 	Equal(t, uint64(0), documentsCount)
 }
+
+func TestDeleteMany(t *testing.T) {
+	testDB := newTestDB(t)
+
+	piet := NewMockuser()
+	jan := NewMockuser()
+	jan.Username = "Jan"
+	err := testDB.Insert(piet, jan, NewMockuser())
+	NoError(t, err)
+
+	deleted, err := testDB.DeleteMany(&MockUser{}, bson.M{"username": "Piet"})
+	NoError(t, err)
+	Equal(t, uint64(2), deleted)
+
+	results := []MockUser{}
+	err = testDB.Find(&MockUser{}, &results, nil)
+	NoError(t, err)
+	Len(t, results, 1)
+	Equal(t, jan.ID, results[0].ID)
+
+	deleted, err = testDB.DeleteMany(&MockUser{}, bson.M{"username": "Piet"})
+	NoError(t, err)
+	Equal(t, uint64(0), deleted)
+}
@@ -35,7 +35,10 @@ func (c *TestConnection) Dump(shouldPanic bool) {
 //
 // shouldPanic controls if the output is only printed or also should panic
 func (c *TestConnection) DumpCollection(entry db.Entry, shouldPanic bool) {
-	collection := c.getCollectionFromEntry(entry)
+	collection, err := c.getCollectionFromEntry(entry)
+	if err != nil {
+		panic(err)
+	}
 
 	jsonBytes, err := json.MarshalIndent(collection.data, "", "    ")
 	if err != nil {
@@ -1,8 +1,10 @@
 package testingdb
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -17,16 +19,80 @@ type filter struct {
 	empty   bool
 }
 
-func newFilter(filters bson.M) *filter {
+// newFilter returns a filter for the filters or an error if the filters are invalid
+func newFilter(filters bson.M) (*filter, error) {
+	err := validateFilter(reflect.ValueOf(filters))
+	if err != nil {
+		return nil, err
+	}
 	return &filter{
 		filters: reflect.ValueOf(filters),
 		empty:   len(filters) == 0,
+	}, nil
+}
+
+// validateFilter returns an error for the operator arguments MongoDB rejects
+// Only the arguments that could otherwise silently give other results than MongoDB are checked
+func validateFilter(filter reflect.Value) error {
+	for filter.Kind() == reflect.Interface && !filter.IsNil() {
+		filter = filter.Elem()
 	}
+	filter = asFilterMap(filter)
+
+	switch filter.Kind() {
+	case reflect.Map:
+		if filter.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		iter := filter.MapRange()
+		for iter.Next() {
+			if iter.Key().String() == "$size" {
+				err := validateSizeArgument(iter.Value())
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err := validateFilter(iter.Value())
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if !isList(filter) {
+			return nil
+		}
+		for i := 0; i < filter.Len(); i++ {
+			err := validateFilter(filter.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func (f *filter) matches(value any) bool {
+// validateSizeArgument returns an error if the $size argument is not a whole number
+// Like MongoDB other operators like $gt are not supported inside of $size
+func validateSizeArgument(argument reflect.Value) error {
+	for argument.Kind() == reflect.Interface && !argument.IsNil() {
+		argument = argument.Elem()
+	}
+	size, ok := numberAsFloat(argument)
+	if !ok {
+		return errors.New("$size needs a number")
+	}
+	if size != float64(int(size)) {
+		return errors.New("$size must be a whole number")
+	}
+	return nil
+}
+
+// matches returns true if the value matches the filter
+// An error is returned if the filter contains something that is not supported by the testing database
+func (f *filter) matches(value any) (bool, error) {
 	if f.empty {
-		return true
+		return true, nil
 	}
 
 	valueReflection := reflect.ValueOf(value)
@@ -38,22 +104,26 @@ func (f *filter) matches(value any) bool {
 }
 
 var timeType = reflect.TypeOf(time.Time{})
+var objectIDType = reflect.TypeOf(primitive.ObjectID{})
 
-func filterMatchesValue(filterMap reflect.Value, value, valueParrentInCaseOfListEntryOrValue reflect.Value) bool {
+func filterMatchesValue(filterMap reflect.Value, value, valueParrentInCaseOfListEntryOrValue reflect.Value) (bool, error) {
 	for value.Kind() == reflect.Interface && !value.IsNil() {
 		value = value.Elem()
 	}
 	for valueParrentInCaseOfListEntryOrValue.Kind() == reflect.Interface && !valueParrentInCaseOfListEntryOrValue.IsNil() {
 		valueParrentInCaseOfListEntryOrValue = valueParrentInCaseOfListEntryOrValue.Elem()
 	}
-
-	valueFieldsMap, valueIsStruct := mapStruct(value.Type())
+	filterMap = asFilterMap(filterMap)
+	if isNilValue(value) {
+		return nilValueMatches(filterMap), nil
+	}
+	err := assertMapHasStringKeys(filterMap.Type())
+	if err != nil {
+		return false, err
+	}
 
 	iter := filterMap.MapRange()
-
-filtersLoop:
 	for iter.Next() {
-		// FIXME we assume the key is a string, maybe we should support also other values
 		key := iter.Key().String()
 		filter := iter.Value()
 		if filter.Kind() == reflect.Interface {
@@ -76,113 +146,174 @@ filtersLoop:
 			case "$gt":
 				if filter.Type().ConvertibleTo(timeType) {
 					if !value.Type().ConvertibleTo(timeType) {
-						return false
+						return false, nil
 					}
 					if value.Convert(timeType).Interface().(time.Time).Before(filter.Convert(timeType).Interface().(time.Time)) {
-						return false
+						return false, nil
+					}
+				} else if filter.Kind() == reflect.String {
+					if !compareStrings(numComparisonGreater, value, filter) {
+						return false, nil
 					}
 				} else if !compareNumbers(numComparisonGreater, value, filter) {
-					return false
+					return false, nil
 				}
 			case "$gte":
 				if filter.Type().ConvertibleTo(timeType) {
 					if !value.Type().ConvertibleTo(timeType) {
-						return false
+						return false, nil
 					}
 					if value.Convert(timeType).Interface().(time.Time).Before(filter.Convert(timeType).Interface().(time.Time)) {
-						return false
+						return false, nil
+					}
+				} else if filter.Kind() == reflect.String {
+					if !compareStrings(numComparisonGreaterOrEqual, value, filter) {
+						return false, nil
 					}
 				} else if !compareNumbers(numComparisonGreaterOrEqual, value, filter) {
-					return false
+					return false, nil
 				}
 			case "$lt":
 				if filter.Type().ConvertibleTo(timeType) {
 					if !value.Type().ConvertibleTo(timeType) {
-						return false
+						return false, nil
 					}
 					if value.Convert(timeType).Interface().(time.Time).After(filter.Convert(timeType).Interface().(time.Time)) {
-						return false
+						return false, nil
+					}
+				} else if filter.Kind() == reflect.String {
+					if !compareStrings(numComparisonLess, value, filter) {
+						return false, nil
 					}
 				} else if !compareNumbers(numComparisonLess, value, filter) {
-					return false
+					return false, nil
 				}
 			case "$lte":
 				if filter.Type().ConvertibleTo(timeType) {
 					if !value.Type().ConvertibleTo(timeType) {
-						return false
+						return false, nil
 					}
 					if value.Convert(timeType).Interface().(time.Time).After(filter.Convert(timeType).Interface().(time.Time)) {
-						return false
+						return false, nil
+					}
+				} else if filter.Kind() == reflect.String {
+					if !compareStrings(numComparisonLessOrEqual, value, filter) {
+						return false, nil
 					}
 				} else if !compareNumbers(numComparisonLessOrEqual, value, filter) {
-					return false
+					return false, nil
 				}
 			case "$eq":
-				if !filterCompare(filter, value, false) {
-					return false
+				matches, err := filterCompare(filter, value, false)
+				if err != nil || !matches {
+					return false, err
+				}
+			case "$in":
+				matches, err := valueInList(filter, value, "$in")
+				if err != nil || !matches {
+					return false, err
+				}
+			case "$nin":
+				inList, err := valueInList(filter, value, "$nin")
+				if err != nil || inList {
+					return false, err
+				}
+			case "$all":
+				matches, err := allMatch(filter, value)
+				if err != nil || !matches {
+					return false, err
+				}
+			case "$elemMatch":
+				matches, err := elemMatches(filter, value)
+				if err != nil || !matches {
+					return false, err
+				}
+			case "$exists":
+				// Values that do not exist are handled by nilValueMatches so if we get here the value exists
+				if filter.Kind() != reflect.Bool {
+					return false, errors.New("$exists should have a boolean as argument")
 				}
+				if !filter.Bool() {
+					return false, nil
+				}
+			case "$regex":
+				var pattern, regexOptions string
+				switch typedFilter := filter.Interface().(type) {
+				case string:
+					pattern = typedFilter
+				case primitive.Regex:
+					pattern = typedFilter.Pattern
+					regexOptions = typedFilter.Options
+				default:
+					return false, errors.New("$regex should have a string or regex as argument")
+				}
+				options := filterMap.MapIndex(reflect.ValueOf("$options"))
+				for options.IsValid() && options.Kind() == reflect.Interface {
+					options = options.Elem()
+				}
+				if options.IsValid() {
+					regexOptions = options.String()
+				}
+				matches, err := regexMatches(pattern, regexOptions, value)
+				if err != nil || !matches {
+					return false, err
+				}
+			case "$options":
+				// Used by $regex
 			case "$not", "$ne":
-				if filterCompare(filter, value, false) {
-					return false
+				matches, err := filterCompare(filter, value, false)
+				if err != nil || matches {
+					return false, err
 				}
 			case "$or":
-				if filter.Kind() != reflect.Slice && filter.Kind() != reflect.Array {
-					return false
+				entries, err := logicalFilterEntries(filter, key)
+				if err != nil {
+					return false, err
 				}
-
 				foundOk := false
-				for i := 0; i < filter.Len(); i++ {
-					orEntry := filter.Index(i)
-					if orEntry.Kind() != reflect.Map || orEntry.IsNil() {
-						// TODO maybe we should also support structs here
-						continue
+				for _, orEntry := range entries {
+					matches, err := filterMatchesValue(orEntry, valueParrentInCaseOfListEntryOrValue, valueParrentInCaseOfListEntryOrValue)
+					if err != nil {
+						return false, err
 					}
-					assertMapHasStringKeys(orEntry.Type())
-					if filterMatchesValue(orEntry, valueParrentInCaseOfListEntryOrValue, valueParrentInCaseOfListEntryOrValue) {
+					if matches {
 						foundOk = true
 						break
 					}
 				}
 				if !foundOk {
-					return false
+					return false, nil
 				}
 			case "$and":
-				if filter.Kind() != reflect.Slice && filter.Kind() != reflect.Array {
-					return false
+				entries, err := logicalFilterEntries(filter, key)
+				if err != nil {
+					return false, err
 				}
-
-				for i := 0; i < filter.Len(); i++ {
-					andEntry := filter.Index(i)
-					if andEntry.Kind() != reflect.Map || andEntry.IsNil() {
-						// TODO maybe we should also support structs here
-						continue
-					}
-					assertMapHasStringKeys(andEntry.Type())
-					if !filterMatchesValue(andEntry, valueParrentInCaseOfListEntryOrValue, valueParrentInCaseOfListEntryOrValue) {
-						return false
+				for _, andEntry := range entries {
+					matches, err := filterMatchesValue(andEntry, valueParrentInCaseOfListEntryOrValue, valueParrentInCaseOfListEntryOrValue)
+					if err != nil || !matches {
+						return false, err
 					}
 				}
-			case "$size":
-				var expectedSize int
-				switch filter.Kind() {
-				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-					expectedSize = int(filter.Int())
-				case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-					expectedSize = int(filter.Uint())
-				default:
-					panic("$size should have a number as argument")
+			case "$nor":
+				entries, err := logicalFilterEntries(filter, key)
+				if err != nil {
+					return false, err
 				}
-
-				if valueParrentInCaseOfListEntryOrValue.Kind() != reflect.Slice &&
-					valueParrentInCaseOfListEntryOrValue.Kind() != reflect.Array {
-					return false
+				for _, norEntry := range entries {
+					matches, err := filterMatchesValue(norEntry, valueParrentInCaseOfListEntryOrValue, valueParrentInCaseOfListEntryOrValue)
+					if err != nil || matches {
+						return false, err
+					}
 				}
-				if valueParrentInCaseOfListEntryOrValue.Kind() == reflect.Slice &&
-					valueParrentInCaseOfListEntryOrValue.IsNil() {
-					return false
+			case "$size":
+				// $size is normally handled by applyListOperators, we only get here if the value is not a list
+				if !isList(value) {
+					return false, nil
 				}
-				if valueParrentInCaseOfListEntryOrValue.Len() != expectedSize {
-					return false
+				matches, err := listSizeMatches(filter, value)
+				if err != nil || !matches {
+					return false, err
 				}
 			case "$type":
 				var expectedType string
@@ -205,165 +336,230 @@ filtersLoop:
 				case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 					expectedType = intToTypeName[int64(filter.Uint())]
 				default:
-					panic("$type should have a string or number as argument")
+					return false, errors.New("$type should have a string or number as argument")
 				}
 
 				valKind := valueParrentInCaseOfListEntryOrValue.Kind()
 				switch expectedType {
 				case "decimal", "double":
 					if valKind != reflect.Float64 && valKind != reflect.Float32 {
-						return false
+						return false, nil
 					}
 				case "int":
 					switch valKind {
 					case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 					case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 					default:
-						return false
+						return false, nil
 					}
 				case "string":
 					if valKind != reflect.String {
-						return false
+						return false, nil
 					}
 				case "object":
 					if valKind == reflect.Map {
 						if valueParrentInCaseOfListEntryOrValue.IsNil() {
-							return false
+							return false, nil
 						}
 					} else if valKind != reflect.Struct {
-						return false
+						return false, nil
 					}
 				case "array":
 					if valKind != reflect.Slice && valKind != reflect.Array {
-						return false
+						return false, nil
 					}
 					if valueParrentInCaseOfListEntryOrValue.Kind() == reflect.Slice &&
 						valueParrentInCaseOfListEntryOrValue.IsNil() {
-						return false
+						return false, nil
 					}
 				case "bool":
 					if valKind != reflect.Bool {
-						return false
+						return false, nil
 					}
 				case "null":
 					switch valKind {
 					case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
 						if !valueParrentInCaseOfListEntryOrValue.IsNil() {
-							return false
+							return false, nil
 						}
 					default:
-						return false
+						return false, nil
 					}
 				default:
-					return false
+					return false, nil
 				}
 			default:
 				// For docs see:
 				// https://docs.mongodb.com/manual/reference/operator/query/
-				panic("FIXME unimplemented custom MongoDB filter " + key)
+				return false, fmt.Errorf("unsupported MongoDB filter operator %s", key)
 			}
 			continue
 		}
 
-		if !valueIsStruct {
-			return false
+		// Negations match if the filter without the negation does not match
+		// Like MongoDB they are evaluated on the whole key instead of every entry of a list so {"tags": {"$ne": "a"}} matches if none of the tags is "a"
+		positiveFilter, negatedFilters := splitNegatedFilters(filter)
+		for _, negatedFilter := range negatedFilters {
+			matches, err := keyMatches(key, negatedFilter, value)
+			if err != nil || matches {
+				return false, err
+			}
 		}
-
-		splittedKey := strings.SplitN(key, ".", 2)
-		field, fieldFound := valueFieldsMap[splittedKey[0]]
-		if !fieldFound {
-			return false
+		if len(negatedFilters) > 0 && positiveFilter.Len() == 0 {
+			continue
 		}
 
-		tempValueCopy := value
-		for _, goPathPart := range field.GoPathToField {
-			tempValueCopy = tempValueCopy.FieldByName(goPathPart)
+		matches, err := keyMatches(key, positiveFilter, value)
+		if err != nil || !matches {
+			return false, err
 		}
-		valueField := tempValueCopy.FieldByName(field.GoFieldName)
+	}
 
-		if !filter.IsValid() {
-			// filter is probably a nil interface{}
-			// note that isNil panics if the value is a nil interface without a type
-			// so we check here for: interface{}(nil)
-			// and not: interface{}([]string(nil))
-			switch valueField.Kind() {
-			case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
-				if valueField.IsNil() {
-					continue filtersLoop
-				}
-			}
-			return false
+	return true, nil
+}
+
+// keyMatches returns true if the value at the dotted key of value matches the filter
+func keyMatches(key string, filter, value reflect.Value) (bool, error) {
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if filter.Kind() == reflect.Interface {
+		// A nil interface{} results in an invalid value
+		filter = filter.Elem()
+	}
+
+	splittedKey := strings.SplitN(key, ".", 2)
+	var valueField reflect.Value
+	if value.Kind() == reflect.Map && value.Type().Key().Kind() == reflect.String {
+		// Map keys are resolved like struct fields, a missing key results in an invalid value that is handled like null
+		valueField = value.MapIndex(reflect.ValueOf(splittedKey[0]).Convert(value.Type().Key()))
+	} else if valueFieldsMap, valueIsStruct := mapStruct(value.Type()); valueIsStruct {
+		// A missing field results in an invalid value that is handled like null
+		field, fieldFound := valueFieldsMap[splittedKey[0]]
+		if fieldFound {
+			valueField = structFieldValue(value, field)
 		}
+	}
 
-		if len(splittedKey) == 2 {
-			filter = reflect.ValueOf(primitive.M{splittedKey[1]: filter.Interface()})
-			if !filterCompare(filter, valueField, true) {
-				return false
-			}
-		} else if !filterCompare(filter, valueField, false) {
-			return false
+	if !filter.IsValid() && len(splittedKey) == 1 {
+		if !valueField.IsValid() {
+			return true, nil
 		}
+		// filter is probably a nil interface{}
+		// note that isNil panics if the value is a nil interface without a type
+		// so we check here for: interface{}(nil)
+		// and not: interface{}([]string(nil))
+		switch valueField.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			return valueField.IsNil(), nil
+		}
+		return false, nil
 	}
 
-	return true
+	if len(splittedKey) == 2 {
+		var remainderFilter any
+		if filter.IsValid() {
+			remainderFilter = filter.Interface()
+		}
+		return filterCompare(reflect.ValueOf(primitive.M{splittedKey[1]: remainderFilter}), valueField, true)
+	}
+	return filterCompare(filter, valueField, false)
 }
 
-func filterCompare(filter, value reflect.Value, filterIsRemainderOfKey bool) bool {
-	for value.Kind() == reflect.Ptr {
+func filterCompare(filter, value reflect.Value, filterIsRemainderOfKey bool) (bool, error) {
+	for filter.Kind() == reflect.Interface {
+		// A nil interface{} results in an invalid value
+		filter = filter.Elem()
+	}
+	filter = asFilterMap(filter)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
 		if value.IsNil() {
-			return false
+			return nilValueMatches(filter), nil
 		}
 		value = value.Elem()
 	}
+	if isNilValue(value) {
+		return nilValueMatches(filter), nil
+	}
+	if !filter.IsValid() {
+		// The filter is null but the value is not
+		return false, nil
+	}
 
 	filterKind := filter.Kind()
 	valueKind := value.Kind()
 
-	if filter.Kind() == reflect.Array {
+	valueIsList := isList(value)
+	if filterKind == reflect.Map && valueIsList {
+		// Checks for null and existence and the list operators apply to the list itself and not to the entries of the list
+		filterLen := filter.Len()
+		var err error
+		filter, err = applyListOperators(filter, value)
+		if err != nil || !filter.IsValid() {
+			return false, err
+		}
+		if filterLen > 0 && filter.Len() == 0 {
+			return true, nil
+		}
+	}
+
+	if filterKind == reflect.Array && !valueIsList {
 		filterObjectID, ok := filter.Interface().(primitive.ObjectID)
 		if ok {
 			goFieldValue, ok := value.Interface().(primitive.ObjectID)
-			return ok && goFieldValue == filterObjectID
+			return ok && goFieldValue == filterObjectID, nil
 		}
 	}
 
-	valueIsList := valueKind == reflect.Array || valueKind == reflect.Slice
 	if filterKind != reflect.Map && valueIsList {
 		if value.Kind() == reflect.Slice && value.IsNil() {
-			return false
+			return false, nil
+		}
+		if isList(filter) {
+			equal, err := listsEqual(filter, value)
+			if err != nil || equal {
+				// The whole list equals the filter
+				return equal, err
+			}
 		}
 		for i := 0; i < value.Len(); i++ {
-			if filterCompare(filter, value.Index(i), filterIsRemainderOfKey) {
-				return true
+			matches, err := filterCompare(filter, value.Index(i), filterIsRemainderOfKey)
+			if err != nil || matches {
+				return matches, err
 			}
 		}
-		return false
+		return false, nil
 	}
 
 	switch filterKind {
 	case reflect.String:
 		if valueKind != reflect.String || value.String() != filter.String() {
-			return false
+			return false, nil
 		}
 	case reflect.Bool:
 		if valueKind != reflect.Bool || value.Bool() != filter.Bool() {
-			return false
+			return false, nil
 		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		fallthrough
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fallthrough
+	case reflect.Float32, reflect.Float64:
 		if !compareNumbers(numComparisonEqual, filter, value) {
-			return false
+			return false, nil
 		}
 	case reflect.Map:
-		assertMapHasStringKeys(filter.Type())
+		err := assertMapHasStringKeys(filter.Type())
+		if err != nil {
+			return false, err
+		}
 		if filterKind != reflect.Map || filter.IsNil() {
-			return false
+			return false, nil
 		}
 
 		if valueIsList {
 			if value.Kind() == reflect.Slice && value.IsNil() {
-				return false
+				return false, nil
 			}
 
 			if filterIsRemainderOfKey {
@@ -380,35 +576,78 @@ func filterCompare(filter, value reflect.Value, filterIsRemainderOfKey bool) boo
 				if err == nil {
 					// This is a query like:
 					// {'foo.1': "bar"}
+					filter = filterValue
+					if len(filterKey) == 2 {
+						var remainderFilter any
+						if filterValue.IsValid() {
+							remainderFilter = filterValue.Interface()
+						}
+						filter = reflect.ValueOf(primitive.M{filterKey[1]: remainderFilter})
+					}
 					if index >= value.Len() || index < 0 {
-						return false
+						// The entry does not exist, this allows queries like {'foo.2': {'$exists': true}} to check the length of a list
+						return nilValueMatches(filter), nil
 					}
+					return filterCompare(filter, value.Index(index), len(filterKey) == 2)
+				}
+			}
 
-					filter = filterValue
-					if len(filterKey) == 2 {
-						filter = reflect.ValueOf(primitive.M{filterKey[1]: filterValue.Interface()})
+			anyEntryMatches := func(filter reflect.Value) (bool, error) {
+				for i := 0; i < value.Len(); i++ {
+					matches, err := filterMatchesValue(filter, value.Index(i), value)
+					if err != nil || matches {
+						return matches, err
 					}
-					return filterCompare(filterValue, value.Index(index), len(filterKey) == 2)
 				}
+				return false, nil
 			}
 
-			for i := 0; i < value.Len(); i++ {
-				if filterMatchesValue(filter, value.Index(i), value) {
-					return true
+			if !filterIsRemainderOfKey && filter.Len() > 1 && onlyOperators(filter) {
+				// Like MongoDB every operator may be matched by another entry of the list
+				// So {"$gt": 1, "$lt": 3} matches [0, 5], use $elemMatch to match a single entry
+				iter := filter.MapRange()
+				for iter.Next() {
+					key := iter.Key().String()
+					if key == "$options" {
+						continue
+					}
+					operatorFilter := bson.M{key: iter.Value().Interface()}
+					if key == "$regex" {
+						options := filter.MapIndex(reflect.ValueOf("$options"))
+						if options.IsValid() {
+							operatorFilter["$options"] = options.Interface()
+						}
+					}
+					matches, err := anyEntryMatches(reflect.ValueOf(operatorFilter))
+					if err != nil || !matches {
+						return false, err
+					}
 				}
+				return true, nil
 			}
-			return false
+
+			return anyEntryMatches(filter)
 		}
 
 		return filterMatchesValue(filter, value, value)
+	case reflect.Slice, reflect.Array:
+		// The value is not a list so it can't be equal to the list filter
+		return false, nil
+	case reflect.Struct:
+		switch typedFilter := filter.Interface().(type) {
+		case primitive.Regex:
+			return regexMatches(typedFilter.Pattern, typedFilter.Options, value)
+		case time.Time:
+			return value.Type().ConvertibleTo(timeType) && value.Convert(timeType).Interface().(time.Time).Equal(typedFilter), nil
+		}
+		fallthrough
 	default:
-		fmt.Println(filterKind)
 		valueInterf := value.Interface()
 		filterInterf := filter.Interface()
-		panic(fmt.Sprintf("Unimplemented value: %T %#v, filter: %T %#v", valueInterf, valueInterf, filterInterf, filterInterf))
+		return false, fmt.Errorf("unsupported filter value: %T %#v, filter: %T %#v", valueInterf, valueInterf, filterInterf, filterInterf)
 	}
 
-	return true
+	return true, nil
 }
 
 type structField struct {
@@ -486,6 +725,29 @@ const (
 )
 
 func compareNumbers(kind numComparison, a, b reflect.Value) bool {
+	aIsFloat := a.Kind() == reflect.Float32 || a.Kind() == reflect.Float64
+	bIsFloat := b.Kind() == reflect.Float32 || b.Kind() == reflect.Float64
+	if aIsFloat || bIsFloat {
+		aFloat, aOk := numberAsFloat(a)
+		bFloat, bOk := numberAsFloat(b)
+		if !aOk || !bOk {
+			return false
+		}
+		switch kind {
+		case numComparisonEqual:
+			return aFloat == bFloat
+		case numComparisonGreater:
+			return aFloat > bFloat
+		case numComparisonGreaterOrEqual:
+			return aFloat >= bFloat
+		case numComparisonLess:
+			return aFloat < bFloat
+		case numComparisonLessOrEqual:
+			return aFloat <= bFloat
+		}
+		return false
+	}
+
 	compareUInts := func(a, b uint64) bool {
 		switch kind {
 		case numComparisonEqual:
@@ -550,8 +812,374 @@ func compareNumbers(kind numComparison, a, b reflect.Value) bool {
 	return false
 }
 
-func assertMapHasStringKeys(m reflect.Type) {
+// compareStrings compares the strings a and b by their bytes like MongoDB does without a collation
+// Returns false if a is not a string as MongoDB only compares values of the same type
+func compareStrings(kind numComparison, a, b reflect.Value) bool {
+	if a.Kind() != reflect.String {
+		return false
+	}
+	res := strings.Compare(a.String(), b.String())
+	switch kind {
+	case numComparisonEqual:
+		return res == 0
+	case numComparisonGreater:
+		return res > 0
+	case numComparisonGreaterOrEqual:
+		return res >= 0
+	case numComparisonLess:
+		return res < 0
+	case numComparisonLessOrEqual:
+		return res <= 0
+	}
+	return false
+}
+
+// assertMapHasStringKeys returns an error if the filter map has keys that are not strings
+func assertMapHasStringKeys(m reflect.Type) error {
 	if m.Key().Kind() != reflect.String {
-		panic("TODO support filter type map with non string key")
+		return fmt.Errorf("unsupported filter map type %s, filter maps should have string keys", m)
+	}
+	return nil
+}
+
+func numberAsFloat(value reflect.Value) (float64, bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// isNilValue returns true if the value would be stored as null in MongoDB or does not exist
+func isNilValue(value reflect.Value) bool {
+	if !value.IsValid() {
+		return true
+	}
+	switch value.Kind() {
+	case reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return value.IsNil()
+	}
+	return false
+}
+
+// nilValueMatches returns true if the filter matches a null or non existing value
+func nilValueMatches(filter reflect.Value) bool {
+	for filter.Kind() == reflect.Interface || filter.Kind() == reflect.Ptr {
+		if filter.IsNil() {
+			return true
+		}
+		filter = filter.Elem()
+	}
+	if !filter.IsValid() {
+		return true
+	}
+	if filter.Kind() != reflect.Map {
+		return false
+	}
+
+	iter := filter.MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		value := iter.Value()
+		for value.Kind() == reflect.Interface && !value.IsNil() {
+			value = value.Elem()
+		}
+
+		switch key {
+		case "$exists":
+			if value.Kind() != reflect.Bool || value.Bool() {
+				return false
+			}
+		case "$eq":
+			if !nilValueMatches(value) {
+				return false
+			}
+		case "$ne", "$not":
+			if nilValueMatches(value) {
+				return false
+			}
+		case "$in", "$nin":
+			foundOk := false
+			for i := 0; i < value.Len(); i++ {
+				if nilValueMatches(value.Index(i)) {
+					foundOk = true
+					break
+				}
+			}
+			if foundOk != (key == "$in") {
+				return false
+			}
+		case "$type":
+			if value.Kind() == reflect.String && value.String() == "null" {
+				continue
+			}
+			if value.CanInt() && value.Int() == 10 {
+				continue
+			}
+			return false
+		case "$options":
+			// Used by $regex
+		default:
+			if strings.HasPrefix(key, "$") {
+				// All other operators require a value
+				return false
+			}
+			if !nilValueMatches(value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyListOperators evaluates the null and existence checks and the list operators of filter against a non null list
+// It returns the remaining filter or an invalid value if one of the checks failed
+func applyListOperators(filter, list reflect.Value) (reflect.Value, error) {
+	remainder := bson.M{}
+	iter := filter.MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		value := iter.Value()
+		for value.Kind() == reflect.Interface && !value.IsNil() {
+			value = value.Elem()
+		}
+
+		switch {
+		case key == "$exists" && value.Kind() == reflect.Bool:
+			if !value.Bool() {
+				return reflect.Value{}, nil
+			}
+		case key == "$eq" && isNilValue(value):
+			return reflect.Value{}, nil
+		case key == "$ne" && isNilValue(value):
+			// The list is not null
+		case key == "$size":
+			matches, err := listSizeMatches(value, list)
+			if err != nil || !matches {
+				return reflect.Value{}, err
+			}
+		case key == "$all":
+			matches, err := allMatch(value, list)
+			if err != nil || !matches {
+				return reflect.Value{}, err
+			}
+		case key == "$elemMatch":
+			matches, err := elemMatches(value, list)
+			if err != nil || !matches {
+				return reflect.Value{}, err
+			}
+		default:
+			remainder[key] = iter.Value().Interface()
+		}
+	}
+	return reflect.ValueOf(remainder), nil
+}
+
+// splitNegatedFilters splits the negations $ne, $nin, $not and {$exists: false} from a filter
+// The negated filters are returned without the negation, the value should not match any of them
+func splitNegatedFilters(filter reflect.Value) (positive reflect.Value, negated []reflect.Value) {
+	filter = asFilterMap(filter)
+	if filter.Kind() != reflect.Map {
+		return filter, nil
+	}
+
+	positiveMap := bson.M{}
+	iter := filter.MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		value := iter.Value()
+		for value.Kind() == reflect.Interface && !value.IsNil() {
+			value = value.Elem()
+		}
+		value = asFilterMap(value)
+
+		switch {
+		case key == "$ne":
+			if value.Kind() == reflect.Map {
+				negated = append(negated, reflect.ValueOf(bson.M{"$eq": value.Interface()}))
+			} else {
+				negated = append(negated, value)
+			}
+		case key == "$nin":
+			negated = append(negated, reflect.ValueOf(bson.M{"$in": value.Interface()}))
+		case key == "$not":
+			negated = append(negated, value)
+		case key == "$exists" && value.Kind() == reflect.Bool && !value.Bool():
+			negated = append(negated, reflect.ValueOf(bson.M{"$exists": true}))
+		default:
+			positiveMap[key] = iter.Value().Interface()
+		}
+	}
+	if len(negated) == 0 {
+		return filter, nil
+	}
+	return reflect.ValueOf(positiveMap), negated
+}
+
+// asFilterMap converts a bson.D filter into a map, other values are returned as is
+func asFilterMap(filter reflect.Value) reflect.Value {
+	if !filter.IsValid() || filter.Type() != reflect.TypeOf(primitive.D{}) {
+		return filter
+	}
+	filterMap := bson.M{}
+	for _, entry := range filter.Interface().(primitive.D) {
+		filterMap[entry.Key] = entry.Value
+	}
+	return reflect.ValueOf(filterMap)
+}
+
+// logicalFilterEntries returns the filters of a logical operator like $or
+func logicalFilterEntries(filter reflect.Value, operator string) ([]reflect.Value, error) {
+	if filter.Kind() != reflect.Slice && filter.Kind() != reflect.Array {
+		return nil, errors.New(operator + " should have an array as argument")
+	}
+
+	entries := []reflect.Value{}
+	for i := 0; i < filter.Len(); i++ {
+		entry := filter.Index(i)
+		for entry.Kind() == reflect.Interface && !entry.IsNil() {
+			entry = entry.Elem()
+		}
+		entry = asFilterMap(entry)
+		if entry.Kind() != reflect.Map || entry.IsNil() {
+			return nil, errors.New(operator + " should only contain objects")
+		}
+		err := assertMapHasStringKeys(entry.Type())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// onlyOperators returns true if all the keys of the filter are operators like $gt
+func onlyOperators(filter reflect.Value) bool {
+	iter := filter.MapRange()
+	for iter.Next() {
+		if !strings.HasPrefix(iter.Key().String(), "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// isList returns true if the value is a list, object ids are not seen as lists
+func isList(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice:
+		return true
+	case reflect.Array:
+		return value.Type() != objectIDType
+	}
+	return false
+}
+
+// listsEqual returns true if the list and the filter have the same length and all entries match in order
+func listsEqual(filter, list reflect.Value) (bool, error) {
+	if filter.Len() != list.Len() {
+		return false, nil
+	}
+	for i := 0; i < filter.Len(); i++ {
+		matches, err := filterCompare(filter.Index(i), list.Index(i), false)
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// valueInList returns true if the value matches one of the entries of the list argument of $in or $nin
+func valueInList(list, value reflect.Value, operator string) (bool, error) {
+	if !isList(list) {
+		return false, errors.New(operator + " should have an array as argument")
+	}
+	for i := 0; i < list.Len(); i++ {
+		matches, err := filterCompare(list.Index(i), value, false)
+		if err != nil || matches {
+			return matches, err
+		}
+	}
+	return false, nil
+}
+
+// allMatch returns true if every entry of the $all argument matches the value
+func allMatch(filter, value reflect.Value) (bool, error) {
+	if !isList(filter) {
+		return false, errors.New("$all should have an array as argument")
+	}
+	if filter.Len() == 0 {
+		// Like MongoDB an empty $all matches nothing
+		return false, nil
+	}
+	for i := 0; i < filter.Len(); i++ {
+		matches, err := filterCompare(filter.Index(i), value, false)
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// elemMatches returns true if one of the entries of the list matches all the $elemMatch filters
+func elemMatches(filter, list reflect.Value) (bool, error) {
+	filter = asFilterMap(filter)
+	if filter.Kind() != reflect.Map {
+		return false, errors.New("$elemMatch should have an object as argument")
+	}
+	if !isList(list) {
+		return false, nil
+	}
+	for i := 0; i < list.Len(); i++ {
+		entry := list.Index(i)
+		for (entry.Kind() == reflect.Ptr || entry.Kind() == reflect.Interface) && !entry.IsNil() {
+			entry = entry.Elem()
+		}
+		if isNilValue(entry) {
+			if nilValueMatches(filter) {
+				return true, nil
+			}
+			continue
+		}
+		matches, err := filterMatchesValue(filter, entry, entry)
+		if err != nil || matches {
+			return matches, err
+		}
+	}
+	return false, nil
+}
+
+// listSizeMatches returns true if the list has the length of the $size argument
+func listSizeMatches(filter, list reflect.Value) (bool, error) {
+	expectedSize, ok := numberAsFloat(filter)
+	if !ok || expectedSize != float64(int(expectedSize)) {
+		return false, errors.New("$size should have a whole number as argument")
+	}
+	return list.Len() == int(expectedSize), nil
+}
+
+// regexMatches returns true if the value is a string matching the pattern
+// The i, m and s options are supported
+func regexMatches(pattern, options string, value reflect.Value) (bool, error) {
+	for _, option := range options {
+		switch option {
+		case 'i', 'm', 's':
+			pattern = "(?" + string(option) + ")" + pattern
+		default:
+			return false, errors.New("unsupported $regex option " + string(option))
+		}
+	}
+	if value.Kind() != reflect.String {
+		return false, nil
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
 	}
+	return regex.MatchString(value.String()), nil
 }
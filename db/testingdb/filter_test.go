@@ -10,7 +10,15 @@ import (
 )
 
 func FilterMatches(filter bson.M, data any) bool {
-	return newFilter(filter).matches(data)
+	f, err := newFilter(filter)
+	if err != nil {
+		panic(err)
+	}
+	matches, err := f.matches(data)
+	if err != nil {
+		panic(err)
+	}
+	return matches
 }
 
 func TestFilter(t *testing.T) {
@@ -212,6 +220,69 @@ func TestFilter(t *testing.T) {
 			bson.M{"foo.bar": "zzz"},
 			struct{ Foo []exampleNestedField }{[]exampleNestedField{{"aaa"}, {"bbb"}, {"ccc"}}},
 		},
+		{
+			"$in",
+			bson.M{"foo": bson.M{"$in": []any{1, 5}}},
+			bson.M{"foo": bson.M{"$in": []any{1, 2}}},
+			struct{ Foo int }{Foo: 5},
+		},
+		{
+			"$in with a slice value",
+			bson.M{"foo": bson.M{"$in": []string{"a", "c"}}},
+			bson.M{"foo": bson.M{"$in": []string{"d"}}},
+			struct{ Foo []string }{Foo: []string{"b", "c"}},
+		},
+		{
+			"$in with object ids",
+			bson.M{"foo": bson.M{"$in": []primitive.ObjectID{{0x11}}}},
+			bson.M{"foo": bson.M{"$in": []primitive.ObjectID{{0x22}}}},
+			struct{ Foo primitive.ObjectID }{Foo: primitive.ObjectID{0x11}},
+		},
+		{
+			"$exists",
+			bson.M{"foo": bson.M{"$exists": true, "$ne": nil}},
+			bson.M{"bar": bson.M{"$exists": true, "$ne": nil}},
+			struct {
+				Foo *string
+				Bar *string
+			}{Foo: &stringValue},
+		},
+		{
+			"$exists on an empty slice",
+			bson.M{"foo": bson.M{"$exists": true, "$ne": nil}},
+			bson.M{"bar": bson.M{"$exists": true, "$ne": nil}},
+			struct {
+				Foo []string
+				Bar []string
+			}{Foo: []string{}},
+		},
+		{
+			"$ne with a null value",
+			bson.M{"foo": bson.M{"$ne": 2}},
+			bson.M{"foo": bson.M{"$eq": 2}},
+			struct{ Foo *int }{},
+		},
+		{
+			"$regex",
+			bson.M{"foo": bson.M{"$regex": "^ab", "$options": "i"}},
+			bson.M{"foo": bson.M{"$regex": "^ab"}},
+			struct{ Foo string }{Foo: "ABC"},
+		},
+		{
+			"float values",
+			bson.M{"foo": 2.0, "bar": bson.M{"$gt": 1.5}},
+			bson.M{"foo": 2.5},
+			struct {
+				Foo int
+				Bar float64
+			}{Foo: 2, Bar: 2},
+		},
+		{
+			"path into a map",
+			bson.M{"foo.a": "b", "foo.c": nil, "foo.d": bson.M{"$exists": false}},
+			bson.M{"foo.c": bson.M{"$exists": true}},
+			struct{ Foo map[string]any }{Foo: map[string]any{"a": "b"}},
+		},
 	}
 
 	for idx := range scenarios {
@@ -262,3 +333,25 @@ func TestFilterType(t *testing.T) {
 		})
 	}
 }
+
+func TestFilterUnsupported(t *testing.T) {
+	data := struct{ Foo map[string]any }{Foo: map[string]any{"bar": 1}}
+
+	scenarios := []struct {
+		name   string
+		filter bson.M
+	}{
+		{"unknown operator", bson.M{"foo.bar": bson.M{"$between": bson.A{1, 2}}}},
+		{"map with non string keys", bson.M{"foo": map[int]any{1: 1}}},
+		{"unsupported regex option", bson.M{"foo.bar": bson.M{"$regex": "1", "$options": "x"}}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			f, err := newFilter(s.filter)
+			NoError(t, err)
+			_, err = f.matches(data)
+			Error(t, err)
+		})
+	}
+}
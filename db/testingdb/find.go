@@ -24,23 +24,43 @@ func (c *TestConnection) FindOne(placeInto db.Entry, filters bson.M, optionalOpt
 	if !opts.NoDefaultFilters {
 		dbHelpers.MergeFilters(placeInto.DefaultFindFilters(), filters)
 	}
-	itemsFilter := newFilter(queryFilters)
+	itemsFilter, err := newFilter(queryFilters)
+	if err != nil {
+		return err
+	}
 
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	for _, item := range c.getCollectionFromEntry(placeInto).data {
-		if !itemsFilter.matches(item) {
-			continue
+	collection, err := c.getCollectionFromEntry(placeInto)
+	if err != nil {
+		return err
+	}
+
+	items := []db.Entry{}
+	for _, item := range collection.data {
+		matches, err := itemsFilter.matches(item)
+		if err != nil {
+			return err
+		}
+		if matches {
+			items = append(items, item)
 		}
+	}
 
-		// We use elem here to get passed the pointer into the underlaying data
-		placeIntoRefl := reflect.ValueOf(placeInto).Elem()
-		placeIntoRefl.Set(reflect.ValueOf(item).Elem())
-		return nil
+	opts.Limit = 1
+	items, err = applyFindOptions(items, opts)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return mongo.ErrNoDocuments
 	}
 
-	return mongo.ErrNoDocuments
+	// We use elem here to get passed the pointer into the underlaying data
+	placeIntoRefl := reflect.ValueOf(placeInto).Elem()
+	placeIntoRefl.Set(reflect.ValueOf(copyEntry(items[0])).Elem())
+	return nil
 }
 
 // Find finds documents in the collection of the base
@@ -57,7 +77,10 @@ func (c *TestConnection) Find(base db.Entry, results any, filters bson.M, option
 	if !opts.NoDefaultFilters {
 		dbHelpers.MergeFilters(base.DefaultFindFilters(), filters)
 	}
-	itemsFilter := newFilter(queryFilters)
+	itemsFilter, err := newFilter(queryFilters)
+	if err != nil {
+		return err
+	}
 
 	c.m.Lock()
 	defer c.m.Unlock()
@@ -75,12 +98,29 @@ func (c *TestConnection) Find(base db.Entry, results any, filters bson.M, option
 	resultsSliceContentType := resultRefl.Type().Elem()
 	resultIsSliceOfPtrs := resultsSliceContentType.Kind() == reflect.Ptr
 
-	for _, item := range c.getCollectionFromEntry(base).data {
-		if !itemsFilter.matches(item) {
-			continue
+	collection, err := c.getCollectionFromEntry(base)
+	if err != nil {
+		return err
+	}
+
+	items := []db.Entry{}
+	for _, item := range collection.data {
+		matches, err := itemsFilter.matches(item)
+		if err != nil {
+			return err
+		}
+		if matches {
+			items = append(items, item)
 		}
+	}
+
+	items, err = applyFindOptions(items, opts)
+	if err != nil {
+		return err
+	}
 
-		itemRefl := reflect.ValueOf(item)
+	for _, item := range items {
+		itemRefl := reflect.ValueOf(copyEntry(item))
 		if resultIsSliceOfPtrs {
 			resultRefl = reflect.Append(resultRefl, itemRefl)
 		} else {
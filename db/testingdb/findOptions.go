@@ -0,0 +1,231 @@
+package testingdb
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// applyFindOptions sorts, paginates and projects the found items like MongoDB would
+func applyFindOptions(items []db.Entry, opts db.FindOptions) ([]db.Entry, error) {
+	if len(opts.Sort) > 0 {
+		sort.SliceStable(items, func(i, j int) bool {
+			a := reflect.ValueOf(items[i])
+			b := reflect.ValueOf(items[j])
+			for _, sortField := range opts.Sort {
+				res := compareValues(valueAtPath(a, sortField.Key), valueAtPath(b, sortField.Key))
+				if res == 0 {
+					continue
+				}
+				if direction, ok := numberAsFloat(reflect.ValueOf(sortField.Value)); ok && direction < 0 {
+					return res > 0
+				}
+				return res < 0
+			}
+			return false
+		})
+	}
+
+	if opts.Skip > 0 {
+		if opts.Skip >= int64(len(items)) {
+			return []db.Entry{}, nil
+		}
+		items = items[opts.Skip:]
+	}
+	if opts.Limit > 0 && opts.Limit < int64(len(items)) {
+		items = items[:opts.Limit]
+	}
+
+	if len(opts.Projection) > 0 {
+		projected := make([]db.Entry, len(items))
+		for idx, item := range items {
+			var err error
+			projected[idx], err = projectEntry(item, opts.Projection)
+			if err != nil {
+				return nil, err
+			}
+		}
+		items = projected
+	}
+
+	return items, nil
+}
+
+// projectEntry returns a copy of the entry that only contains the fields of the projection
+// Projections on nested fields include or exclude the whole top level field
+func projectEntry(entry db.Entry, projection map[string]any) (db.Entry, error) {
+	include := map[string]bool{}
+	includeID := true
+	inclusionMode := false
+	exclusionMode := false
+	for key, value := range projection {
+		fieldIncluded := true
+		switch typedValue := value.(type) {
+		case bool:
+			fieldIncluded = typedValue
+		default:
+			number, ok := numberAsFloat(reflect.ValueOf(value))
+			if !ok {
+				return nil, errors.New("projection values must be booleans or numbers")
+			}
+			fieldIncluded = number != 0
+		}
+
+		if key == "_id" {
+			includeID = fieldIncluded
+			continue
+		}
+		if fieldIncluded {
+			inclusionMode = true
+		} else {
+			exclusionMode = true
+		}
+		include[strings.SplitN(key, ".", 2)[0]] = fieldIncluded
+	}
+	if inclusionMode && exclusionMode {
+		return nil, errors.New("projection cannot contain both included and excluded fields")
+	}
+
+	source := reflect.ValueOf(entry).Elem()
+	result := reflect.New(source.Type())
+	if !inclusionMode {
+		// Start with a full copy and remove the excluded fields
+		result.Elem().Set(source)
+	}
+
+	fields, _ := mapStruct(source.Type())
+	for dbName, field := range fields {
+		var copyField bool
+		if dbName == "_id" {
+			copyField = includeID
+		} else if inclusionMode {
+			copyField = include[dbName]
+		} else {
+			fieldIncluded, ok := include[dbName]
+			copyField = !ok || fieldIncluded
+		}
+
+		sourceField := structFieldValue(source, field)
+		resultField := structFieldValue(result.Elem(), field)
+		if copyField {
+			resultField.Set(sourceField)
+		} else {
+			resultField.Set(reflect.Zero(resultField.Type()))
+		}
+	}
+
+	return result.Interface().(db.Entry), nil
+}
+
+func structFieldValue(value reflect.Value, field structField) reflect.Value {
+	for _, goPathPart := range field.GoPathToField {
+		value = value.FieldByName(goPathPart)
+	}
+	return value.FieldByName(field.GoFieldName)
+}
+
+// valueAtPath returns the value at a dotted database path
+// An invalid value is returned if the path does not exist
+func valueAtPath(value reflect.Value, path string) reflect.Value {
+	for _, part := range strings.Split(path, ".") {
+		for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+			if value.IsNil() {
+				return reflect.Value{}
+			}
+			value = value.Elem()
+		}
+
+		switch value.Kind() {
+		case reflect.Struct:
+			fields, _ := mapStruct(value.Type())
+			field, ok := fields[part]
+			if !ok {
+				return reflect.Value{}
+			}
+			value = structFieldValue(value, field)
+		case reflect.Map:
+			if value.Type().Key().Kind() != reflect.String {
+				return reflect.Value{}
+			}
+			value = value.MapIndex(reflect.ValueOf(part).Convert(value.Type().Key()))
+			if !value.IsValid() {
+				return value
+			}
+		default:
+			return reflect.Value{}
+		}
+	}
+	return value
+}
+
+// compareValues compares 2 values for sorting, null values are sorted before all other values
+func compareValues(a, b reflect.Value) int {
+	for a.IsValid() && (a.Kind() == reflect.Ptr || a.Kind() == reflect.Interface) && !a.IsNil() {
+		a = a.Elem()
+	}
+	for b.IsValid() && (b.Kind() == reflect.Ptr || b.Kind() == reflect.Interface) && !b.IsNil() {
+		b = b.Elem()
+	}
+
+	aIsNil := isNilValue(a)
+	bIsNil := isNilValue(b)
+	switch {
+	case aIsNil && bIsNil:
+		return 0
+	case aIsNil:
+		return -1
+	case bIsNil:
+		return 1
+	}
+
+	if aNumber, ok := numberAsFloat(a); ok {
+		bNumber, ok := numberAsFloat(b)
+		if !ok {
+			return 0
+		}
+		switch {
+		case aNumber < bNumber:
+			return -1
+		case aNumber > bNumber:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	switch aValue := a.Interface().(type) {
+	case string:
+		if b.Kind() == reflect.String {
+			return strings.Compare(aValue, b.String())
+		}
+	case bool:
+		if b.Kind() == reflect.Bool && aValue != b.Bool() {
+			if aValue {
+				return 1
+			}
+			return -1
+		}
+	case time.Time:
+		if bValue, ok := b.Interface().(time.Time); ok {
+			switch {
+			case aValue.Before(bValue):
+				return -1
+			case aValue.After(bValue):
+				return 1
+			default:
+				return 0
+			}
+		}
+	case primitive.ObjectID:
+		if bValue, ok := b.Interface().(primitive.ObjectID); ok {
+			return bytes.Compare(aValue[:], bValue[:])
+		}
+	}
+	return 0
+}
@@ -3,12 +3,14 @@ package testingdb
 import (
 	"testing"
 
+	"github.com/script-development/RT-CV/db"
+
 	. "github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 func TestFindOneWithoutFilters(t *testing.T) {
-	testDB := NewDB()
+	testDB := newTestDB(t)
 
 	mockData := NewMockuser()
 	Equal(t, "users", mockData.CollectionName())
@@ -23,7 +25,7 @@ func TestFindOneWithoutFilters(t *testing.T) {
 }
 
 func TestFindWithoutFilters(t *testing.T) {
-	testDB := NewDB()
+	testDB := newTestDB(t)
 
 	mockData := NewMockuser()
 	Equal(t, "users", mockData.CollectionName())
@@ -43,3 +45,88 @@ func TestFindWithoutFilters(t *testing.T) {
 	Len(t, foundResultsPtrs, 1)
 	Equal(t, mockData.ID, foundResultsPtrs[0].ID)
 }
+
+func TestFindWithOptions(t *testing.T) {
+	testDB := newTestDB(t)
+
+	for _, username := range []string{"b", "c", "a", "d"} {
+		user := NewMockuser()
+		user.Username = username
+		realname := "real " + username
+		user.Realname = &realname
+		err := testDB.Insert(user)
+		NoError(t, err)
+	}
+
+	usernames := func(users []MockUser) []string {
+		res := []string{}
+		for _, user := range users {
+			res = append(res, user.Username)
+		}
+		return res
+	}
+
+	scenarios := []struct {
+		name   string
+		opts   db.FindOptions
+		expect []string
+	}{
+		{"sort ascending", db.FindOptions{Sort: bson.D{{Key: "username", Value: 1}}}, []string{"a", "b", "c", "d"}},
+		{"sort descending", db.FindOptions{Sort: bson.D{{Key: "username", Value: -1}}}, []string{"d", "c", "b", "a"}},
+		{"limit", db.FindOptions{Sort: bson.D{{Key: "username", Value: 1}}, Limit: 2}, []string{"a", "b"}},
+		{"skip", db.FindOptions{Sort: bson.D{{Key: "username", Value: 1}}, Skip: 1, Limit: 2}, []string{"b", "c"}},
+		{"skip past end", db.FindOptions{Skip: 10}, []string{}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			results := []MockUser{}
+			err := testDB.Find(&MockUser{}, &results, nil, s.opts)
+			NoError(t, err)
+			Equal(t, s.expect, usernames(results))
+		})
+	}
+
+	t.Run("find one", func(t *testing.T) {
+		result := MockUser{}
+		err := testDB.FindOne(&result, nil, db.FindOptions{Sort: bson.D{{Key: "username", Value: -1}}})
+		NoError(t, err)
+		Equal(t, "d", result.Username)
+	})
+
+	t.Run("projection include", func(t *testing.T) {
+		results := []MockUser{}
+		err := testDB.Find(&MockUser{}, &results, nil, db.FindOptions{Projection: bson.M{"username": 1}, Limit: 1})
+		NoError(t, err)
+		Len(t, results, 1)
+		NotEmpty(t, results[0].Username)
+		False(t, results[0].ID.IsZero())
+		Nil(t, results[0].Realname)
+	})
+
+	t.Run("projection exclude", func(t *testing.T) {
+		results := []MockUser{}
+		err := testDB.Find(&MockUser{}, &results, nil, db.FindOptions{Projection: bson.M{"username": 0, "_id": 0}, Limit: 1})
+		NoError(t, err)
+		Len(t, results, 1)
+		Empty(t, results[0].Username)
+		True(t, results[0].ID.IsZero())
+		NotNil(t, results[0].Realname)
+	})
+
+	t.Run("projection does not modify the stored data", func(t *testing.T) {
+		results := []MockUser{}
+		err := testDB.Find(&MockUser{}, &results, nil)
+		NoError(t, err)
+		for _, result := range results {
+			NotEmpty(t, result.Username)
+			NotNil(t, result.Realname)
+		}
+	})
+
+	t.Run("mixed projection", func(t *testing.T) {
+		results := []MockUser{}
+		err := testDB.Find(&MockUser{}, &results, nil, db.FindOptions{Projection: bson.M{"username": 0, "real_name": 1}})
+		Error(t, err)
+	})
+}
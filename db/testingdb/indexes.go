@@ -0,0 +1,131 @@
+package testingdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// uniqueIndex is a unique index of a collection
+type uniqueIndex struct {
+	name   string
+	keys   []string
+	sparse bool
+	// partial limits the index to the entries matching the partialFilterExpression, nil if the index contains all entries
+	partial *filter
+}
+
+// uniqueIndexesOf returns the unique indexes from the Indexes() declaration of the entry
+// Other indexes are ignored as they only make queries faster
+func uniqueIndexesOf(entry db.Entry) []uniqueIndex {
+	indexes := []uniqueIndex{}
+	for _, index := range entry.Indexes() {
+		if index.Options == nil || index.Options.Unique == nil || !*index.Options.Unique {
+			continue
+		}
+
+		keys := []string{}
+		switch typedKeys := index.Keys.(type) {
+		case bson.D:
+			for _, key := range typedKeys {
+				keys = append(keys, key.Key)
+			}
+		case bson.M:
+			for key := range typedKeys {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+		case map[string]any:
+			for key := range typedKeys {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+		default:
+			panic(fmt.Sprintf("unsupported index keys type %T", index.Keys))
+		}
+
+		name := strings.Join(keys, "_")
+		if index.Options.Name != nil {
+			name = *index.Options.Name
+		}
+		var partial *filter
+		if index.Options.PartialFilterExpression != nil {
+			partialFilter, ok := index.Options.PartialFilterExpression.(bson.M)
+			if !ok {
+				panic(fmt.Sprintf("unsupported partial filter expression type %T", index.Options.PartialFilterExpression))
+			}
+			var err error
+			partial, err = newFilter(partialFilter)
+			if err != nil {
+				panic(fmt.Sprintf("invalid partial filter expression of index %s: %s", name, err.Error()))
+			}
+		}
+
+		indexes = append(indexes, uniqueIndex{
+			name:    name,
+			keys:    keys,
+			sparse:  index.Options.Sparse != nil && *index.Options.Sparse,
+			partial: partial,
+		})
+	}
+	return indexes
+}
+
+// checkUniqueIndexes returns a duplicate key error if the collection violates one of its unique indexes
+// Like in MongoDB a missing field is seen as null, arrays are compared as a whole
+func (c *TestConnection) checkUniqueIndexes(collection Collection) error {
+	for _, index := range c.uniqueIndexes[collection.name] {
+		seen := make(map[string]bool, len(collection.data))
+		for _, entry := range collection.data {
+			if index.partial != nil {
+				matches, err := index.partial.matches(entry)
+				if err != nil {
+					return err
+				}
+				if !matches {
+					continue
+				}
+			}
+
+			entryValue := reflect.ValueOf(entry)
+			values := make([]any, len(index.keys))
+			allNil := true
+			for idx, key := range index.keys {
+				value := valueAtPath(entryValue, key)
+				for value.IsValid() && (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) && !value.IsNil() {
+					value = value.Elem()
+				}
+				if !value.IsValid() || isNilValue(value) {
+					continue
+				}
+				values[idx] = value.Interface()
+				allNil = false
+			}
+			if allNil && index.sparse {
+				continue
+			}
+
+			key := fmt.Sprintf("%#v", values)
+			if seen[key] {
+				return duplicateKeyError(collection.name, index, values)
+			}
+			seen[key] = true
+		}
+	}
+	return nil
+}
+
+// duplicateKeyError returns an error that is recognized by mongo.IsDuplicateKeyError
+func duplicateKeyError(collectionName string, index uniqueIndex, values []any) error {
+	return mongo.WriteException{
+		WriteErrors: mongo.WriteErrors{{
+			Code:    11000,
+			Message: fmt.Sprintf("E11000 duplicate key error collection: %s index: %s dup key: %v", collectionName, index.name, values),
+		}},
+	}
+}
@@ -9,9 +9,14 @@ import (
 // Implements db.Connection
 func (c *TestConnection) Insert(data ...db.Entry) error {
 	c.m.Lock()
-	defer c.m.Unlock()
+	err := c.UnsafeInsert(data...)
+	c.m.Unlock()
+	if err != nil {
+		return err
+	}
 
-	return c.UnsafeInsert(data...)
+	c.notify(entryChanges(db.ChangeInsert, data...)...)
+	return nil
 }
 
 // UnsafeInsert inserts data directly into the database
@@ -22,14 +27,29 @@ func (c *TestConnection) UnsafeInsert(entries ...db.Entry) error {
 	if len(entries) == 0 {
 		return nil
 	}
-	for _, entry := range entries {
+	copiedEntries := make([]db.Entry, len(entries))
+	for idx, entry := range entries {
 		if entry.GetID().IsZero() {
 			entry.SetID(primitive.NewObjectID())
 		}
+		copiedEntries[idx] = copyEntry(entry)
 	}
 
-	collection := c.getCollectionFromEntry(entries[0])
-	collection.data = append(collection.data, entries...)
-	c.setCollection(collection)
-	return nil
+	collection, err := c.getCollectionFromEntry(entries[0])
+	if err != nil {
+		return err
+	}
+	// Copy the data so a failed write does not modify the data of the collection
+	collection.data = append(append(make([]db.Entry, 0, len(collection.data)+len(entries)), collection.data...), copiedEntries...)
+	return c.setCollection(collection)
+}
+
+// InsertMany inserts a slice of entries into the database
+// Implements db.Connection
+func (c *TestConnection) InsertMany(entries any) error {
+	parsedEntries, err := db.EntriesOf(entries)
+	if err != nil {
+		return err
+	}
+	return c.Insert(parsedEntries...)
 }
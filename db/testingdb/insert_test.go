@@ -7,7 +7,7 @@ import (
 )
 
 func TestInsert(t *testing.T) {
-	testDB := NewDB()
+	testDB := newTestDB(t)
 
 	mockData := NewMockuser()
 	Equal(t, "users", mockData.CollectionName())
@@ -23,3 +23,27 @@ func TestInsert(t *testing.T) {
 
 	NoError(t, err)
 }
+
+func TestInsertMany(t *testing.T) {
+	testDB := newTestDB(t)
+
+	users := []MockUser{*NewMockuser(), {Username: "Jan"}}
+	err := testDB.InsertMany(users)
+	NoError(t, err)
+	False(t, users[1].ID.IsZero(), "new ids should be visible to the caller")
+
+	count, err := testDB.Count(&MockUser{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(2), count)
+
+	err = testDB.InsertMany([]*MockUser{NewMockuser()})
+	NoError(t, err)
+	count, err = testDB.Count(&MockUser{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(3), count)
+
+	err = testDB.InsertMany([]string{"not an entry"})
+	Error(t, err)
+	err = testDB.InsertMany(NewMockuser())
+	Error(t, err)
+}
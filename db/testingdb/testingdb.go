@@ -1,8 +1,11 @@
 package testingdb
 
 import (
+	"errors"
+	"reflect"
 	"sync"
 
+	"github.com/apex/log"
 	"github.com/script-development/RT-CV/db"
 )
 
@@ -10,15 +13,61 @@ import (
 type TestConnection struct {
 	m           sync.Mutex
 	collections map[string]Collection
+
+	// store persists the collections, if nil the data only lives in memory
+	store Store
+	// uniqueIndexes contains the unique indexes of the registered entries by collection name
+	uniqueIndexes map[string][]uniqueIndex
+
+	// versions counts the writes made to every collection, it is used to detect writes made outside of a transaction while it runs
+	versions map[string]uint64
+	// startVersions is only set for transactions and contains the versions of the collections when the transaction started
+	startVersions map[string]uint64
+	// changedCollections is only set for transactions and contains the names of the collections modified by the transaction
+	changedCollections map[string]bool
+	// pendingChanges contains the changes made by a transaction that are send to the watchers on commit
+	pendingChanges []db.Change
+
+	watchersLock sync.Mutex
+	watchers     []*watcher
+}
+
+// ErrWriteConflict is returned when committing a transaction that modified a collection that was also modified outside of the transaction while it ran
+var ErrWriteConflict = errors.New("write conflict, a collection modified by the transaction was modified outside of the transaction")
+
+// Store persists the collections of a TestConnection
+type Store interface {
+	// Load returns the stored entries of a collection
+	// newEntry returns an empty entry of the collection to decode a stored entry into
+	Load(collection string, newEntry func() db.Entry) ([]db.Entry, error)
+
+	// Save replaces the stored entries of the collections
+	// Either all collections are saved or none of them, a transaction that modified multiple collections is saved at once
+	Save(collections map[string][]db.Entry) error
 }
 
 // NewDB returns a testing database connection that is compatible with db.Connection
 func NewDB() *TestConnection {
 	return &TestConnection{
-		collections: map[string]Collection{},
+		collections:   map[string]Collection{},
+		uniqueIndexes: map[string][]uniqueIndex{},
+		versions:      map[string]uint64{},
 	}
 }
 
+// NewDBWithStore returns a database connection like NewDB that keeps its data in the store
+// Collections are loaded from the store when they are first used and saved to the store on every write
+func NewDBWithStore(store Store) *TestConnection {
+	c := NewDB()
+	c.store = store
+	return c
+}
+
+// Store returns the store of the connection, nil if the data only lives in memory
+func (c *TestConnection) Store() Store {
+	return c.store
+}
+
 // Collection contains all the data for a collection
 type Collection struct {
 	name string
@@ -26,22 +75,77 @@ type Collection struct {
 }
 
 // RegisterEntries implements db.Connection
-// We don't need to implement this function
-func (*TestConnection) RegisterEntries(...db.Entry) {}
+// Loads the entries from the store and sets up the unique indexes of the entries
+func (c *TestConnection) RegisterEntries(entries ...db.Entry) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, entry := range entries {
+		collection, err := c.getCollectionFromEntry(entry)
+		if err != nil {
+			log.WithError(err).Fatalf("unable to load the %s collection", entry.CollectionName())
+		}
 
-func (c *TestConnection) getCollectionFromEntry(e db.Entry) Collection {
+		indexes := uniqueIndexesOf(entry)
+		if len(indexes) == 0 {
+			continue
+		}
+		c.uniqueIndexes[collection.name] = indexes
+		err = c.checkUniqueIndexes(collection)
+		if err != nil {
+			log.WithError(err).Fatalf("unable to create the indexes of the %s collection", collection.name)
+		}
+	}
+}
+
+func (c *TestConnection) getCollectionFromEntry(e db.Entry) (Collection, error) {
 	collectionName := e.CollectionName()
 	v, ok := c.collections[collectionName]
 	if ok {
-		return v
+		return v, nil
 	}
 
-	return Collection{
+	collection := Collection{
 		name: collectionName,
 		data: []db.Entry{},
 	}
+	if c.store == nil {
+		return collection, nil
+	}
+
+	entryType := reflect.TypeOf(e).Elem()
+	data, err := c.store.Load(collectionName, func() db.Entry {
+		return reflect.New(entryType).Interface().(db.Entry)
+	})
+	if err != nil {
+		return collection, err
+	}
+	collection.data = append(collection.data, data...)
+
+	// Cache the loaded collection without marking it as changed
+	c.collections[collectionName] = collection
+	return collection, nil
 }
 
-func (c *TestConnection) setCollection(collection Collection) {
+func (c *TestConnection) setCollection(collection Collection) error {
+	err := c.checkUniqueIndexes(collection)
+	if err != nil {
+		return err
+	}
+
+	if c.changedCollections != nil {
+		// Transactions are saved to the store on commit
+		c.changedCollections[collection.name] = true
+	} else if c.store != nil {
+		err = c.store.Save(map[string][]db.Entry{collection.name: collection.data})
+		if err != nil {
+			return err
+		}
+	}
+
 	c.collections[collection.name] = collection
+	if c.changedCollections == nil {
+		c.versions[collection.name]++
+	}
+	return nil
 }
@@ -1,12 +1,40 @@
 package testingdb
 
 import (
+	"os"
 	"testing"
 
 	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/filedb"
 	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// useFileStore is set by TestMain to run the tests a second time with the data stored in files
+var useFileStore bool
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code == 0 {
+		useFileStore = true
+		code = m.Run()
+	}
+	os.Exit(code)
+}
+
+// newTestDB returns a new database, when running the tests with the file store the data is stored in a temp directory
+func newTestDB(t *testing.T) *TestConnection {
+	if !useFileStore {
+		return NewDB()
+	}
+
+	store, err := filedb.NewStore(t.TempDir())
+	NoError(t, err)
+	return NewDBWithStore(store)
+}
+
 type MockUser struct {
 	db.M     `bson:",inline"`
 	Realname *string `bson:"real_name,omitempty"`
@@ -39,3 +67,68 @@ func TestNewDB(t *testing.T) {
 	testDB := NewDB()
 	NotNil(t, testDB)
 }
+
+type mockAccount struct {
+	db.M  `bson:",inline"`
+	Email *string `bson:"email"`
+	Login any     `bson:"login"`
+}
+
+func (*mockAccount) CollectionName() string {
+	return "accounts"
+}
+
+func (*mockAccount) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.M{"email": 1}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.M{"login": 1}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"login": bson.M{"$type": "string"}})},
+	}
+}
+
+func TestUniqueIndexes(t *testing.T) {
+	testDB := newTestDB(t)
+	testDB.RegisterEntries(&mockAccount{})
+
+	email := "piet@example.com"
+	otherEmail := "jan@example.com"
+	piet := &mockAccount{M: db.NewM(), Email: &email}
+	err := testDB.Insert(piet)
+	NoError(t, err)
+
+	err = testDB.Insert(&mockAccount{M: db.NewM(), Email: &email})
+	True(t, mongo.IsDuplicateKeyError(err))
+
+	// Sparse indexes allow multiple entries without the field
+	err = testDB.Insert(&mockAccount{M: db.NewM()}, &mockAccount{M: db.NewM()})
+	NoError(t, err)
+
+	jan := &mockAccount{M: db.NewM(), Email: &otherEmail}
+	err = testDB.Insert(jan)
+	NoError(t, err)
+	_, err = testDB.UpdateMany(&mockAccount{}, bson.M{"_id": jan.ID}, bson.M{"$set": bson.M{"email": email}})
+	True(t, mongo.IsDuplicateKeyError(err))
+	err = testDB.UpdateByID(&mockAccount{M: jan.M, Email: &email})
+	True(t, mongo.IsDuplicateKeyError(err))
+
+	// Failed writes should not change the data
+	count, err := testDB.Count(&mockAccount{}, bson.M{"email": email})
+	NoError(t, err)
+	Equal(t, uint64(1), count)
+	count, err = testDB.Count(&mockAccount{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(4), count)
+
+	// The entry using the value can be updated to release it
+	err = testDB.DeleteByID(&mockAccount{}, piet.ID)
+	NoError(t, err)
+	err = testDB.UpdateByID(&mockAccount{M: jan.M, Email: &email})
+	NoError(t, err)
+
+	// Partial indexes only contain the entries matching the partial filter expression
+	err = testDB.Insert(&mockAccount{M: db.NewM(), Login: 1}, &mockAccount{M: db.NewM(), Login: 1})
+	NoError(t, err)
+	err = testDB.Insert(&mockAccount{M: db.NewM(), Login: "piet"})
+	NoError(t, err)
+	err = testDB.Insert(&mockAccount{M: db.NewM(), Login: "piet"})
+	True(t, mongo.IsDuplicateKeyError(err))
+}
@@ -0,0 +1,82 @@
+package testingdb
+
+import "github.com/script-development/RT-CV/db"
+
+// WithTransaction executes fn inside of a transaction
+// The transaction works on a copy of the collections, when fn succeeds the collections modified by the transaction replace the collections of c
+// If one of these collections was modified outside of the transaction while it ran nothing is committed and ErrWriteConflict is returned
+// If the connection has a store the changed collections are saved at once on commit
+func (c *TestConnection) WithTransaction(fn func(tx db.Connection) error) error {
+	if c.changedCollections != nil {
+		// We are already inside of a transaction
+		return fn(c)
+	}
+
+	c.m.Lock()
+	tx := &TestConnection{
+		collections:        make(map[string]Collection, len(c.collections)),
+		store:              c.store,
+		uniqueIndexes:      c.uniqueIndexes,
+		startVersions:      make(map[string]uint64, len(c.versions)),
+		changedCollections: map[string]bool{},
+	}
+	for name, version := range c.versions {
+		tx.startVersions[name] = version
+	}
+	for name, collection := range c.collections {
+		// Copy the data slices so modifications in the transaction are not visible outside of it
+		// The entries themselves are not copied as they are copied when they enter or leave the database and writes replace them
+		tx.collections[name] = Collection{
+			name: collection.name,
+			data: append([]db.Entry{}, collection.data...),
+		}
+	}
+	c.m.Unlock()
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	tx.m.Lock()
+	err = c.commit(tx)
+	tx.m.Unlock()
+	c.m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tx.watchersLock.Lock()
+	changes := tx.pendingChanges
+	tx.watchersLock.Unlock()
+	c.notify(changes...)
+	return nil
+}
+
+// commit replaces the collections of c with the collections modified by the transaction tx
+// Not thread safe
+func (c *TestConnection) commit(tx *TestConnection) error {
+	for name := range tx.changedCollections {
+		if c.versions[name] != tx.startVersions[name] {
+			return ErrWriteConflict
+		}
+	}
+
+	if c.store != nil && len(tx.changedCollections) > 0 {
+		changes := make(map[string][]db.Entry, len(tx.changedCollections))
+		for name := range tx.changedCollections {
+			changes[name] = tx.collections[name].data
+		}
+		err := c.store.Save(changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name := range tx.changedCollections {
+		c.collections[name] = tx.collections[name]
+		c.versions[name]++
+	}
+	return nil
+}
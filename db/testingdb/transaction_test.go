@@ -0,0 +1,113 @@
+package testingdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestWithTransaction(t *testing.T) {
+	testDB := newTestDB(t)
+
+	existingUser := NewMockuser()
+	err := testDB.Insert(existingUser)
+	NoError(t, err)
+
+	count := func(conn db.Connection) uint64 {
+		count, err := conn.Count(&MockUser{}, nil)
+		NoError(t, err)
+		return count
+	}
+
+	t.Run("rollback", func(t *testing.T) {
+		err := testDB.WithTransaction(func(tx db.Connection) error {
+			err := tx.Insert(NewMockuser())
+			NoError(t, err)
+			err = tx.DeleteByID(&MockUser{}, existingUser.ID)
+			NoError(t, err)
+
+			// The writes are visible inside of the transaction but not outside of it
+			Equal(t, uint64(1), count(tx))
+			err = tx.FindOne(&MockUser{}, bson.M{"_id": existingUser.ID})
+			Error(t, err)
+			Equal(t, uint64(1), count(testDB))
+
+			return errors.New("something went wrong")
+		})
+		EqualError(t, err, "something went wrong")
+
+		Equal(t, uint64(1), count(testDB))
+		err = testDB.FindOne(&MockUser{}, bson.M{"_id": existingUser.ID})
+		NoError(t, err)
+	})
+
+	t.Run("rollback modifications made through find results", func(t *testing.T) {
+		err := testDB.WithTransaction(func(tx db.Connection) error {
+			users := []*MockUser{}
+			err := tx.Find(&MockUser{}, &users, nil)
+			NoError(t, err)
+			for _, user := range users {
+				user.Username = "Modified"
+				err = tx.UpdateByID(user)
+				NoError(t, err)
+			}
+			return errors.New("rollback")
+		})
+		EqualError(t, err, "rollback")
+
+		result := MockUser{}
+		err = testDB.FindOne(&result, bson.M{"_id": existingUser.ID})
+		NoError(t, err)
+		Equal(t, existingUser.Username, result.Username)
+	})
+
+	t.Run("conflicting write outside of the transaction", func(t *testing.T) {
+		outsideUser := NewMockuser()
+		err := testDB.WithTransaction(func(tx db.Connection) error {
+			err := tx.Insert(NewMockuser())
+			NoError(t, err)
+			return testDB.Insert(outsideUser)
+		})
+		ErrorIs(t, err, ErrWriteConflict)
+
+		// The write made outside of the transaction is kept and the write of the transaction is dropped
+		Equal(t, uint64(2), count(testDB))
+		err = testDB.DeleteByID(&MockUser{}, outsideUser.ID)
+		NoError(t, err)
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		newUser := NewMockuser()
+		err := testDB.WithTransaction(func(tx db.Connection) error {
+			err := tx.Insert(newUser)
+			if err != nil {
+				return err
+			}
+
+			// Nested transactions are part of the outer transaction
+			return tx.WithTransaction(func(nestedTx db.Connection) error {
+				_, err := nestedTx.UpdateMany(&MockUser{}, bson.M{"_id": existingUser.ID}, bson.M{"$set": bson.M{"username": "Jan"}})
+				return err
+			})
+		})
+		NoError(t, err)
+
+		Equal(t, uint64(2), count(testDB))
+		result := MockUser{}
+		err = testDB.FindOne(&result, bson.M{"_id": existingUser.ID})
+		NoError(t, err)
+		Equal(t, "Jan", result.Username)
+	})
+
+	t.Run("with tenant", func(t *testing.T) {
+		tenantConn := db.WithTenant(testDB, existingUser.ID)
+		err := tenantConn.WithTransaction(func(tx db.Connection) error {
+			NotNil(t, db.TenantOf(tx))
+			return errors.New("rollback")
+		})
+		Error(t, err)
+	})
+}
@@ -1,22 +1,231 @@
 package testingdb
 
-import "github.com/script-development/RT-CV/db"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
 
 // UpdateByID updates a document in the database by its ID
 func (c *TestConnection) UpdateByID(updateData db.Entry) error {
 	c.m.Lock()
-	defer c.m.Unlock()
+	updated, err := c.unsafeUpdateByID(updateData)
+	c.m.Unlock()
+	if err != nil {
+		return err
+	}
+	if updated {
+		c.notify(entryChanges(db.ChangeUpdate, updateData)...)
+	}
+	return nil
+}
 
+// unsafeUpdateByID replaces the document with the id of updateData and returns if a document was replaced
+// Not thread safe
+func (c *TestConnection) unsafeUpdateByID(updateData db.Entry) (bool, error) {
 	updateDataID := updateData.GetID()
-	collection := c.getCollectionFromEntry(updateData)
+	collection, err := c.getCollectionFromEntry(updateData)
+	if err != nil {
+		return false, err
+	}
 
 	for i, entry := range collection.data {
 		if entry.GetID() == updateDataID {
-			collection.data[i] = updateData
-			c.setCollection(collection)
-			break
+			// Copy the data so a failed write does not modify the data of the collection
+			collection.data = append([]db.Entry{}, collection.data...)
+			collection.data[i] = copyEntry(updateData)
+			return true, c.setCollection(collection)
+		}
+	}
+	return false, nil
+}
+
+// UpdateMany applies the update to all documents matching the filter
+// Only the $set and $unset update operators are supported
+func (c *TestConnection) UpdateMany(entry db.Entry, filter bson.M, update bson.M) (uint64, error) {
+	for operator := range update {
+		if operator != "$set" && operator != "$unset" {
+			return 0, fmt.Errorf("unsupported update operator %s", operator)
+		}
+	}
+
+	c.m.Lock()
+	updatedEntries, err := c.unsafeUpdateMany(entry, filter, update)
+	c.m.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	c.notify(entryChanges(db.ChangeUpdate, updatedEntries...)...)
+	return uint64(len(updatedEntries)), nil
+}
+
+// unsafeUpdateMany applies the update to all documents matching the filter and returns the updated documents
+// Not thread safe
+func (c *TestConnection) unsafeUpdateMany(entry db.Entry, filter bson.M, update bson.M) ([]db.Entry, error) {
+	itemsFilter, err := newFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := c.getCollectionFromEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the data so a failed write does not modify the data of the collection
+	collection.data = append([]db.Entry{}, collection.data...)
+
+	updatedEntries := []db.Entry{}
+	for i, item := range collection.data {
+		matches, err := itemsFilter.matches(item)
+		if err != nil {
+			return nil, err
+		}
+		if !matches {
+			continue
+		}
+
+		// Copy the entry so the update is not visible to others who have a reference to the entry
+		// like the caller of Find or a transaction snapshot
+		updatedItem := reflect.ValueOf(copyEntry(item))
+
+		for operator, fields := range update {
+			var fieldsMap map[string]any
+			switch typedFields := fields.(type) {
+			case bson.M:
+				fieldsMap = typedFields
+			case map[string]any:
+				fieldsMap = typedFields
+			default:
+				return nil, fmt.Errorf("%s must be an object", operator)
+			}
+			for path, value := range fieldsMap {
+				var err error
+				if operator == "$unset" {
+					err = setValueAtPath(updatedItem.Elem(), path, nil)
+				} else {
+					err = setValueAtPath(updatedItem.Elem(), path, value)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
+
+		collection.data[i] = updatedItem.Interface().(db.Entry)
+		updatedEntries = append(updatedEntries, collection.data[i])
+	}
+
+	if len(updatedEntries) == 0 {
+		return updatedEntries, nil
 	}
+	return updatedEntries, c.setCollection(collection)
+}
+
+// setValueAtPath sets the value at a dotted database path, a nil value sets the zero value
+// Maps on the path are copied before they are modified
+func setValueAtPath(value reflect.Value, path string, newValue any) error {
+	if path == "_id" {
+		return errors.New("the _id field cannot be updated")
+	}
+
+	parts := strings.Split(path, ".")
+	for idx, part := range parts {
+		isLast := idx == len(parts)-1
 
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() && newValue == nil {
+				// Nothing to unset
+				return nil
+			}
+
+			// Copy the value the pointer points to so the original stays untouched
+			copiedValue := reflect.New(value.Type().Elem())
+			if !value.IsNil() {
+				copiedValue.Elem().Set(value.Elem())
+			}
+			value.Set(copiedValue)
+			value = copiedValue.Elem()
+		}
+
+		switch value.Kind() {
+		case reflect.Struct:
+			fields, _ := mapStruct(value.Type())
+			field, ok := fields[part]
+			if !ok {
+				if newValue == nil {
+					// Like MongoDB unsetting a field that does not exist does nothing
+					return nil
+				}
+				return fmt.Errorf("unknown field %s", path)
+			}
+			value = structFieldValue(value, field)
+			if isLast {
+				return assignValue(value, newValue)
+			}
+		case reflect.Map:
+			if !isLast || value.Type().Key().Kind() != reflect.String {
+				return fmt.Errorf("cannot update %s, only the keys of maps at the end of a path can be updated", path)
+			}
+
+			copiedMap := reflect.MakeMap(value.Type())
+			iter := value.MapRange()
+			for iter.Next() {
+				copiedMap.SetMapIndex(iter.Key(), iter.Value())
+			}
+
+			key := reflect.ValueOf(part).Convert(value.Type().Key())
+			if newValue == nil {
+				copiedMap.SetMapIndex(key, reflect.Value{})
+			} else {
+				mapValue := reflect.New(value.Type().Elem()).Elem()
+				err := assignValue(mapValue, newValue)
+				if err != nil {
+					return err
+				}
+				copiedMap.SetMapIndex(key, mapValue)
+			}
+			value.Set(copiedMap)
+			return nil
+		default:
+			return fmt.Errorf("cannot update %s", path)
+		}
+	}
 	return nil
 }
+
+// assignValue sets target to value, converting numbers and pointers where needed
+func assignValue(target reflect.Value, value any) error {
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	valueRefl := reflect.ValueOf(value)
+	if valueRefl.Type().AssignableTo(target.Type()) {
+		target.Set(valueRefl)
+		return nil
+	}
+
+	if target.Kind() == reflect.Ptr {
+		newValue := reflect.New(target.Type().Elem())
+		err := assignValue(newValue.Elem(), value)
+		if err != nil {
+			return err
+		}
+		target.Set(newValue)
+		return nil
+	}
+
+	_, valueIsNumber := numberAsFloat(valueRefl)
+	_, targetIsNumber := numberAsFloat(target)
+	if valueIsNumber && targetIsNumber || valueRefl.Kind() == target.Kind() && valueRefl.Type().ConvertibleTo(target.Type()) {
+		target.Set(valueRefl.Convert(target.Type()))
+		return nil
+	}
+
+	return fmt.Errorf("cannot set a value of type %T on a field of type %s", value, target.Type())
+}
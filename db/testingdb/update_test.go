@@ -1,13 +1,15 @@
 package testingdb
 
 import (
+	"reflect"
 	"testing"
 
 	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func TestUpdate(t *testing.T) {
-	testDB := NewDB()
+	testDB := newTestDB(t)
 
 	mockData := NewMockuser()
 	Equal(t, "users", mockData.CollectionName())
@@ -30,9 +32,84 @@ func TestUpdate(t *testing.T) {
 	NoError(t, err)
 
 	// Check if the data in the database is actually replaced
-	collectionData := testDB.getCollectionFromEntry(newMockData).data
+	collectionData := testDB.collections[newMockData.CollectionName()].data
 	Equal(t, 1, len(collectionData))
 	firstItem := collectionData[0].(*MockUser)
 	NotNil(t, firstItem.Realname)
 	Equal(t, realname, *firstItem.Realname)
 }
+
+func TestUpdateMany(t *testing.T) {
+	testDB := newTestDB(t)
+
+	piet := NewMockuser()
+	jan := NewMockuser()
+	jan.Username = "Jan"
+	err := testDB.Insert(piet, jan)
+	NoError(t, err)
+
+	// Keep a reference like Find would return to check the stored entries are copied before updating
+	foundPiet := []*MockUser{}
+	err = testDB.Find(&MockUser{}, &foundPiet, bson.M{"username": "Piet"})
+	NoError(t, err)
+
+	realname := "Piet Pietersen"
+	updated, err := testDB.UpdateMany(&MockUser{}, bson.M{"username": "Piet"}, bson.M{"$set": bson.M{"real_name": realname}})
+	NoError(t, err)
+	Equal(t, uint64(1), updated)
+	Nil(t, foundPiet[0].Realname)
+
+	result := MockUser{}
+	err = testDB.FindOne(&result, bson.M{"_id": piet.ID})
+	NoError(t, err)
+	NotNil(t, result.Realname)
+	Equal(t, realname, *result.Realname)
+
+	updated, err = testDB.UpdateMany(&MockUser{}, nil, bson.M{"$set": bson.M{"username": "Klaas"}})
+	NoError(t, err)
+	Equal(t, uint64(2), updated)
+	count, err := testDB.Count(&MockUser{}, bson.M{"username": "Klaas"})
+	NoError(t, err)
+	Equal(t, uint64(2), count)
+
+	updated, err = testDB.UpdateMany(&MockUser{}, bson.M{"_id": piet.ID}, bson.M{"$unset": bson.M{"real_name": ""}})
+	NoError(t, err)
+	Equal(t, uint64(1), updated)
+	err = testDB.FindOne(&result, bson.M{"_id": piet.ID})
+	NoError(t, err)
+	Nil(t, result.Realname)
+
+	_, err = testDB.UpdateMany(&MockUser{}, nil, bson.M{"$inc": bson.M{"username": 1}})
+	Error(t, err)
+	_, err = testDB.UpdateMany(&MockUser{}, nil, bson.M{"$set": bson.M{"unknown": 1}})
+	Error(t, err)
+	_, err = testDB.UpdateMany(&MockUser{}, nil, bson.M{"$set": bson.M{"username": 1}})
+	Error(t, err)
+}
+
+func TestSetValueAtPath(t *testing.T) {
+	type nested struct {
+		Count  *int           `bson:"count"`
+		Labels map[string]any `bson:"labels"`
+	}
+	type document struct {
+		Nested *nested `bson:"nested"`
+	}
+
+	original := document{Nested: &nested{Labels: map[string]any{"a": "b"}}}
+	doc := original
+
+	err := setValueAtPath(reflect.ValueOf(&doc).Elem(), "nested.count", 3)
+	NoError(t, err)
+	err = setValueAtPath(reflect.ValueOf(&doc).Elem(), "nested.labels.c", "d")
+	NoError(t, err)
+	err = setValueAtPath(reflect.ValueOf(&doc).Elem(), "nested.labels.a", nil)
+	NoError(t, err)
+
+	Equal(t, 3, *doc.Nested.Count)
+	Equal(t, map[string]any{"c": "d"}, doc.Nested.Labels)
+
+	// The original document should be left untouched
+	Nil(t, original.Nested.Count)
+	Equal(t, map[string]any{"a": "b"}, original.Nested.Labels)
+}
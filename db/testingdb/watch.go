@@ -0,0 +1,140 @@
+package testingdb
+
+import (
+	"reflect"
+
+	"github.com/script-development/RT-CV/db"
+)
+
+// watcher is a function watching the changes of a collection
+type watcher struct {
+	collection string
+	fn         func(change db.Change)
+}
+
+// Watch calls fn for every change made to the collection of entry
+// Changes are delivered synchronously after the write is done, changes made in a transaction are delivered when the transaction is committed
+func (c *TestConnection) Watch(entry db.Entry, fn func(change db.Change)) (func(), error) {
+	w := &watcher{
+		collection: entry.CollectionName(),
+		fn:         fn,
+	}
+
+	c.watchersLock.Lock()
+	c.watchers = append(c.watchers, w)
+	c.watchersLock.Unlock()
+
+	stop := func() {
+		c.watchersLock.Lock()
+		defer c.watchersLock.Unlock()
+		for idx, existingWatcher := range c.watchers {
+			if existingWatcher == w {
+				c.watchers = append(c.watchers[:idx:idx], c.watchers[idx+1:]...)
+				return
+			}
+		}
+	}
+	return stop, nil
+}
+
+// notify sends the changes to the watchers
+// Within a transaction the changes are kept until the transaction is committed
+// Should not be called while holding c.m as watchers might use the database
+func (c *TestConnection) notify(changes ...db.Change) {
+	c.watchersLock.Lock()
+	if c.changedCollections != nil {
+		c.pendingChanges = append(c.pendingChanges, changes...)
+		c.watchersLock.Unlock()
+		return
+	}
+	watchers := append([]*watcher{}, c.watchers...)
+	c.watchersLock.Unlock()
+
+	for _, change := range changes {
+		for _, w := range watchers {
+			if w.collection != change.Collection {
+				continue
+			}
+			if change.Entry != nil {
+				// Every watcher gets its own copy so they can't modify the data in the database
+				change.Entry = copyEntry(change.Entry)
+			}
+			w.fn(change)
+		}
+	}
+}
+
+// entryChanges creates the changes for entries
+func entryChanges(kind db.ChangeKind, entries ...db.Entry) []db.Change {
+	changes := make([]db.Change, len(entries))
+	for idx, entry := range entries {
+		changes[idx] = db.Change{
+			Kind:       kind,
+			Collection: entry.CollectionName(),
+			ID:         entry.GetID(),
+			Entry:      entry,
+		}
+	}
+	return changes
+}
+
+// copyEntry returns a deep copy of the entry
+// Entries are copied when they enter or leave the database so modifications made by callers never change the stored data
+func copyEntry(entry db.Entry) db.Entry {
+	return deepCopy(reflect.ValueOf(entry)).Interface().(db.Entry)
+}
+
+// deepCopy returns a copy of value that shares no pointers, slices or maps with value
+// Unexported struct fields are copied as is
+func deepCopy(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		if value.Kind() == reflect.Interface {
+			copied := reflect.New(value.Type()).Elem()
+			copied.Set(deepCopy(value.Elem()))
+			return copied
+		}
+		copied := reflect.New(value.Type().Elem())
+		copied.Elem().Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(deepCopy(value.Index(i)))
+		}
+		return copied
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return copied
+	case reflect.Array:
+		copied := reflect.New(value.Type()).Elem()
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(deepCopy(value.Index(i)))
+		}
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if copied.Field(i).CanSet() {
+				copied.Field(i).Set(deepCopy(value.Field(i)))
+			}
+		}
+		return copied
+	default:
+		return value
+	}
+}
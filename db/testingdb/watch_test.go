@@ -0,0 +1,85 @@
+package testingdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestWatch(t *testing.T) {
+	testDB := newTestDB(t)
+
+	changes := []db.Change{}
+	stop, err := testDB.Watch(&MockUser{}, func(change db.Change) {
+		changes = append(changes, change)
+	})
+	NoError(t, err)
+
+	kinds := func() []db.ChangeKind {
+		res := []db.ChangeKind{}
+		for _, change := range changes {
+			res = append(res, change.Kind)
+		}
+		changes = []db.Change{}
+		return res
+	}
+
+	user := NewMockuser()
+	err = testDB.Insert(user)
+	NoError(t, err)
+	Equal(t, user.ID, changes[0].ID)
+	Equal(t, "users", changes[0].Collection)
+	Equal(t, []db.ChangeKind{db.ChangeInsert}, kinds())
+
+	err = testDB.UpdateByID(user)
+	NoError(t, err)
+	_, err = testDB.UpdateMany(&MockUser{}, nil, bson.M{"$set": bson.M{"username": "Jan"}})
+	NoError(t, err)
+	Equal(t, "Jan", changes[1].Entry.(*MockUser).Username)
+	Equal(t, []db.ChangeKind{db.ChangeUpdate, db.ChangeUpdate}, kinds())
+
+	err = testDB.DeleteByID(&MockUser{}, user.ID)
+	NoError(t, err)
+	Nil(t, changes[0].Entry)
+	Equal(t, []db.ChangeKind{db.ChangeDelete}, kinds())
+
+	// Changes made within a transaction are only send when the transaction is committed
+	err = testDB.WithTransaction(func(tx db.Connection) error {
+		err := tx.Insert(NewMockuser())
+		NoError(t, err)
+		Empty(t, changes)
+		return errors.New("rollback")
+	})
+	Error(t, err)
+	Empty(t, changes)
+
+	err = testDB.WithTransaction(func(tx db.Connection) error {
+		err := tx.Insert(NewMockuser(), NewMockuser())
+		NoError(t, err)
+		_, err = tx.DeleteMany(&MockUser{}, nil)
+		return err
+	})
+	NoError(t, err)
+	Equal(t, []db.ChangeKind{db.ChangeInsert, db.ChangeInsert, db.ChangeDelete, db.ChangeDelete}, kinds())
+
+	// Changes to other collections are not send
+	err = testDB.Insert(&otherMockEntry{M: db.NewM()})
+	NoError(t, err)
+	Empty(t, changes)
+
+	stop()
+	err = testDB.Insert(NewMockuser())
+	NoError(t, err)
+	Empty(t, changes)
+}
+
+type otherMockEntry struct {
+	db.M `bson:",inline"`
+}
+
+func (*otherMockEntry) CollectionName() string {
+	return "other"
+}
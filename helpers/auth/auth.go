@@ -2,6 +2,7 @@ package auth
 
 import (
 	"errors"
+	"os"
 	"strings"
 	"time"
 
@@ -21,8 +22,14 @@ func GenAuthHeaderKey(id, key string) string {
 // Helper helps authenticate a user
 type Helper struct {
 	// the cache key is the key ID
-	cache  *xsync.MapOf[string, cachedKey]
-	dbConn db.Connection
+	cache *xsync.MapOf[string, cachedKey]
+	// the cache key is the session ID
+	sessionsCache *xsync.MapOf[string, cachedSession]
+	sessionSecret []byte
+	dbConn        db.Connection
+	// userAttempts and ipAttempts count the failed login and totp attempts, see (*Helper).AttemptRetryAfter
+	userAttempts *throttle
+	ipAttempts   *throttle
 }
 
 type cachedKey struct {
@@ -32,10 +39,16 @@ type cachedKey struct {
 }
 
 // NewHelper returns a new instance of AuthHelper
+// Session tokens are signed using the $SESSION_SECRET shell variable,
+// if not set a random secret is used meaning sessions won't survive a restart and are not shared between replicas
 func NewHelper(dbConn db.Connection) *Helper {
 	return &Helper{
-		cache:  xsync.NewMapOf[cachedKey](),
-		dbConn: dbConn,
+		cache:         xsync.NewMapOf[cachedKey](),
+		sessionsCache: xsync.NewMapOf[cachedSession](),
+		sessionSecret: newSessionSecret(os.Getenv("SESSION_SECRET")),
+		dbConn:        dbConn,
+		userAttempts:  newThrottle(MaxFailedAttemptsPerUser),
+		ipAttempts:    newThrottle(MaxFailedAttemptsPerIP),
 	}
 }
 
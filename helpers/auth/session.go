@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/crypto"
+	"github.com/script-development/RT-CV/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// AccessTokenTTL is how long a session access token is valid
+	AccessTokenTTL = 15 * time.Minute
+	// SessionTTL is how long a session stays valid without being refreshed
+	SessionTTL = 7 * 24 * time.Hour
+
+	// sessionCacheTTL is how long we cache a session and its user
+	// Revoking a session on another replica can take up to this long to be picked up by this replica
+	sessionCacheTTL = time.Minute
+)
+
+var (
+	// ErrSessionTokenInvalid = session token is invalid
+	ErrSessionTokenInvalid = errors.New("session token is invalid")
+	// ErrSessionTokenExpired = session token is expired
+	ErrSessionTokenExpired = errors.New("session token is expired, use the refresh token to obtain a new one")
+	// ErrSessionRevoked = the session is revoked or expired
+	ErrSessionRevoked = errors.New("session is revoked or expired")
+	// ErrRefreshTokenInvalid = the refresh token is invalid
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+)
+
+type cachedSession struct {
+	validTil time.Time
+	session  *models.Session
+	key      *models.APIKey
+}
+
+// SessionTokens are the tokens send to the client after logging in or refreshing a session
+type SessionTokens struct {
+	AccessToken          string    `json:"accessToken" description:"Send this token in the Authorization header as \"Bearer {accessToken}\""`
+	AccessTokenExpiresAt time.Time `json:"accessTokenExpiresAt"`
+	RefreshToken         string    `json:"refreshToken" description:"Can be used once to obtain new tokens"`
+	SessionExpiresAt     time.Time `json:"sessionExpiresAt"`
+}
+
+func newSessionSecret(secret string) []byte {
+	if secret != "" {
+		return crypto.NormalizeKey([]byte(secret))
+	}
+
+	// No secret provided, tokens will be invalid after a restart
+	randomSecret := make([]byte, 32)
+	_, err := rand.Read(randomSecret)
+	if err != nil {
+		panic(err)
+	}
+	return randomSecret
+}
+
+func (h *Helper) signSessionToken(payload string) string {
+	mac := hmac.New(sha256.New, h.sessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// genAccessToken creates a signed access token with the format: {sessionID}.{expires unix}.{signature}
+func (h *Helper) genAccessToken(sessionID primitive.ObjectID, expiresAt time.Time) string {
+	payload := sessionID.Hex() + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + h.signSessionToken(payload)
+}
+
+func genRefreshToken() (token string, hash string, err error) {
+	tokenBytes := make([]byte, 32)
+	_, err = rand.Read(tokenBytes)
+	if err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(tokenBytes)
+	return token, crypto.HashSha512String(token), nil
+}
+
+// CreateSession creates a new session for a user and returns the tokens for that session
+func (h *Helper) CreateSession(user *models.DashboardUser, ip, userAgent string) (*models.Session, SessionTokens, error) {
+	refreshToken, refreshTokenHash, err := genRefreshToken()
+	if err != nil {
+		return nil, SessionTokens{}, err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		M:                db.NewM(),
+		UserID:           user.ID,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(SessionTTL),
+		IP:               ip,
+		UserAgent:        userAgent,
+		RefreshTokenHash: refreshTokenHash,
+	}
+	err = h.dbConn.Insert(session)
+	if err != nil {
+		return nil, SessionTokens{}, err
+	}
+
+	accessTokenExpiresAt := now.Add(AccessTokenTTL)
+	return session, SessionTokens{
+		AccessToken:          h.genAccessToken(session.ID, accessTokenExpiresAt),
+		AccessTokenExpiresAt: accessTokenExpiresAt,
+		RefreshToken:         refreshToken,
+		SessionExpiresAt:     session.ExpiresAt,
+	}, nil
+}
+
+// RefreshSession exchanges a refresh token for new session tokens
+// The old refresh token can't be used anymore after this
+// If an already used refresh token is used again the session is revoked and ErrRefreshTokenInvalid is returned together with the revoked session
+func (h *Helper) RefreshSession(refreshToken string) (*models.Session, SessionTokens, error) {
+	refreshTokenHash := crypto.HashSha512String(refreshToken)
+
+	session := models.Session{}
+	err := h.dbConn.FindOne(&session, bson.M{"refreshTokenHash": refreshTokenHash})
+	if err == mongo.ErrNoDocuments {
+		err = h.dbConn.FindOne(&session, bson.M{"previousRefreshTokenHash": refreshTokenHash})
+		if err == mongo.ErrNoDocuments {
+			return nil, SessionTokens{}, ErrRefreshTokenInvalid
+		} else if err != nil {
+			return nil, SessionTokens{}, err
+		}
+
+		// This refresh token was already used, someone might have stolen it
+		if !session.Revoked {
+			session.Revoked = true
+			err = h.dbConn.UpdateByID(&session)
+			if err != nil {
+				return nil, SessionTokens{}, err
+			}
+			h.RemoveSessionCache(session.ID)
+		}
+		return &session, SessionTokens{}, ErrRefreshTokenInvalid
+	} else if err != nil {
+		return nil, SessionTokens{}, err
+	}
+
+	if !session.Active() {
+		return nil, SessionTokens{}, ErrSessionRevoked
+	}
+
+	user, err := models.GetDashboardUser(h.dbConn, session.UserID)
+	if err == mongo.ErrNoDocuments || (err == nil && user.Disabled) {
+		return nil, SessionTokens{}, ErrSessionRevoked
+	} else if err != nil {
+		return nil, SessionTokens{}, err
+	}
+
+	newRefreshToken, newRefreshTokenHash, err := genRefreshToken()
+	if err != nil {
+		return nil, SessionTokens{}, err
+	}
+
+	now := time.Now()
+	session.PreviousRefreshTokenHash = session.RefreshTokenHash
+	session.RefreshTokenHash = newRefreshTokenHash
+	session.ExpiresAt = now.Add(SessionTTL)
+	err = h.dbConn.UpdateByID(&session)
+	if err != nil {
+		return nil, SessionTokens{}, err
+	}
+	h.RemoveSessionCache(session.ID)
+
+	accessTokenExpiresAt := now.Add(AccessTokenTTL)
+	return &session, SessionTokens{
+		AccessToken:          h.genAccessToken(session.ID, accessTokenExpiresAt),
+		AccessTokenExpiresAt: accessTokenExpiresAt,
+		RefreshToken:         newRefreshToken,
+		SessionExpiresAt:     session.ExpiresAt,
+	}, nil
+}
+
+// RevokeSession revokes a session, the access and refresh tokens of this session can't be used anymore after this
+func (h *Helper) RevokeSession(session *models.Session) error {
+	session.Revoked = true
+	err := h.dbConn.UpdateByID(session)
+	if err != nil {
+		return err
+	}
+	h.RemoveSessionCache(session.ID)
+	return nil
+}
+
+// RemoveSessionCache removes a cached session
+func (h *Helper) RemoveSessionCache(sessionID primitive.ObjectID) {
+	h.sessionsCache.Delete(sessionID.Hex())
+}
+
+// RemoveUserSessionsCache removes all cached sessions of a user
+// Should be called when a user is modified so the changes are directly applied
+func (h *Helper) RemoveUserSessionsCache(userID primitive.ObjectID) {
+	h.sessionsCache.Range(func(key string, value cachedSession) bool {
+		if value.session.UserID == userID {
+			h.sessionsCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// ValidSession validates a session access token
+// The returned api key represents the user of the session, see (*models.DashboardUser).APIKey
+func (h *Helper) ValidSession(accessToken string) (*models.APIKey, *models.Session, error) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return nil, nil, ErrSessionTokenInvalid
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(h.signSessionToken(payload)), []byte(parts[2])) {
+		return nil, nil, ErrSessionTokenInvalid
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, nil, ErrSessionTokenInvalid
+	}
+	if time.Now().After(time.Unix(expiresAt, 0)) {
+		return nil, nil, ErrSessionTokenExpired
+	}
+
+	cacheEntry, ok := h.sessionsCache.Load(parts[0])
+	if ok && time.Now().Before(cacheEntry.validTil) {
+		return cacheEntry.key, cacheEntry.session, nil
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		return nil, nil, ErrSessionTokenInvalid
+	}
+	session, err := models.GetSession(h.dbConn, sessionID)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, ErrSessionRevoked
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if !session.Active() {
+		return nil, nil, ErrSessionRevoked
+	}
+
+	user, err := models.GetDashboardUser(h.dbConn, session.UserID)
+	if err == mongo.ErrNoDocuments || (err == nil && user.Disabled) {
+		return nil, nil, ErrSessionRevoked
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	key := user.APIKey()
+	h.sessionsCache.Store(parts[0], cachedSession{
+		validTil: time.Now().Add(sessionCacheTTL),
+		session:  &session,
+		key:      key,
+	})
+
+	return key, &session, nil
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxFailedAttemptsPerUser is the number of failed login and totp attempts of a single user allowed within FailedAttemptsWindow
+	MaxFailedAttemptsPerUser = 5
+	// MaxFailedAttemptsPerIP is the number of failed login and totp attempts from a single ip allowed within FailedAttemptsWindow
+	MaxFailedAttemptsPerIP = 20
+	// FailedAttemptsWindow is how long failed attempts are counted, after a user or ip is blocked it can try again once the window has passed
+	FailedAttemptsWindow = 15 * time.Minute
+
+	// maxThrottleEntries is the amount of counted users or ips after which the expired entries are removed
+	maxThrottleEntries = 10_000
+)
+
+// ErrTooManyAttempts is returned if a user or ip has too many failed login or totp attempts
+var ErrTooManyAttempts = errors.New("too many failed attempts, try again later")
+
+// throttle counts the failed attempts per user or ip
+// The counts are kept in memory so every replica has its own counts
+type throttle struct {
+	m        sync.Mutex
+	max      int
+	failures map[string]failedAttempts
+}
+
+type failedAttempts struct {
+	count   int
+	resetAt time.Time
+}
+
+func newThrottle(max int) *throttle {
+	return &throttle{
+		max:      max,
+		failures: map[string]failedAttempts{},
+	}
+}
+
+// retryAfter returns how long the key has to wait before it can try again, 0 if the key is allowed to try now
+func (t *throttle) retryAfter(key string, now time.Time) time.Duration {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	failures, ok := t.failures[key]
+	if !ok || !now.Before(failures.resetAt) || failures.count < t.max {
+		return 0
+	}
+	return failures.resetAt.Sub(now)
+}
+
+// failed counts a failed attempt of the key
+func (t *throttle) failed(key string, now time.Time) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if len(t.failures) >= maxThrottleEntries {
+		for otherKey, failures := range t.failures {
+			if !now.Before(failures.resetAt) {
+				delete(t.failures, otherKey)
+			}
+		}
+	}
+
+	failures, ok := t.failures[key]
+	if !ok || !now.Before(failures.resetAt) {
+		failures = failedAttempts{resetAt: now.Add(FailedAttemptsWindow)}
+	}
+	failures.count++
+	t.failures[key] = failures
+}
+
+// reset removes the failed attempts of the key
+func (t *throttle) reset(key string) {
+	t.m.Lock()
+	delete(t.failures, key)
+	t.m.Unlock()
+}
+
+// AttemptRetryAfter returns how long the user or ip has to wait before it can try to login or use a totp code again
+// Returns 0 if the attempt is allowed
+func (h *Helper) AttemptRetryAfter(username, ip string) time.Duration {
+	now := time.Now()
+	retryAfter := h.userAttempts.retryAfter(username, now)
+	if ipRetryAfter := h.ipAttempts.retryAfter(ip, now); ipRetryAfter > retryAfter {
+		retryAfter = ipRetryAfter
+	}
+	return retryAfter
+}
+
+// AttemptFailed counts a failed login or totp attempt of the user from the ip
+func (h *Helper) AttemptFailed(username, ip string) {
+	now := time.Now()
+	h.userAttempts.failed(username, now)
+	h.ipAttempts.failed(ip, now)
+}
+
+// AttemptSucceeded resets the failed attempts of the user
+// The failed attempts of the ip are kept so an ip cannot reset its count by logging in with its own account
+func (h *Helper) AttemptSucceeded(username string) {
+	h.userAttempts.reset(username)
+}
@@ -25,11 +25,29 @@ type EncryptWriter struct {
 	buff              []byte
 	encryptedDataBuff []byte
 	nonceHasher       hash.Hash
+	additionalData    []byte
 }
 
 // NewEncryptWriter creates a new instance of the encrypt writer
 func NewEncryptWriter(key []byte, dst io.Writer) (*EncryptWriter, error) {
-	c, err := aes.NewCipher(NormalizeKey(key))
+	k, err := NewKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyEncryptWriter(k, dst)
+}
+
+// NewKeyEncryptWriter creates a new instance of the encrypt writer
+// The output starts with a header that contains the id of the key and the parameters used to derive the AES key
+func NewKeyEncryptWriter(key *Key, dst io.Writer) (*EncryptWriter, error) {
+	salt := make([]byte, saltSize)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, err
+	}
+	header := newHeader(key, DefaultKDFParams, salt)
+
+	c, err := aes.NewCipher(header.KDF.deriveKey(key.secret, salt))
 	if err != nil {
 		return nil, err
 	}
@@ -40,6 +58,7 @@ func NewEncryptWriter(key []byte, dst io.Writer) (*EncryptWriter, error) {
 		buff:              []byte{},
 		encryptedDataBuff: []byte{},
 		nonceHasher:       sha512.New(),
+		additionalData:    header.raw,
 	}
 
 	ew.gcm, err = cipher.NewGCM(c)
@@ -53,6 +72,10 @@ func NewEncryptWriter(key []byte, dst io.Writer) (*EncryptWriter, error) {
 		return nil, err
 	}
 
+	err = ew.mustWriteToDst(header.raw)
+	if err != nil {
+		return nil, err
+	}
 	err = ew.mustWriteToDst(ew.nonce)
 	if err != nil {
 		return nil, err
@@ -95,7 +118,7 @@ func (ew *EncryptWriter) Close() error {
 
 	if len(ew.buff) > 0 {
 		// Write the remaining data
-		ew.encryptedDataBuff = ew.gcm.Seal(ew.encryptedDataBuff[:0], ew.nonce, ew.buff, nil)
+		ew.encryptedDataBuff = ew.gcm.Seal(ew.encryptedDataBuff[:0], ew.nonce, ew.buff, ew.additionalData)
 		encryptedDataBuffSize := numbers.UintToBytes(uint64(len(ew.encryptedDataBuff)), 32)
 
 		err = ew.mustWriteToDst(encryptedDataBuffSize)
@@ -116,7 +139,7 @@ func (ew *EncryptWriter) tryWriterChunk() error {
 			return nil
 		}
 
-		ew.encryptedDataBuff = ew.gcm.Seal(ew.encryptedDataBuff[:0], ew.nonce, ew.buff[:chunkSize], nil)
+		ew.encryptedDataBuff = ew.gcm.Seal(ew.encryptedDataBuff[:0], ew.nonce, ew.buff[:chunkSize], ew.additionalData)
 		encryptedDataBuffSize := numbers.UintToBytes(uint64(len(ew.encryptedDataBuff)), 32)
 
 		// Write the size of the chunk
@@ -164,7 +187,6 @@ func (ew *EncryptWriter) mustWriteToDst(b []byte) error {
 
 // EncryptReader decrypts files using the io.Reader
 type EncryptReader struct {
-	key             []byte
 	nonce           []byte
 	chunk           []byte
 	chunkReadOffset int
@@ -172,31 +194,46 @@ type EncryptReader struct {
 	source          io.Reader
 	nonceHasher     hash.Hash
 	eof             bool
+	additionalData  []byte
 }
 
 // NewEncryptReader creates a new instance of the encrypt reader
 // This can read from another reader where the data is encrypted using EncryptWriter
 func NewEncryptReader(key []byte, source io.Reader) (*EncryptReader, error) {
+	keyring, err := NewKeyring(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyringEncryptReader(keyring, source)
+}
+
+// NewKeyringEncryptReader creates a new instance of the encrypt reader that decrypts using the keys of the keyring
+func NewKeyringEncryptReader(keyring *Keyring, source io.Reader) (*EncryptReader, error) {
 	r := &EncryptReader{
-		key:         key,
 		chunk:       make([]byte, chunkSize),
 		source:      source,
 		nonceHasher: sha512.New(),
 	}
 
-	c, err := aes.NewCipher(NormalizeKey(key))
+	header, read, err := readHeader(source)
 	if err != nil {
 		return nil, err
 	}
+	if header == nil {
+		return r, r.initLegacy(keyring, read)
+	}
 
-	r.gcm, err = cipher.NewGCM(c)
+	key, err := keyring.key(header.KeyID)
 	if err != nil {
 		return nil, err
 	}
+	r.gcm, err = newGCM(header.KDF.deriveKey(key.secret, header.Salt))
+	if err != nil {
+		return nil, err
+	}
+	r.additionalData = header.raw
 
-	nonceSize := r.gcm.NonceSize()
-
-	r.nonce, err = r.mustReadFromSource(nonceSize)
+	r.nonce, err = r.mustReadFromSource(r.gcm.NonceSize())
 	if err != nil {
 		return nil, err
 	}
@@ -209,6 +246,58 @@ func NewEncryptReader(key []byte, source io.Reader) (*EncryptReader, error) {
 	return r, nil
 }
 
+// initLegacy prepares the reader for a stream without a header
+// These streams do not contain a key id so we try every key in the keyring on the first chunk
+func (r *EncryptReader) initLegacy(keyring *Keyring, read []byte) error {
+	gcms := make([]cipher.AEAD, len(keyring.keys))
+	for idx, key := range keyring.keys {
+		gcm, err := newGCM(NormalizeKey(key.secret))
+		if err != nil {
+			return err
+		}
+		gcms[idx] = gcm
+	}
+
+	nonceSize := gcms[0].NonceSize()
+	if len(read) > nonceSize {
+		return errors.New("invalid encrypted data")
+	}
+	r.nonce = read
+	if len(read) < nonceSize {
+		rest, err := r.mustReadFromSource(nonceSize - len(read))
+		if err != nil {
+			return err
+		}
+		r.nonce = append(r.nonce, rest...)
+	}
+
+	encryptedChunk, err := r.readEncryptedChunk()
+	if err != nil {
+		return err
+	}
+	r.gcm = gcms[0]
+	if r.eof {
+		return nil
+	}
+
+	for _, gcm := range gcms {
+		r.gcm = gcm
+		err = r.openChunk(encryptedChunk)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
 // Read Implements io.Reader
 func (r *EncryptReader) Read(p []byte) (n int, err error) {
 	if r.eof {
@@ -255,27 +344,36 @@ func (r *EncryptReader) Read(p []byte) (n int, err error) {
 }
 
 // readNextChunk reads the next encrypted chunk and decrypts it
-func (r *EncryptReader) readNextChunk() (err error) {
+func (r *EncryptReader) readNextChunk() error {
+	encryptedChunk, err := r.readEncryptedChunk()
+	if err != nil || r.eof {
+		return err
+	}
+	return r.openChunk(encryptedChunk)
+}
+
+// readEncryptedChunk reads the next encrypted chunk, sets r.eof if there are no more chunks
+func (r *EncryptReader) readEncryptedChunk() ([]byte, error) {
 	encryptedChunkSizeBytes, err := r.mustReadFromSource(4)
 	if err != nil {
 		if err == io.EOF {
 			r.eof = true
-			return nil
+			return nil, nil
 		}
-		return err
+		return nil, err
 	}
 
 	encryptedChunkSize, err := numbers.BytesToUint(encryptedChunkSizeBytes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	encryptedBytes, err := r.mustReadFromSource(int(encryptedChunkSize))
-	if err != nil {
-		return err
-	}
+	return r.mustReadFromSource(int(encryptedChunkSize))
+}
 
-	r.chunk, err = r.gcm.Open(r.chunk[:0], r.nonce, encryptedBytes, nil)
+// openChunk decrypts a chunk
+func (r *EncryptReader) openChunk(encryptedChunk []byte) (err error) {
+	r.chunk, err = r.gcm.Open(r.chunk[:0], r.nonce, encryptedChunk, r.additionalData)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,173 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/script-development/RT-CV/helpers/numbers"
+)
+
+/*
+
+Data layout of the header written by EncryptWriter
+
+[]byte{
+	...headerMagic (8 bytes),
+	version (1 byte),
+	kdf (1 byte, kdfArgon2id),
+	...kdf time as uint32 (4 bytes),
+	...kdf memory as uint32 (4 bytes),
+	kdf threads (1 byte),
+	salt length (1 byte),
+	...salt,
+	key id length (1 byte),
+	...key id,
+}
+
+The header is followed by the nonce and the encrypted chunks
+The header is used as additional data for every chunk so it can't be modified without the decryption failing
+
+Streams created before the header was added start directly with the nonce and use NormalizeKey to create the AES key
+
+*/
+
+var headerMagic = []byte("RTCV-ENC")
+
+const (
+	headerVersion = 1
+	kdfArgon2id   = 1
+	saltSize      = 16
+
+	maxKDFTime   = 100
+	maxKDFMemory = 1024 * 1024 // 1 GiB
+)
+
+// Header describes how a stream is encrypted
+type Header struct {
+	Version uint8
+	KeyID   string
+	KDF     KDFParams
+	Salt    []byte
+
+	// raw contains the encoded header
+	raw []byte
+}
+
+func newHeader(key *Key, kdf KDFParams, salt []byte) *Header {
+	h := &Header{
+		Version: headerVersion,
+		KeyID:   key.ID,
+		KDF:     kdf,
+		Salt:    salt,
+	}
+
+	raw := append([]byte{}, headerMagic...)
+	raw = append(raw, h.Version, kdfArgon2id)
+	raw = append(raw, numbers.UintToBytes(uint64(kdf.Time), 32)...)
+	raw = append(raw, numbers.UintToBytes(uint64(kdf.Memory), 32)...)
+	raw = append(raw, kdf.Threads, uint8(len(salt)))
+	raw = append(raw, salt...)
+	raw = append(raw, uint8(len(h.KeyID)))
+	raw = append(raw, []byte(h.KeyID)...)
+	h.raw = raw
+
+	return h
+}
+
+// ReadHeader reads the header of an encrypted stream
+// Returns a nil header if the stream was created before headers where added
+func ReadHeader(source io.Reader) (*Header, error) {
+	header, _, err := readHeader(source)
+	return header, err
+}
+
+// readHeader reads the header of an encrypted stream
+// If the stream has no header the header is nil and the bytes read from the stream are returned
+func readHeader(source io.Reader) (header *Header, read []byte, err error) {
+	raw := bytes.NewBuffer(nil)
+	mustRead := func(n int) ([]byte, error) {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(source, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("unexpected end of the encrypted data")
+		}
+		raw.Write(buf)
+		return buf, err
+	}
+	mustReadUint32 := func() (uint32, error) {
+		buf, err := mustRead(4)
+		if err != nil {
+			return 0, err
+		}
+		nr, err := numbers.BytesToUint(buf)
+		return uint32(nr), err
+	}
+
+	magic := make([]byte, len(headerMagic))
+	n, err := io.ReadFull(source, magic)
+	if err != nil {
+		if n == 0 {
+			return nil, nil, io.EOF
+		}
+		// Too short to contain a header
+		return nil, magic[:n], nil
+	}
+	if !bytes.Equal(magic, headerMagic) {
+		return nil, magic, nil
+	}
+	raw.Write(magic)
+
+	versionAndKDF, err := mustRead(2)
+	if err != nil {
+		return nil, nil, err
+	}
+	if versionAndKDF[0] != headerVersion {
+		return nil, nil, fmt.Errorf("unsupported encryption version %d", versionAndKDF[0])
+	}
+	if versionAndKDF[1] != kdfArgon2id {
+		return nil, nil, fmt.Errorf("unsupported key derivation function %d", versionAndKDF[1])
+	}
+
+	header = &Header{Version: versionAndKDF[0]}
+	header.KDF.Time, err = mustReadUint32()
+	if err != nil {
+		return nil, nil, err
+	}
+	header.KDF.Memory, err = mustReadUint32()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	threadsAndSaltLen, err := mustRead(2)
+	if err != nil {
+		return nil, nil, err
+	}
+	header.KDF.Threads = threadsAndSaltLen[0]
+	header.Salt, err = mustRead(int(threadsAndSaltLen[1]))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyIDLen, err := mustRead(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyID, err := mustRead(int(keyIDLen[0]))
+	if err != nil {
+		return nil, nil, err
+	}
+	header.KeyID = string(keyID)
+
+	if header.KDF.Time == 0 || header.KDF.Threads == 0 || len(header.Salt) == 0 {
+		return nil, nil, errors.New("invalid key derivation parameters in header")
+	}
+	if header.KDF.Time > maxKDFTime || header.KDF.Memory > maxKDFMemory {
+		// Protects against headers that would take forever or all memory to derive the key
+		return nil, nil, errors.New("key derivation parameters in header exceed the limits")
+	}
+
+	header.raw = raw.Bytes()
+	return header, nil, nil
+}
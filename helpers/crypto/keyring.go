@@ -0,0 +1,135 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// KDFParams are the argon2id parameters used to derive the AES key of an encrypted stream from a key
+type KDFParams struct {
+	Time    uint32
+	Memory  uint32 // In KiB
+	Threads uint8
+}
+
+// DefaultKDFParams are the argon2id parameters used for new encrypted streams
+// These are the second recommended option of RFC 9106 for memory constrained environments
+var DefaultKDFParams = KDFParams{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+// deriveKey derives a 32 byte key for AES-256 from the secret
+func (p KDFParams) deriveKey(secret, salt []byte) []byte {
+	return argon2.IDKey(secret, salt, p.Time, p.Memory, p.Threads, 32)
+}
+
+// keyIDSalt is the salt used to derive the key ids
+// The id is derived using the KDF so it can't be used to guess the key faster than guessing the key of a encrypted stream
+var keyIDSalt = []byte("rt-cv encryption key id")
+
+// keyIDs caches the ids of keys as deriving them is expensive
+var keyIDs sync.Map
+
+// Key is an encryption key
+type Key struct {
+	// ID identifies the key without revealing it, it is stored in the header of encrypted streams
+	ID     string
+	secret []byte
+}
+
+// NewKey creates a key from a secret of at least 16 chars
+func NewKey(secret []byte) (*Key, error) {
+	if len(secret) < 16 {
+		return nil, errors.New("an encryption keys needs to be at least 16 chars")
+	}
+
+	id, ok := keyIDs.Load(string(secret))
+	if !ok {
+		id = hex.EncodeToString(DefaultKDFParams.deriveKey(secret, keyIDSalt)[:8])
+		keyIDs.Store(string(secret), id)
+	}
+
+	return &Key{
+		ID:     id.(string),
+		secret: secret,
+	}, nil
+}
+
+// Keyring contains the key used to encrypt new data and the old keys that can still be used to decrypt data
+type Keyring struct {
+	current *Key
+	keys    []*Key
+}
+
+// NewKeyring creates a keyring that encrypts using current and decrypts using current and the old keys
+func NewKeyring(current []byte, old ...[]byte) (*Keyring, error) {
+	currentKey, err := NewKey(current)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring := &Keyring{
+		current: currentKey,
+		keys:    []*Key{currentKey},
+	}
+	for idx, secret := range old {
+		key, err := NewKey(secret)
+		if err != nil {
+			return nil, fmt.Errorf("old key %d: %s", idx+1, err.Error())
+		}
+		keyring.keys = append(keyring.keys, key)
+	}
+	return keyring, nil
+}
+
+// Current returns the key used to encrypt new data
+func (k *Keyring) Current() *Key {
+	return k.current
+}
+
+// WithoutOldKeys returns a keyring that only contains the current key
+func (k *Keyring) WithoutOldKeys() *Keyring {
+	return &Keyring{
+		current: k.current,
+		keys:    []*Key{k.current},
+	}
+}
+
+// ErrUnknownKey is returned when data is encrypted with a key that is not in the keyring
+var ErrUnknownKey = errors.New("data is encrypted with a key that is not in the keyring")
+
+// key returns the key with the id
+func (k *Keyring) key(id string) (*Key, error) {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w, key id: %s", ErrUnknownKey, id)
+}
+
+// Reencrypt decrypts src using the keyring and writes it to dst encrypted with the current key of the keyring
+func Reencrypt(keyring *Keyring, src io.Reader, dst io.Writer) error {
+	r, err := NewKeyringEncryptReader(keyring, src)
+	if err != nil {
+		return err
+	}
+	w, err := NewKeyEncryptWriter(keyring.Current(), dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,197 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/script-development/RT-CV/helpers/numbers"
+	. "github.com/stretchr/testify/assert"
+)
+
+var (
+	testKey    = []byte("a-testkey-that-is-longer-than-16-chars")
+	testOldKey = []byte("an-old-testkey-that-is-longer-than-16-chars")
+)
+
+func encryptForTest(t *testing.T, key *Key, data string) []byte {
+	out := bytes.NewBuffer(nil)
+	w, err := NewKeyEncryptWriter(key, out)
+	if !NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = w.Write([]byte(data))
+	NoError(t, err)
+	NoError(t, w.Close())
+	return out.Bytes()
+}
+
+func decryptForTest(keyring *Keyring, data []byte) (string, error) {
+	r, err := NewKeyringEncryptReader(keyring, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	decrypted, err := io.ReadAll(r)
+	return string(decrypted), err
+}
+
+// legacyEncrypt encrypts data in the format used before the header was added
+func legacyEncrypt(t *testing.T, key []byte, data string) []byte {
+	gcm, err := newGCM(NormalizeKey(key))
+	NoError(t, err)
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	NoError(t, err)
+
+	out := append([]byte{}, nonce...)
+	hasher := sha512.New()
+	remaining := []byte(data)
+	for len(remaining) > 0 {
+		chunk := remaining
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		remaining = remaining[len(chunk):]
+
+		encrypted := gcm.Seal(nil, nonce, chunk, nil)
+		out = append(out, numbers.UintToBytes(uint64(len(encrypted)), 32)...)
+		out = append(out, encrypted...)
+		genNextNonce(hasher, nonce)
+	}
+	return out
+}
+
+func TestKeyID(t *testing.T) {
+	key, err := NewKey(testKey)
+	NoError(t, err)
+	Len(t, key.ID, 16)
+
+	sameKey, err := NewKey(append([]byte{}, testKey...))
+	NoError(t, err)
+	Equal(t, key.ID, sameKey.ID)
+
+	otherKey, err := NewKey(testOldKey)
+	NoError(t, err)
+	NotEqual(t, key.ID, otherKey.ID)
+
+	_, err = NewKey([]byte("short"))
+	Error(t, err)
+}
+
+func TestHeader(t *testing.T) {
+	key, err := NewKey(testKey)
+	NoError(t, err)
+	encrypted := encryptForTest(t, key, "data")
+
+	header, err := ReadHeader(bytes.NewReader(encrypted))
+	NoError(t, err)
+	if NotNil(t, header) {
+		Equal(t, uint8(headerVersion), header.Version)
+		Equal(t, key.ID, header.KeyID)
+		Equal(t, DefaultKDFParams, header.KDF)
+		Len(t, header.Salt, saltSize)
+	}
+
+	// Streams without a header have no header
+	header, err = ReadHeader(bytes.NewReader(legacyEncrypt(t, testKey, "data")))
+	NoError(t, err)
+	Nil(t, header)
+
+	// Every stream uses a new salt
+	Equal(t, len(encrypted), len(encryptForTest(t, key, "data")))
+	NotEqual(t, encrypted, encryptForTest(t, key, "data"))
+}
+
+func TestKeyring(t *testing.T) {
+	oldKeyring, err := NewKeyring(testOldKey)
+	NoError(t, err)
+	data := strings.Repeat("very secret data.", chunkSize/4)
+	encryptedWithOldKey := encryptForTest(t, oldKeyring.Current(), data)
+
+	// The old key is used to decrypt
+	keyring, err := NewKeyring(testKey, testOldKey)
+	NoError(t, err)
+	decrypted, err := decryptForTest(keyring, encryptedWithOldKey)
+	NoError(t, err)
+	Equal(t, data, decrypted)
+
+	// Without the old key the data can't be decrypted
+	newKeyring, err := NewKeyring(testKey)
+	NoError(t, err)
+	_, err = decryptForTest(newKeyring, encryptedWithOldKey)
+	True(t, errors.Is(err, ErrUnknownKey), err)
+
+	_, err = NewKeyring(testKey, []byte("short"))
+	Error(t, err)
+}
+
+func TestLegacyStreams(t *testing.T) {
+	data := strings.Repeat("very nice.", chunkSize)
+
+	keyring, err := NewKeyring(testKey, testOldKey)
+	NoError(t, err)
+	for _, key := range [][]byte{testKey, testOldKey} {
+		decrypted, err := decryptForTest(keyring, legacyEncrypt(t, key, data))
+		NoError(t, err)
+		Equal(t, data, decrypted)
+	}
+
+	otherKeyring, err := NewKeyring([]byte("yet-another-key-longer-than-16-chars"))
+	NoError(t, err)
+	_, err = decryptForTest(otherKeyring, legacyEncrypt(t, testKey, data))
+	Error(t, err)
+}
+
+func TestModifiedHeader(t *testing.T) {
+	keyring, err := NewKeyring(testKey)
+	NoError(t, err)
+	encrypted := encryptForTest(t, keyring.Current(), "data")
+
+	// Lower the KDF time, this results in a different key
+	modified := append([]byte{}, encrypted...)
+	modified[len(headerMagic)+2] = 1
+	_, err = decryptForTest(keyring, modified)
+	Error(t, err)
+
+	// Change the salt, this results in a different key
+	modified = append([]byte{}, encrypted...)
+	modified[len(headerMagic)+12]++
+	_, err = decryptForTest(keyring, modified)
+	Error(t, err)
+
+	// Unsupported versions are rejected
+	modified = append([]byte{}, encrypted...)
+	modified[len(headerMagic)] = 2
+	_, err = decryptForTest(keyring, modified)
+	Error(t, err)
+}
+
+func TestReencrypt(t *testing.T) {
+	data := strings.Repeat("very nice.", chunkSize)
+
+	keyring, err := NewKeyring(testKey, testOldKey)
+	NoError(t, err)
+	for _, encrypted := range [][]byte{
+		legacyEncrypt(t, testOldKey, data),
+		encryptForTest(t, keyring.keys[1], data),
+	} {
+		out := bytes.NewBuffer(nil)
+		err = Reencrypt(keyring, bytes.NewReader(encrypted), out)
+		NoError(t, err)
+
+		header, err := ReadHeader(bytes.NewReader(out.Bytes()))
+		NoError(t, err)
+		Equal(t, keyring.Current().ID, header.KeyID)
+
+		// Only the current key is needed to decrypt the result
+		currentKeyring, err := NewKeyring(testKey)
+		NoError(t, err)
+		decrypted, err := decryptForTest(currentKeyring, out.Bytes())
+		NoError(t, err)
+		Equal(t, data, decrypted)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	fuzzymatcher "github.com/mjarkk/fuzzy-matcher"
 	"github.com/script-development/RT-CV/helpers/jsonHelpers"
 	"github.com/script-development/RT-CV/models"
+	"github.com/script-development/RT-CV/models/matcher"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -19,7 +20,8 @@ type FoundMatch struct {
 }
 
 // Match tries to match a profile to a CV
-func Match(scraperKeyID, requestID primitive.ObjectID, profiles []*models.Profile, cv models.CV) []FoundMatch {
+// If tree is set the desired professions linked to a branch of the matcher tree also match the titles of that branch and its sub branches
+func Match(scraperKeyID, requestID primitive.ObjectID, tree *matcher.Tree, profiles []*models.Profile, cv models.CV) []FoundMatch {
 	res := []FoundMatch{}
 
 	now := time.Now()
@@ -29,7 +31,7 @@ func Match(scraperKeyID, requestID primitive.ObjectID, profiles []*models.Profil
 	sort.Sort(cv.WorkExperiences)
 
 	for _, profile := range profiles {
-		if !profile.Active {
+		if !profile.IsScheduledActive(now) {
 			continue
 		}
 
@@ -45,6 +47,7 @@ func Match(scraperKeyID, requestID primitive.ObjectID, profiles []*models.Profil
 			KeyID:       scraperKeyID,
 			When:        jsonHelpers.RFC3339Nano(now),
 			ReferenceNr: cv.ReferenceNumber,
+			TenantID:    profile.TenantID,
 		}
 
 		// Check domain
@@ -154,6 +157,13 @@ func Match(scraperKeyID, requestID primitive.ObjectID, profiles []*models.Profil
 				}
 				profile.DesiredProfessionsFuzzyMatcherCache = fuzzymatcher.NewMatcher(profileProfessionNames...)
 			}
+			if tree != nil && profile.DesiredProfessionsTreeMatcherIdxCache == nil {
+				titles, titlesIdx := expandProfessions(tree, profile.DesiredProfessions)
+				if len(titles) > 0 {
+					profile.DesiredProfessionsTreeMatcherCache = fuzzymatcher.NewMatcher(titles...)
+				}
+				profile.DesiredProfessionsTreeMatcherIdxCache = titlesIdx
+			}
 
 			for _, cvPreferredJob := range cv.PreferredJobs {
 				if len(cvPreferredJob) == 0 {
@@ -161,6 +171,12 @@ func Match(scraperKeyID, requestID primitive.ObjectID, profiles []*models.Profil
 				}
 
 				matchedDesiredProfession := profile.DesiredProfessionsFuzzyMatcherCache.Match(cvPreferredJob)
+				if matchedDesiredProfession == -1 && profile.DesiredProfessionsTreeMatcherCache != nil {
+					matchedTitle := profile.DesiredProfessionsTreeMatcherCache.Match(cvPreferredJob)
+					if matchedTitle != -1 {
+						matchedDesiredProfession = profile.DesiredProfessionsTreeMatcherIdxCache[matchedTitle]
+					}
+				}
 				if matchedDesiredProfession != -1 {
 					match.DesiredProfession = &profile.DesiredProfessions[matchedDesiredProfession].Name
 					matchedADesiredProfession = true
@@ -334,6 +350,29 @@ func Match(scraperKeyID, requestID primitive.ObjectID, profiles []*models.Profil
 	return res
 }
 
+// expandProfessions returns the matcher tree titles of the professions linked to a tree branch
+// titlesIdx contains for every title the index of the profession it belongs to
+func expandProfessions(tree *matcher.Tree, professions []models.ProfileProfession) (titles []string, titlesIdx []int) {
+	titles = []string{}
+	titlesIdx = []int{}
+	for idx, profession := range professions {
+		if profession.LeafId.IsZero() {
+			continue
+		}
+
+		branchTitles, err := tree.TitlesForBranch(profession.LeafId)
+		if err != nil {
+			// The branch was removed from the tree, only the name of the profession can be matched
+			continue
+		}
+		for _, title := range branchTitles {
+			titles = append(titles, title)
+			titlesIdx = append(titlesIdx, idx)
+		}
+	}
+	return titles, titlesIdx
+}
+
 func totalMonths(t time.Time) int {
 	return t.Year()*12 + int(t.Month()) - 1
 }
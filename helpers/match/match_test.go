@@ -4,9 +4,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
 	"github.com/script-development/RT-CV/helpers/jsonHelpers"
 	"github.com/script-development/RT-CV/mock"
 	"github.com/script-development/RT-CV/models"
+	"github.com/script-development/RT-CV/models/matcher"
 	. "github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -15,7 +18,7 @@ func MustMatchSingle(t *testing.T, p models.Profile, cv models.CV) {
 	p.AllowedScrapers = []primitive.ObjectID{mock.Key2.ID}
 	p.Active = true
 
-	matches := Match(mock.Key2.ID, primitive.NewObjectID(), []*models.Profile{&p}, cv)
+	matches := Match(mock.Key2.ID, primitive.NewObjectID(), nil, []*models.Profile{&p}, cv)
 	Equal(t, 1, len(matches), matches)
 }
 
@@ -23,12 +26,12 @@ func MustNotMatchSingle(t *testing.T, p models.Profile, cv models.CV) {
 	p.AllowedScrapers = []primitive.ObjectID{mock.Key2.ID}
 	p.Active = true
 
-	matches := Match(mock.Key2.ID, primitive.NewObjectID(), []*models.Profile{&p}, cv)
+	matches := Match(mock.Key2.ID, primitive.NewObjectID(), nil, []*models.Profile{&p}, cv)
 	Equal(t, 0, len(matches), matches)
 }
 
 func TestMatchSiteMismatch(t *testing.T) {
-	matches := Match(mock.Key2.ID, primitive.NewObjectID(), []*models.Profile{{
+	matches := Match(mock.Key2.ID, primitive.NewObjectID(), nil, []*models.Profile{{
 		AllowedScrapers: []primitive.ObjectID{mock.Key1.ID},
 		Active:          true,
 	}}, models.CV{})
@@ -36,10 +39,20 @@ func TestMatchSiteMismatch(t *testing.T) {
 }
 
 func TestMatchNonActive(t *testing.T) {
-	matches := Match(mock.Key2.ID, primitive.NewObjectID(), []*models.Profile{{Active: false}}, models.CV{})
+	matches := Match(mock.Key2.ID, primitive.NewObjectID(), nil, []*models.Profile{{Active: false}}, models.CV{})
 	Equal(t, 0, len(matches), matches)
 }
 
+func TestMatchSchedule(t *testing.T) {
+	tomorrow := time.Now().Add(24 * time.Hour)
+	yesterday := time.Now().Add(-24 * time.Hour)
+
+	MustMatchSingle(t, models.Profile{ActiveUntil: &tomorrow}, models.CV{})
+	MustNotMatchSingle(t, models.Profile{ActiveFrom: &tomorrow}, models.CV{})
+	MustNotMatchSingle(t, models.Profile{ActiveUntil: &yesterday}, models.CV{})
+	MustNotMatchSingle(t, models.Profile{MaxMatchesTotal: 1, MatchCount: models.ProfileMatchCount{Total: 1}}, models.CV{})
+}
+
 func TestMatchEmptyProfile(t *testing.T) {
 	MustMatchSingle(t, models.Profile{}, models.CV{})
 }
@@ -222,6 +235,63 @@ func TestMatchDesiredProfession(t *testing.T) {
 	)
 }
 
+func TestMatchDesiredProfessionTree(t *testing.T) {
+	dbConn := testingdb.NewDB()
+	driver := &matcher.Branch{M: db.NewM(), Titles: []string{"Vrachtwagenchauffeur", "Truckchauffeur"}, TitleKind: matcher.Job}
+	pilot := &matcher.Branch{M: db.NewM(), Titles: []string{"Piloot"}, TitleKind: matcher.Job}
+	transport := &matcher.Branch{
+		M:         db.NewM(),
+		Titles:    []string{"Transport"},
+		TitleKind: matcher.Sector,
+		Branches:  []primitive.ObjectID{driver.ID, pilot.ID},
+	}
+	err := dbConn.Insert(driver, pilot, transport)
+	NoError(t, err)
+	tree, err := matcher.NewTree(dbConn)
+	NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		leafID        primitive.ObjectID
+		preferredJob  string
+		expectedMatch bool
+	}{
+		{"synonym of leaf", driver.ID, "Truckchauffeur", true},
+		{"job in sector", transport.ID, "Piloot", true},
+		{"job outside of leaf", driver.ID, "Piloot", false},
+		{"unknown leaf", primitive.NewObjectID(), "Truckchauffeur", false},
+		{"no leaf", primitive.NilObjectID, "Truckchauffeur", false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			profile := &models.Profile{
+				Active:                true,
+				MustDesiredProfession: true,
+				DesiredProfessions:    []models.ProfileProfession{{Name: "Chauffeur", LeafId: testCase.leafID}},
+			}
+			cv := models.CV{PreferredJobs: []string{testCase.preferredJob}}
+
+			matches := Match(mock.Key2.ID, primitive.NewObjectID(), tree, []*models.Profile{profile}, cv)
+			if !testCase.expectedMatch {
+				Len(t, matches, 0)
+				return
+			}
+			if Len(t, matches, 1) {
+				Equal(t, "Chauffeur", *matches[0].Matches.DesiredProfession)
+			}
+
+			// Without the tree only the name of the profession is matched but the cached expanded professions are still used
+			Len(t, Match(mock.Key2.ID, primitive.NewObjectID(), nil, []*models.Profile{profile}, cv), 1)
+			Len(t, Match(mock.Key2.ID, primitive.NewObjectID(), nil, []*models.Profile{{
+				Active:                true,
+				MustDesiredProfession: true,
+				DesiredProfessions:    profile.DesiredProfessions,
+			}}, cv), 0)
+		})
+	}
+}
+
 func TestMatchDesiredProfessionExperienced(t *testing.T) {
 	// Match on profession experienced
 	MustMatchSingle(
@@ -310,3 +380,103 @@ func TestYearSince(t *testing.T) {
 		)
 	}
 }
+
+func simulationCVs() []models.HistoricCV {
+	now := time.Now()
+	cv := func(hoursAgo int, referenceNr string, keyID primitive.ObjectID, zip string, preferredJob string) models.HistoricCV {
+		return models.HistoricCV{
+			KeyID:     keyID,
+			ScannedAt: now.Add(-time.Duration(hoursAgo) * time.Hour),
+			CV: models.CV{
+				ReferenceNumber: referenceNr,
+				PersonalDetails: models.PersonalDetails{Zip: zip},
+				PreferredJobs:   []string{preferredJob},
+			},
+		}
+	}
+	// Sorted newest first like the cv history returns them
+	return []models.HistoricCV{
+		cv(1, "a", mock.Key2.ID, "1500AB", "Software developer"),
+		cv(2, "bb", mock.Key2.ID, "1500AB", "Chauffeur"),
+		cv(3, "ccc", mock.Key2.ID, "9000AB", "Software developer"),
+		cv(4, "dddd", mock.Key1.ID, "1500AB", "Software developer"),
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	cvs := simulationCVs()
+	profile := models.Profile{
+		// Simulations ignore the active state of the profile
+		Active:                false,
+		AllowedScrapers:       []primitive.ObjectID{mock.Key2.ID},
+		MustDesiredProfession: true,
+		DesiredProfessions:    []models.ProfileProfession{{Name: "Software developer"}},
+		Zipcodes:              []models.ProfileDutchZipcode{{From: 1000, To: 2000}},
+	}
+
+	res := Simulate(nil, profile, cvs)
+	Equal(t, 4, res.CVs)
+	Equal(t, 1, res.Matches)
+	Equal(t, []string{"a"}, res.SampleReferenceNumbers)
+	Equal(t, cvs[0].ScannedAt, *res.To)
+	Equal(t, cvs[3].ScannedAt, *res.From)
+
+	remaining := map[string]int{}
+	for _, step := range res.Funnel {
+		remaining[step.Criterion] = step.Remaining
+		if step.Criterion == "educations" || step.Criterion == "optionalCriteria" {
+			False(t, step.Applied, step.Criterion)
+		}
+	}
+	Equal(t, 3, remaining["allowedScrapers"])
+	Equal(t, 2, remaining["desiredProfessions"])
+	Equal(t, 1, remaining["zipCodes"])
+	Equal(t, res.Matches, res.Funnel[len(res.Funnel)-1].Remaining)
+
+	// Without criteria every CV matches
+	res = Simulate(nil, models.Profile{}, cvs)
+	Equal(t, 4, res.Matches)
+	Equal(t, 0, Simulate(nil, profile, nil).Matches)
+}
+
+func TestSimulateOptionalCriteria(t *testing.T) {
+	res := Simulate(nil, models.Profile{
+		DesiredProfessions: []models.ProfileProfession{{Name: "Chauffeur"}},
+		Zipcodes:           []models.ProfileDutchZipcode{{From: 1000, To: 2000}},
+	}, simulationCVs())
+	Equal(t, 1, res.Matches)
+	Equal(t, []string{"bb"}, res.SampleReferenceNumbers)
+
+	last := res.Funnel[len(res.Funnel)-1]
+	Equal(t, "optionalCriteria", last.Criterion)
+	True(t, last.Applied)
+	Equal(t, 1, last.Remaining)
+}
+
+func TestCompareSimulations(t *testing.T) {
+	current := models.Profile{
+		MustDesiredProfession: true,
+		DesiredProfessions:    []models.ProfileProfession{{Name: "Software developer"}},
+	}
+	edited := current
+	edited.Zipcodes = []models.ProfileDutchZipcode{{From: 1000, To: 2000}}
+	edited.AllowedScrapers = []primitive.ObjectID{mock.Key1.ID}
+
+	res := Compare(nil, current, edited, simulationCVs())
+	Equal(t, 3, res.Current.Matches)
+	Equal(t, 1, res.Edited.Matches)
+	Equal(t, 2, res.OnlyCurrent)
+	Equal(t, []string{"a", "ccc"}, res.SampleOnlyCurrent)
+	Equal(t, 0, res.OnlyEdited)
+	Empty(t, res.SampleOnlyEdited)
+	// The numbers count scans so a CV scanned again by another key is counted for both scans
+	cvs := simulationCVs()
+	rescanned := cvs[3]
+	rescanned.KeyID = mock.Key2.ID
+	cvs = append(cvs, rescanned)
+	res = Compare(nil, current, edited, cvs)
+	Equal(t, 4, res.Current.Matches)
+	Equal(t, 1, res.Edited.Matches)
+	Equal(t, 3, res.OnlyCurrent)
+	Equal(t, []string{"a", "ccc", "dddd"}, res.SampleOnlyCurrent)
+}
@@ -0,0 +1,230 @@
+package match
+
+import (
+	"time"
+
+	"github.com/script-development/RT-CV/models"
+	"github.com/script-development/RT-CV/models/matcher"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SimulationSampleSize is the maximum amount of reference numbers returned as sample by a simulation
+const SimulationSampleSize = 10
+
+// FunnelStep is a step of the criterion funnel of a simulation
+type FunnelStep struct {
+	Criterion string `json:"criterion"`
+	Applied   bool   `json:"applied" description:"False if the profile does not use this criterion, remaining is then equal to the previous step"`
+	Remaining int    `json:"remaining" description:"The number of scanned CVs that pass this criterion and all the criteria of the previous steps"`
+}
+
+// SimulationResult contains the matches a profile would have made with the CVs of the CV history
+// All numbers count scanned CVs, a CV that was scanned multiple times is counted once for every scan
+type SimulationResult struct {
+	CVs                    int          `json:"cvs" description:"The number of scanned CVs from the CV history the profile was tested against, a CV scanned multiple times is counted for every scan"`
+	From                   *time.Time   `json:"from" description:"When the oldest CV the profile was tested against was scanned, null if there are no CVs"`
+	To                     *time.Time   `json:"to" description:"When the newest CV the profile was tested against was scanned, null if there are no CVs"`
+	Matches                int          `json:"matches" description:"The number of scanned CVs the profile matched"`
+	Funnel                 []FunnelStep `json:"funnel" description:"The number of scanned CVs left after applying every criterion of the profile, the last step equals the number of matches"`
+	SampleReferenceNumbers []string     `json:"sampleReferenceNumbers" description:"The unique reference numbers of the newest matched CVs"`
+
+	// matched contains the indexes of the matched CVs in the simulated CVs
+	matched []int
+}
+
+// SimulationComparison compares the simulated matches of the current version of a profile with an edited version
+// Like SimulationResult all numbers count scanned CVs
+type SimulationComparison struct {
+	Current           SimulationResult `json:"current"`
+	Edited            SimulationResult `json:"edited"`
+	OnlyCurrent       int              `json:"onlyCurrent" description:"The number of scanned CVs only matched by the current version"`
+	OnlyEdited        int              `json:"onlyEdited" description:"The number of scanned CVs only matched by the edited version"`
+	SampleOnlyCurrent []string         `json:"sampleOnlyCurrent" description:"The unique reference numbers of the newest CVs only matched by the current version"`
+	SampleOnlyEdited  []string         `json:"sampleOnlyEdited" description:"The unique reference numbers of the newest CVs only matched by the edited version"`
+}
+
+// simulationCriteria are the steps of the funnel in the order they are applied
+// Every step only adds the criteria a CV must meet, the last step also adds the optional criteria of which a CV must meet at least one
+var simulationCriteria = []struct {
+	name  string
+	apply func(dst *models.Profile, src models.Profile) (applied bool)
+}{
+	{"allowedScrapers", func(dst *models.Profile, src models.Profile) bool {
+		dst.AllowedScrapers = src.AllowedScrapers
+		return len(src.AllowedScrapers) > 0
+	}},
+	{"educations", func(dst *models.Profile, src models.Profile) bool {
+		if !src.MustEducation || len(src.Educations) == 0 {
+			return false
+		}
+		dst.MustEducation = true
+		dst.MustEducationFinished = src.MustEducationFinished
+		dst.Educations = src.Educations
+		return true
+	}},
+	{"yearsSinceEducation", func(dst *models.Profile, src models.Profile) bool {
+		dst.YearsSinceEducation = src.YearsSinceEducation
+		return src.YearsSinceEducation != nil && *src.YearsSinceEducation > 0
+	}},
+	{"desiredProfessions", func(dst *models.Profile, src models.Profile) bool {
+		if !src.MustDesiredProfession || len(src.DesiredProfessions) == 0 {
+			return false
+		}
+		dst.MustDesiredProfession = true
+		dst.DesiredProfessions = src.DesiredProfessions
+		return true
+	}},
+	{"professionExperienced", func(dst *models.Profile, src models.Profile) bool {
+		if !src.MustExpProfession || len(src.ProfessionExperienced) == 0 {
+			return false
+		}
+		dst.MustExpProfession = true
+		dst.ProfessionExperienced = src.ProfessionExperienced
+		return true
+	}},
+	{"yearsSinceWork", func(dst *models.Profile, src models.Profile) bool {
+		dst.YearsSinceWork = src.YearsSinceWork
+		return src.YearsSinceWork != nil && *src.YearsSinceWork > 0
+	}},
+	{"driversLicenses", func(dst *models.Profile, src models.Profile) bool {
+		if !src.MustDriversLicense || len(src.DriversLicenses) == 0 {
+			return false
+		}
+		dst.MustDriversLicense = true
+		dst.DriversLicenses = src.DriversLicenses
+		return true
+	}},
+	{"zipCodes", func(dst *models.Profile, src models.Profile) bool {
+		dst.Zipcodes = src.Zipcodes
+		return len(src.Zipcodes) > 0
+	}},
+	{"optionalCriteria", func(dst *models.Profile, src models.Profile) bool {
+		applied := len(dst.Educations) != len(src.Educations) ||
+			len(dst.DesiredProfessions) != len(src.DesiredProfessions) ||
+			len(dst.ProfessionExperienced) != len(src.ProfessionExperienced) ||
+			len(dst.DriversLicenses) != len(src.DriversLicenses)
+		*dst = simulationProfile(src)
+		return applied
+	}},
+}
+
+// simulationProfile returns a copy of profile that matches regardless of its active state, schedule and match budget
+func simulationProfile(profile models.Profile) models.Profile {
+	return models.Profile{
+		M:                     profile.M,
+		T:                     profile.T,
+		Name:                  profile.Name,
+		Active:                true,
+		AllowedScrapers:       profile.AllowedScrapers,
+		MustDesiredProfession: profile.MustDesiredProfession,
+		DesiredProfessions:    profile.DesiredProfessions,
+		YearsSinceWork:        profile.YearsSinceWork,
+		MustExpProfession:     profile.MustExpProfession,
+		ProfessionExperienced: profile.ProfessionExperienced,
+		MustDriversLicense:    profile.MustDriversLicense,
+		DriversLicenses:       profile.DriversLicenses,
+		MustEducationFinished: profile.MustEducationFinished,
+		MustEducation:         profile.MustEducation,
+		YearsSinceEducation:   profile.YearsSinceEducation,
+		Educations:            profile.Educations,
+		Zipcodes:              profile.Zipcodes,
+	}
+}
+
+// Simulate tests profile against the CVs of the CV history as if it was active while the CVs where scanned
+// The active state, schedule and match budget of the profile are ignored
+func Simulate(tree *matcher.Tree, profile models.Profile, cvs []models.HistoricCV) SimulationResult {
+	res := SimulationResult{
+		CVs:                    len(cvs),
+		Funnel:                 make([]FunnelStep, len(simulationCriteria)),
+		SampleReferenceNumbers: []string{},
+	}
+	if len(cvs) > 0 {
+		// The CVs of the CV history are sorted newest first
+		res.To = &cvs[0].ScannedAt
+		res.From = &cvs[len(cvs)-1].ScannedAt
+	}
+
+	remaining := make([]int, len(cvs))
+	for idx := range cvs {
+		remaining[idx] = idx
+	}
+	stepProfile := models.Profile{M: profile.M, T: profile.T, Active: true}
+	for idx, criterion := range simulationCriteria {
+		applied := criterion.apply(&stepProfile, profile)
+		res.Funnel[idx] = FunnelStep{Criterion: criterion.name, Applied: applied}
+		if applied {
+			// Match caches data on the profile, copy the profile so every step starts without caches
+			matchProfile := stepProfile
+			remaining = matchingCVs(tree, &matchProfile, cvs, remaining)
+		}
+		res.Funnel[idx].Remaining = len(remaining)
+	}
+
+	res.Matches = len(remaining)
+	res.matched = remaining
+	res.SampleReferenceNumbers = sample(cvs, remaining)
+	return res
+}
+
+// matchingCVs returns the indexes of the CVs matched by profile, only the CVs at the indexes of candidates are tested
+func matchingCVs(tree *matcher.Tree, profile *models.Profile, cvs []models.HistoricCV, candidates []int) []int {
+	res := []int{}
+	profiles := []*models.Profile{profile}
+	for _, idx := range candidates {
+		cv := cvs[idx]
+		if len(Match(cv.KeyID, primitive.NilObjectID, tree, profiles, cv.CV)) > 0 {
+			res = append(res, idx)
+		}
+	}
+	return res
+}
+
+// Compare simulates the current and the edited version of a profile and returns the differences
+func Compare(tree *matcher.Tree, current, edited models.Profile, cvs []models.HistoricCV) SimulationComparison {
+	res := SimulationComparison{
+		Current: Simulate(tree, current, cvs),
+		Edited:  Simulate(tree, edited, cvs),
+	}
+
+	onlyCurrent := difference(res.Current.matched, res.Edited.matched)
+	onlyEdited := difference(res.Edited.matched, res.Current.matched)
+	res.OnlyCurrent = len(onlyCurrent)
+	res.OnlyEdited = len(onlyEdited)
+	res.SampleOnlyCurrent = sample(cvs, onlyCurrent)
+	res.SampleOnlyEdited = sample(cvs, onlyEdited)
+	return res
+}
+
+// difference returns the CV indexes in a that are not in b
+func difference(a, b []int) []int {
+	inB := map[int]bool{}
+	for _, idx := range b {
+		inB[idx] = true
+	}
+	res := []int{}
+	for _, idx := range a {
+		if !inB[idx] {
+			res = append(res, idx)
+		}
+	}
+	return res
+}
+
+// sample returns the first SimulationSampleSize unique reference numbers of the CVs at the indexes
+func sample(cvs []models.HistoricCV, indexes []int) []string {
+	res := []string{}
+	seen := map[string]bool{}
+	for _, idx := range indexes {
+		if len(res) == SimulationSampleSize {
+			break
+		}
+		referenceNr := cvs[idx].CV.ReferenceNumber
+		if seen[referenceNr] {
+			continue
+		}
+		seen[referenceNr] = true
+		res = append(res, referenceNr)
+	}
+	return res
+}
@@ -220,6 +220,14 @@ func getHandlers(middleware []M, route *R) []func(*fiber.Ctx) error {
 	return handlers
 }
 
+// handlers returns the middlewares of the router followed by the handlers of middleware and route
+// The result is always a new slice so routes and groups never share (and overwrite) each others handlers
+func (r *Router) handlers(middleware []M, route *R) []func(*fiber.Ctx) error {
+	handlers := make([]func(*fiber.Ctx) error, len(r.middlewares), len(r.middlewares)+len(middleware)+1)
+	copy(handlers, r.middlewares)
+	return append(handlers, getHandlers(middleware, route)...)
+}
+
 // Group prefixes the routes within the group with a route and adds a middleware to them if specified
 func (r *Router) Group(prefix string, group func(*Router), middlewares ...M) {
 	group(&Router{
@@ -227,7 +235,7 @@ func (r *Router) Group(prefix string, group func(*Router), middlewares ...M) {
 		prefix:      r.appendPrefix(prefix),
 		fiber:       r.fiber.Group(prefix),
 		base:        r.base,
-		middlewares: append(r.middlewares, getHandlers(middlewares, nil)...),
+		middlewares: r.handlers(middlewares, nil),
 	})
 }
 
@@ -235,35 +243,35 @@ func (r *Router) Group(prefix string, group func(*Router), middlewares ...M) {
 func (r *Router) Get(prefix string, routeDefinition R, middlewares ...M) {
 	routeDefinition.check()
 	r.newRoute(prefix, Get, routeDefinition, middlewares)
-	r.fiber.Get(prefix, append(r.middlewares, getHandlers(middlewares, &routeDefinition)...)...)
+	r.fiber.Get(prefix, r.handlers(middlewares, &routeDefinition)...)
 }
 
 // Post defines a POST route
 func (r *Router) Post(prefix string, routeDefinition R, middlewares ...M) {
 	routeDefinition.check()
 	r.newRoute(prefix, Post, routeDefinition, middlewares)
-	r.fiber.Post(prefix, append(r.middlewares, getHandlers(middlewares, &routeDefinition)...)...)
+	r.fiber.Post(prefix, r.handlers(middlewares, &routeDefinition)...)
 }
 
 // Put defines a PUT route
 func (r *Router) Put(prefix string, routeDefinition R, middlewares ...M) {
 	routeDefinition.check()
 	r.newRoute(prefix, Put, routeDefinition, middlewares)
-	r.fiber.Put(prefix, append(r.middlewares, getHandlers(middlewares, &routeDefinition)...)...)
+	r.fiber.Put(prefix, r.handlers(middlewares, &routeDefinition)...)
 }
 
 // Patch defines a PATCH route
 func (r *Router) Patch(prefix string, routeDefinition R, middlewares ...M) {
 	routeDefinition.check()
 	r.newRoute(prefix, Patch, routeDefinition, middlewares)
-	r.fiber.Patch(prefix, append(r.middlewares, getHandlers(middlewares, &routeDefinition)...)...)
+	r.fiber.Patch(prefix, r.handlers(middlewares, &routeDefinition)...)
 }
 
 // Delete defines a DELETE route
 func (r *Router) Delete(prefix string, routeDefinition R, middlewares ...M) {
 	routeDefinition.check()
 	r.newRoute(prefix, Delete, routeDefinition, middlewares)
-	r.fiber.Delete(prefix, append(r.middlewares, getHandlers(middlewares, &routeDefinition)...)...)
+	r.fiber.Delete(prefix, r.handlers(middlewares, &routeDefinition)...)
 }
 
 // Static defines a static file path
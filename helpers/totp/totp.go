@@ -0,0 +1,103 @@
+// Package totp implements time based one time passwords as described in RFC 6238
+// These are the codes generated by authenticator apps like Google Authenticator
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is the amount of time a code is valid
+	Period = 30 * time.Second
+	// Digits is the amount of digits a code has
+	Digits = 6
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret generates a new random base32 encoded secret
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// Code returns the code for the secret at a specific time
+func Code(secret string, at time.Time) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	return code(key, uint64(Step(at))), nil
+}
+
+// Step returns the number of the period a time is in, every period has its own code
+func Step(at time.Time) int64 {
+	return at.Unix() / int64(Period.Seconds())
+}
+
+func code(key []byte, counter uint64) string {
+	counterBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(counterBytes, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counterBytes)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, see RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// Validate checks if the code is valid for the secret at a specific time and returns the step of the code
+// To allow for clock drift the code of the previous and next period are also accepted
+// Codes of lastUsedStep or earlier are rejected so a code can only be used once, store the returned step and pass it as lastUsedStep the next time
+func Validate(secret, userCode string, at time.Time, lastUsedStep int64) (step int64, ok bool) {
+	userCode = strings.TrimSpace(userCode)
+	if len(userCode) != Digits {
+		return 0, false
+	}
+
+	for _, offset := range []time.Duration{0, -Period, Period} {
+		codeAt := at.Add(offset)
+		expectedCode, err := Code(secret, codeAt)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expectedCode), []byte(userCode)) == 1 {
+			step = Step(codeAt)
+			return step, step > lastUsedStep
+		}
+	}
+	return 0, false
+}
+
+// URL returns a otpauth:// url that can be converted into a QR code and scanned by an authenticator app
+func URL(issuer, accountName, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + accountName)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
@@ -0,0 +1,56 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestCode(t *testing.T) {
+	// Test vectors from RFC 6238 appendix B, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	testCases := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, testCase := range testCases {
+		code, err := Code(secret, time.Unix(testCase.unix, 0))
+		NoError(t, err)
+		Equal(t, testCase.expected, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	NoError(t, err)
+
+	now := time.Now()
+	code, err := Code(secret, now)
+	NoError(t, err)
+
+	step, ok := Validate(secret, code, now, 0)
+	True(t, ok)
+	Equal(t, Step(now), step)
+	_, ok = Validate(secret, code, now.Add(Period), 0)
+	True(t, ok)
+	_, ok = Validate(secret, code, now.Add(Period*3), 0)
+	False(t, ok)
+	_, ok = Validate(secret, "12345", now, 0)
+	False(t, ok)
+
+	// A code cannot be used again once it's step is used
+	_, ok = Validate(secret, code, now, step)
+	False(t, ok)
+	_, ok = Validate(secret, code, now.Add(Period), step-1)
+	True(t, ok)
+}
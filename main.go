@@ -16,8 +16,11 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/script-development/RT-CV/controller"
 	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/filedb"
+	"github.com/script-development/RT-CV/db/migrations"
 	"github.com/script-development/RT-CV/db/mongo"
 	"github.com/script-development/RT-CV/db/mongo/backup"
+	"github.com/script-development/RT-CV/db/testingdb"
 	"github.com/script-development/RT-CV/helpers/random"
 	"github.com/script-development/RT-CV/helpers/requestLogger"
 	"github.com/script-development/RT-CV/helpers/slack"
@@ -33,12 +36,27 @@ import (
 var AppVersion = "LOCAL"
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			os.Exit(restoreCommand(os.Args[2:]))
+		case "archive":
+			os.Exit(archiveCommand(os.Args[2:]))
+		case "rekey":
+			os.Exit(rekeyCommand(os.Args[2:]))
+		case "tree":
+			os.Exit(treeCommand(os.Args[2:]))
+		}
+	}
+
 	doProfile := false
 	forceBackup := false
 	restoreBackup := ""
+	migrateDryRun := false
 	flag.BoolVar(&doProfile, "profile", false, "start profiling")
 	flag.BoolVar(&forceBackup, "forceBackup", false, "force a creating a backup")
-	flag.StringVar(&restoreBackup, "restoreBackup", "", "select a backup file to restore into the database")
+	flag.StringVar(&restoreBackup, "restoreBackup", "", "select a backup file from the backup storage to restore into the database (deprecated, use the restore subcommand)")
+	flag.BoolVar(&migrateDryRun, "migrateDryRun", false, "show the pending database migrations without applying them and exit")
 	flag.Parse()
 	if restoreBackup == "" {
 		restoreBackup = os.Getenv("RESTORE_BACKUP")
@@ -70,59 +88,73 @@ func main() {
 		}()
 	}
 
-	// Loading the .env if available
-	_, err := os.Stat(".env")
-	if err == nil {
-		err := godotenv.Load()
-		if err != nil {
-			log.Fatalf("Error loading .env file: %s", err.Error())
-		}
-	} else {
-		log.Info("No .env file found")
-	}
-
-	slackWebHookURL := strings.TrimSpace(os.Getenv("SLACK_WEBHOOK_URL"))
-	if slackWebHookURL != "" {
-		slack.SetupLogHandler(slackWebHookURL)
-	}
+	loadEnv()
 
 	// Initialize the database
-	var dbConn db.Connection
-	useTestingDB := strings.ToLower(os.Getenv("USE_TESTING_DB")) == "true"
-	if useTestingDB {
-		dbConn = mock.NewMockDB()
-		log.WithField("id", mock.DashboardKey.ID.Hex()).WithField("key", mock.DashboardKey.Key).Info("Mock dashboard key")
-		if restoreBackup != "" {
+	dbConn, useTestingDB := connectToDB()
+	if restoreBackup != "" {
+		if useTestingDB {
 			log.Fatal("Restoring a backup is not supported in the testing database")
 		}
-	} else {
-		dbConn = mongo.ConnectToDB()
-		if restoreBackup != "" {
-			backup.Restore(dbConn, restoreBackup, backup.StartScheduleOptionsFromEnv())
-			os.Exit(0)
-		}
+		os.Exit(runRestore(dbConn, restoreBackup, backup.RestoreOptions{}))
+	}
+
+	// The migrations run before the other entries are registered so they can fix data that would break the creation of their indexes
+	dbConn.RegisterEntries(&migrations.AppliedMigration{})
+	_, err := migrations.Run(dbConn, models.Migrations, migrateDryRun)
+	if err != nil {
+		log.WithError(err).Fatal("running the database migrations failed")
+	}
+	if migrateDryRun {
+		os.Exit(0)
 	}
 
 	dbConn.RegisterEntries(
 		&models.APIKey{},
 		&models.Profile{},
 		&models.Backup{},
+		&models.BackupCatalogEntry{},
 		&models.OnMatchHook{},
 		&matcher.Branch{},
 		&models.ScraperLoginUsers{},
+		&models.DashboardUser{},
+		&models.Session{},
+		&models.AuditLogEntry{},
+		&models.ProfileRevision{},
+		&models.Tenant{},
+		&models.CVHistoryEntry{},
 	)
 
+	var backups *backup.Scheduler
 	backupEnabled := strings.ToLower(os.Getenv("MONGODB_BACKUP_ENABLED")) == "true"
 	if backupEnabled {
 		if useTestingDB {
 			log.Warn("Backup is not supported in testing mode")
 		} else {
-			backup.StartsSchedule(dbConn, backup.StartScheduleOptionsFromEnv(), forceBackup)
+			backups, err = backup.StartsSchedule(dbConn, backup.StartScheduleOptionsFromEnv(), forceBackup)
+			if err != nil {
+				log.WithError(err).Fatal("Error initializing backup")
+			}
 		}
 	}
 
+	controller.StartProfileScheduler(dbConn)
+	controller.StartProfileRevisionPruner(dbConn)
+
+	cvHistory, err := models.CVHistoryFromEnv()
+	if err != nil {
+		log.WithError(err).Fatal("Error initializing the cv history")
+	}
+	if cvHistory != nil {
+		controller.StartCVHistoryPruner(dbConn, cvHistory)
+	}
+
 	models.CheckDashboardKeyExists(dbConn)
 
+	if os.Getenv("SESSION_SECRET") == "" {
+		log.Warn("$SESSION_SECRET not set, dashboard user sessions won't survive a restart and are not shared between replicas")
+	}
+
 	// Create a new fiber instance (http server)
 	// do not use fiber Prefork!, this service is not written to support it
 	app := fiber.New(fiber.Config{
@@ -139,7 +171,7 @@ func main() {
 		c.Set("X-App-Version", AppVersion)
 		return err
 	})
-	app.Use(controller.InsertData(dbConn))
+	app.Use(controller.InsertData(dbConn, backups, cvHistory))
 	app.Use(requestLogger.New())
 
 	// Setup the app routes
@@ -154,3 +186,42 @@ func main() {
 	// Start the webserver
 	log.Fatal(app.Listen(":4000").Error())
 }
+
+// loadEnv loads the .env file if available and sets up the log handlers configured in the env
+func loadEnv() {
+	_, err := os.Stat(".env")
+	if err == nil {
+		err := godotenv.Load()
+		if err != nil {
+			log.Fatalf("Error loading .env file: %s", err.Error())
+		}
+	} else {
+		log.Info("No .env file found")
+	}
+
+	slackWebHookURL := strings.TrimSpace(os.Getenv("SLACK_WEBHOOK_URL"))
+	if slackWebHookURL != "" {
+		slack.SetupLogHandler(slackWebHookURL)
+	}
+}
+
+// connectToDB connects to the database selected in the env
+// useTestingDB is true if the database is the mock database
+func connectToDB() (dbConn db.Connection, useTestingDB bool) {
+	if strings.ToLower(os.Getenv("USE_TESTING_DB")) == "true" {
+		log.WithField("id", mock.DashboardKey.ID.Hex()).WithField("key", mock.DashboardKey.Key).Info("Mock dashboard key")
+		return mock.NewMockDB(), true
+	}
+
+	fileDBPath := os.Getenv("FILE_DB_PATH")
+	if fileDBPath != "" {
+		store, err := filedb.NewStore(fileDBPath)
+		if err != nil {
+			log.WithError(err).Fatal("unable to open the file database")
+		}
+		log.WithField("path", fileDBPath).Info("Using the file database")
+		return testingdb.NewDBWithStore(store), false
+	}
+
+	return mongo.ConnectToDB(), false
+}
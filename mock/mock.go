@@ -21,7 +21,7 @@ func init() {
 		Name:    "Key with all roles",
 		Enabled: true,
 		Domains: []string{"werk.nl"},
-		Key:     "aaa",
+		Key:     "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
 		Roles:   models.APIKeyRoleAll,
 	}
 	// Key2 is a mock api key
@@ -30,7 +30,7 @@ func init() {
 		Name:    "Scraper key",
 		Enabled: true,
 		Domains: []string{"werk.nl"},
-		Key:     "bbb",
+		Key:     "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
 		Roles:   models.APIKeyRoleScraper,
 	}
 	// Key3 is a mock api key
@@ -39,7 +39,7 @@ func init() {
 		Name:    "Information obtainer key",
 		Enabled: true,
 		Domains: []string{"werk.nl"},
-		Key:     "ccc",
+		Key:     "cccccccccccccccccccccccccccccccc",
 		Roles:   models.APIKeyRoleInformationObtainer,
 	}
 	// DashboardKey is the mock key for the dashboard
@@ -51,8 +51,8 @@ func init() {
 		System:  true,
 		Enabled: true,
 		Domains: []string{"*"},
-		Key:     "ddd",
-		Roles:   models.APIKeyRoleDashboard,
+		Key:     "dddddddddddddddddddddddddddddddd",
+		Roles:   models.APIKeyRoleDashboard | models.APIKeyRoleAdmin,
 	}
 
 	// Profile1 contains the first example profile
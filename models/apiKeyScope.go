@@ -0,0 +1,287 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyScope limits the resources an api key has access to
+// A nil scope means the key can access everything its roles allow
+type APIKeyScope struct {
+	ReadOnly bool `json:"readOnly" bson:"readOnly" description:"If true the key can only read data, routes that modify server state are forbidden"`
+
+	// ProfileIDs and ProfileLabels together define the profiles this key can access
+	// If both are empty the key can access all profiles
+	ProfileIDs    []primitive.ObjectID `json:"profileIds" bson:"profileIds" description:"The profiles this key can access, if both profileIds and profileLabels are empty all profiles can be accessed"`
+	ProfileLabels map[string]any       `json:"profileLabels" bson:"profileLabels" description:"Label selector, profiles that have all of these labels with equal values can be accessed by this key"`
+
+	HookIDs []primitive.ObjectID `json:"hookIds" bson:"hookIds" description:"The on match hooks this key can access, if empty all hooks can be accessed"`
+}
+
+// Validate validates the scope
+func (s *APIKeyScope) Validate() error {
+	if s == nil {
+		return nil
+	}
+
+	for key := range s.ProfileLabels {
+		if key == "" {
+			return errors.New("scope.profileLabels cannot contain an empty key")
+		}
+	}
+	for idx, id := range s.ProfileIDs {
+		if id.IsZero() {
+			return fmt.Errorf("scope.profileIds[%d] is an invalid id", idx)
+		}
+	}
+	for idx, id := range s.HookIDs {
+		if id.IsZero() {
+			return fmt.Errorf("scope.hookIds[%d] is an invalid id", idx)
+		}
+	}
+
+	return nil
+}
+
+// CanWrite returns true if the scope allows modifying server state
+func (s *APIKeyScope) CanWrite() bool {
+	return s == nil || !s.ReadOnly
+}
+
+// Contains returns true if other does not give access to anything outside of this scope
+// Keys with a scope use this to make sure they can only create and modify keys within their own scope
+func (s *APIKeyScope) Contains(other *APIKeyScope) bool {
+	if s == nil {
+		return true
+	}
+	if other == nil {
+		return false
+	}
+
+	if s.ReadOnly && !other.ReadOnly {
+		return false
+	}
+
+	if s.RestrictsProfiles() {
+		if !other.RestrictsProfiles() || !idsContain(s.ProfileIDs, other.ProfileIDs) {
+			return false
+		}
+		if len(other.ProfileLabels) > 0 {
+			// The label selector of other must be at least as strict as the label selector of this scope
+			if len(s.ProfileLabels) == 0 {
+				return false
+			}
+			for key, expectedValue := range s.ProfileLabels {
+				value, ok := other.ProfileLabels[key]
+				if !ok || !labelValuesEqual(value, expectedValue) {
+					return false
+				}
+			}
+		}
+	}
+
+	if s.RestrictsHooks() && (!other.RestrictsHooks() || !idsContain(s.HookIDs, other.HookIDs)) {
+		return false
+	}
+
+	return true
+}
+
+// idsContain returns true if all of the ids in other are in ids
+func idsContain(ids, other []primitive.ObjectID) bool {
+	for _, otherID := range other {
+		found := false
+		for _, id := range ids {
+			if id == otherID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// RestrictsProfiles returns true if the scope limits the profiles that can be accessed
+func (s *APIKeyScope) RestrictsProfiles() bool {
+	return s != nil && (len(s.ProfileIDs) > 0 || len(s.ProfileLabels) > 0)
+}
+
+// ProfileAllowed returns true if the profile can be accessed within this scope
+// A profile is allowed if its ID is within ProfileIDs or if its labels match the ProfileLabels selector
+func (s *APIKeyScope) ProfileAllowed(profile *Profile) bool {
+	if !s.RestrictsProfiles() {
+		return true
+	}
+
+	for _, id := range s.ProfileIDs {
+		if id == profile.ID {
+			return true
+		}
+	}
+
+	if len(s.ProfileLabels) == 0 {
+		return false
+	}
+	for key, expectedValue := range s.ProfileLabels {
+		value, ok := profile.Lables[key]
+		if !ok || !labelValuesEqual(value, expectedValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterProfiles returns only the profiles that can be accessed within this scope
+func (s *APIKeyScope) FilterProfiles(profiles []Profile) []Profile {
+	if !s.RestrictsProfiles() {
+		return profiles
+	}
+
+	res := []Profile{}
+	for _, profile := range profiles {
+		if s.ProfileAllowed(&profile) {
+			res = append(res, profile)
+		}
+	}
+	return res
+}
+
+// RestrictsHooks returns true if the scope limits the on match hooks that can be accessed
+func (s *APIKeyScope) RestrictsHooks() bool {
+	return s != nil && len(s.HookIDs) > 0
+}
+
+// HookAllowed returns true if the on match hook can be accessed within this scope
+func (s *APIKeyScope) HookAllowed(id primitive.ObjectID) bool {
+	if !s.RestrictsHooks() {
+		return true
+	}
+
+	for _, allowedID := range s.HookIDs {
+		if allowedID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterHooks returns only the on match hooks that can be accessed within this scope
+func (s *APIKeyScope) FilterHooks(hooks []OnMatchHook) []OnMatchHook {
+	if !s.RestrictsHooks() {
+		return hooks
+	}
+
+	res := []OnMatchHook{}
+	for _, hook := range hooks {
+		if s.HookAllowed(hook.ID) {
+			res = append(res, hook)
+		}
+	}
+	return res
+}
+
+// labelValuesEqual compares 2 label values
+// Labels can come from json (numbers are float64) or from the database (numbers are int32, int64, etc..)
+// So numbers are compared by their value regardless of their type, all other values must have the same type and value
+func labelValuesEqual(a, b any) bool {
+	aNumber, aIsNumber := labelNumber(a)
+	bNumber, bIsNumber := labelNumber(b)
+	if aIsNumber || bIsNumber {
+		return aIsNumber && bIsNumber && aNumber == bNumber
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// labelNumber returns the value of a numeric label as float64
+func labelNumber(value any) (float64, bool) {
+	switch typedValue := value.(type) {
+	case int:
+		return float64(typedValue), true
+	case int32:
+		return float64(typedValue), true
+	case int64:
+		return float64(typedValue), true
+	case float32:
+		return float64(typedValue), true
+	case float64:
+		return typedValue, true
+	default:
+		return 0, false
+	}
+}
+
+// APIKeyAccess tells what kind of access a route requires from an api key
+// Like roles accesses can be combined together using bit sifting
+// For example:
+//
+//	APIKeyAccessWrite | APIKeyAccessProfiles // A route that modifies profiles
+type APIKeyAccess uint64
+
+const (
+	// APIKeyAccessWrite is required by routes that modify server state
+	// Keys with a read only scope cannot use these routes
+	// = 1
+	APIKeyAccessWrite APIKeyAccess = 1 << iota
+
+	// APIKeyAccessProfiles is used by routes that work with profiles
+	// These routes only show and modify the profiles within the scope of the key
+	// = 2
+	APIKeyAccessProfiles
+
+	// APIKeyAccessHooks is used by routes that work with on match hooks
+	// These routes only show and modify the hooks within the scope of the key
+	// = 4
+	APIKeyAccessHooks
+)
+
+// APIKeyAccessAllArray is an array of all accesses
+var APIKeyAccessAllArray = []APIKeyAccess{
+	APIKeyAccessWrite,
+	APIKeyAccessProfiles,
+	APIKeyAccessHooks,
+}
+
+// Description returns a description of the access
+// Only works on single accesses
+func (a APIKeyAccess) Description() (description, slug string, ok bool) {
+	switch a {
+	case APIKeyAccessWrite:
+		return "Modifies server state, not allowed for keys with a read only scope", "write", true
+	case APIKeyAccessProfiles:
+		return "Limited to the profiles within the scope of the key", "profiles", true
+	case APIKeyAccessHooks:
+		return "Limited to the on match hooks within the scope of the key", "hooks", true
+	default:
+		return "Unknown access", "unknown", false
+	}
+}
+
+// APIAccess contains information about a APIKeyAccess
+type APIAccess struct {
+	Access      APIKeyAccess `json:"access"`
+	Slug        string       `json:"slug"`
+	Description string       `json:"description"`
+}
+
+// ConvertToAPIAccesses convers the unreadable access number into an array of APIAccess
+func (a APIKeyAccess) ConvertToAPIAccesses() []APIAccess {
+	res := []APIAccess{}
+	for _, access := range APIKeyAccessAllArray {
+		if a&access == access {
+			description, slug, _ := access.Description()
+			res = append(res, APIAccess{Access: access, Slug: slug, Description: description})
+		}
+	}
+	return res
+}
+
+// Contains check if a contains all of other
+func (a APIKeyAccess) Contains(other APIKeyAccess) bool {
+	return a&other == other
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"errors"
+
 	"github.com/apex/log"
 	"github.com/script-development/RT-CV/db"
 	"github.com/script-development/RT-CV/helpers/random"
@@ -11,12 +13,17 @@ import (
 // APIKey contains a registered API key
 type APIKey struct {
 	db.M    `bson:",inline"`
+	db.T    `bson:",inline"`
 	Name    string     `json:"name"`
 	Enabled bool       `json:"enabled"`
 	Domains []string   `json:"domains"`
 	Key     string     `json:"key"`
 	Roles   APIKeyRole `json:"roles" description:"What are the actions this key can do, every truthy bit of this number represends a role"`
 
+	// Scope limits the resources this key has access to
+	// If nil the key can access everything its roles allow
+	Scope *APIKeyScope `json:"scope" bson:"scope,omitempty" description:"Limits the resources this key has access to, if null the key can access everything its roles allow"`
+
 	// System indicates if this is a key required by the system
 	// These are keys whereof at least one needs to exists otherwise RT-CV would not work
 	System bool `json:"system" description:"True when the key is generated (& required) by RT-CV to function"`
@@ -36,36 +43,58 @@ func (*APIKey) DefaultFindFilters() bson.M {
 // This key can be send to someone safely without exposing the key
 // To generate this object use the (*APIKey).Info method
 type APIKeyInfo struct {
-	ID      primitive.ObjectID `json:"id"`
-	Name    string             `json:"name"`
-	Domains []string           `json:"domains"`
-	Roles   []APIRole          `json:"roles"`
-	System  bool               `json:"system"`
+	ID       primitive.ObjectID  `json:"id"`
+	Name     string              `json:"name"`
+	Domains  []string            `json:"domains"`
+	Roles    []APIRole           `json:"roles"`
+	Scope    *APIKeyScope        `json:"scope"`
+	TenantID *primitive.ObjectID `json:"tenantId"`
+	System   bool                `json:"system"`
 }
 
 // Info converts the APIKey into APIKeyInfo
 // This key can be send to someone safely without exposing the key
 func (a *APIKey) Info() APIKeyInfo {
 	return APIKeyInfo{
-		ID:      a.ID,
-		Name:    a.Name,
-		Domains: a.Domains,
-		Roles:   a.Roles.ConvertToAPIRoles(),
-		System:  a.System,
+		ID:       a.ID,
+		Name:     a.Name,
+		Domains:  a.Domains,
+		Roles:    a.Roles.ConvertToAPIRoles(),
+		Scope:    a.Scope,
+		TenantID: a.TenantID,
+		System:   a.System,
 	}
 }
 
+// ErrScraperKeyWithTenant is returned when a scraper key is assigned to a tenant
+var ErrScraperKeyWithTenant = errors.New("keys with the scraper role are shared between all tenants and cannot belong to a tenant")
+
+// ValidateTenant checks if the key is allowed to belong to its tenant
+func (a *APIKey) ValidateTenant() error {
+	if a.TenantID != nil && a.Roles.ContainsSome(APIKeyRoleScraper) {
+		return ErrScraperKeyWithTenant
+	}
+	return nil
+}
+
 // GetAPIKeys returns all the keys registered in the database
-func GetAPIKeys(conn db.Connection) ([]APIKey, error) {
+func GetAPIKeys(conn db.Connection, opts ...db.FindOptions) ([]APIKey, error) {
 	keys := []APIKey{}
-	err := conn.Find(&APIKey{}, &keys, nil)
+	err := conn.Find(&APIKey{}, &keys, nil, opts...)
 	return keys, err
 }
 
+// CountAPIKeys returns the number of keys returned by GetAPIKeys
+// Count does not apply the default find filters so they are added here to skip the disabled keys
+func CountAPIKeys(conn db.Connection) (uint64, error) {
+	return conn.Count(&APIKey{}, (&APIKey{}).DefaultFindFilters())
+}
+
 // GetScraperAPIKeys returns all the keys with scraper roles registered in the database
+// Scraper keys are shared between all tenants so this also returns keys outside of the tenant of conn
 func GetScraperAPIKeys(conn db.Connection) ([]APIKey, error) {
 	keys := []APIKey{}
-	err := conn.Find(&APIKey{}, &keys, bson.M{
+	err := db.WithoutTenant(conn).Find(&APIKey{}, &keys, bson.M{
 		"system": false,
 		"roles":  1,
 	})
@@ -103,7 +132,7 @@ const (
 	// = 8
 	APIKeyRoleDashboard
 
-	// APIKeyRoleAdmin Currently unused
+	// APIKeyRoleAdmin can manage api keys and system settings
 	// = 16
 	APIKeyRoleAdmin
 )
@@ -134,7 +163,7 @@ func (a APIKeyRole) Description() (description, slug string, ok bool) {
 	case APIKeyRoleDashboard:
 		return "Can access the dashboard and modify server state", "dashboard", true
 	case APIKeyRoleAdmin:
-		return "Unused role", "admin", true
+		return "Can manage api keys and system settings", "admin", true
 	default:
 		return "Unknown role", "unknown", false
 	}
@@ -175,16 +204,34 @@ func (a APIKeyRole) Valid() bool {
 	return a > 0 && a <= APIKeyRoleAll
 }
 
+// systemDashboardKeyRoles are the roles the system dashboard key should have
+const systemDashboardKeyRoles = APIKeyRoleDashboard | APIKeyRoleAdmin
+
 // CheckDashboardKeyExists checks weather the required system keys are available and if not creates them
 func CheckDashboardKeyExists(conn db.Connection) {
-	keys := []APIKey{}
-	err := conn.Find(&APIKey{}, &keys, bson.M{"system": true, "roles": APIKeyRoleDashboard})
+	systemKeys := []APIKey{}
+	err := conn.Find(&APIKey{}, &systemKeys, bson.M{"system": true})
 	if err != nil {
 		log.WithError(err).Fatalf("unable to fetch api keys")
 	}
 
-	if len(keys) != 0 {
-		log.Infof("One system dashboard key exists with id %s and role %d", keys[0].ID.Hex(), APIKeyRoleDashboard)
+	for _, key := range systemKeys {
+		if !key.Roles.ContainsAll(APIKeyRoleDashboard) {
+			continue
+		}
+
+		if !key.Roles.ContainsAll(systemDashboardKeyRoles) {
+			// Dashboard keys created before the admin role was used lack the admin role
+			// Without it the dashboard is not able to manage api keys
+			log.Infof("Adding the admin role to system dashboard key %s", key.ID.Hex())
+			key.Roles |= systemDashboardKeyRoles
+			err = conn.UpdateByID(&key)
+			if err != nil {
+				log.WithError(err).Fatalf("unable to update the system dashboard key")
+			}
+		}
+
+		log.Infof("One system dashboard key exists with id %s and role %d", key.ID.Hex(), key.Roles)
 		return
 	}
 
@@ -195,7 +242,7 @@ func CheckDashboardKeyExists(conn db.Connection) {
 		Enabled: true,
 		Domains: []string{"*"},
 		Key:     string(random.GenerateKey()),
-		Roles:   APIKeyRoleDashboard,
+		Roles:   systemDashboardKeyRoles,
 		System:  true,
 	}
 	err = conn.Insert(key)
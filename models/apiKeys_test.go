@@ -3,7 +3,9 @@ package models
 import (
 	"testing"
 
+	"github.com/script-development/RT-CV/db"
 	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestApiKeyRole(t *testing.T) {
@@ -66,3 +68,65 @@ func TestApiKeyRole(t *testing.T) {
 		})
 	}
 }
+
+func TestAPIKeyScope(t *testing.T) {
+	profileInScopeByID := &Profile{M: db.NewM()}
+	profileInScopeByLabels := &Profile{M: db.NewM(), Lables: map[string]any{"customer": "a", "amount": int32(2)}}
+	profileOutOfScope := &Profile{M: db.NewM(), Lables: map[string]any{"customer": "b", "amount": int32(2)}}
+
+	scope := &APIKeyScope{
+		ProfileIDs:    []primitive.ObjectID{profileInScopeByID.ID},
+		ProfileLabels: map[string]any{"customer": "a", "amount": float64(2)},
+	}
+
+	True(t, scope.ProfileAllowed(profileInScopeByID))
+	True(t, scope.ProfileAllowed(profileInScopeByLabels))
+	False(t, scope.ProfileAllowed(profileOutOfScope))
+	Len(t, scope.FilterProfiles([]Profile{*profileInScopeByID, *profileInScopeByLabels, *profileOutOfScope}), 2)
+
+	// A nil scope or a scope without profile restrictions should allow all profiles
+	var nilScope *APIKeyScope
+	True(t, nilScope.ProfileAllowed(profileOutOfScope))
+	True(t, (&APIKeyScope{}).ProfileAllowed(profileOutOfScope))
+
+	hookID := primitive.NewObjectID()
+	hookScope := &APIKeyScope{HookIDs: []primitive.ObjectID{hookID}}
+	True(t, hookScope.HookAllowed(hookID))
+	False(t, hookScope.HookAllowed(primitive.NewObjectID()))
+	True(t, nilScope.HookAllowed(primitive.NewObjectID()))
+
+	// Label values are compared by type, only numbers of different types can be equal
+	False(t, (&APIKeyScope{ProfileLabels: map[string]any{"amount": "2"}}).ProfileAllowed(profileInScopeByLabels))
+	True(t, (&APIKeyScope{ProfileLabels: map[string]any{"amount": int64(2)}}).ProfileAllowed(profileInScopeByLabels))
+
+	True(t, nilScope.CanWrite())
+	True(t, hookScope.CanWrite())
+	False(t, (&APIKeyScope{ReadOnly: true}).CanWrite())
+}
+
+func TestAPIKeyScopeContains(t *testing.T) {
+	profileID := primitive.NewObjectID()
+	hookID := primitive.NewObjectID()
+	scope := &APIKeyScope{
+		ProfileIDs:    []primitive.ObjectID{profileID},
+		ProfileLabels: map[string]any{"customer": "a"},
+		HookIDs:       []primitive.ObjectID{hookID},
+	}
+
+	var nilScope *APIKeyScope
+	True(t, nilScope.Contains(scope))
+	True(t, nilScope.Contains(nil))
+	False(t, scope.Contains(nil))
+	True(t, scope.Contains(scope))
+
+	True(t, scope.Contains(&APIKeyScope{ProfileIDs: []primitive.ObjectID{profileID}, HookIDs: []primitive.ObjectID{hookID}}))
+	True(t, scope.Contains(&APIKeyScope{ProfileLabels: map[string]any{"customer": "a", "team": "b"}, HookIDs: []primitive.ObjectID{hookID}}))
+	False(t, scope.Contains(&APIKeyScope{ProfileIDs: []primitive.ObjectID{primitive.NewObjectID()}, HookIDs: []primitive.ObjectID{hookID}}))
+	False(t, scope.Contains(&APIKeyScope{ProfileLabels: map[string]any{"customer": "b"}, HookIDs: []primitive.ObjectID{hookID}}))
+	False(t, scope.Contains(&APIKeyScope{ProfileIDs: []primitive.ObjectID{profileID}}), "the hooks are not limited")
+	False(t, scope.Contains(&APIKeyScope{HookIDs: []primitive.ObjectID{hookID}}), "the profiles are not limited")
+
+	readOnly := &APIKeyScope{ReadOnly: true}
+	True(t, readOnly.Contains(&APIKeyScope{ReadOnly: true, HookIDs: []primitive.ObjectID{hookID}}))
+	False(t, readOnly.Contains(&APIKeyScope{}))
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AuditAction is an action that is logged in the audit log
+type AuditAction string
+
+const (
+	// AuditActionLogin is logged when a user successfully logs in
+	AuditActionLogin AuditAction = "login"
+	// AuditActionLoginFailed is logged when a login attempt fails
+	AuditActionLoginFailed AuditAction = "login_failed"
+	// AuditActionSessionRefreshed is logged when a session obtains a new access token
+	AuditActionSessionRefreshed AuditAction = "session_refreshed"
+	// AuditActionLogout is logged when a user logs out
+	AuditActionLogout AuditAction = "logout"
+	// AuditActionSessionRevoked is logged when a session is revoked by someone else than the session owner or because of refresh token reuse
+	AuditActionSessionRevoked AuditAction = "session_revoked"
+	// AuditActionUserCreated is logged when a dashboard user is created
+	AuditActionUserCreated AuditAction = "user_created"
+	// AuditActionUserUpdated is logged when a dashboard user is modified
+	AuditActionUserUpdated AuditAction = "user_updated"
+	// AuditActionUserDeleted is logged when a dashboard user is deleted
+	AuditActionUserDeleted AuditAction = "user_deleted"
+	// AuditActionTOTPEnabled is logged when a user enables the second factor
+	AuditActionTOTPEnabled AuditAction = "totp_enabled"
+	// AuditActionTOTPDisabled is logged when a user disables the second factor
+	AuditActionTOTPDisabled AuditAction = "totp_disabled"
+)
+
+// AuditLogEntry is a security related event
+type AuditLogEntry struct {
+	db.M      `bson:",inline"`
+	db.T      `bson:",inline"`
+	When      time.Time           `json:"when" bson:"when"`
+	Action    AuditAction         `json:"action" bson:"action"`
+	UserID    *primitive.ObjectID `json:"userId" bson:"userId,omitempty" description:"The dashboard user this entry is about"`
+	SessionID *primitive.ObjectID `json:"sessionId" bson:"sessionId,omitempty"`
+	ActorID   *primitive.ObjectID `json:"actorId" bson:"actorId,omitempty" description:"The api key or dashboard user that executed the action"`
+	IP        string              `json:"ip" bson:"ip"`
+	Message   string              `json:"message" bson:"message"`
+}
+
+// CollectionName returns the collection name of the AuditLogEntry
+func (*AuditLogEntry) CollectionName() string {
+	return "auditLog"
+}
+
+// Indexes implements db.Entry
+func (*AuditLogEntry) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.M{"when": -1}},
+		{Keys: bson.M{"userId": 1}},
+	}
+}
+
+// AddAuditLogEntry inserts a new entry into the audit log
+func AddAuditLogEntry(conn db.Connection, entry AuditLogEntry) error {
+	entry.M = db.NewM()
+	if entry.When.IsZero() {
+		entry.When = time.Now()
+	}
+	return conn.Insert(&entry)
+}
+
+// GetAuditLog returns the audit log entries, if userID is not nil only the entries of that user are returned
+func GetAuditLog(conn db.Connection, userID *primitive.ObjectID) ([]AuditLogEntry, error) {
+	filter := bson.M{}
+	if userID != nil {
+		filter["userId"] = *userID
+	}
+	entries := []AuditLogEntry{}
+	err := conn.Find(&AuditLogEntry{}, &entries, filter)
+	return entries, err
+}
@@ -4,7 +4,9 @@ import (
 	"time"
 
 	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 /*
@@ -68,3 +70,46 @@ func SetLastBackupToNow(dbConn db.Connection) error {
 	backup.Time = time.Now()
 	return dbConn.UpdateByID(&backup)
 }
+
+// BackupCatalogEntry contains the meta data of a backup file in the backup storage
+type BackupCatalogEntry struct {
+	db.M        `bson:",inline"`
+	Name        string            `json:"name"`
+	CreatedAt   time.Time         `bson:"createdAt" json:"createdAt"`
+	Collections map[string]uint64 `json:"collections" description:"The amount of documents per collection in the backup"`
+}
+
+// CollectionName returns the collection name of the BackupCatalogEntry
+func (*BackupCatalogEntry) CollectionName() string {
+	return "backupCatalog"
+}
+
+// Indexes implements db.Entry
+func (*BackupCatalogEntry) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.M{"name": 1}, Options: options.Index().SetUnique(true)},
+	}
+}
+
+// GetBackupCatalog returns the meta data of all backups by name
+func GetBackupCatalog(dbConn db.Connection) (map[string]BackupCatalogEntry, error) {
+	entries := []BackupCatalogEntry{}
+	err := dbConn.Find(&BackupCatalogEntry{}, &entries, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]BackupCatalogEntry, len(entries))
+	for _, entry := range entries {
+		res[entry.Name] = entry
+	}
+	return res, nil
+}
+
+// DeleteBackupCatalogEntries removes the meta data of the backups with the names
+func DeleteBackupCatalogEntries(dbConn db.Connection, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	_, err := dbConn.DeleteMany(&BackupCatalogEntry{}, bson.M{"name": bson.M{"$in": names}})
+	return err
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/crypto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultCVHistoryRetention is how long scanned CVs are kept in the CV history if $CV_HISTORY_RETENTION_DAYS is not set
+	DefaultCVHistoryRetention = 7 * 24 * time.Hour
+	// DefaultCVHistoryLimit is the maximum amount of CVs used for a simulation if $CV_HISTORY_LIMIT is not set
+	DefaultCVHistoryLimit = 10_000
+)
+
+// CVHistoryEntry is an encrypted copy of a scanned CV kept by the CV history
+type CVHistoryEntry struct {
+	db.M      `bson:",inline"`
+	KeyID     primitive.ObjectID `bson:"keyId"`
+	ScannedAt time.Time          `bson:"scannedAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	CV        []byte             `bson:"cv"` // The CV as json, encrypted with the CV history key
+}
+
+// CollectionName returns the collection name of the CVHistoryEntry
+func (*CVHistoryEntry) CollectionName() string {
+	return "cvHistory"
+}
+
+// Indexes implements db.Entry
+func (*CVHistoryEntry) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.M{"scannedAt": -1}},
+		// MongoDB removes the expired CVs by itself, the other databases rely on (*CVHistory).Prune
+		{Keys: bson.M{"expiresAt": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+}
+
+// HistoricCV is a decrypted CV from the CV history
+type HistoricCV struct {
+	KeyID     primitive.ObjectID
+	ScannedAt time.Time
+	CV        CV
+}
+
+// CVHistory keeps the recently scanned CVs encrypted for a limited time so profiles can be tested against them before they are activated
+// The CV history is opt-in as it means storing personal data, see CVHistoryFromEnv
+type CVHistory struct {
+	key       []byte
+	Retention time.Duration
+	Limit     int
+
+	// pending contains the CVs added by AddInBackground that are not yet written to the database
+	pending       []CVHistoryEntry
+	c             *sync.Cond
+	writerStarted bool
+}
+
+// NewCVHistory creates a new CV history that encrypts the CVs with key
+func NewCVHistory(key string, retention time.Duration, limit int) (*CVHistory, error) {
+	if len(key) < 16 {
+		return nil, errors.New("the cv history key must be at least 16 characters long")
+	}
+	if retention <= 0 {
+		return nil, errors.New("the cv history retention must be positive")
+	}
+	if limit <= 0 {
+		return nil, errors.New("the cv history limit must be positive")
+	}
+	return &CVHistory{
+		key:       []byte(key),
+		Retention: retention,
+		Limit:     limit,
+		c:         sync.NewCond(&sync.Mutex{}),
+	}, nil
+}
+
+// CVHistoryFromEnv creates the CV history from the env variables
+// Returns nil if $CV_HISTORY_ENABLED is not set to true
+func CVHistoryFromEnv() (*CVHistory, error) {
+	if strings.ToLower(os.Getenv("CV_HISTORY_ENABLED")) != "true" {
+		return nil, nil
+	}
+
+	retention := DefaultCVHistoryRetention
+	days := positiveIntFromEnv("CV_HISTORY_RETENTION_DAYS")
+	if days > 0 {
+		retention = time.Duration(days) * 24 * time.Hour
+	}
+	limit := DefaultCVHistoryLimit
+	if envLimit := positiveIntFromEnv("CV_HISTORY_LIMIT"); envLimit > 0 {
+		limit = envLimit
+	}
+
+	history, err := NewCVHistory(os.Getenv("CV_HISTORY_KEY"), retention, limit)
+	if err != nil {
+		return nil, errors.New(err.Error() + ", make sure you have set the CV_HISTORY_KEY env variable")
+	}
+	return history, nil
+}
+
+// positiveIntFromEnv returns the value of the env variable or 0 if it's not set or not a positive number
+func positiveIntFromEnv(key string) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+	parsedValue, err := strconv.Atoi(value)
+	if err != nil || parsedValue <= 0 {
+		log.WithField("value", value).Warnf("$%s is not a positive number, using the default", key)
+		return 0
+	}
+	return parsedValue
+}
+
+// Add stores an encrypted copy of a CV scanned by the api key keyID
+func (h *CVHistory) Add(conn db.Connection, keyID primitive.ObjectID, cv CV, now time.Time) error {
+	entry, err := h.newEntry(keyID, cv, now)
+	if err != nil {
+		return err
+	}
+	return conn.Insert(&entry)
+}
+
+// AddInBackground is equal to Add but the CV is written to the database by a background process so scanning a CV does not have to wait for it
+// The CVs added while the previous CVs are written are written together, if more than Limit CVs are waiting the oldest are dropped
+func (h *CVHistory) AddInBackground(conn db.Connection, keyID primitive.ObjectID, cv CV, now time.Time) error {
+	entry, err := h.newEntry(keyID, cv, now)
+	if err != nil {
+		return err
+	}
+
+	h.c.L.Lock()
+	h.pending = append(h.pending, entry)
+	if dropped := len(h.pending) - h.Limit; dropped > 0 {
+		log.WithField("dropped", dropped).Warn("the cv history cannot keep up with the scanned CVs, dropping the oldest CVs")
+		h.pending = append([]CVHistoryEntry{}, h.pending[dropped:]...)
+	}
+	h.c.Signal()
+	if !h.writerStarted {
+		h.writerStarted = true
+		// The cv history entries do not belong to a tenant
+		go h.writePending(db.WithoutTenant(conn))
+	}
+	h.c.L.Unlock()
+	return nil
+}
+
+// writePending is a process that should be running in the background that writes the CVs added by AddInBackground
+func (h *CVHistory) writePending(conn db.Connection) {
+	for {
+		h.c.L.Lock()
+		for len(h.pending) == 0 {
+			h.c.Wait()
+		}
+		entries := h.pending
+		h.pending = nil
+		h.c.L.Unlock()
+
+		err := conn.InsertMany(entries)
+		if err != nil {
+			log.WithError(err).WithField("cvs", len(entries)).Error("unable to add the cvs to the cv history")
+		}
+	}
+}
+
+// newEntry encrypts the CV and returns it as cv history entry
+func (h *CVHistory) newEntry(keyID primitive.ObjectID, cv CV, now time.Time) (CVHistoryEntry, error) {
+	cvJSON, err := json.Marshal(cv)
+	if err != nil {
+		return CVHistoryEntry{}, err
+	}
+	encryptedCV, err := crypto.Encrypt(cvJSON, h.key)
+	if err != nil {
+		return CVHistoryEntry{}, err
+	}
+
+	return CVHistoryEntry{
+		M:         db.NewM(),
+		KeyID:     keyID,
+		ScannedAt: now,
+		ExpiresAt: now.Add(h.Retention),
+		CV:        encryptedCV,
+	}, nil
+}
+
+// CVs returns the newest CVs of the history that are not expired, newest first
+// CVs that can't be decrypted, for example because the key was changed, are skipped
+func (h *CVHistory) CVs(conn db.Connection, now time.Time) ([]HistoricCV, error) {
+	entries := []CVHistoryEntry{}
+	err := conn.Find(
+		&CVHistoryEntry{},
+		&entries,
+		bson.M{"expiresAt": bson.M{"$gt": now}},
+		db.FindOptions{
+			Sort:  bson.D{{Key: "scannedAt", Value: -1}},
+			Limit: int64(h.Limit),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]HistoricCV, 0, len(entries))
+	for _, entry := range entries {
+		cvJSON, err := crypto.Decrypt(entry.CV, h.key)
+		if err != nil {
+			continue
+		}
+		historicCV := HistoricCV{
+			KeyID:     entry.KeyID,
+			ScannedAt: entry.ScannedAt,
+		}
+		err = json.Unmarshal(cvJSON, &historicCV.CV)
+		if err != nil {
+			continue
+		}
+		res = append(res, historicCV)
+	}
+	return res, nil
+}
+
+// Prune removes the expired CVs and returns the number of removed CVs
+func (h *CVHistory) Prune(conn db.Connection, now time.Time) (uint64, error) {
+	return conn.DeleteMany(&CVHistoryEntry{}, bson.M{"expiresAt": bson.M{"$lte": now}})
+}
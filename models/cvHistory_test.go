@@ -0,0 +1,100 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/tj/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNewCVHistory(t *testing.T) {
+	_, err := NewCVHistory("too short", time.Hour, 10)
+	Error(t, err)
+	_, err = NewCVHistory("this-is-a-long-enough-key", 0, 10)
+	Error(t, err)
+	_, err = NewCVHistory("this-is-a-long-enough-key", time.Hour, 0)
+	Error(t, err)
+
+	t.Setenv("CV_HISTORY_ENABLED", "")
+	history, err := CVHistoryFromEnv()
+	NoError(t, err)
+	Nil(t, history)
+
+	t.Setenv("CV_HISTORY_ENABLED", "true")
+	t.Setenv("CV_HISTORY_KEY", "")
+	_, err = CVHistoryFromEnv()
+	Error(t, err)
+
+	t.Setenv("CV_HISTORY_KEY", "this-is-a-long-enough-key")
+	t.Setenv("CV_HISTORY_RETENTION_DAYS", "2")
+	history, err = CVHistoryFromEnv()
+	NoError(t, err)
+	Equal(t, 48*time.Hour, history.Retention)
+	Equal(t, DefaultCVHistoryLimit, history.Limit)
+}
+
+func TestCVHistory(t *testing.T) {
+	conn := testingdb.NewDB()
+	history, err := NewCVHistory("this-is-a-long-enough-key", 24*time.Hour, 2)
+	NoError(t, err)
+
+	keyID := primitive.NewObjectID()
+	now := time.Now()
+	for idx, referenceNr := range []string{"a", "b", "c"} {
+		err = history.Add(conn, keyID, CV{ReferenceNumber: referenceNr}, now.Add(time.Duration(idx)*time.Hour))
+		NoError(t, err)
+	}
+
+	// The CVs are stored encrypted
+	entries := []CVHistoryEntry{}
+	err = conn.Find(&CVHistoryEntry{}, &entries, nil)
+	NoError(t, err)
+	Len(t, entries, 3)
+	NotContains(t, string(entries[0].CV), `"referenceNumber"`)
+
+	// Only the newest CVs up to the limit are returned
+	cvs, err := history.CVs(conn, now.Add(2*time.Hour))
+	NoError(t, err)
+	Len(t, cvs, 2)
+	Equal(t, "c", cvs[0].CV.ReferenceNumber)
+	Equal(t, "b", cvs[1].CV.ReferenceNumber)
+	Equal(t, keyID, cvs[0].KeyID)
+
+	// Expired CVs are not returned and removed by Prune
+	cvs, err = history.CVs(conn, now.Add(25*time.Hour+time.Minute))
+	NoError(t, err)
+	Len(t, cvs, 1)
+	removed, err := history.Prune(conn, now.Add(25*time.Hour+time.Minute))
+	NoError(t, err)
+	Equal(t, uint64(2), removed)
+	count, err := conn.Count(&CVHistoryEntry{}, bson.M{})
+	NoError(t, err)
+	Equal(t, uint64(1), count)
+
+	// CVs encrypted with another key are skipped
+	otherHistory, err := NewCVHistory("this-is-another-long-enough-key", 24*time.Hour, 2)
+	NoError(t, err)
+	cvs, err = otherHistory.CVs(conn, now)
+	NoError(t, err)
+	Empty(t, cvs)
+}
+
+func TestCVHistoryAddInBackground(t *testing.T) {
+	conn := testingdb.NewDB()
+	history, err := NewCVHistory("this-is-a-long-enough-key", 24*time.Hour, 10)
+	NoError(t, err)
+
+	now := time.Now()
+	for _, referenceNr := range []string{"a", "b", "c"} {
+		err = history.AddInBackground(conn, primitive.NewObjectID(), CV{ReferenceNumber: referenceNr}, now)
+		NoError(t, err)
+	}
+
+	Eventually(t, func() bool {
+		count, err := conn.Count(&CVHistoryEntry{}, nil)
+		return err == nil && count == 3
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DashboardUser is a person that can login into the dashboard using a username and password
+// Logged in users get a session, see Session
+type DashboardUser struct {
+	db.M         `bson:",inline"`
+	db.T         `bson:",inline"`
+	Username     string       `json:"username" bson:"username"`
+	PasswordHash string       `json:"-" bson:"passwordHash"`
+	Roles        APIKeyRole   `json:"roles" bson:"roles" description:"What are the actions this user can do, every truthy bit of this number represends a role"`
+	Scope        *APIKeyScope `json:"scope" bson:"scope,omitempty" description:"Limits the resources this user has access to, if null the user can access everything its roles allow"`
+	Disabled     bool         `json:"disabled" bson:"disabled"`
+
+	// TOTPSecret is set when the user has started setting up a second factor
+	// The second factor is only required once TOTPEnabled is true
+	TOTPSecret  string `json:"-" bson:"totpSecret"`
+	TOTPEnabled bool   `json:"totpEnabled" bson:"totpEnabled"`
+	// TOTPLastStep is the step of the last accepted totp code, codes of this step or earlier are rejected
+	TOTPLastStep int64 `json:"-" bson:"totpLastStep"`
+}
+
+// CollectionName returns the collection name of the DashboardUser
+func (*DashboardUser) CollectionName() string {
+	return "dashboardUsers"
+}
+
+// Indexes implements db.Entry
+func (*DashboardUser) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.M{"username": 1}, Options: options.Index().SetUnique(true)},
+	}
+}
+
+// minPasswordLength is the minimum length of a dashboard user password
+const minPasswordLength = 10
+
+// ErrInvalidCredentials is returned when the username or password is wrong
+// We do not tell which one is wrong to not leak what usernames exist
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// NormalizeUsername trims and lower cases a username so usernames are case insensitive
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// SetPassword validates and hashes the password
+func (u *DashboardUser) SetPassword(password string) error {
+	if len(password) < minPasswordLength {
+		return errors.New("password must have a length of at least 10 chars")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+// CheckPassword returns true if the password matches the stored password hash
+func (u *DashboardUser) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// UseTOTPStep marks the totp step as used by the user
+// Returns false if the step, or a later step, was already used, for example by a concurrent login with the same code
+func UseTOTPStep(conn db.Connection, userID primitive.ObjectID, step int64) (bool, error) {
+	updated, err := conn.UpdateMany(
+		&DashboardUser{},
+		bson.M{
+			"_id": userID,
+			"$or": bson.A{
+				bson.M{"totpLastStep": bson.M{"$lt": step}},
+				// Users created before the last step was stored
+				bson.M{"totpLastStep": nil},
+			},
+		},
+		bson.M{"$set": bson.M{"totpLastStep": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
+
+// APIKey returns an api key representing this user
+// This key is never stored in the database, it's used so the routes can check roles and scopes equal to api keys
+func (u *DashboardUser) APIKey() *APIKey {
+	return &APIKey{
+		M:       u.M,
+		T:       u.T,
+		Name:    u.Username,
+		Enabled: !u.Disabled,
+		Domains: []string{"*"},
+		Roles:   u.Roles,
+		Scope:   u.Scope,
+	}
+}
+
+// GetDashboardUsers returns all dashboard users
+func GetDashboardUsers(conn db.Connection) ([]DashboardUser, error) {
+	users := []DashboardUser{}
+	err := conn.Find(&DashboardUser{}, &users, nil)
+	return users, err
+}
+
+// GetDashboardUser returns a dashboard user by id
+func GetDashboardUser(conn db.Connection, id primitive.ObjectID) (DashboardUser, error) {
+	user := DashboardUser{}
+	err := conn.FindOne(&user, bson.M{"_id": id})
+	return user, err
+}
+
+// GetDashboardUserByUsername returns a dashboard user by its username
+func GetDashboardUserByUsername(conn db.Connection, username string) (DashboardUser, error) {
+	user := DashboardUser{}
+	err := conn.FindOne(&user, bson.M{"username": NormalizeUsername(username)})
+	return user, err
+}
@@ -1,172 +1,117 @@
 package matcher
 
 import (
-	"encoding/gob"
-	"io/fs"
-	"io/ioutil"
-	"os"
-	"path"
-
-	"github.com/apex/log"
-	"github.com/script-development/RT-CV/helpers/random"
+	"encoding/json"
+	"sync"
+
+	"github.com/script-development/RT-CV/db"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-/*
-
-TODO: Implement caching
+// maxCachedTrees is the maximum number of trees kept in the cache, when full the oldest tree is removed
+const maxCachedTrees = 50
 
-*/
+// cache contains the tree JSON responses and the search index
+// Everything is kept in memory so a change can't leave stale files behind and the cache can be invalidated from other instances using WatchTree
+var cache = struct {
+	m sync.Mutex
 
-func treeIDFileName(id *primitive.ObjectID) string {
-	if id == nil {
-		return "root.json"
-	}
-	return id.Hex() + ".json"
-}
+	// generation is increased by NukeCache so results build from an older version of the tree are not cached
+	generation uint64
 
-func cacheDir(createDirIfNotExsisting bool) (string, error) {
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		return "", err
-	}
-	cacheDir = path.Join(cacheDir, "rtcv/tree")
-
-	if !createDirIfNotExsisting {
-		return cacheDir, nil
-	}
-
-	err = os.MkdirAll(cacheDir, 0755)
-	return cacheDir, err
-}
+	// trees maps the hex of branch ids to their JSON, the root of the tree is cached under an empty key
+	trees     map[string][]byte
+	treeOrder []string
+	index     *searchIndex
+}{}
 
 // NukeCache well the name explains itself it clears the complete cache
 func NukeCache() error {
-	cacheDir, err := cacheDir(false)
-	if err != nil {
-		return nil
-	}
-	_, err = os.Stat(cacheDir)
-	if err != nil {
-		return nil
-	}
-	return os.RemoveAll(cacheDir)
-}
-
-// checkCacheDirSize caches the
-func checkCacheDirSize(cacheDirPath string) error {
-	entries, err := os.ReadDir(cacheDirPath)
-	if err != nil {
-		return err
-	}
-	if len(entries) > 50 {
-		// Remove 4 random entries
-		for i := 0; i < 4; i++ {
-			entry := random.SliceIndex(entries)
-			name := path.Join(cacheDirPath, entry.Name())
-			err = os.RemoveAll(name)
-			if err != nil {
-				log.WithError(err).WithField("name", name).Warn("unable to remove cache entry")
-				continue
-			}
-		}
-	}
+	cache.m.Lock()
+	defer cache.m.Unlock()
 
+	cache.generation++
+	cache.trees = nil
+	cache.treeOrder = nil
+	cache.index = nil
 	return nil
 }
 
-// CacheTree caches a tree
-func CacheTree(id *primitive.ObjectID, bytes []byte) error {
-	cacheDirPath, err := cacheDir(true)
-	if err != nil {
-		return err
-	}
-
-	err = checkCacheDirSize(cacheDirPath)
-	if err != nil {
-		return err
-	}
-
-	return ioutil.WriteFile(path.Join(cacheDirPath, treeIDFileName(id)), bytes, 0644)
+// WatchTree clears the cache on every change to the tree and calls onChange if set
+// This also picks up changes made by other instances of RT-CV using the same database
+func WatchTree(dbConn db.Connection, onChange func()) (stop func(), err error) {
+	return dbConn.Watch(&Branch{}, func(db.Change) {
+		NukeCache()
+		if onChange != nil {
+			onChange()
+		}
+	})
 }
 
-// ObtainCachedTree might return a cached tree
-func ObtainCachedTree(id *primitive.ObjectID) (fs.FileInfo, *os.File, error) {
-	cacheDirPath, err := cacheDir(false)
-	if err != nil {
-		return nil, nil, err
-	}
-	f, err := os.Open(path.Join(cacheDirPath, treeIDFileName(id)))
-	if err != nil {
-		return nil, nil, err
+// GetBranchJSON returns the JSON of GetBranch, the result is cached until NukeCache is called
+func (tc *Tree) GetBranchJSON(dbConn db.Connection, branchID *primitive.ObjectID) ([]byte, error) {
+	key := ""
+	if branchID != nil {
+		key = branchID.Hex()
 	}
 
-	inf, err := f.Stat()
-	if err != nil {
-		_ = f.Close()
-		return nil, nil, err
+	cache.m.Lock()
+	cachedJSON, ok := cache.trees[key]
+	generation := cache.generation
+	cache.m.Unlock()
+	if ok {
+		return cachedJSON, nil
 	}
 
-	return inf, f, nil
-}
-
-// cacheSearch safes a list of optimized data for searching
-func cacheSearch(data []FuzzySearchCacheEntry) error {
-	cacheDirPath, err := cacheDir(true)
+	branch, err := tc.GetBranch(dbConn, branchID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	err = checkCacheDirSize(cacheDirPath)
+	branchJSON, err := json.Marshal(branch)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	f, err := os.OpenFile(path.Join(cacheDirPath, "search.gob"), os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return err
+	cache.m.Lock()
+	defer cache.m.Unlock()
+	if cache.generation != generation {
+		// The tree changed while we where reading it
+		return branchJSON, nil
 	}
-	defer f.Close()
-
-	encoder := gob.NewEncoder(f)
-	for _, entry := range data {
-		err = encoder.Encode(entry)
-		if err != nil {
-			return err
+	if cache.trees == nil {
+		cache.trees = map[string][]byte{}
+	}
+	if _, ok := cache.trees[key]; !ok {
+		if len(cache.treeOrder) >= maxCachedTrees {
+			delete(cache.trees, cache.treeOrder[0])
+			cache.treeOrder = cache.treeOrder[1:]
 		}
+		cache.treeOrder = append(cache.treeOrder, key)
 	}
-
-	return nil
+	cache.trees[key] = branchJSON
+	return branchJSON, nil
 }
 
-// searchCache reads a optimized searching list
-func searchCache(found func(FuzzySearchCacheEntry) (stop bool)) error {
-	cacheDirPath, err := cacheDir(true)
-	if err != nil {
-		return err
+// searchIndexFor returns the cached search index or builds it
+func (tc *Tree) searchIndexFor(dbConn db.Connection) (*searchIndex, error) {
+	cache.m.Lock()
+	index := cache.index
+	generation := cache.generation
+	cache.m.Unlock()
+	if index != nil {
+		return index, nil
 	}
 
-	f, err := os.Open(path.Join(cacheDirPath, "search.gob"))
+	err := tc.build(dbConn)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
-
-	decoder := gob.NewDecoder(f)
-	first := true
-	for {
-		resp := FuzzySearchCacheEntry{}
-		err = decoder.Decode(&resp)
-		if err != nil {
-			if !first && err.Error() == "gob: unknown type id or corrupted data" {
-				// This error is thrown when we reached the end of the file / no bytes left over to read
-				return nil
-			}
-			return err
-		}
-		if found(resp) {
-			return nil
-		}
-		first = false
+	index = newSearchIndex(tc)
+
+	cache.m.Lock()
+	if cache.generation == generation {
+		cache.index = index
 	}
+	cache.m.Unlock()
+	return index, nil
 }
@@ -0,0 +1,324 @@
+package matcher
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/agnivade/levenshtein"
+	"github.com/script-development/RT-CV/helpers/wordvalidator"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// searchIndex is an in memory trigram index of all titles in the tree
+type searchIndex struct {
+	entries []searchEntry
+	// trigrams maps every 3 letter part of the normalized titles to the entries containing it
+	// The entry indexes are in ascending order
+	trigrams map[string][]int
+}
+
+// searchEntry is a single title of a branch
+type searchEntry struct {
+	normalizedTitle string
+	// words are the words of normalizedTitle, used to find titles where one of the words contains a typo
+	words  []string
+	result SearchResult
+}
+
+// The ranks of a match, lower is better
+const (
+	rankExact = iota
+	rankPrefix
+	rankWordPrefix
+	rankContains
+	rankTypo
+)
+
+// minTypoQueryLen is the minimal length of a query before we search for titles with typos
+// wordvalidator.IsSame allows 1 typo in short words so shorter queries would match almost everything
+const minTypoQueryLen = 4
+
+// normalizeSearchText lowercases text and removes the accents and garbage characters so titles and queries can be compared
+func normalizeSearchText(text string) string {
+	normalized, _ := optimizeQuery(text)
+	return strings.TrimSpace(normalized)
+}
+
+func newSearchIndex(tc *Tree) *searchIndex {
+	for _, rootBranchID := range tc.rootBranches {
+		tc.branches[rootBranchID].countTotalSubBranches()
+	}
+
+	index := &searchIndex{
+		entries:  []searchEntry{},
+		trigrams: map[string][]int{},
+	}
+	paths := branchPaths(tc)
+	for id, branch := range tc.branches {
+		for _, title := range branch.Titles {
+			normalizedTitle := normalizeSearchText(title)
+			if normalizedTitle == "" {
+				continue
+			}
+			index.entries = append(index.entries, searchEntry{
+				normalizedTitle: normalizedTitle,
+				words:           strings.Fields(normalizedTitle),
+				result: SearchResult{
+					BranchID:         id,
+					Title:            title,
+					TitleKind:        branch.TitleKind,
+					TotalSubBranches: branch.TotalSubBranches,
+					Path:             paths[id],
+				},
+			})
+		}
+	}
+
+	for idx, entry := range index.entries {
+		for _, trigram := range trigrams(entry.normalizedTitle) {
+			index.trigrams[trigram] = append(index.trigrams[trigram], idx)
+		}
+	}
+
+	return index
+}
+
+// branchPaths returns the ancestors of every branch in the tree starting at the root
+// If a branch has multiple parents the path through the first parent found is used
+func branchPaths(tc *Tree) map[primitive.ObjectID][]SearchPathEntry {
+	paths := make(map[primitive.ObjectID][]SearchPathEntry, len(tc.branches))
+
+	var walk func(branch *Branch, path []SearchPathEntry)
+	walk = func(branch *Branch, path []SearchPathEntry) {
+		if _, seen := paths[branch.ID]; seen {
+			return
+		}
+		paths[branch.ID] = path
+
+		title := ""
+		if len(branch.Titles) > 0 {
+			title = branch.Titles[0]
+		}
+		// Copy the path so the sub branches don't share the underlying array
+		subPath := make([]SearchPathEntry, len(path), len(path)+1)
+		copy(subPath, path)
+		subPath = append(subPath, SearchPathEntry{BranchID: branch.ID, Title: title})
+
+		for _, subBranch := range branch.ParsedBranches {
+			walk(subBranch, subPath)
+		}
+	}
+
+	rootBranchIDs := make([]primitive.ObjectID, len(tc.rootBranches))
+	copy(rootBranchIDs, tc.rootBranches)
+	// Sort the roots so a branch with multiple parents always gets the same path
+	sort.Slice(rootBranchIDs, func(i, j int) bool {
+		return rootBranchIDs[i].Hex() < rootBranchIDs[j].Hex()
+	})
+	for _, id := range rootBranchIDs {
+		walk(tc.branches[id], []SearchPathEntry{})
+	}
+
+	// Branches that are only reachable through a cycle have no root
+	for id := range tc.branches {
+		if _, seen := paths[id]; !seen {
+			paths[id] = []SearchPathEntry{}
+		}
+	}
+
+	return paths
+}
+
+// trigrams returns the unique 3 letter parts of text
+func trigrams(text string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for idx := 0; idx+3 <= len(text); idx++ {
+		trigram := text[idx : idx+3]
+		if !seen[trigram] {
+			seen[trigram] = true
+			result = append(result, trigram)
+		}
+	}
+	return result
+}
+
+// candidates returns the indexes of the entries that might contain query
+// All entries are returned for queries shorter than a trigram
+func (index *searchIndex) candidates(query string) []int {
+	queryTrigrams := trigrams(query)
+	if len(queryTrigrams) == 0 {
+		candidates := make([]int, len(index.entries))
+		for idx := range candidates {
+			candidates[idx] = idx
+		}
+		return candidates
+	}
+
+	postings := make([][]int, len(queryTrigrams))
+	for idx, trigram := range queryTrigrams {
+		postings[idx] = index.trigrams[trigram]
+		if len(postings[idx]) == 0 {
+			return nil
+		}
+	}
+	// Start with the shortest list so the intersection is as small as possible from the start
+	sort.Slice(postings, func(i, j int) bool {
+		return len(postings[i]) < len(postings[j])
+	})
+
+	candidates := postings[0]
+	for _, posting := range postings[1:] {
+		candidates = intersectSorted(candidates, posting)
+		if len(candidates) == 0 {
+			return nil
+		}
+	}
+	return candidates
+}
+
+// intersectSorted returns the values that are in both a and b, both should be sorted in ascending order
+func intersectSorted(a, b []int) []int {
+	result := []int{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// rank returns how well title matches query, ok is false if the title does not contain the query
+func rank(title, query string) (rank int, ok bool) {
+	idx := strings.Index(title, query)
+	switch {
+	case idx == -1:
+		return 0, false
+	case title == query:
+		return rankExact, true
+	case idx == 0:
+		return rankPrefix, true
+	case title[idx-1] == ' ' || strings.Contains(title, " "+query):
+		return rankWordPrefix, true
+	default:
+		return rankContains, true
+	}
+}
+
+// typoDistance returns the edit distance between the entry and query if the title or one of its words is somewhat equal to the query
+// ok is false if the entry doesn't match the query
+func (entry *searchEntry) typoDistance(query string) (distance int, ok bool) {
+	distance = -1
+	check := func(text string) {
+		if !wordvalidator.IsSame(text, query) {
+			return
+		}
+		textDistance := levenshtein.ComputeDistance(text, query)
+		if distance == -1 || textDistance < distance {
+			distance = textDistance
+		}
+	}
+
+	check(entry.normalizedTitle)
+	if len(entry.words) > 1 {
+		for _, word := range entry.words {
+			check(word)
+		}
+	}
+	return distance, distance != -1
+}
+
+// search returns a page of the entries that match query and the total number of matched branches
+func (index *searchIndex) search(query string, opts SearchOptions) ([]SearchResult, int) {
+	query = normalizeSearchText(query)
+
+	type match struct {
+		entry *searchEntry
+		rank  int
+		// distance is the edit distance of a match with a typo
+		distance int
+	}
+	isBetter := func(a, b match) bool {
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		return len(a.entry.normalizedTitle) < len(b.entry.normalizedTitle)
+	}
+
+	bestMatches := map[primitive.ObjectID]match{}
+	addMatch := func(m match) {
+		// A branch can have multiple matching titles, only keep the best one
+		current, exists := bestMatches[m.entry.result.BranchID]
+		if !exists || isBetter(m, current) {
+			bestMatches[m.entry.result.BranchID] = m
+		}
+	}
+
+	for _, idx := range index.candidates(query) {
+		entry := &index.entries[idx]
+		entryRank, ok := rank(entry.normalizedTitle, query)
+		if ok {
+			addMatch(match{entry: entry, rank: entryRank})
+		}
+	}
+
+	if len(query) >= minTypoQueryLen {
+		// Titles with a typo don't share all the trigrams of the query so all entries need to be checked
+		for idx := range index.entries {
+			entry := &index.entries[idx]
+			if _, exists := bestMatches[entry.result.BranchID]; exists {
+				// The branch already has a better match
+				continue
+			}
+			distance, ok := entry.typoDistance(query)
+			if ok {
+				addMatch(match{entry: entry, rank: rankTypo, distance: distance})
+			}
+		}
+	}
+
+	matches := make([]match, 0, len(bestMatches))
+	for _, m := range bestMatches {
+		matches = append(matches, m)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		if a.entry.result.TotalSubBranches != b.entry.result.TotalSubBranches {
+			// Broader branches cover more jobs so they are more likely to be searched for
+			return a.entry.result.TotalSubBranches > b.entry.result.TotalSubBranches
+		}
+		if len(a.entry.normalizedTitle) != len(b.entry.normalizedTitle) {
+			return len(a.entry.normalizedTitle) < len(b.entry.normalizedTitle)
+		}
+		if a.entry.normalizedTitle != b.entry.normalizedTitle {
+			return a.entry.normalizedTitle < b.entry.normalizedTitle
+		}
+		return a.entry.result.BranchID.Hex() < b.entry.result.BranchID.Hex()
+	})
+
+	total := len(matches)
+	results := []SearchResult{}
+	for idx := opts.Offset; idx < total && len(results) < opts.Limit; idx++ {
+		if idx < 0 {
+			continue
+		}
+		results = append(results, matches[idx].entry.result)
+	}
+	return results, total
+}
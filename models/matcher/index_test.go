@@ -0,0 +1,168 @@
+package matcher
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func searchTitles(results []SearchResult) []string {
+	titles := make([]string, len(results))
+	for idx, result := range results {
+		titles[idx] = result.Title
+	}
+	return titles
+}
+
+func TestSearch(t *testing.T) {
+	NukeCache()
+
+	truckDriver := newTestBranch("Vrachtwagenchauffeur")
+	forkliftDriver := newTestBranch("Heftruckchauffeur")
+	driver := newTestBranch("Chauffeur", truckDriver, forkliftDriver)
+	taxiDriver := newTestBranch("Taxi chauffeur")
+	chauffeurs := newTestBranch("Chauffeurs")
+	pilot := newTestBranch("Piloot")
+	pilot.Titles = append(pilot.Titles, "Vliegtuigchauffeur", "Vliegtuig chauffeur")
+	dbConn := newTestTreeDB(t, truckDriver, forkliftDriver, driver, taxiDriver, chauffeurs, pilot)
+
+	results, total, err := (&Tree{}).Search(dbConn, "CHAUFFEUR ", SearchOptions{})
+	NoError(t, err)
+	Equal(t, 6, total)
+	Equal(
+		t,
+		[]string{"Chauffeur", "Chauffeurs", "Taxi chauffeur", "Vliegtuig chauffeur", "Heftruckchauffeur", "Vrachtwagenchauffeur"},
+		searchTitles(results),
+	)
+	Equal(t, uint(2), results[0].TotalSubBranches)
+
+	results, total, err = (&Tree{}).Search(dbConn, "chauffeur", SearchOptions{Offset: 2, Limit: 2})
+	NoError(t, err)
+	Equal(t, 6, total)
+	Equal(t, []string{"Taxi chauffeur", "Vliegtuig chauffeur"}, searchTitles(results))
+
+	results, total, err = (&Tree{}).Search(dbConn, "ch", SearchOptions{Limit: 100})
+	NoError(t, err)
+	Equal(t, 6, total)
+	Len(t, results, 6)
+
+	results, total, err = (&Tree{}).Search(dbConn, "piloot", SearchOptions{})
+	NoError(t, err)
+	Equal(t, 1, total)
+	Equal(t, []string{"Piloot"}, searchTitles(results))
+
+	results, total, err = (&Tree{}).Search(dbConn, "brandweer", SearchOptions{})
+	NoError(t, err)
+	Equal(t, 0, total)
+	Empty(t, results)
+}
+
+func TestSearchNukeCache(t *testing.T) {
+	NukeCache()
+
+	pilot := newTestBranch("Piloot")
+	dbConn := newTestTreeDB(t, pilot)
+
+	results, _, err := (&Tree{}).Search(dbConn, "piloot", SearchOptions{})
+	NoError(t, err)
+	Len(t, results, 1)
+
+	helicopterPilot := newTestBranch("Helikopterpiloot")
+	pilot.Branches = []primitive.ObjectID{helicopterPilot.ID}
+	err = dbConn.Insert(helicopterPilot)
+	NoError(t, err)
+	err = dbConn.UpdateByID(pilot)
+	NoError(t, err)
+
+	// The index is only rebuild after the cache is nuked
+	results, _, err = (&Tree{}).Search(dbConn, "piloot", SearchOptions{})
+	NoError(t, err)
+	Len(t, results, 1)
+
+	NukeCache()
+	results, _, err = (&Tree{}).Search(dbConn, "piloot", SearchOptions{})
+	NoError(t, err)
+	Equal(t, []string{"Piloot", "Helikopterpiloot"}, searchTitles(results))
+	Equal(t, uint(1), results[0].TotalSubBranches)
+}
+
+func TestGetBranchJSONCache(t *testing.T) {
+	NukeCache()
+
+	pilot := newTestBranch("Piloot")
+	dbConn := newTestTreeDB(t, pilot)
+
+	tree, err := (&Tree{}).GetBranchJSON(dbConn, nil)
+	NoError(t, err)
+	Contains(t, string(tree), "Piloot")
+
+	err = dbConn.Insert(newTestBranch("Brandweerman"))
+	NoError(t, err)
+	tree, err = (&Tree{}).GetBranchJSON(dbConn, nil)
+	NoError(t, err)
+	NotContains(t, string(tree), "Brandweerman")
+
+	NukeCache()
+	tree, err = (&Tree{}).GetBranchJSON(dbConn, nil)
+	NoError(t, err)
+	Contains(t, string(tree), "Brandweerman")
+
+	_, err = (&Tree{}).GetBranchJSON(dbConn, &primitive.NilObjectID)
+	Equal(t, ErrBranchNotFound, err)
+}
+
+func TestSearchTypos(t *testing.T) {
+	NukeCache()
+
+	programmer := newTestBranch("Programmeur")
+	javaProgrammer := newTestBranch("Java programmeur")
+	ict := newTestBranch("ICT", programmer, javaProgrammer)
+	dbConn := newTestTreeDB(t, programmer, javaProgrammer, ict)
+
+	results, total, err := (&Tree{}).Search(dbConn, "programeur", SearchOptions{})
+	NoError(t, err)
+	Equal(t, 2, total)
+	Equal(t, []string{"Programmeur", "Java programmeur"}, searchTitles(results))
+
+	// Exact matches should rank above matches with a typo
+	results, _, err = (&Tree{}).Search(dbConn, "programmeur", SearchOptions{})
+	NoError(t, err)
+	Equal(t, []string{"Programmeur", "Java programmeur"}, searchTitles(results))
+
+	// Short queries don't match titles with typos
+	results, total, err = (&Tree{}).Search(dbConn, "ITC", SearchOptions{})
+	NoError(t, err)
+	Equal(t, 0, total)
+	Empty(t, results)
+}
+
+func TestSearchPath(t *testing.T) {
+	NukeCache()
+
+	transportDriver := newTestBranch("Chauffeur")
+	transport := newTestBranch("Transport", transportDriver)
+	healthcareDriver := newTestBranch("Chauffeur")
+	ambulance := newTestBranch("Ambulance", healthcareDriver)
+	healthcare := newTestBranch("Zorg", ambulance)
+	dbConn := newTestTreeDB(t, transportDriver, transport, healthcareDriver, ambulance, healthcare)
+
+	results, _, err := (&Tree{}).Search(dbConn, "chauffeur", SearchOptions{})
+	NoError(t, err)
+	paths := map[primitive.ObjectID][]SearchPathEntry{}
+	for _, result := range results {
+		paths[result.BranchID] = result.Path
+	}
+	Equal(t, []SearchPathEntry{{BranchID: transport.ID, Title: "Transport"}}, paths[transportDriver.ID])
+	Equal(
+		t,
+		[]SearchPathEntry{{BranchID: healthcare.ID, Title: "Zorg"}, {BranchID: ambulance.ID, Title: "Ambulance"}},
+		paths[healthcareDriver.ID],
+	)
+
+	results, _, err = (&Tree{}).Search(dbConn, "zorg", SearchOptions{})
+	NoError(t, err)
+	if Len(t, results, 1) {
+		Equal(t, []SearchPathEntry{}, results[0].Path)
+	}
+}
@@ -0,0 +1,495 @@
+package matcher
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrCycle is returned when a change would make a branch a sub branch of itself
+var ErrCycle = errors.New("a branch cannot become a sub branch of itself or of one of its sub branches")
+
+// treeEdit loads all branches of the tree so they can be changed in memory and saved at once
+// It should only be used within a transaction
+type treeEdit struct {
+	branches map[primitive.ObjectID]*Branch
+	changed  map[primitive.ObjectID]bool
+	deleted  []primitive.ObjectID
+	// merged maps the merged branches to the branch they are merged into
+	merged map[primitive.ObjectID]primitive.ObjectID
+}
+
+func newTreeEdit(dbConn db.Connection) (*treeEdit, error) {
+	// Not a slice of pointers so the changes are made to copies of the branches and not to entries cached by the database connection
+	branches := []Branch{}
+	err := dbConn.Find(&Branch{}, &branches, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	e := &treeEdit{
+		branches: make(map[primitive.ObjectID]*Branch, len(branches)),
+		changed:  map[primitive.ObjectID]bool{},
+		deleted:  []primitive.ObjectID{},
+		merged:   map[primitive.ObjectID]primitive.ObjectID{},
+	}
+	for idx := range branches {
+		e.branches[branches[idx].ID] = &branches[idx]
+	}
+	return e, nil
+}
+
+// get returns a branch or ErrBranchNotFound
+func (e *treeEdit) get(id primitive.ObjectID) (*Branch, error) {
+	branch, ok := e.branches[id]
+	if !ok {
+		return nil, ErrBranchNotFound
+	}
+	return branch, nil
+}
+
+// sortedIDs returns the ids of all branches sorted so the results of the edits are predictable
+func (e *treeEdit) sortedIDs() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(e.branches))
+	for id := range e.branches {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	return ids
+}
+
+// parents returns the branches that have id as sub branch
+func (e *treeEdit) parents(id primitive.ObjectID) []*Branch {
+	parents := []*Branch{}
+	for _, parentID := range e.sortedIDs() {
+		parent := e.branches[parentID]
+		for _, subBranchID := range parent.Branches {
+			if subBranchID == id {
+				parents = append(parents, parent)
+				break
+			}
+		}
+	}
+	return parents
+}
+
+// isSubBranch returns true if id is branchID or one of its (indirect) sub branches
+func (e *treeEdit) isSubBranch(branchID, id primitive.ObjectID) bool {
+	seen := map[primitive.ObjectID]bool{}
+	var walk func(branchID primitive.ObjectID) bool
+	walk = func(branchID primitive.ObjectID) bool {
+		if branchID == id {
+			return true
+		}
+		branch, ok := e.branches[branchID]
+		if !ok || seen[branchID] {
+			return false
+		}
+		seen[branchID] = true
+		for _, subBranchID := range branch.Branches {
+			if walk(subBranchID) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(branchID)
+}
+
+func (e *treeEdit) addSubBranch(parent *Branch, id primitive.ObjectID) {
+	for _, subBranchID := range parent.Branches {
+		if subBranchID == id {
+			return
+		}
+	}
+	parent.Branches = append(parent.Branches, id)
+	e.changed[parent.ID] = true
+}
+
+func (e *treeEdit) removeSubBranch(parent *Branch, id primitive.ObjectID) {
+	subBranches := []primitive.ObjectID{}
+	for _, subBranchID := range parent.Branches {
+		if subBranchID != id {
+			subBranches = append(subBranches, subBranchID)
+		}
+	}
+	if len(subBranches) != len(parent.Branches) {
+		parent.Branches = subBranches
+		e.changed[parent.ID] = true
+	}
+}
+
+// move removes a branch from all its parents and adds it to newParent, if newParent is nil the branch is moved to the root of the tree
+func (e *treeEdit) move(branch *Branch, newParent *Branch) error {
+	if newParent != nil && e.isSubBranch(branch.ID, newParent.ID) {
+		return ErrCycle
+	}
+
+	for _, parent := range e.parents(branch.ID) {
+		e.removeSubBranch(parent, branch.ID)
+	}
+	if newParent != nil {
+		e.addSubBranch(newParent, branch.ID)
+	}
+	return nil
+}
+
+// merge merges source into target
+// The titles and sub branches of source are added to target, the parents of source now reference target and source is removed
+func (e *treeEdit) merge(target *Branch, source *Branch) error {
+	if e.isSubBranch(source.ID, target.ID) || e.isSubBranch(target.ID, source.ID) {
+		return ErrCycle
+	}
+
+	for _, title := range source.Titles {
+		if !containsTitle(target.Titles, title) {
+			target.Titles = append(target.Titles, title)
+			e.changed[target.ID] = true
+		}
+	}
+	for _, subBranchID := range source.Branches {
+		e.addSubBranch(target, subBranchID)
+	}
+	for _, parent := range e.parents(source.ID) {
+		e.removeSubBranch(parent, source.ID)
+		e.addSubBranch(parent, target.ID)
+	}
+
+	delete(e.branches, source.ID)
+	delete(e.changed, source.ID)
+	e.deleted = append(e.deleted, source.ID)
+	for from, to := range e.merged {
+		if to == source.ID {
+			e.merged[from] = target.ID
+		}
+	}
+	e.merged[source.ID] = target.ID
+	return nil
+}
+
+// save writes the changes to the database
+// The professions of profiles linked to merged branches are linked to the branch they are merged into
+func (e *treeEdit) save(dbConn db.Connection) error {
+	for id := range e.changed {
+		err := dbConn.UpdateByID(e.branches[id])
+		if err != nil {
+			return err
+		}
+	}
+	if len(e.deleted) > 0 {
+		err := dbConn.DeleteByID(&Branch{}, e.deleted...)
+		if err != nil {
+			return err
+		}
+	}
+
+	mergedInto := map[primitive.ObjectID][]primitive.ObjectID{}
+	for from, to := range e.merged {
+		mergedInto[to] = append(mergedInto[to], from)
+	}
+	for to, from := range mergedInto {
+		_, err := models.RelinkProfileProfessions(dbConn, from, to)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// editTree applies fn to the tree within a transaction and clears the caches when the changes are saved
+func editTree(dbConn db.Connection, fn func(e *treeEdit) error) error {
+	var changed bool
+	err := dbConn.WithTransaction(func(tx db.Connection) error {
+		e, err := newTreeEdit(tx)
+		if err != nil {
+			return err
+		}
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+		changed = len(e.changed) > 0 || len(e.deleted) > 0
+		return e.save(tx)
+	})
+	if err != nil || !changed {
+		return err
+	}
+	return NukeCache()
+}
+
+// MoveBranch removes a branch from all its parents and adds it to newParentID
+// If newParentID is nil the branch is moved to the root of the tree
+func MoveBranch(dbConn db.Connection, branchID primitive.ObjectID, newParentID *primitive.ObjectID) error {
+	return editTree(dbConn, func(e *treeEdit) error {
+		branch, err := e.get(branchID)
+		if err != nil {
+			return err
+		}
+
+		var newParent *Branch
+		if newParentID != nil {
+			newParent, err = e.get(*newParentID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return e.move(branch, newParent)
+	})
+}
+
+// MergeBranches merges the branches with sourceIDs into the branch with targetID
+// The titles and sub branches of the merged branches are added to the target and the merged branches are removed.
+// Profile professions linked to a merged branch are linked to the target.
+func MergeBranches(dbConn db.Connection, targetID primitive.ObjectID, sourceIDs []primitive.ObjectID) error {
+	return editTree(dbConn, func(e *treeEdit) error {
+		target, err := e.get(targetID)
+		if err != nil {
+			return err
+		}
+
+		for _, sourceID := range sourceIDs {
+			if sourceID == targetID {
+				continue
+			}
+			source, err := e.get(sourceID)
+			if err != nil {
+				return err
+			}
+			err = e.merge(target, source)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BranchReference is a reference from a branch to one of its sub branches
+type BranchReference struct {
+	BranchID    primitive.ObjectID `json:"branchId"`
+	SubBranchID primitive.ObjectID `json:"subBranchId"`
+}
+
+// DuplicateTitle is a title used by multiple branches with the same parent
+type DuplicateTitle struct {
+	Title     string               `json:"title"`
+	ParentID  *primitive.ObjectID  `json:"parentId" description:"the parent of the branches, null for the root of the tree"`
+	BranchIDs []primitive.ObjectID `json:"branchIds" description:"the branches with the title, if there is only one branch the title is used multiple times by that branch"`
+}
+
+// IntegrityReport contains the problems found in the matcher tree
+type IntegrityReport struct {
+	Repaired           bool                 `json:"repaired"`
+	DanglingReferences []BranchReference    `json:"danglingReferences" description:"references to sub branches that do not exist, these are removed when repairing"`
+	Cycles             []BranchReference    `json:"cycles" description:"references to sub branches that make a branch a sub branch of itself, these are removed when repairing"`
+	Orphans            []primitive.ObjectID `json:"orphans" description:"branches that can't be reached from the root of the tree because they are part of a cycle, removing the cycles makes them reachable again"`
+	DuplicateTitles    []DuplicateTitle     `json:"duplicateTitles" description:"the branches are merged into the first branch when repairing"`
+}
+
+// Ok returns true if no problems are found
+func (r IntegrityReport) Ok() bool {
+	return len(r.DanglingReferences) == 0 && len(r.Cycles) == 0 && len(r.Orphans) == 0 && len(r.DuplicateTitles) == 0
+}
+
+// CheckIntegrity checks the matcher tree for references to branches that do not exist, cycles, orphans and duplicate titles
+// If repair is true the problems are repaired, duplicate branches are merged into the first branch with the title
+// Merging branches can result in new duplicate titles in their sub branches, running the check again repairs those.
+func CheckIntegrity(dbConn db.Connection, repair bool) (IntegrityReport, error) {
+	report := IntegrityReport{
+		Repaired:           repair,
+		DanglingReferences: []BranchReference{},
+		Cycles:             []BranchReference{},
+		Orphans:            []primitive.ObjectID{},
+		DuplicateTitles:    []DuplicateTitle{},
+	}
+
+	errDryRun := errors.New("dry run")
+	err := editTree(dbConn, func(e *treeEdit) error {
+		ids := e.sortedIDs()
+
+		// Dangling references
+		for _, id := range ids {
+			branch := e.branches[id]
+			for _, subBranchID := range append([]primitive.ObjectID{}, branch.Branches...) {
+				if _, ok := e.branches[subBranchID]; !ok {
+					report.DanglingReferences = append(report.DanglingReferences, BranchReference{BranchID: id, SubBranchID: subBranchID})
+					e.removeSubBranch(branch, subBranchID)
+				}
+			}
+		}
+
+		// Cycles, every reference to a branch that is currently being walked creates a cycle
+		const (
+			notVisited = iota
+			walking
+			visited
+		)
+		state := map[primitive.ObjectID]int{}
+		var walk func(branch *Branch)
+		walk = func(branch *Branch) {
+			state[branch.ID] = walking
+			for _, subBranchID := range append([]primitive.ObjectID{}, branch.Branches...) {
+				switch state[subBranchID] {
+				case walking:
+					report.Cycles = append(report.Cycles, BranchReference{BranchID: branch.ID, SubBranchID: subBranchID})
+					e.removeSubBranch(branch, subBranchID)
+				case notVisited:
+					walk(e.branches[subBranchID])
+				}
+			}
+			state[branch.ID] = visited
+		}
+
+		hasParents := map[primitive.ObjectID]bool{}
+		for _, branch := range e.branches {
+			for _, subBranchID := range branch.Branches {
+				hasParents[subBranchID] = true
+			}
+		}
+		for _, id := range ids {
+			if !hasParents[id] {
+				walk(e.branches[id])
+			}
+		}
+		// The branches that are not yet visited can't be reached from the root
+		for _, id := range ids {
+			if state[id] == notVisited {
+				report.Orphans = append(report.Orphans, id)
+			}
+		}
+		orphanRoots := []primitive.ObjectID{}
+		for _, id := range report.Orphans {
+			if state[id] == notVisited {
+				// Removing the cycles found from here on makes this orphan a root branch
+				orphanRoots = append(orphanRoots, id)
+				walk(e.branches[id])
+			}
+		}
+
+		// Duplicate titles
+		checkSiblings := func(parentID *primitive.ObjectID, siblings []primitive.ObjectID) {
+			titleBranches := map[string][]primitive.ObjectID{}
+			titles := []string{}
+			for _, id := range siblings {
+				for _, title := range e.branches[id].Titles {
+					key := strings.ToLower(strings.TrimSpace(title))
+					if _, ok := titleBranches[key]; !ok {
+						titles = append(titles, title)
+					}
+					titleBranches[key] = append(titleBranches[key], id)
+				}
+			}
+			for _, title := range titles {
+				branchIDs := titleBranches[strings.ToLower(strings.TrimSpace(title))]
+				if len(branchIDs) > 1 {
+					report.DuplicateTitles = append(report.DuplicateTitles, DuplicateTitle{
+						Title:     title,
+						ParentID:  parentID,
+						BranchIDs: uniqueIDs(branchIDs),
+					})
+				}
+			}
+		}
+		rootBranches := []primitive.ObjectID{}
+		for _, id := range ids {
+			if !hasParents[id] {
+				rootBranches = append(rootBranches, id)
+			}
+		}
+		rootBranches = append(rootBranches, orphanRoots...)
+		checkSiblings(nil, rootBranches)
+		for _, id := range ids {
+			parentID := id
+			checkSiblings(&parentID, e.branches[id].Branches)
+		}
+
+		if !repair {
+			return errDryRun
+		}
+
+		for _, duplicate := range report.DuplicateTitles {
+			target, ok := e.branches[duplicate.BranchIDs[0]]
+			if !ok {
+				// Already merged into another branch because of another duplicate title
+				target = e.branches[e.merged[duplicate.BranchIDs[0]]]
+			}
+
+			if len(duplicate.BranchIDs) == 1 {
+				titles := []string{}
+				for _, title := range target.Titles {
+					if !containsTitle(titles, title) {
+						titles = append(titles, title)
+					}
+				}
+				target.Titles = titles
+				e.changed[target.ID] = true
+				continue
+			}
+
+			for _, sourceID := range duplicate.BranchIDs[1:] {
+				source, ok := e.branches[sourceID]
+				if !ok || source == target {
+					continue
+				}
+				err := e.merge(target, source)
+				if err == ErrCycle {
+					// One of the branches is also a sub branch of the other one, these have to be fixed by hand
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == errDryRun {
+		err = nil
+	}
+	return report, err
+}
+
+// uniqueIDs removes the duplicate ids while keeping the order
+func uniqueIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := map[primitive.ObjectID]bool{}
+	unique := []primitive.ObjectID{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// FindMissingBranches returns the ids that are not a branch of the tree
+// It implements models.FindMissingBranches
+func FindMissingBranches(dbConn db.Connection, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ids = uniqueIDs(ids)
+	branches := []Branch{}
+	err := dbConn.Find(&Branch{}, &branches, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[primitive.ObjectID]bool{}
+	for _, branch := range branches {
+		found[branch.ID] = true
+	}
+	missing := []primitive.ObjectID{}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,140 @@
+package matcher
+
+import (
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	"github.com/script-development/RT-CV/models"
+	. "github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTestBranch(title string, subBranches ...*Branch) *Branch {
+	branch := &Branch{M: db.NewM(), Titles: []string{title}, TitleKind: Job, Branches: []primitive.ObjectID{}}
+	for _, subBranch := range subBranches {
+		branch.Branches = append(branch.Branches, subBranch.ID)
+	}
+	return branch
+}
+
+func newTestTreeDB(t *testing.T, branches ...*Branch) *testingdb.TestConnection {
+	dbConn := testingdb.NewDB()
+	dbConn.RegisterEntries(&Branch{}, &models.Profile{})
+	err := dbConn.Insert(branchesToEntries(branches)...)
+	NoError(t, err)
+	return dbConn
+}
+
+func branchesToEntries(branches []*Branch) []db.Entry {
+	entries := make([]db.Entry, len(branches))
+	for idx, branch := range branches {
+		entries[idx] = branch
+	}
+	return entries
+}
+
+func getTestBranch(t *testing.T, dbConn db.Connection, id primitive.ObjectID) *Branch {
+	branch, err := GetBranch(dbConn, id)
+	NoError(t, err)
+	return branch
+}
+
+func TestMoveBranch(t *testing.T) {
+	leaf := newTestBranch("Piloot")
+	a := newTestBranch("Transport", leaf)
+	b := newTestBranch("Luchtvaart")
+	dbConn := newTestTreeDB(t, leaf, a, b)
+
+	err := MoveBranch(dbConn, leaf.ID, &b.ID)
+	NoError(t, err)
+	Empty(t, getTestBranch(t, dbConn, a.ID).Branches)
+	Equal(t, []primitive.ObjectID{leaf.ID}, getTestBranch(t, dbConn, b.ID).Branches)
+
+	err = MoveBranch(dbConn, b.ID, &leaf.ID)
+	Equal(t, ErrCycle, err)
+	err = MoveBranch(dbConn, b.ID, &b.ID)
+	Equal(t, ErrCycle, err)
+	err = MoveBranch(dbConn, b.ID, &primitive.NilObjectID)
+	Equal(t, ErrBranchNotFound, err)
+
+	err = MoveBranch(dbConn, leaf.ID, nil)
+	NoError(t, err)
+	Empty(t, getTestBranch(t, dbConn, b.ID).Branches)
+}
+
+func TestMergeBranches(t *testing.T) {
+	leaf := newTestBranch("Heftruckchauffeur")
+	target := newTestBranch("Chauffeur")
+	source := newTestBranch("Driver", leaf)
+	parent := newTestBranch("Transport", target, source)
+	dbConn := newTestTreeDB(t, leaf, target, source, parent)
+
+	profile := &models.Profile{
+		M:                  db.NewM(),
+		DesiredProfessions: []models.ProfileProfession{{Name: "Driver", LeafId: source.ID}, {Name: "Other"}},
+	}
+	err := dbConn.Insert(profile)
+	NoError(t, err)
+
+	err = MergeBranches(dbConn, leaf.ID, []primitive.ObjectID{source.ID})
+	Equal(t, ErrCycle, err)
+
+	err = MergeBranches(dbConn, target.ID, []primitive.ObjectID{source.ID})
+	NoError(t, err)
+
+	merged := getTestBranch(t, dbConn, target.ID)
+	Equal(t, []string{"Chauffeur", "Driver"}, merged.Titles)
+	Equal(t, []primitive.ObjectID{leaf.ID}, merged.Branches)
+	Equal(t, []primitive.ObjectID{target.ID}, getTestBranch(t, dbConn, parent.ID).Branches)
+	_, err = GetBranch(dbConn, source.ID)
+	Error(t, err)
+
+	updatedProfile, err := models.GetProfile(dbConn, profile.ID)
+	NoError(t, err)
+	Equal(t, target.ID, updatedProfile.DesiredProfessions[0].LeafId)
+	True(t, updatedProfile.DesiredProfessions[1].LeafId.IsZero())
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	missingID := primitive.NewObjectID()
+	pilot := newTestBranch("Piloot")
+	helicopterPilot := newTestBranch("Helikopterpiloot")
+	pilotDuplicate := newTestBranch("piloot", helicopterPilot)
+	root := newTestBranch("Transport", pilot, pilotDuplicate)
+	root.Branches = append(root.Branches, missingID)
+	cycleA := newTestBranch("A")
+	cycleB := newTestBranch("B", cycleA)
+	cycleA.Branches = []primitive.ObjectID{cycleB.ID}
+	dbConn := newTestTreeDB(t, helicopterPilot, pilot, pilotDuplicate, root, cycleA, cycleB)
+
+	report, err := CheckIntegrity(dbConn, false)
+	NoError(t, err)
+	False(t, report.Ok())
+	False(t, report.Repaired)
+	Equal(t, []BranchReference{{BranchID: root.ID, SubBranchID: missingID}}, report.DanglingReferences)
+	Equal(t, []BranchReference{{BranchID: cycleB.ID, SubBranchID: cycleA.ID}}, report.Cycles)
+	Equal(t, []primitive.ObjectID{cycleA.ID, cycleB.ID}, report.Orphans)
+	Equal(t, []DuplicateTitle{{Title: "Piloot", ParentID: &root.ID, BranchIDs: []primitive.ObjectID{pilot.ID, pilotDuplicate.ID}}}, report.DuplicateTitles)
+
+	// The check without repair should not change anything
+	Len(t, getTestBranch(t, dbConn, root.ID).Branches, 3)
+
+	repairReport, err := CheckIntegrity(dbConn, true)
+	NoError(t, err)
+	True(t, repairReport.Repaired)
+	report.Repaired = true
+	Equal(t, report, repairReport)
+
+	Equal(t, []primitive.ObjectID{pilot.ID}, getTestBranch(t, dbConn, root.ID).Branches)
+	Len(t, getTestBranch(t, dbConn, pilot.ID).Branches, 1)
+	Empty(t, getTestBranch(t, dbConn, cycleB.ID).Branches)
+
+	report, err = CheckIntegrity(dbConn, false)
+	NoError(t, err)
+	True(t, report.Ok(), report)
+
+	tree, err := (&Tree{}).GetBranch(dbConn, nil)
+	NoError(t, err)
+	Len(t, tree.ParsedBranches, 2)
+}
@@ -57,7 +57,7 @@ func optimizeQuery(q string) (formattedQ string, apearingLetters uint32) {
 
 	if lastAppearingSpaceIdx != 0 && len(q) != 0 && resp[len(resp)-1] == ' ' {
 		// Strip trailing spaces
-		resp = resp[lastAppearingSpaceIdx:]
+		resp = resp[:lastAppearingSpaceIdx]
 	}
 
 	return string(resp), apearingLetters
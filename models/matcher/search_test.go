@@ -16,6 +16,7 @@ func TestOptimizeQuery(t *testing.T) {
 		{"a", "a"},
 		{"foo", "foo"},
 		{"foo bar", "foo bar"},
+		{"foo ", "foo"},
 		{"foo\tbar", "foo bar"},
 		{"FOO BAR", "foo bar"},
 	}
@@ -0,0 +1,466 @@
+package matcher
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrBranchNotFound is returned when a branch does not exist in the tree
+var ErrBranchNotFound = errors.New("branch not found")
+
+// TreeNode is a branch in the nested import and export format of the matcher tree
+type TreeNode struct {
+	Titles    []string   `json:"titles"`
+	TitleKind TitleKind  `json:"titleKind"`
+	Branches  []TreeNode `json:"branches,omitempty"`
+}
+
+// Export returns the root branches, or the branch with branchID, together with all their sub branches in the nested format
+// Branches with multiple parents are exported once for every parent
+func (tc *Tree) Export(dbConn db.Connection, branchID *primitive.ObjectID) ([]TreeNode, error) {
+	err := tc.build(dbConn)
+	if err != nil {
+		return nil, err
+	}
+
+	branches := []*Branch{}
+	if branchID != nil {
+		branch, ok := tc.branches[*branchID]
+		if !ok {
+			return nil, ErrBranchNotFound
+		}
+		branches = append(branches, branch)
+	} else {
+		for _, id := range tc.rootBranches {
+			branches = append(branches, tc.branches[id])
+		}
+		// The root branches come from a map, sort them so exporting the same tree always gives the same result
+		sort.Slice(branches, func(i, j int) bool {
+			return strings.Join(branches[i].Titles, "|") < strings.Join(branches[j].Titles, "|")
+		})
+	}
+
+	nodes := exportNodes(branches, map[primitive.ObjectID]bool{})
+	if nodes == nil {
+		nodes = []TreeNode{}
+	}
+	return nodes, nil
+}
+
+// exportNodes converts branches into tree nodes, nil is returned if there are no nodes
+// exporting contains the branches that are being exported higher up in the tree so a cycle can't cause an endless loop
+func exportNodes(branches []*Branch, exporting map[primitive.ObjectID]bool) []TreeNode {
+	var nodes []TreeNode
+	for _, branch := range branches {
+		if exporting[branch.ID] {
+			// Do not follow cycles
+			continue
+		}
+
+		exporting[branch.ID] = true
+		nodes = append(nodes, TreeNode{
+			Titles:    branch.Titles,
+			TitleKind: branch.TitleKind,
+			Branches:  exportNodes(branch.ParsedBranches, exporting),
+		})
+		delete(exporting, branch.ID)
+	}
+	return nodes
+}
+
+// ImportResult describes the changes made by an import
+type ImportResult struct {
+	Added     int            `json:"added"`
+	Updated   int            `json:"updated"`
+	Unchanged int            `json:"unchanged"`
+	Changes   []ImportChange `json:"changes"`
+}
+
+// ImportChange is a branch added or updated by an import
+type ImportChange struct {
+	BranchID  primitive.ObjectID `json:"branchId"`
+	Path      []string           `json:"path" description:"the first title of the branch and of its parents within the import"`
+	Added     bool               `json:"added"`
+	Titles    []string           `json:"titles"`
+	TitleKind TitleKind          `json:"titleKind"`
+	OldTitles []string           `json:"oldTitles,omitempty" description:"the titles before the import, only set for updated branches"`
+}
+
+// validateNodes checks the nodes before they are imported
+func validateNodes(nodes []TreeNode, path []string) error {
+	for _, node := range nodes {
+		nodePath := path
+		if len(node.Titles) > 0 {
+			nodePath = append(path[:len(path):len(path)], node.Titles[0])
+		}
+
+		err := AddLeafProps{Titles: node.Titles, TitleKind: node.TitleKind}.validate()
+		if err == nil {
+			for _, title := range node.Titles {
+				if strings.TrimSpace(title) == "" {
+					err = errors.New("titles cannot be empty")
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", strings.Join(nodePath, " > "), err.Error())
+		}
+
+		err = validateNodes(node.Branches, nodePath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import merges nodes into the sub branches of parentID, or into the root of the tree if parentID is nil
+//
+// A node matches an existing branch with the same parent if they share a title, titles missing from the branch are added to it.
+// Nodes that do not match a branch are added, branches missing from the import are kept.
+// This makes it safe to import the same data multiple times.
+func (tc *Tree) Import(dbConn db.Connection, parentID *primitive.ObjectID, nodes []TreeNode) (ImportResult, error) {
+	err := validateNodes(nodes, []string{})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	var result ImportResult
+	err = dbConn.WithTransaction(func(tx db.Connection) error {
+		err := tc.build(tx)
+		if err != nil {
+			return err
+		}
+
+		state := &importState{
+			added:   []*Branch{},
+			updated: map[primitive.ObjectID]*Branch{},
+			result:  ImportResult{Changes: []ImportChange{}},
+		}
+
+		var parent *Branch
+		var siblings []*Branch
+		if parentID != nil {
+			var ok bool
+			parent, ok = tc.branches[*parentID]
+			if !ok {
+				return ErrBranchNotFound
+			}
+			siblings = parent.ParsedBranches
+		} else {
+			for _, id := range tc.rootBranches {
+				siblings = append(siblings, tc.branches[id])
+			}
+		}
+		state.importNodes(parent, siblings, nodes, []string{})
+
+		if len(state.added) > 0 {
+			err = tx.InsertMany(state.added)
+			if err != nil {
+				return err
+			}
+		}
+		for _, branch := range state.updated {
+			err = tx.UpdateByID(branch)
+			if err != nil {
+				return err
+			}
+		}
+
+		result = state.result
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	if result.Added > 0 || result.Updated > 0 {
+		err = NukeCache()
+	}
+	return result, err
+}
+
+// importState keeps track of the changes made during an import so they can be written to the database at once
+type importState struct {
+	added   []*Branch
+	updated map[primitive.ObjectID]*Branch
+	result  ImportResult
+}
+
+// importNodes merges nodes into siblings, the sub branches of parent
+// parent is nil for the root of the tree
+//
+// The branches of the tree are never modified, branches are cloned before they are changed so a failed import leaves the tree untouched
+func (s *importState) importNodes(parent *Branch, siblings []*Branch, nodes []TreeNode, path []string) {
+	// Copy the siblings so adding a branch does not modify the sub branches of a branch of the tree
+	siblings = append([]*Branch{}, siblings...)
+
+	for _, node := range nodes {
+		nodePath := append(path[:len(path):len(path)], node.Titles[0])
+
+		branch := s.findBranchWithTitles(siblings, node.Titles)
+		if branch == nil {
+			branch = &Branch{
+				M:              db.NewM(),
+				Titles:         node.Titles,
+				TitleKind:      node.TitleKind,
+				Branches:       []primitive.ObjectID{},
+				ParsedBranches: []*Branch{},
+			}
+			s.added = append(s.added, branch)
+			siblings = append(siblings, branch)
+			if parent != nil {
+				parent = s.edit(parent)
+				parent.Branches = append(parent.Branches, branch.ID)
+				parent.ParsedBranches = append(parent.ParsedBranches, branch)
+			}
+
+			s.result.Added++
+			s.result.Changes = append(s.result.Changes, ImportChange{
+				BranchID:  branch.ID,
+				Path:      nodePath,
+				Added:     true,
+				Titles:    branch.Titles,
+				TitleKind: branch.TitleKind,
+			})
+		} else {
+			oldTitles := branch.Titles
+			newTitles := append([]string{}, oldTitles...)
+			for _, title := range node.Titles {
+				if !containsTitle(newTitles, title) {
+					newTitles = append(newTitles, title)
+				}
+			}
+
+			if len(newTitles) != len(oldTitles) || branch.TitleKind != node.TitleKind {
+				branch = s.edit(branch)
+				branch.Titles = newTitles
+				branch.TitleKind = node.TitleKind
+
+				s.result.Updated++
+				s.result.Changes = append(s.result.Changes, ImportChange{
+					BranchID:  branch.ID,
+					Path:      nodePath,
+					Titles:    branch.Titles,
+					TitleKind: branch.TitleKind,
+					OldTitles: oldTitles,
+				})
+			} else {
+				s.result.Unchanged++
+			}
+		}
+
+		s.importNodes(branch, branch.ParsedBranches, node.Branches, nodePath)
+	}
+}
+
+// edit returns the version of a branch that can be modified by the import
+// Existing branches are cloned and marked as updated, new branches are already inserted with all their changes
+func (s *importState) edit(branch *Branch) *Branch {
+	for _, added := range s.added {
+		if added == branch {
+			return branch
+		}
+	}
+	updated, ok := s.updated[branch.ID]
+	if !ok {
+		updated = branch.clone()
+		s.updated[branch.ID] = updated
+	}
+	return updated
+}
+
+// current returns the modified version of a branch if the import modified it
+func (s *importState) current(branch *Branch) *Branch {
+	updated, ok := s.updated[branch.ID]
+	if ok {
+		return updated
+	}
+	return branch
+}
+
+// findBranchWithTitles returns the current version of the first branch that has one of titles
+func (s *importState) findBranchWithTitles(branches []*Branch, titles []string) *Branch {
+	for _, branch := range branches {
+		branch = s.current(branch)
+		for _, title := range titles {
+			if containsTitle(branch.Titles, title) {
+				return branch
+			}
+		}
+	}
+	return nil
+}
+
+// containsTitle returns true if titles contains title, ignoring casing and surrounding whitespace
+func containsTitle(titles []string, title string) bool {
+	title = strings.TrimSpace(title)
+	for _, t := range titles {
+		if strings.EqualFold(strings.TrimSpace(t), title) {
+			return true
+		}
+	}
+	return false
+}
+
+// The CSV format contains a row per branch with the columns below
+// The id column is only used to reference the parent of a branch within the file, rows without a parent are at the top of the import
+// Titles are separated by a | and the kind is job, sector or education
+var csvHeader = []string{"id", "parent", "kind", "titles"}
+
+const csvTitlesSeparator = "|"
+
+var titleKindNames = map[TitleKind]string{
+	Job:       "job",
+	Sector:    "sector",
+	Education: "education",
+}
+
+// parseTitleKind parses the kind column of the CSV format, the number of a kind is also accepted
+func parseTitleKind(value string) (TitleKind, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for kind, name := range titleKindNames {
+		if name == value {
+			return kind, nil
+		}
+	}
+
+	nr, err := strconv.ParseUint(value, 10, 8)
+	if err == nil && TitleKind(nr).Valid() == nil {
+		return TitleKind(nr), nil
+	}
+	return 0, fmt.Errorf("unknown title kind %q", value)
+}
+
+// WriteCSV writes nodes in the CSV format
+func WriteCSV(w io.Writer, nodes []TreeNode) error {
+	csvWriter := csv.NewWriter(w)
+	err := csvWriter.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+
+	lastID := 0
+	var writeNodes func(nodes []TreeNode, parent string) error
+	writeNodes = func(nodes []TreeNode, parent string) error {
+		for _, node := range nodes {
+			lastID++
+			id := strconv.Itoa(lastID)
+			err := csvWriter.Write([]string{id, parent, titleKindNames[node.TitleKind], strings.Join(node.Titles, csvTitlesSeparator)})
+			if err != nil {
+				return err
+			}
+			err = writeNodes(node.Branches, id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = writeNodes(nodes, "")
+	if err != nil {
+		return err
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// ReadCSV reads nodes from the CSV format
+// The rows can be in any order
+func ReadCSV(r io.Reader) ([]TreeNode, error) {
+	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord = len(csvHeader)
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return []TreeNode{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for idx, column := range csvHeader {
+		if strings.ToLower(strings.TrimSpace(header[idx])) != column {
+			return nil, fmt.Errorf("expected the header to be %s", strings.Join(csvHeader, ","))
+		}
+	}
+
+	type row struct {
+		line int
+		id   string
+		node TreeNode
+	}
+	rows := map[string]*row{}
+	children := map[string][]*row{}
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := csvReader.FieldPos(0)
+
+		id := strings.TrimSpace(record[0])
+		if id == "" {
+			return nil, fmt.Errorf("line %d: missing id", line)
+		}
+		if _, ok := rows[id]; ok {
+			return nil, fmt.Errorf("line %d: duplicate id %q", line, id)
+		}
+		kind, err := parseTitleKind(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		titles := []string{}
+		for _, title := range strings.Split(record[3], csvTitlesSeparator) {
+			title = strings.TrimSpace(title)
+			if title != "" {
+				titles = append(titles, title)
+			}
+		}
+
+		r := &row{line: line, id: id, node: TreeNode{Titles: titles, TitleKind: kind}}
+		rows[id] = r
+		parent := strings.TrimSpace(record[1])
+		children[parent] = append(children[parent], r)
+	}
+
+	for parent, parentChildren := range children {
+		if _, ok := rows[parent]; parent != "" && !ok {
+			return nil, fmt.Errorf("line %d: unknown parent %q", parentChildren[0].line, parent)
+		}
+	}
+
+	reached := 0
+	var buildNodes func(parent string) []TreeNode
+	buildNodes = func(parent string) []TreeNode {
+		var nodes []TreeNode
+		for _, r := range children[parent] {
+			reached++
+			r.node.Branches = buildNodes(r.id)
+			nodes = append(nodes, r.node)
+		}
+		return nodes
+	}
+	nodes := buildNodes("")
+	if reached != len(rows) {
+		// Rows that are not reached from the top of the import must be part of a cycle
+		return nil, errors.New("the parents of the rows contain a cycle")
+	}
+	if nodes == nil {
+		nodes = []TreeNode{}
+	}
+	return nodes, nil
+}
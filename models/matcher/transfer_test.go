@@ -0,0 +1,140 @@
+package matcher
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/stretchr/testify/assert"
+)
+
+func testTreeNodes() []TreeNode {
+	return []TreeNode{
+		{
+			Titles:    []string{"Transport"},
+			TitleKind: Sector,
+			Branches: []TreeNode{
+				{Titles: []string{"Vrachtwagenchauffeur", "Truckchauffeur"}, TitleKind: Job},
+				{Titles: []string{"Piloot"}, TitleKind: Job},
+			},
+		},
+		{Titles: []string{"Zorg"}, TitleKind: Sector},
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	dbConn := testingdb.NewDB()
+	dbConn.RegisterEntries(&Branch{})
+
+	result, err := (&Tree{}).Import(dbConn, nil, testTreeNodes())
+	NoError(t, err)
+	Equal(t, 4, result.Added)
+	Equal(t, 0, result.Updated)
+	Len(t, result.Changes, 4)
+	Equal(t, []string{"Transport", "Piloot"}, result.Changes[2].Path)
+
+	exported, err := (&Tree{}).Export(dbConn, nil)
+	NoError(t, err)
+	Equal(t, testTreeNodes(), exported)
+
+	// Importing the same data again should not change anything
+	result, err = (&Tree{}).Import(dbConn, nil, testTreeNodes())
+	NoError(t, err)
+	Equal(t, ImportResult{Unchanged: 4, Changes: []ImportChange{}}, result)
+
+	// Matched branches get the missing titles and new sub branches
+	result, err = (&Tree{}).Import(dbConn, nil, []TreeNode{{
+		Titles:    []string{"transport", "Logistiek"},
+		TitleKind: Sector,
+		Branches:  []TreeNode{{Titles: []string{"Heftruckchauffeur"}, TitleKind: Job}},
+	}})
+	NoError(t, err)
+	Equal(t, 1, result.Added)
+	Equal(t, 1, result.Updated)
+	Equal(t, []string{"Transport"}, result.Changes[0].OldTitles)
+	Equal(t, []string{"Transport", "Logistiek"}, result.Changes[0].Titles)
+
+	exported, err = (&Tree{}).Export(dbConn, &result.Changes[0].BranchID)
+	NoError(t, err)
+	if Len(t, exported, 1) {
+		Equal(t, []string{"Transport", "Logistiek"}, exported[0].Titles)
+		Len(t, exported[0].Branches, 3)
+	}
+
+	// Import into a sub branch
+	result, err = (&Tree{}).Import(dbConn, &result.Changes[0].BranchID, []TreeNode{{Titles: []string{"Piloot"}, TitleKind: Job}})
+	NoError(t, err)
+	Equal(t, 1, result.Unchanged)
+
+	// Nothing is imported if part of the data is invalid
+	_, err = (&Tree{}).Import(dbConn, nil, []TreeNode{
+		{Titles: []string{"Bouw"}, TitleKind: Sector, Branches: []TreeNode{{TitleKind: Job}}},
+	})
+	EqualError(t, err, "Bouw: a title is required to add a leaf to a branch")
+	count, err := dbConn.Count(&Branch{}, nil)
+	NoError(t, err)
+	Equal(t, uint64(5), count)
+}
+
+func TestImportDoesNotModifyTheTree(t *testing.T) {
+	dbConn := testingdb.NewDB()
+	dbConn.RegisterEntries(&Branch{})
+	_, err := (&Tree{}).Import(dbConn, nil, testTreeNodes())
+	NoError(t, err)
+
+	tree := &Tree{}
+	_, err = tree.Import(dbConn, nil, []TreeNode{{
+		Titles:    []string{"Transport", "Logistiek"},
+		TitleKind: Sector,
+		Branches:  []TreeNode{{Titles: []string{"Heftruckchauffeur"}, TitleKind: Job}},
+	}})
+	NoError(t, err)
+
+	// The branches built by the import keep the data read from the database
+	for _, branch := range tree.branches {
+		if branch.Titles[0] == "Transport" {
+			Equal(t, []string{"Transport"}, branch.Titles)
+			Len(t, branch.Branches, 2)
+			Len(t, branch.ParsedBranches, 2)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := WriteCSV(buf, testTreeNodes())
+	NoError(t, err)
+	Equal(t, "id,parent,kind,titles\n"+
+		"1,,sector,Transport\n"+
+		"2,1,job,Vrachtwagenchauffeur|Truckchauffeur\n"+
+		"3,1,job,Piloot\n"+
+		"4,,sector,Zorg\n", buf.String())
+
+	nodes, err := ReadCSV(buf)
+	NoError(t, err)
+	Equal(t, testTreeNodes(), nodes)
+
+	// The rows can be in any order and the kind can be a number
+	nodes, err = ReadCSV(strings.NewReader("id,parent,kind,titles\n" +
+		"b,a,0,Piloot\n" +
+		"a,,1,Transport\n"))
+	NoError(t, err)
+	Equal(t, []TreeNode{{
+		Titles:    []string{"Transport"},
+		TitleKind: Sector,
+		Branches:  []TreeNode{{Titles: []string{"Piloot"}, TitleKind: Job}},
+	}}, nodes)
+
+	errorCases := map[string]string{
+		"id,parent,kind,titles\n1,2,job,Piloot\n":                   "line 2: unknown parent \"2\"",
+		"id,parent,kind,titles\n1,,job,Piloot\n1,,job,Kapitein\n":   "line 3: duplicate id \"1\"",
+		"id,parent,kind,titles\n1,,root,Piloot\n":                   "line 2: unknown title kind \"root\"",
+		"id,parent,kind,titles\n1,2,job,Piloot\n2,1,job,Kapitein\n": "the parents of the rows contain a cycle",
+		"name,parent,kind,titles\n":                                 "expected the header to be id,parent,kind,titles",
+	}
+	for input, expectedErr := range errorCases {
+		_, err = ReadCSV(strings.NewReader(input))
+		EqualError(t, err, expectedErr)
+	}
+}
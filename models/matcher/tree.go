@@ -1,12 +1,6 @@
 package matcher
 
 import (
-	"errors"
-	"os"
-	"sort"
-	"strings"
-
-	"github.com/apex/log"
 	"github.com/script-development/RT-CV/db"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -26,13 +20,15 @@ func (tc *Tree) GetBranch(dbConn db.Connection, branchID *primitive.ObjectID) (*
 	}
 
 	if branchID != nil {
-		// TODO what do we do when a branch cannot be found?
-		return tc.branches[*branchID], nil
+		branch, ok := tc.branches[*branchID]
+		if !ok {
+			return nil, ErrBranchNotFound
+		}
+		return branch, nil
 	}
 
 	parsedBranches := make([]*Branch, len(tc.rootBranches))
 	for idx := range tc.rootBranches {
-		// TODO what do we do when a branch cannot be found?
 		parsedBranches[idx] = tc.branches[tc.rootBranches[idx]]
 	}
 
@@ -44,29 +40,70 @@ func (tc *Tree) GetBranch(dbConn db.Connection, branchID *primitive.ObjectID) (*
 	}, nil
 }
 
+// NewTree loads the full matcher tree from the database
+// Unlike the other methods of Tree the returned tree is not rebuild on every call so it can be shared
+func NewTree(dbConn db.Connection) (*Tree, error) {
+	tc := &Tree{}
+	err := tc.build(dbConn)
+	if err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// TitlesForBranch returns the titles of a branch and the titles of all its sub branches
+// The titles of a branch are synonyms so a match on any of them is a match on the branch
+func (tc *Tree) TitlesForBranch(branchID primitive.ObjectID) ([]string, error) {
+	ids := []primitive.ObjectID{}
+	err := tc.findIDsForBranch(branchID, map[primitive.ObjectID]bool{}, &ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// A branch can be reachable via multiple parents, make sure every title is only added once
+	seen := map[string]bool{}
+	titles := []string{}
+	for _, id := range ids {
+		for _, title := range tc.branches[id].Titles {
+			if seen[title] {
+				continue
+			}
+			seen[title] = true
+			titles = append(titles, title)
+		}
+	}
+	return titles, nil
+}
+
 // GetIDsForBranch returns a spesific branches child branches their ids
 func (tc *Tree) GetIDsForBranch(dbConn db.Connection, branchID primitive.ObjectID) ([]primitive.ObjectID, error) {
-	err := tc.build(dbConn)
+	// Only the relations between the branches are required here so we do not fetch the titles
+	err := tc.build(dbConn, db.FindOptions{Projection: bson.M{"branches": 1}})
 	if err != nil {
 		return nil, err
 	}
 
 	resp := []primitive.ObjectID{}
-	err = tc.findIDsForBranch(branchID, &resp)
+	err = tc.findIDsForBranch(branchID, map[primitive.ObjectID]bool{}, &resp)
 	return resp, err
 }
 
 // findIDsForBranch adds a spesific branches child branches their ids to addTo parameter
-func (tc *Tree) findIDsForBranch(branchID primitive.ObjectID, addTo *[]primitive.ObjectID) error {
+// seen contains the ids already added so branches with multiple parents are only added once and cycles are not followed
+func (tc *Tree) findIDsForBranch(branchID primitive.ObjectID, seen map[primitive.ObjectID]bool, addTo *[]primitive.ObjectID) error {
+	if seen[branchID] {
+		return nil
+	}
+	seen[branchID] = true
 	*addTo = append(*addTo, branchID)
 
 	branch, ok := tc.branches[branchID]
 	if !ok {
-		return errors.New("branch not found")
+		return ErrBranchNotFound
 	}
 
 	for _, id := range branch.Branches {
-		err := tc.findIDsForBranch(id, addTo)
+		err := tc.findIDsForBranch(id, seen, addTo)
 		if err != nil {
 			return err
 		}
@@ -81,134 +118,78 @@ type SearchResult struct {
 	Title            string             `json:"title"`
 	TitleKind        TitleKind          `json:"kind"`
 	TotalSubBranches uint               `json:"totalSubBranches"`
+	// Path contains the ancestors of the branch starting at the root of the tree
+	// This can be used to tell branches with the same title apart
+	Path []SearchPathEntry `json:"path"`
 }
 
-// FuzzySearchCacheEntry is a single entry in the fuzzy search cache
-type FuzzySearchCacheEntry struct {
-	NormalizedTitle  string
-	TitleLetters     uint32
-	TitleLen         int
-	TotalSubBranches uint
-	Result           SearchResult
+// SearchPathEntry is a single ancestor of a search result
+type SearchPathEntry struct {
+	BranchID primitive.ObjectID `json:"branchId"`
+	Title    string             `json:"title"`
 }
 
-// Search searches for a leaf in the tree
-func (tc *Tree) Search(logger *log.Entry, dbConn db.Connection, query string) ([]SearchResult, error) {
-	// t1 := time.Now()
-
-	query, queryLetters := optimizeQuery(query)
-	querylen := len(query)
+// SearchOptions limits the results of the search method
+type SearchOptions struct {
+	// Offset is the number of results to skip
+	Offset int
+	// Limit is the maximum number of results, defaults to DefaultSearchLimit
+	Limit int
+}
 
-	matches := []SearchResult{}
-	foundEntry := func(entry FuzzySearchCacheEntry) (stop bool) {
-		if entry.TitleLetters&queryLetters == queryLetters && entry.TitleLen > querylen && strings.Contains(entry.NormalizedTitle, query) {
-			matches = append(matches, entry.Result)
-			if len(matches) == 10 {
-				stop = true
-			}
-		}
-		return stop
-	}
+// DefaultSearchLimit is the number of search results returned if no limit is set
+const DefaultSearchLimit = 10
 
-	err := searchCache(foundEntry)
+// Search searches for branches with a title that contains the query or is somewhat equal to it, see wordvalidator.IsSame
+// The results are ranked by how well the title matches the query (exact, prefix, word prefix, contains and with typos) and by the number of sub branches, a branch is only returned once.
+// The total number of matched branches is returned so the results can be paginated.
+func (tc *Tree) Search(dbConn db.Connection, query string, opts SearchOptions) (results []SearchResult, total int, err error) {
+	index, err := tc.searchIndexFor(dbConn)
 	if err != nil {
-		if os.IsNotExist(err) {
-			logger.Info("search cache is not present.. creating it")
-		} else {
-			logger.WithError(err).Warn("obtaining search cache failed.. re-creating it")
-		}
-
-		err := tc.build(dbConn)
-		if err != nil {
-			return nil, err
-		}
-
-		for _, rootBranchID := range tc.rootBranches {
-			tc.branches[rootBranchID].countTotalSubBranches()
-		}
-
-		cache := []FuzzySearchCacheEntry{}
-		for id, b := range tc.branches {
-			for _, title := range b.Titles {
-				normalizeSearchTitle, lettersApearing := optimizeQuery(title)
-
-				cache = append(cache, FuzzySearchCacheEntry{
-					NormalizedTitle:  normalizeSearchTitle,
-					TitleLetters:     lettersApearing,
-					TitleLen:         len(normalizeSearchTitle),
-					TotalSubBranches: tc.branches[id].TotalSubBranches,
-					Result: SearchResult{
-						BranchID:         id,
-						Title:            title,
-						TitleKind:        tc.branches[id].TitleKind,
-						TotalSubBranches: tc.branches[id].TotalSubBranches,
-					},
-				})
-			}
-		}
-
-		sort.Slice(cache, func(i, j int) bool {
-			return cache[i].TotalSubBranches > cache[j].TotalSubBranches
-		})
-
-		err = cacheSearch(cache)
-		if err != nil {
-			return nil, err
-		}
-
-		for _, entry := range cache {
-			if foundEntry(entry) {
-				break
-			}
-		}
+		return nil, 0, err
 	}
 
-	// t2 := time.Now()
-	// fmt.Println("build", t2.Sub(t1).Milliseconds())
-
-	return matches, nil
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultSearchLimit
+	}
+	results, total = index.search(query, opts)
+	return results, total, nil
 }
 
 // build builds the tree cache
-func (tc *Tree) build(dbConn db.Connection) error {
+// opts can be used to only fetch the fields that are needed
+func (tc *Tree) build(dbConn db.Connection, opts ...db.FindOptions) error {
 	branches := []*Branch{}
-	err := dbConn.Find(&Branch{}, &branches, bson.M{})
+	err := dbConn.Find(&Branch{}, &branches, bson.M{}, opts...)
 	if err != nil {
 		return err
 	}
 
-	tc.branches = map[primitive.ObjectID]*Branch{}
-
-	// fill the branches map and set the HasParents property
-	for idx := range branches {
-		branch := branches[idx]
+	tc.branches = make(map[primitive.ObjectID]*Branch, len(branches))
+	for _, branch := range branches {
+		// Reset the properties set below, the database might return branches of an earlier build
+		branch.HasParents = false
+		branch.TotalSubBranches = 0
+		tc.branches[branch.ID] = branch
+	}
 
+	// Link the branches to their sub branches and set the HasParents property
+	// References to branches that do not exist are skipped, use CheckIntegrity to remove them
+	for _, branch := range branches {
+		branch.ParsedBranches = make([]*Branch, 0, len(branch.Branches))
 		for _, id := range branch.Branches {
-			referencedBranch := tc.branches[id]
-			if referencedBranch == nil {
-				tc.branches[id] = &Branch{HasParents: true}
-			} else {
-				referencedBranch.HasParents = true
+			referencedBranch, ok := tc.branches[id]
+			if !ok {
+				continue
 			}
+			referencedBranch.HasParents = true
+			branch.ParsedBranches = append(branch.ParsedBranches, referencedBranch)
 		}
-
-		if exsitingBranch, ok := tc.branches[branch.ID]; ok {
-			branch.HasParents = exsitingBranch.HasParents
-		}
-		tc.branches[branch.ID] = branches[idx]
 	}
 
+	// Find the root branches
 	tc.rootBranches = []primitive.ObjectID{}
-
-	// Link the branches to their parents
-	// And find the root branches
-	for _, branch := range tc.branches {
-		branch.ParsedBranches = make([]*Branch, len(branch.Branches))
-		for idx := 0; idx < len(branch.Branches); idx++ {
-			// TODO what do we do when a branch cannot be found?
-			referencedBranch := tc.branches[branch.Branches[idx]]
-			branch.ParsedBranches[idx] = referencedBranch
-		}
+	for _, branch := range branches {
 		if !branch.HasParents {
 			tc.rootBranches = append(tc.rootBranches, branch.ID)
 		}
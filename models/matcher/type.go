@@ -74,7 +74,7 @@ type Branch struct {
 	TitleKind TitleKind `bson:"titleKind" json:"titleKind"`
 
 	// Branches contains sub branches ontop of this branch
-	Branches []primitive.ObjectID `json:"branchesIds,omitempty"`
+	Branches []primitive.ObjectID `bson:"branches" json:"branchesIds,omitempty"`
 
 	// ParsedBranches can be set when building a tree that is send to a user over the api in JSON format
 	ParsedBranches []*Branch `bson:"-" json:"branches,omitempty"`
@@ -84,6 +84,9 @@ type Branch struct {
 
 	// Set by the countTotalSubBranches method
 	TotalSubBranches uint `json:"-" bson:"-"`
+
+	// Set while counting the sub branches so a cycle in the tree can't cause an endless loop
+	counting bool
 }
 
 // CollectionName implements db.Entry
@@ -98,6 +101,16 @@ func (*Branch) Indexes() []mongo.IndexModel {
 	}
 }
 
+// clone returns a copy of the branch that can be modified without modifying the branch itself
+// The sub branches are not cloned
+func (b *Branch) clone() *Branch {
+	cloned := *b
+	cloned.Titles = append([]string{}, b.Titles...)
+	cloned.Branches = append([]primitive.ObjectID{}, b.Branches...)
+	cloned.ParsedBranches = append([]*Branch{}, b.ParsedBranches...)
+	return &cloned
+}
+
 // GetBranch fetches a spesific branch from the database
 func GetBranch(dbConn db.Connection, id primitive.ObjectID) (*Branch, error) {
 	result := &Branch{}
@@ -145,21 +158,28 @@ func (b *Branch) AddLeaf(dbConn db.Connection, props AddLeafProps, injectIntoSou
 		ParsedBranches: []*Branch{},
 	}
 
-	err = dbConn.Insert(newBranch)
+	// Insert the new branch and link it to its parent in one go so we never end up with a branch without its parent
+	oldBranches := b.Branches
+	err = dbConn.WithTransaction(func(tx db.Connection) error {
+		err := tx.Insert(newBranch)
+		if err != nil {
+			return err
+		}
+
+		b.Branches = append(b.Branches, newBranch.ID)
+		if bIsRoot {
+			return nil
+		}
+		return tx.UpdateByID(b)
+	})
 	if err != nil {
+		b.Branches = oldBranches
 		return nil, err
 	}
 
-	b.Branches = append(b.Branches, newBranch.ID)
 	if injectIntoSource {
 		b.ParsedBranches = append(b.ParsedBranches, newBranch)
 	}
-	if !bIsRoot {
-		err = dbConn.UpdateByID(b)
-		if err != nil {
-			return nil, err
-		}
-	}
 
 	err = NukeCache()
 	if err != nil {
@@ -188,12 +208,14 @@ func (b *Branch) Update(dbConn db.Connection, props AddLeafProps) error {
 
 // countTotalSubBranches counts all the children and sets the
 func (b *Branch) countTotalSubBranches() {
-	if b.TotalSubBranches != 0 {
+	if b.TotalSubBranches != 0 || b.counting {
 		return
 	}
 
+	b.counting = true
 	for _, sb := range b.ParsedBranches {
 		sb.countTotalSubBranches()
 		b.TotalSubBranches += sb.TotalSubBranches + 1
 	}
+	b.counting = false
 }
@@ -13,6 +13,7 @@ type Match struct {
 	KeyID       primitive.ObjectID      `json:"keyId" bson:"keyId" description:"the key used to upload this CV, this will be the api key used by the scraper"`
 	When        jsonHelpers.RFC3339Nano `json:"when"`
 	ReferenceNr string                  `json:"referenceNr" bson:"referenceNr" description:"The reference number of the CV"`
+	TenantID    *primitive.ObjectID     `json:"tenantId" bson:"tenantId,omitempty" description:"The tenant of the matched profile, null if the profile does not belong to a tenant"`
 
 	// Is this a debug match
 	// This is currently only true if the match was made using the /tryMatcher dashboard page
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/migrations"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Migrations contains the migrations of the database, they are applied on startup
+// Add new migrations to the end of the list with a higher version
+var Migrations = []migrations.Migration{
+	{
+		Version: 1,
+		Name:    "set the missing disabled field of on match hooks",
+		Up: func(conn db.Connection) (uint64, error) {
+			// Disabled was added later to on match hooks so older hooks might not have it
+			return conn.UpdateMany(
+				&OnMatchHook{},
+				bson.M{"disabled": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"disabled": false}},
+			)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "set the missing listsAllowed field of profiles",
+		Up: func(conn db.Connection) (uint64, error) {
+			return conn.UpdateMany(
+				&Profile{},
+				bson.M{"listsAllowed": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"listsAllowed": false}},
+			)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "remove the old id of converted profiles",
+		Up: func(conn db.Connection) (uint64, error) {
+			// The conversion of the old profiles is long done, the old ids are not used anymore
+			return conn.UpdateMany(
+				&Profile{},
+				bson.M{"_old_id": bson.M{"$exists": true}},
+				bson.M{"$unset": bson.M{"_old_id": ""}},
+			)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "rename the leafid field of profile professions to leafId",
+		Up:      migrateProfessionLeafIDs,
+	},
+	{
+		Version: 5,
+		Name:    "move duplicated external ids of profiles to the " + duplicateExternalIDLabel + " label",
+		Up:      migrateDuplicateProfileExternalIDs,
+	},
+}
+
+// legacyProfileProfession is a ProfileProfession as it was stored before the leafId field had a bson tag
+type legacyProfileProfession struct {
+	Name      string             `bson:"name"`
+	LeafID    primitive.ObjectID `bson:"leafid"`
+	NewLeafID primitive.ObjectID `bson:"leafId"`
+}
+
+func (p legacyProfileProfession) convert() ProfileProfession {
+	leafID := p.NewLeafID
+	if leafID.IsZero() {
+		leafID = p.LeafID
+	}
+	return ProfileProfession{Name: p.Name, LeafId: leafID}
+}
+
+// legacyProfile contains the fields of a profile that contain professions
+type legacyProfile struct {
+	db.M                  `bson:",inline"`
+	DesiredProfessions    []legacyProfileProfession `bson:"desiredProfessions"`
+	ProfessionExperienced []legacyProfileProfession `bson:"professionExperienced"`
+}
+
+func (*legacyProfile) CollectionName() string {
+	return "profiles"
+}
+
+func migrateProfessionLeafIDs(conn db.Connection) (uint64, error) {
+	profiles := []legacyProfile{}
+	err := conn.Find(&legacyProfile{}, &profiles, bson.M{"$or": []bson.M{
+		{"desiredProfessions.leafid": bson.M{"$exists": true}},
+		{"professionExperienced.leafid": bson.M{"$exists": true}},
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	convert := func(professions []legacyProfileProfession) []ProfileProfession {
+		if professions == nil {
+			return nil
+		}
+		res := make([]ProfileProfession, len(professions))
+		for idx, profession := range professions {
+			res[idx] = profession.convert()
+		}
+		return res
+	}
+
+	for _, profile := range profiles {
+		_, err = conn.UpdateMany(&Profile{}, bson.M{"_id": profile.ID}, bson.M{"$set": bson.M{
+			"desiredProfessions":    convert(profile.DesiredProfessions),
+			"professionExperienced": convert(profile.ProfessionExperienced),
+		}})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return uint64(len(profiles)), nil
+}
+
+// duplicateExternalIDLabel contains the external id of a profile that had the same external id as an older profile of its tenant
+const duplicateExternalIDLabel = "duplicateExternalId"
+
+// migrateDuplicateProfileExternalIDs makes the external ids of profiles unique per tenant so the unique index on the external id can be created
+// The oldest profile keeps the external id, the others get the external id as the duplicateExternalId label so it's not lost
+func migrateDuplicateProfileExternalIDs(conn db.Connection) (uint64, error) {
+	externalIDLabel := "lables." + ProfileExternalIDLabel
+	profiles := []Profile{}
+	err := conn.Find(&Profile{}, &profiles, bson.M{externalIDLabel: bson.M{"$type": "string"}}, db.FindOptions{
+		Sort:       bson.D{{Key: "_id", Value: 1}},
+		Projection: bson.M{"_id": 1, "tenantId": 1, "lables": 1},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	type tenantExternalID struct {
+		tenantID   primitive.ObjectID // Zero for profiles without a tenant
+		externalID string
+	}
+	var updated uint64
+	seen := map[tenantExternalID]bool{}
+	for _, profile := range profiles {
+		externalID := profile.ExternalID()
+		key := tenantExternalID{externalID: externalID}
+		if profile.TenantID != nil {
+			key.tenantID = *profile.TenantID
+		}
+		if !seen[key] {
+			seen[key] = true
+			continue
+		}
+
+		log.WithField("profile", profile.ID.Hex()).Warnf("profile has the same external id %q as an older profile, the external id is moved to the %s label", externalID, duplicateExternalIDLabel)
+		_, err = conn.UpdateMany(&Profile{}, bson.M{"_id": profile.ID}, bson.M{
+			"$set":   bson.M{"lables." + duplicateExternalIDLabel: externalID},
+			"$unset": bson.M{externalIDLabel: ""},
+		})
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
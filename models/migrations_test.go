@@ -0,0 +1,49 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/tj/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMigrateDuplicateProfileExternalIDs(t *testing.T) {
+	conn := testingdb.NewDB()
+	tenantID := primitive.NewObjectID()
+	newProfile := func(name string, tenantID *primitive.ObjectID, externalID string) *Profile {
+		return &Profile{
+			M:      db.NewM(),
+			T:      db.T{TenantID: tenantID},
+			Name:   name,
+			Lables: map[string]any{ProfileExternalIDLabel: externalID},
+		}
+	}
+	original := newProfile("original", nil, "a")
+	duplicate := newProfile("duplicate", nil, "a")
+	otherTenant := newProfile("other tenant", &tenantID, "a")
+	unique := newProfile("unique", nil, "b")
+	// The profiles are stored before the unique index exists
+	err := conn.Insert(original, duplicate, otherTenant, unique)
+	NoError(t, err)
+
+	updated, err := migrateDuplicateProfileExternalIDs(conn)
+	NoError(t, err)
+	Equal(t, uint64(1), updated)
+
+	for _, profile := range []*Profile{original, otherTenant, unique} {
+		stored, err := GetProfile(conn, profile.ID)
+		NoError(t, err)
+		Equal(t, profile.ExternalID(), stored.ExternalID(), profile.Name)
+	}
+	stored, err := GetProfile(conn, duplicate.ID)
+	NoError(t, err)
+	Equal(t, "", stored.ExternalID())
+	Equal(t, "a", stored.Lables[duplicateExternalIDLabel])
+
+	// After the migration the unique index can be created
+	conn.RegisterEntries(&Profile{})
+	err = conn.Insert(newProfile("copy", nil, "a"))
+	Error(t, err)
+}
@@ -24,6 +24,7 @@ type Header struct {
 // OnMatchHook can hook onto the matching process and call API calls in case of matches
 type OnMatchHook struct {
 	db.M     `bson:",inline"`
+	db.T     `bson:",inline"`
 	KeyID    primitive.ObjectID `json:"keyId" bson:"keyId"`
 	Disabled bool               `json:"disabled" bson:"disabled"`
 
@@ -48,10 +49,7 @@ func GetOnMatchHooks(dbConn db.Connection, props GetOnMatchHooksProps) ([]OnMatc
 	query := bson.M{}
 	if !props.AllowDisabled {
 		// We do not allow disabled
-		//
-		// As disabled was later added to OnMatchHook it might be missing from the database
-		// Because of this we check for the opposite of disabled hence this bloated query
-		query["disabled"] = bson.M{"$not": bson.M{"$eq": true}}
+		query["disabled"] = false
 	}
 
 	hooks := []OnMatchHook{}
@@ -75,6 +73,8 @@ const (
 	DataKindMatch DataKind = iota
 	// DataKindList is the data kind for when a list of cvs is matched
 	DataKindList
+	// DataKindProfileStatus is the data kind for when a profile is activated or paused by its schedule or match budget
+	DataKindProfileStatus
 )
 
 func (k DataKind) contentTypeAndDataKind() (contentType string, dataKind string) {
@@ -83,6 +83,8 @@ func (k DataKind) contentTypeAndDataKind() (contentType string, dataKind string)
 		contentType, dataKind = "application/json", "match"
 	case DataKindList:
 		contentType, dataKind = "application/json", "list"
+	case DataKindProfileStatus:
+		contentType, dataKind = "application/json", "profileStatus"
 	}
 	return contentType, dataKind
 }
@@ -1,9 +1,10 @@
 package models
 
 import (
-	"errors"
 	"fmt"
-	"regexp"
+	"reflect"
+	"strings"
+	"time"
 
 	fuzzymatcher "github.com/mjarkk/fuzzy-matcher"
 	"github.com/script-development/RT-CV/db"
@@ -11,11 +12,13 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Profile contains all the information about a search profile
 type Profile struct {
 	db.M            `bson:",inline"`
+	db.T            `bson:",inline"`
 	Name            string               `json:"name"`
 	Active          bool                 `json:"active"`
 	AllowedScrapers []primitive.ObjectID `json:"allowedScrapers" bson:"allowedScrapers" description:"Define a list of scraper keys that can use this profile, if value is undefined or empty all keys are allowed"`
@@ -40,12 +43,19 @@ type Profile struct {
 	// What should happen on a match
 	OnMatch ProfileOnMatch `json:"onMatch" bson:"onMatch" description:"What should happen when a match is made on this profile"`
 
-	Lables map[string]any `json:"labels" description:"custom labels that can be used by API users to identify profiles, the key needs to be a string and the value can be anything"`
+	// Labels are stored under the lables key in the database for backwards compatibility
+	Lables map[string]any `json:"labels" bson:"lables" description:"custom labels that can be used by API users to identify profiles, the key needs to be a string and the value can be anything"`
 
 	ListsAllowed bool `json:"listsAllowed" bson:"listsAllowed"`
 
-	// OldID is used to keep track of converted old profiles
-	OldID *uint64 `bson:"_old_id" json:"-"`
+	// Scheduling, see profileSchedule.go
+	ActiveFrom         *time.Time             `json:"activeFrom" bson:"activeFrom,omitempty" description:"The profile only matches from this moment on, if null the profile matches right away"`
+	ActiveUntil        *time.Time             `json:"activeUntil" bson:"activeUntil,omitempty" description:"The profile stops matching at this moment, if null the profile keeps matching"`
+	ActiveWindows      []ProfileActiveWindow  `json:"activeWindows" bson:"activeWindows,omitempty" description:"The profile only matches within one of these windows, if empty the profile matches at any time"`
+	MaxMatchesPerDay   int                    `json:"maxMatchesPerDay" bson:"maxMatchesPerDay,omitempty" description:"The profile pauses itself for the rest of the day after this number of matches, 0 means no limit"`
+	MaxMatchesTotal    int                    `json:"maxMatchesTotal" bson:"maxMatchesTotal,omitempty" description:"The profile pauses itself after this number of matches, 0 means no limit"`
+	MatchCount         ProfileMatchCount      `json:"matchCount" bson:"matchCount" description:"The number of matches made by this profile, only counted if a maximum number of matches is set. Set by RT-CV"`
+	LastScheduleStatus *ProfileScheduleStatus `json:"lastScheduleStatus" bson:"lastScheduleStatus,omitempty" description:"The schedule status of the profile the last time it was checked, this is checked every minute. Set by RT-CV"`
 
 	// Variables set by the matching process only when they needed
 	// These are mainly used for caching so we don't have to calculate values twice
@@ -53,6 +63,8 @@ type Profile struct {
 	EducationFuzzyMatcherCache             *fuzzymatcher.Matcher        `bson:"-" json:"-"`
 	ProfessionExperiencedFuzzyMatcherCache *fuzzymatcher.Matcher        `bson:"-" json:"-"`
 	DesiredProfessionsFuzzyMatcherCache    *fuzzymatcher.Matcher        `bson:"-" json:"-"`
+	DesiredProfessionsTreeMatcherCache     *fuzzymatcher.Matcher        `bson:"-" json:"-"`
+	DesiredProfessionsTreeMatcherIdxCache  []int                        `bson:"-" json:"-"` // maps the titles of DesiredProfessionsTreeMatcherCache to DesiredProfessions
 	DomainPartsCache                       [][]string                   `bson:"-" json:"-"`
 	NormalizedDriversLicensesCache         []jsonHelpers.DriversLicense `bson:"-" json:"-"`
 
@@ -75,6 +87,14 @@ func (*Profile) Indexes() []mongo.IndexModel {
 		{Keys: bson.M{"zipCodes": 1}},
 		{Keys: bson.M{"onMatch.sendMail": 1}},
 		{Keys: bson.M{"listsAllowed": 1}},
+		// Imports match profiles on the external id so it must be unique within a tenant, profiles without one are not part of the index
+		// Before this index existed duplicates could be stored, these are removed by a migration
+		{
+			Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "lables." + ProfileExternalIDLabel, Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"lables." + ProfileExternalIDLabel: bson.M{"$type": "string"},
+			}),
+		},
 	}
 }
 
@@ -91,6 +111,17 @@ func GetListsProfiles(conn db.Connection) ([]Profile, error) {
 	return profiles, err
 }
 
+// IsListsProfile returns true if the profile would be returned by GetListsProfiles
+func (p *Profile) IsListsProfile() bool {
+	return p.Active && p.ListsAllowed && len(p.Zipcodes) > 0
+}
+
+// IsActualMatchActive returns true if the profile would be returned by GetActualMatchActiveProfiles
+func (p *Profile) IsActualMatchActive() bool {
+	return p.Active && !p.ListsAllowed &&
+		(len(p.DesiredProfessions) > 0 || len(p.ProfessionExperienced) > 0 || len(p.DriversLicenses) > 0 || len(p.Educations) > 0)
+}
+
 func actualActiveMatchProfilesFilter() bson.M {
 	return bson.M{
 		"active": true,
@@ -100,8 +131,7 @@ func actualActiveMatchProfilesFilter() bson.M {
 			{"driversLicenses": isArrayWContent},
 			{"educations": isArrayWContent},
 		},
-		// we use $not here as there are properties without this property and with `$not: true` we match `false`, `undefined` and `null
-		"listsAllowed": bson.M{"$not": bson.M{"$eq": true}},
+		"listsAllowed": false,
 	}
 }
 
@@ -120,9 +150,9 @@ func GetActualMatchActiveProfilesCount(conn db.Connection) (uint64, error) {
 }
 
 // GetProfiles returns all profiles from the database
-func GetProfiles(conn db.Connection, filters primitive.M) ([]Profile, error) {
+func GetProfiles(conn db.Connection, filters primitive.M, opts ...db.FindOptions) ([]Profile, error) {
 	profiles := []Profile{}
-	err := conn.Find(&Profile{}, &profiles, filters)
+	err := conn.Find(&Profile{}, &profiles, filters, opts...)
 	return profiles, err
 }
 
@@ -138,10 +168,121 @@ func GetProfile(conn db.Connection, id primitive.ObjectID) (Profile, error) {
 	return profile, err
 }
 
+// profileManagedFields are the database fields of a profile set by RT-CV itself
+// These are updated while matching and scheduling so they are never written by UpdateProfileFields
+var profileManagedFields = map[string]bool{
+	"matchCount":         true,
+	"lastScheduleStatus": true,
+}
+
+// UpdateProfileFields stores the fields of the profile that are set by users
+// Unlike UpdateByID this leaves the fields managed by RT-CV untouched, a match or schedule check made at the same time is not overwritten
+func UpdateProfileFields(conn db.Connection, profile *Profile) error {
+	set := bson.M{}
+	unset := bson.M{}
+
+	profileValue := reflect.ValueOf(profile).Elem()
+	forEachProfileUserField(func(fieldIdx int, name string, omitempty bool) {
+		value := profileValue.Field(fieldIdx)
+		if omitempty && value.IsZero() {
+			unset[name] = ""
+		} else {
+			set[name] = value.Interface()
+		}
+	})
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	_, err := conn.UpdateMany(&Profile{}, bson.M{"_id": profile.ID}, update)
+	return err
+}
+
+// ProfileUserFieldsEqual returns true if the fields set by users are equal in a and b
+// Changes that only touch the fields managed by RT-CV, like the match count, are ignored
+func ProfileUserFieldsEqual(a, b *Profile) bool {
+	if a.ID != b.ID || !db.SameTenant(a.TenantID, b.TenantID) {
+		return false
+	}
+
+	aValue := reflect.ValueOf(a).Elem()
+	bValue := reflect.ValueOf(b).Elem()
+	equal := true
+	forEachProfileUserField(func(fieldIdx int, _ string, _ bool) {
+		if equal && !reflect.DeepEqual(aValue.Field(fieldIdx).Interface(), bValue.Field(fieldIdx).Interface()) {
+			equal = false
+		}
+	})
+	return equal
+}
+
+// forEachProfileUserField calls fn with the index of every database field of a profile that is set by users
+func forEachProfileUserField(fn func(fieldIdx int, name string, omitempty bool)) {
+	profileType := reflect.TypeOf(Profile{})
+	for i := 0; i < profileType.NumField(); i++ {
+		field := profileType.Field(i)
+		name, tagOptions, _ := strings.Cut(field.Tag.Get("bson"), ",")
+		if !field.IsExported() || name == "-" || strings.Contains(tagOptions, "inline") {
+			// The id and tenant are inlined and never change
+			continue
+		}
+		if name == "" {
+			// The default name used by the mongo driver
+			name = strings.ToLower(field.Name)
+		}
+		if profileManagedFields[name] {
+			continue
+		}
+
+		fn(i, name, strings.Contains(tagOptions, "omitempty"))
+	}
+}
+
+// RelinkProfileProfessions links the professions of all profiles that are linked to one of the from matcher tree branches to the to branch
+// This is used when matcher tree branches are merged, it returns the number of updated profiles
+func RelinkProfileProfessions(conn db.Connection, from []primitive.ObjectID, to primitive.ObjectID) (int, error) {
+	if len(from) == 0 {
+		return 0, nil
+	}
+
+	// Profiles of all tenants can be linked to the matcher tree
+	conn = db.WithoutTenant(conn)
+	profiles := []Profile{}
+	err := conn.Find(&Profile{}, &profiles, bson.M{"$or": []bson.M{
+		{"desiredProfessions.leafId": bson.M{"$in": from}},
+		{"professionExperienced.leafId": bson.M{"$in": from}},
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	relink := func(professions []ProfileProfession) {
+		for idx, profession := range professions {
+			for _, id := range from {
+				if profession.LeafId == id {
+					professions[idx].LeafId = to
+					break
+				}
+			}
+		}
+	}
+	for idx := range profiles {
+		profile := &profiles[idx]
+		relink(profile.DesiredProfessions)
+		relink(profile.ProfessionExperienced)
+		err = UpdateProfileFields(conn, profile)
+		if err != nil {
+			return idx, err
+		}
+	}
+	return len(profiles), nil
+}
+
 // ProfileProfession contains information about a proffession
 type ProfileProfession struct {
-	Name   string `json:"name"`
-	LeafId primitive.ObjectID
+	Name   string             `json:"name"`
+	LeafId primitive.ObjectID `bson:"leafId"`
 }
 
 // ProfileDriversLicense contains the drivers license name
@@ -185,52 +326,40 @@ type ProfileSendEmailData struct {
 }
 
 // CheckAPIKeysExists checks if apiKeys are valid IDs of existing keys
+// If conn is limited to a tenant the keys must be shared keys or keys of the same tenant
 func CheckAPIKeysExists(conn db.Connection, apiKeys []primitive.ObjectID) error {
-	if len(apiKeys) == 0 {
-		return nil
-	}
-
-	apiKeysInDB, err := GetAPIKeys(conn)
+	unknownKeys, err := unknownAPIKeys(conn, apiKeys)
 	if err != nil {
 		return err
 	}
-outer:
 	for _, allowedKey := range apiKeys {
-		for _, apiKey := range apiKeysInDB {
-			if allowedKey == apiKey.ID {
-				continue outer
-			}
+		if unknownKeys[allowedKey] {
+			return fmt.Errorf("unknown api key id %s", allowedKey.Hex())
 		}
-		return fmt.Errorf("unknown api key id %s", allowedKey.Hex())
 	}
 	return nil
 }
 
-// ValidateCreateNewProfile validates a new profile to create
-func (p *Profile) ValidateCreateNewProfile(conn db.Connection) error {
-	// TODO this needs more validation
-
-	if p.Name == "" {
-		return errors.New("name must be set")
+// unknownAPIKeys returns the apiKeys that are not existing keys usable by the tenant of conn
+func unknownAPIKeys(conn db.Connection, apiKeys []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	unknownKeys := map[primitive.ObjectID]bool{}
+	if len(apiKeys) == 0 {
+		return unknownKeys, nil
 	}
 
-	if len(p.AllowedScrapers) > 0 {
-		err := CheckAPIKeysExists(conn, p.AllowedScrapers)
-		if err != nil {
-			return err
-		}
+	apiKeysInDB, err := GetAPIKeys(db.WithoutTenant(conn))
+	if err != nil {
+		return nil, err
 	}
-
-	emailRegex := regexp.MustCompile(
-		"^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@" +
-			"[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?" +
-			"(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$",
-	)
-	for idx, mail := range p.OnMatch.SendMail {
-		if len(mail.Email) < 3 || len(mail.Email) > 254 || !emailRegex.MatchString(mail.Email) {
-			return fmt.Errorf("onMatch.sendMail[%d].email: invalid email address", idx)
+	tenantID := db.TenantOf(conn)
+outer:
+	for _, allowedKey := range apiKeys {
+		for _, apiKey := range apiKeysInDB {
+			if allowedKey == apiKey.ID && (apiKey.TenantID == nil || db.SameTenant(apiKey.TenantID, tenantID)) {
+				continue outer
+			}
 		}
+		unknownKeys[allowedKey] = true
 	}
-
-	return nil
+	return unknownKeys, nil
 }
@@ -0,0 +1,455 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+
+This file contains a restricted query language for searching profiles
+
+A query looks like a MongoDB filter but only a whitelist of fields and operators can be used:
+
+	{
+		"name": {"$contains": "developer"},
+		"labels.customer": "a",
+		"$or": [{"active": true}, {"yearsSinceWork": {"$lte": 3}}]
+	}
+
+The query is validated and translated into a filter that only uses operators that behave the same in MongoDB and the testingdb
+This makes sure integrators get the same results in tests and production
+
+*/
+
+const (
+	profileQueryMaxDepth       = 5
+	profileQueryMaxConditions  = 100
+	profileQueryMaxInValues    = 1000
+	profileQueryMaxContainsLen = 256
+)
+
+type profileQueryFieldKind uint8
+
+const (
+	profileQueryString profileQueryFieldKind = iota
+	profileQueryBool
+	profileQueryInt
+	profileQueryObjectID
+	profileQueryLabel
+)
+
+type profileQueryField struct {
+	dbPath string
+	kind   profileQueryFieldKind
+	// isList is true if the field is a list or is nested within a list
+	isList bool
+}
+
+// profileQueryFields contains all fields that can be used in a profile query
+// The key is the field name used in the query, labels are handled separately
+var profileQueryFields = map[string]profileQueryField{
+	"id":                         {"_id", profileQueryObjectID, false},
+	"tenantId":                   {"tenantId", profileQueryObjectID, false},
+	"name":                       {"name", profileQueryString, false},
+	"active":                     {"active", profileQueryBool, false},
+	"listsAllowed":               {"listsAllowed", profileQueryBool, false},
+	"allowedScrapers":            {"allowedScrapers", profileQueryObjectID, true},
+	"mustDesiredProfession":      {"mustDesiredProfession", profileQueryBool, false},
+	"desiredProfessions.name":    {"desiredProfessions.name", profileQueryString, true},
+	"yearsSinceWork":             {"yearsSinceWork", profileQueryInt, false},
+	"mustExpProfession":          {"mustExpProfession", profileQueryBool, false},
+	"professionExperienced.name": {"professionExperienced.name", profileQueryString, true},
+	"mustDriversLicense":         {"mustDriversLicense", profileQueryBool, false},
+	"driversLicenses.name":       {"driversLicenses.name", profileQueryString, true},
+	"mustEducationFinished":      {"mustEducationFinished", profileQueryBool, false},
+	"mustEducation":              {"mustEducation", profileQueryBool, false},
+	"yearsSinceEducation":        {"yearsSinceEducation", profileQueryInt, false},
+	"educations.name":            {"educations.name", profileQueryString, true},
+	"zipCodes.from":              {"zipCodes.from", profileQueryInt, true},
+	"zipCodes.to":                {"zipCodes.to", profileQueryInt, true},
+	"onMatch.sendMail.email":     {"onMatch.sendMail.email", profileQueryString, true},
+}
+
+// ProfileQuery is a validated profile query
+// Use ParseProfileQuery to create one
+type ProfileQuery struct {
+	conditions []bson.M
+}
+
+// ParseProfileQuery validates a json profile query and translates it into a ProfileQuery
+func ParseProfileQuery(data []byte) (ProfileQuery, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return ProfileQuery{}, nil
+	}
+
+	query := map[string]any{}
+	err := json.Unmarshal(data, &query)
+	if err != nil {
+		return ProfileQuery{}, fmt.Errorf("query must be a json object, %s", err.Error())
+	}
+
+	parser := profileQueryParser{}
+	conditions, err := parser.parseObject(query, "", 0)
+	if err != nil {
+		return ProfileQuery{}, err
+	}
+	return ProfileQuery{conditions: conditions}, nil
+}
+
+// Filter returns the database filter of the query
+func (q ProfileQuery) Filter() bson.M {
+	if len(q.conditions) == 0 {
+		return bson.M{}
+	}
+	return bson.M{"$and": q.conditions}
+}
+
+type profileQueryParser struct {
+	conditionsCount int
+}
+
+func (p *profileQueryParser) addCondition(conditions []bson.M, condition bson.M) ([]bson.M, error) {
+	p.conditionsCount++
+	if p.conditionsCount > profileQueryMaxConditions {
+		return nil, fmt.Errorf("query cannot contain more than %d conditions", profileQueryMaxConditions)
+	}
+	return append(conditions, condition), nil
+}
+
+func joinProfileQueryPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func (p *profileQueryParser) parseObject(query map[string]any, path string, depth int) ([]bson.M, error) {
+	if depth > profileQueryMaxDepth {
+		return nil, fmt.Errorf("%s: query cannot be nested more than %d levels deep", path, profileQueryMaxDepth)
+	}
+
+	// Sort the keys so errors and the resulting filter are deterministic
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	conditions := []bson.M{}
+	for _, key := range keys {
+		value := query[key]
+		keyPath := joinProfileQueryPath(path, key)
+
+		if key == "$and" || key == "$or" || key == "$nor" {
+			entries, ok := value.([]any)
+			if !ok || len(entries) == 0 {
+				return nil, fmt.Errorf("%s: expected a non empty list of queries", keyPath)
+			}
+
+			subConditions := []bson.M{}
+			for idx, entry := range entries {
+				entryPath := fmt.Sprintf("%s.%d", keyPath, idx)
+				entryQuery, ok := entry.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("%s: expected a query object", entryPath)
+				}
+				entryConditions, err := p.parseObject(entryQuery, entryPath, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				if len(entryConditions) == 0 {
+					return nil, fmt.Errorf("%s: query cannot be empty", entryPath)
+				}
+				subConditions = append(subConditions, bson.M{"$and": entryConditions})
+			}
+
+			var err error
+			conditions, err = p.addCondition(conditions, bson.M{key: subConditions})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if strings.HasPrefix(key, "$") {
+			return nil, fmt.Errorf("%s: unsupported operator, only $and, $or and $nor can be used here", keyPath)
+		}
+
+		field, err := lookupProfileQueryField(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", keyPath, err.Error())
+		}
+
+		operators, isOperatorsObject := value.(map[string]any)
+		if !isOperatorsObject {
+			// {"field": value} is a shorthand for {"field": {"$eq": value}}
+			operators = map[string]any{"$eq": value}
+		} else if len(operators) == 0 {
+			return nil, fmt.Errorf("%s: expected at least one operator", keyPath)
+		}
+
+		operatorKeys := make([]string, 0, len(operators))
+		for operator := range operators {
+			operatorKeys = append(operatorKeys, operator)
+		}
+		sort.Strings(operatorKeys)
+
+		for _, operator := range operatorKeys {
+			condition, err := field.condition(operator, operators[operator])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", joinProfileQueryPath(keyPath, operator), err.Error())
+			}
+			conditions, err = p.addCondition(conditions, condition)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return conditions, nil
+}
+
+var validLabelKey = regexp.MustCompile(`^[^.$]+$`)
+
+func lookupProfileQueryField(key string) (profileQueryField, error) {
+	if strings.HasPrefix(key, "labels.") {
+		labelKey := strings.TrimPrefix(key, "labels.")
+		if !validLabelKey.MatchString(labelKey) {
+			return profileQueryField{}, errors.New("label keys cannot be empty or contain a . or $")
+		}
+		return profileQueryField{
+			// The labels are stored under the lables key, see Profile.Lables
+			dbPath: "lables." + labelKey,
+			kind:   profileQueryLabel,
+		}, nil
+	}
+
+	field, ok := profileQueryFields[key]
+	if !ok {
+		return profileQueryField{}, errors.New("unknown or unsupported field, use labels.<key> to query labels")
+	}
+	return field, nil
+}
+
+func (f profileQueryField) condition(operator string, value any) (bson.M, error) {
+	switch operator {
+	case "$eq":
+		converted, err := f.convertValue(value, true)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{f.dbPath: converted}, nil
+	case "$ne":
+		// On list fields this matches profiles where none of the entries is equal to the value
+		converted, err := f.convertValue(value, true)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{f.dbPath: bson.M{"$ne": converted}}, nil
+	case "$gt", "$gte", "$lt", "$lte":
+		if f.kind != profileQueryInt && f.kind != profileQueryLabel {
+			return nil, errors.New("comparison operators can only be used on number fields and labels")
+		}
+		number, ok := value.(float64)
+		if !ok {
+			return nil, errors.New("expected a number")
+		}
+		converted, err := f.convertValue(number, false)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{f.dbPath: bson.M{operator: converted}}, nil
+	case "$in", "$nin":
+		values, ok := value.([]any)
+		if !ok {
+			return nil, errors.New("expected a list of values")
+		}
+		if len(values) > profileQueryMaxInValues {
+			return nil, fmt.Errorf("expected at most %d values", profileQueryMaxInValues)
+		}
+		converted := make([]any, len(values))
+		for idx, entry := range values {
+			var err error
+			converted[idx], err = f.convertValue(entry, true)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s", idx, err.Error())
+			}
+		}
+		return bson.M{f.dbPath: bson.M{operator: converted}}, nil
+	case "$exists":
+		exists, ok := value.(bool)
+		if !ok {
+			return nil, errors.New("expected a boolean")
+		}
+		if !exists {
+			return bson.M{f.dbPath: nil}, nil
+		}
+		// MongoDB also sees fields set to null as existing, we don't
+		return bson.M{f.dbPath: bson.M{"$exists": true, "$ne": nil}}, nil
+	case "$contains":
+		if f.kind != profileQueryString && f.kind != profileQueryLabel {
+			return nil, errors.New("$contains can only be used on text fields and labels")
+		}
+		text, ok := value.(string)
+		if !ok || len(text) == 0 {
+			return nil, errors.New("expected a non empty string")
+		}
+		if len(text) > profileQueryMaxContainsLen {
+			return nil, fmt.Errorf("text cannot be longer than %d characters", profileQueryMaxContainsLen)
+		}
+		return bson.M{f.dbPath: bson.M{"$regex": regexp.QuoteMeta(text), "$options": "i"}}, nil
+	default:
+		return nil, errors.New("unsupported operator, supported operators are $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists and $contains")
+	}
+}
+
+// convertValue converts a json value into the value stored in the database
+func (f profileQueryField) convertValue(value any, allowNull bool) (any, error) {
+	if value == nil {
+		if !allowNull {
+			return nil, errors.New("value cannot be null")
+		}
+		return nil, nil
+	}
+
+	switch f.kind {
+	case profileQueryString:
+		if _, ok := value.(string); !ok {
+			return nil, errors.New("expected a string")
+		}
+		return value, nil
+	case profileQueryBool:
+		if _, ok := value.(bool); !ok {
+			return nil, errors.New("expected a boolean")
+		}
+		return value, nil
+	case profileQueryInt:
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) || math.Abs(number) > math.MaxInt32 {
+			return nil, errors.New("expected a whole number")
+		}
+		return int64(number), nil
+	case profileQueryObjectID:
+		hex, ok := value.(string)
+		if !ok {
+			return nil, errors.New("expected an id")
+		}
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return nil, errors.New("expected a valid id")
+		}
+		return id, nil
+	case profileQueryLabel:
+		switch value.(type) {
+		case string, bool, float64:
+			return value, nil
+		default:
+			return nil, errors.New("labels can only be compared with strings, numbers and booleans")
+		}
+	default:
+		return nil, errors.New("unknown field kind")
+	}
+}
+
+// profileSortField is a field profiles can be sorted on
+type profileSortField struct {
+	dbField string
+	compare func(a, b *Profile) int
+}
+
+// profileSortFields contains the fields profiles can be sorted on, both in the database and in memory
+var profileSortFields = map[string]profileSortField{
+	"id": {"_id", func(a, b *Profile) int {
+		return bytes.Compare(a.ID[:], b.ID[:])
+	}},
+	"name": {"name", func(a, b *Profile) int {
+		return strings.Compare(a.Name, b.Name)
+	}},
+	"yearsSinceWork": {"yearsSinceWork", func(a, b *Profile) int {
+		return compareIntPtrs(a.YearsSinceWork, b.YearsSinceWork)
+	}},
+	"yearsSinceEducation": {"yearsSinceEducation", func(a, b *Profile) int {
+		return compareIntPtrs(a.YearsSinceEducation, b.YearsSinceEducation)
+	}},
+}
+
+// ProfileSortFields returns the fields profiles can be sorted on mapped to their database fields
+func ProfileSortFields() map[string]string {
+	res := make(map[string]string, len(profileSortFields))
+	for field, sortField := range profileSortFields {
+		res[field] = sortField.dbField
+	}
+	return res
+}
+
+// compareIntPtrs compares 2 numbers where nil is smaller than any number, just like MongoDB sorts null values
+func compareIntPtrs(a, b *int) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	case *a < *b:
+		return -1
+	case *a > *b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ProfileQueryPage describes the sorting and pagination of a profile query
+type ProfileQueryPage struct {
+	// Sort is the field to sort on, prefix with a - for descending order
+	// Can be one of the fields of ProfileSortFields, other values sort on id
+	Sort string
+	// Limit is the max number of profiles returned, 0 means no limit
+	Limit int
+	// Offset is the number of profiles to skip
+	Offset int
+}
+
+// Apply sorts the profiles and returns the requested page
+// Ties are sorted by id so pages are stable
+func (p ProfileQueryPage) Apply(profiles []Profile) []Profile {
+	sortField := strings.TrimPrefix(p.Sort, "-")
+	descending := strings.HasPrefix(p.Sort, "-")
+	sortOn, ok := profileSortFields[sortField]
+	if !ok {
+		sortOn = profileSortFields["id"]
+	}
+
+	sort.SliceStable(profiles, func(i, j int) bool {
+		a, b := &profiles[i], &profiles[j]
+		res := sortOn.compare(a, b)
+		if res == 0 {
+			res = bytes.Compare(a.ID[:], b.ID[:])
+		}
+		if descending {
+			return res > 0
+		}
+		return res < 0
+	})
+
+	if p.Offset >= len(profiles) {
+		return []Profile{}
+	}
+	profiles = profiles[p.Offset:]
+	if p.Limit > 0 && p.Limit < len(profiles) {
+		profiles = profiles[:p.Limit]
+	}
+	return profiles
+}
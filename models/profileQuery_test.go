@@ -0,0 +1,130 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseProfileQueryErrors(t *testing.T) {
+	scenarios := []struct {
+		name  string
+		query string
+	}{
+		{"not an object", `[]`},
+		{"unknown field", `{"secret": 1}`},
+		{"raw mongo operator", `{"$where": "true"}`},
+		{"unknown operator", `{"name": {"$regex": ".*"}}`},
+		{"wrong value type", `{"active": "yes"}`},
+		{"comparison on text", `{"name": {"$gt": 1}}`},
+		{"contains on a bool", `{"active": {"$contains": "t"}}`},
+		{"not a whole number", `{"yearsSinceWork": 1.5}`},
+		{"invalid id", `{"id": "abc"}`},
+		{"$nin without a list", `{"name": {"$nin": "a"}}`},
+		{"empty $nor", `{"$nor": []}`},
+		{"empty $or", `{"$or": []}`},
+		{"empty $or entry", `{"$or": [{}]}`},
+		{"invalid label key", `{"labels.a.b": 1}`},
+		{"object label value", `{"labels.a": {"$eq": {"b": 1}}}`},
+		{"too deep", `{"$or": [{"$or": [{"$or": [{"$or": [{"$or": [{"$or": [{"name": "a"}]}]}]}]}]}]}`},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			_, err := ParseProfileQuery([]byte(s.query))
+			Error(t, err)
+		})
+	}
+}
+
+func TestProfileQuery(t *testing.T) {
+	yearsSinceWork := 2
+	profileA := &Profile{
+		M:                  db.NewM(),
+		Name:               "Senior Developer",
+		Active:             true,
+		YearsSinceWork:     &yearsSinceWork,
+		DesiredProfessions: []ProfileProfession{{Name: "Developer"}},
+		Lables:             map[string]any{"customer": "a", "amount": 2},
+	}
+	profileB := &Profile{
+		M:        db.NewM(),
+		Name:     "Baker",
+		Active:   false,
+		Lables:   map[string]any{"customer": "b"},
+		Zipcodes: []ProfileDutchZipcode{{From: 1000, To: 2000}},
+	}
+	conn := testingdb.NewDB()
+	err := conn.Insert(profileA, profileB)
+	NoError(t, err)
+
+	scenarios := []struct {
+		name     string
+		query    string
+		expected []*Profile
+	}{
+		{"empty query", ``, []*Profile{profileA, profileB}},
+		{"equal", `{"active": true}`, []*Profile{profileA}},
+		{"not equal", `{"name": {"$ne": "Baker"}}`, []*Profile{profileA}},
+		{"contains", `{"name": {"$contains": "DEV"}}`, []*Profile{profileA}},
+		{"contains escapes regex", `{"name": {"$contains": ".*"}}`, []*Profile{}},
+		{"list field", `{"desiredProfessions.name": "Developer"}`, []*Profile{profileA}},
+		{"comparison", `{"yearsSinceWork": {"$lte": 3}}`, []*Profile{profileA}},
+		{"comparison with null", `{"yearsSinceWork": {"$gt": 3}}`, []*Profile{}},
+		{"in", `{"id": {"$in": ["` + profileB.ID.Hex() + `"]}}`, []*Profile{profileB}},
+		{"exists", `{"zipCodes.from": {"$exists": true}}`, []*Profile{profileB}},
+		{"not exists", `{"yearsSinceWork": {"$exists": false}}`, []*Profile{profileB}},
+		{"label", `{"labels.customer": "b"}`, []*Profile{profileB}},
+		{"label number", `{"labels.amount": {"$gte": 2}}`, []*Profile{profileA}},
+		{"missing label", `{"labels.amount": {"$exists": false}}`, []*Profile{profileB}},
+		{"or", `{"$or": [{"labels.customer": "a"}, {"zipCodes.to": 2000}]}`, []*Profile{profileA, profileB}},
+		{"nor", `{"$nor": [{"labels.customer": "a"}, {"active": true}]}`, []*Profile{profileB}},
+		{"not equal on a list", `{"desiredProfessions.name": {"$ne": "Developer"}}`, []*Profile{profileB}},
+		{"not in", `{"name": {"$nin": ["Baker", "Cook"]}}`, []*Profile{profileA}},
+		{"not in a list", `{"desiredProfessions.name": {"$nin": ["Developer"]}}`, []*Profile{profileB}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			query, err := ParseProfileQuery([]byte(s.query))
+			NoError(t, err)
+
+			profiles, err := GetProfiles(conn, query.Filter())
+			NoError(t, err)
+
+			expectedNames := []string{}
+			for _, profile := range s.expected {
+				expectedNames = append(expectedNames, profile.Name)
+			}
+			names := []string{}
+			for _, profile := range profiles {
+				names = append(names, profile.Name)
+			}
+			ElementsMatch(t, expectedNames, names)
+		})
+	}
+}
+
+func TestProfileQueryPage(t *testing.T) {
+	one, two := 1, 2
+	profiles := []Profile{
+		{M: db.NewM(), Name: "b", YearsSinceWork: &two},
+		{M: db.NewM(), Name: "c"},
+		{M: db.NewM(), Name: "a", YearsSinceWork: &one},
+	}
+
+	names := func(profiles []Profile) []string {
+		res := []string{}
+		for _, profile := range profiles {
+			res = append(res, profile.Name)
+		}
+		return res
+	}
+
+	Equal(t, []string{"a", "b", "c"}, names(ProfileQueryPage{Sort: "name"}.Apply(profiles)))
+	Equal(t, []string{"b", "a", "c"}, names(ProfileQueryPage{Sort: "-yearsSinceWork"}.Apply(profiles)))
+	Equal(t, []string{"b"}, names(ProfileQueryPage{Sort: "name", Offset: 1, Limit: 1}.Apply(profiles)))
+	Equal(t, []string{}, names(ProfileQueryPage{Offset: 5}.Apply(profiles)))
+}
@@ -0,0 +1,287 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProfileRestoreWindow is how long a deleted profile can be restored
+const ProfileRestoreWindow = 30 * 24 * time.Hour
+
+// maxProfileRevisionAttempts is how often AddProfileRevision tries to store a revision when another revision of the profile is stored at the same time
+const maxProfileRevisionAttempts = 5
+
+// ErrProfileNotRestorable is returned when restoring a profile that is not deleted or was deleted longer than ProfileRestoreWindow ago
+var ErrProfileNotRestorable = errors.New("profile is not deleted or can no longer be restored")
+
+// ProfileRevisionAction is the change made to a profile in a revision
+type ProfileRevisionAction string
+
+const (
+	// ProfileRevisionCreated is used for the revision of a newly created profile
+	ProfileRevisionCreated ProfileRevisionAction = "created"
+	// ProfileRevisionUpdated is used when a profile is modified
+	ProfileRevisionUpdated ProfileRevisionAction = "updated"
+	// ProfileRevisionDeleted is used when a profile is deleted, the profile of the revision is the profile as it was before it was deleted
+	ProfileRevisionDeleted ProfileRevisionAction = "deleted"
+	// ProfileRevisionRestored is used when a deleted profile is restored
+	ProfileRevisionRestored ProfileRevisionAction = "restored"
+	// ProfileRevisionRolledBack is used when a profile is rolled back to an earlier revision
+	ProfileRevisionRolledBack ProfileRevisionAction = "rolledBack"
+)
+
+// ProfileRevision is a version of a profile stored every time a profile is changed
+type ProfileRevision struct {
+	db.M        `bson:",inline"`
+	db.T        `bson:",inline"`
+	ProfileID   primitive.ObjectID    `json:"profileId" bson:"profileId"`
+	Revision    int                   `json:"revision" bson:"revision" description:"The revision number, starts at 1 and is increased for every change to the profile"`
+	When        time.Time             `json:"when" bson:"when"`
+	AuthorKeyID *primitive.ObjectID   `json:"authorKeyId" bson:"authorKeyId,omitempty" description:"The api key that made the change"`
+	Action      ProfileRevisionAction `json:"action" bson:"action"`
+	Changes     []ProfileFieldChange  `json:"changes" bson:"changes" description:"The top level fields of the profile changed by this revision"`
+	Profile     Profile               `json:"profile" bson:"profile" description:"The profile after this revision, for deleted profiles this is the profile as it was before it was deleted"`
+}
+
+// ProfileFieldChange is a change to a single top level field of a profile
+type ProfileFieldChange struct {
+	Field string `json:"field" bson:"field" description:"The json name of the field"`
+	Old   any    `json:"old" bson:"old"`
+	New   any    `json:"new" bson:"new"`
+}
+
+// CollectionName returns the collection name of the ProfileRevision
+func (*ProfileRevision) CollectionName() string {
+	return "profileRevisions"
+}
+
+// Indexes implements db.Entry
+func (*ProfileRevision) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "profileId", Value: 1}, {Key: "revision", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"action": 1}},
+	}
+}
+
+// AddProfileRevision stores a new revision of profile
+// old is the profile before the change and is used to calculate the changed fields, it can be nil for new, deleted and restored profiles
+//
+// The revision numbers of a profile are unique, if another revision of the profile is stored at the same time the revision number is taken again
+func AddProfileRevision(conn db.Connection, action ProfileRevisionAction, authorKeyID *primitive.ObjectID, old *Profile, profile Profile) error {
+	changes := []ProfileFieldChange{}
+	var err error
+	if action == ProfileRevisionCreated {
+		changes, err = diffProfiles(Profile{}, profile)
+	} else if old != nil {
+		changes, err = diffProfiles(*old, profile)
+	}
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		latest, err := latestProfileRevision(conn, profile.ID)
+		if err == mongo.ErrNoDocuments {
+			// This is the first revision of the profile
+			err = nil
+		} else if err != nil {
+			return err
+		}
+
+		err = conn.Insert(&ProfileRevision{
+			M:           db.NewM(),
+			T:           db.T{TenantID: profile.TenantID},
+			ProfileID:   profile.ID,
+			Revision:    latest.Revision + 1,
+			When:        time.Now(),
+			AuthorKeyID: authorKeyID,
+			Action:      action,
+			Changes:     changes,
+			Profile:     profile,
+		})
+		if err == nil || !mongo.IsDuplicateKeyError(err) || attempt == maxProfileRevisionAttempts {
+			return err
+		}
+	}
+}
+
+// latestProfileRevision returns the last revision of a profile
+// Returns mongo.ErrNoDocuments if there are no revisions
+func latestProfileRevision(conn db.Connection, profileID primitive.ObjectID) (ProfileRevision, error) {
+	revision := ProfileRevision{}
+	err := conn.FindOne(&revision, bson.M{"profileId": profileID}, db.FindOptions{
+		Sort: bson.D{{Key: "revision", Value: -1}},
+	})
+	return revision, err
+}
+
+// GetProfileRevisions returns all revisions of a profile starting with the oldest
+func GetProfileRevisions(conn db.Connection, profileID primitive.ObjectID) ([]ProfileRevision, error) {
+	revisions := []ProfileRevision{}
+	err := conn.Find(&ProfileRevision{}, &revisions, bson.M{"profileId": profileID}, db.FindOptions{
+		Sort: bson.D{{Key: "revision", Value: 1}},
+	})
+	return revisions, err
+}
+
+// GetProfileRevision returns a single revision of a profile
+func GetProfileRevision(conn db.Connection, profileID primitive.ObjectID, revision int) (ProfileRevision, error) {
+	result := ProfileRevision{}
+	err := conn.FindOne(&result, bson.M{"profileId": profileID, "revision": revision})
+	return result, err
+}
+
+// GetDeletedProfiles returns the revisions of the deleted profiles that can still be restored, the most recently deleted profile first
+func GetDeletedProfiles(conn db.Connection) ([]ProfileRevision, error) {
+	revisions := []ProfileRevision{}
+	err := conn.Find(&ProfileRevision{}, &revisions, bson.M{
+		"action": ProfileRevisionDeleted,
+		"when":   bson.M{"$gte": time.Now().Add(-ProfileRestoreWindow)},
+	}, db.FindOptions{
+		Sort: bson.D{{Key: "when", Value: -1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Profiles that are restored after they where deleted have a newer revision
+	deleted := []ProfileRevision{}
+	for _, revision := range revisions {
+		latest, err := latestProfileRevision(conn, revision.ProfileID)
+		if err != nil {
+			return nil, err
+		}
+		if latest.ID == revision.ID {
+			deleted = append(deleted, revision)
+		}
+	}
+	return deleted, nil
+}
+
+// GetRestorableProfile returns the deletion revision of a profile that can be restored
+// Returns ErrProfileNotRestorable if the profile is not deleted or the restore window has passed
+func GetRestorableProfile(conn db.Connection, profileID primitive.ObjectID) (ProfileRevision, error) {
+	latest, err := latestProfileRevision(conn, profileID)
+	if err == mongo.ErrNoDocuments {
+		return latest, ErrProfileNotRestorable
+	} else if err != nil {
+		return latest, err
+	}
+
+	if latest.Action != ProfileRevisionDeleted || time.Since(latest.When) > ProfileRestoreWindow {
+		return latest, ErrProfileNotRestorable
+	}
+	return latest, nil
+}
+
+// PruneProfileRevisions removes the revisions of the profiles that are deleted and can no longer be restored
+// Returns the number of removed revisions
+func PruneProfileRevisions(conn db.Connection, now time.Time) (uint64, error) {
+	// The revisions of all tenants are pruned
+	conn = db.WithoutTenant(conn)
+
+	revisions := []ProfileRevision{}
+	err := conn.Find(&ProfileRevision{}, &revisions, bson.M{
+		"action": ProfileRevisionDeleted,
+		"when":   bson.M{"$lt": now.Add(-ProfileRestoreWindow)},
+	}, db.FindOptions{
+		Projection: bson.M{"profileId": 1, "revision": 1},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var pruned uint64
+	for _, revision := range revisions {
+		latest, err := latestProfileRevision(conn, revision.ProfileID)
+		if err == mongo.ErrNoDocuments {
+			// The profile was deleted multiple times and the revisions are already removed
+			continue
+		} else if err != nil {
+			return pruned, err
+		}
+		if latest.Revision != revision.Revision {
+			// The profile was restored after it was deleted
+			continue
+		}
+
+		removed, err := conn.DeleteMany(&ProfileRevision{}, bson.M{"profileId": revision.ProfileID})
+		if err != nil {
+			return pruned, err
+		}
+		pruned += removed
+	}
+	return pruned, nil
+}
+
+// diffProfiles returns the top level fields that are different between a and b
+func diffProfiles(a, b Profile) ([]ProfileFieldChange, error) {
+	aFields, err := profileFields(a)
+	if err != nil {
+		return nil, err
+	}
+	bFields, err := profileFields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldNames := []string{}
+	for field := range aFields {
+		fieldNames = append(fieldNames, field)
+	}
+	for field := range bFields {
+		if _, ok := aFields[field]; !ok {
+			fieldNames = append(fieldNames, field)
+		}
+	}
+	sort.Strings(fieldNames)
+
+	changes := []ProfileFieldChange{}
+	for _, field := range fieldNames {
+		if field == "id" {
+			continue
+		}
+		if isEmptyJSONValue(aFields[field]) && isEmptyJSONValue(bFields[field]) {
+			// Don't report changes like null to an empty list
+			continue
+		}
+		if !reflect.DeepEqual(aFields[field], bFields[field]) {
+			changes = append(changes, ProfileFieldChange{Field: field, Old: aFields[field], New: bFields[field]})
+		}
+	}
+	return changes, nil
+}
+
+// profileFields returns the top level json fields of a profile
+func profileFields(profile Profile) (map[string]any, error) {
+	profileJSON, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]any{}
+	err = json.Unmarshal(profileJSON, &fields)
+	return fields, err
+}
+
+// isEmptyJSONValue returns true for null, empty lists and empty objects
+func isEmptyJSONValue(value any) bool {
+	switch typedValue := value.(type) {
+	case nil:
+		return true
+	case []any:
+		return len(typedValue) == 0
+	case map[string]any:
+		return len(typedValue) == 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/tj/assert"
+)
+
+func TestDiffProfiles(t *testing.T) {
+	yearsSinceWork := 3
+	a := Profile{M: db.NewM(), Name: "a", DesiredProfessions: []ProfileProfession{}}
+	b := Profile{M: a.M, Name: "b", YearsSinceWork: &yearsSinceWork}
+
+	changes, err := diffProfiles(a, b)
+	NoError(t, err)
+	Equal(t, []ProfileFieldChange{
+		{Field: "name", Old: "a", New: "b"},
+		{Field: "yearsSinceWork", Old: nil, New: float64(3)},
+	}, changes)
+
+	changes, err = diffProfiles(a, a)
+	NoError(t, err)
+	Empty(t, changes)
+}
+
+func TestGetRestorableProfile(t *testing.T) {
+	conn := testingdb.NewDB()
+	profile := Profile{M: db.NewM(), Name: "deleted"}
+
+	_, err := GetRestorableProfile(conn, profile.ID)
+	Equal(t, ErrProfileNotRestorable, err)
+
+	err = AddProfileRevision(conn, ProfileRevisionCreated, nil, nil, profile)
+	NoError(t, err)
+	_, err = GetRestorableProfile(conn, profile.ID)
+	Equal(t, ErrProfileNotRestorable, err)
+
+	err = AddProfileRevision(conn, ProfileRevisionDeleted, nil, nil, profile)
+	NoError(t, err)
+	revision, err := GetRestorableProfile(conn, profile.ID)
+	NoError(t, err)
+	Equal(t, 2, revision.Revision)
+	Equal(t, "deleted", revision.Profile.Name)
+
+	// Profiles deleted before the restore window can't be restored
+	revision.When = time.Now().Add(-ProfileRestoreWindow - time.Hour)
+	err = conn.UpdateByID(&revision)
+	NoError(t, err)
+	_, err = GetRestorableProfile(conn, profile.ID)
+	Equal(t, ErrProfileNotRestorable, err)
+	deleted, err := GetDeletedProfiles(conn)
+	NoError(t, err)
+	Empty(t, deleted)
+}
+
+// racingConnection stores a competing revision right before the first revision is inserted
+type racingConnection struct {
+	*testingdb.TestConnection
+	raced bool
+}
+
+func (c *racingConnection) Insert(data ...db.Entry) error {
+	if !c.raced {
+		c.raced = true
+		competing := *data[0].(*ProfileRevision)
+		competing.M = db.NewM()
+		err := c.TestConnection.Insert(&competing)
+		if err != nil {
+			return err
+		}
+	}
+	return c.TestConnection.Insert(data...)
+}
+
+func TestAddProfileRevisionConcurrently(t *testing.T) {
+	testingDB := testingdb.NewDB()
+	testingDB.RegisterEntries(&ProfileRevision{})
+	conn := &racingConnection{TestConnection: testingDB}
+	profile := Profile{M: db.NewM(), Name: "a"}
+
+	err := AddProfileRevision(conn, ProfileRevisionCreated, nil, nil, profile)
+	NoError(t, err)
+
+	revisions, err := GetProfileRevisions(testingDB, profile.ID)
+	NoError(t, err)
+	Len(t, revisions, 2)
+	Equal(t, 1, revisions[0].Revision)
+	Equal(t, 2, revisions[1].Revision)
+}
+
+func TestPruneProfileRevisions(t *testing.T) {
+	conn := testingdb.NewDB()
+	expired := Profile{M: db.NewM(), Name: "expired"}
+	restored := Profile{M: db.NewM(), Name: "restored"}
+	recent := Profile{M: db.NewM(), Name: "recent"}
+
+	addRevisions := func(profile Profile, actions ...ProfileRevisionAction) {
+		for _, action := range actions {
+			err := AddProfileRevision(conn, action, nil, nil, profile)
+			NoError(t, err)
+		}
+	}
+	addRevisions(expired, ProfileRevisionCreated, ProfileRevisionDeleted)
+	addRevisions(restored, ProfileRevisionCreated, ProfileRevisionDeleted, ProfileRevisionRestored)
+	addRevisions(recent, ProfileRevisionCreated, ProfileRevisionDeleted)
+
+	// Move the deletes of the expired and restored profiles to before the restore window
+	for _, profile := range []Profile{expired, restored} {
+		revision, err := GetProfileRevision(conn, profile.ID, 2)
+		NoError(t, err)
+		revision.When = time.Now().Add(-ProfileRestoreWindow - time.Hour)
+		err = conn.UpdateByID(&revision)
+		NoError(t, err)
+	}
+
+	pruned, err := PruneProfileRevisions(conn, time.Now())
+	NoError(t, err)
+	Equal(t, uint64(2), pruned)
+
+	revisions, err := GetProfileRevisions(conn, expired.ID)
+	NoError(t, err)
+	Empty(t, revisions)
+	revisions, err = GetProfileRevisions(conn, restored.ID)
+	NoError(t, err)
+	Len(t, revisions, 3)
+	revisions, err = GetProfileRevisions(conn, recent.ID)
+	NoError(t, err)
+	Len(t, revisions, 2)
+}
@@ -0,0 +1,313 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	// Embed the timezone database so the schedule location is also available in minimal containers
+	_ "time/tzdata"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// scheduleLocation is the timezone used for the active windows and the daily match budget of profiles
+var scheduleLocation = func() *time.Location {
+	location, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		log.WithError(err).Warn("unable to load the Europe/Amsterdam timezone, using the local timezone for profile schedules")
+		return time.Local
+	}
+	return location
+}()
+
+// ProfileActiveWindow is a period of the week in which a profile is allowed to match
+type ProfileActiveWindow struct {
+	Weekdays []time.Weekday `json:"weekdays" bson:"weekdays" description:"The days of the week this window applies to where 0 is sunday and 6 is saturday, if empty the window applies to every day"`
+	From     string         `json:"from" bson:"from" description:"The start of the window in the Europe/Amsterdam timezone formatted as HH:MM"`
+	To       string         `json:"to" bson:"to" description:"The end of the window formatted as HH:MM, if this is before from the window ends on the next day"`
+}
+
+// ProfileMatchCount counts the matches made with a profile so the match budgets can be enforced
+type ProfileMatchCount struct {
+	Total int    `json:"total" bson:"total"`
+	Day   string `json:"day" bson:"day" description:"The day of onDay formatted as YYYY-MM-DD"`
+	OnDay int    `json:"onDay" bson:"onDay"`
+}
+
+// ProfileScheduleStatus tells if a profile can match right now and if not why
+type ProfileScheduleStatus string
+
+const (
+	// ProfileStatusActive means the profile can match
+	ProfileStatusActive ProfileScheduleStatus = "active"
+	// ProfileStatusInactive means the profile is manually disabled using the active field
+	ProfileStatusInactive ProfileScheduleStatus = "inactive"
+	// ProfileStatusScheduled means the activeFrom date of the profile is not yet reached
+	ProfileStatusScheduled ProfileScheduleStatus = "scheduled"
+	// ProfileStatusExpired means the activeUntil date of the profile has passed
+	ProfileStatusExpired ProfileScheduleStatus = "expired"
+	// ProfileStatusOutsideWindow means the current time is outside of the active windows of the profile
+	ProfileStatusOutsideWindow ProfileScheduleStatus = "outsideWindow"
+	// ProfileStatusDailyBudgetReached means the profile made the maximum number of matches for today
+	ProfileStatusDailyBudgetReached ProfileScheduleStatus = "dailyBudgetReached"
+	// ProfileStatusBudgetReached means the profile made the maximum number of matches
+	ProfileStatusBudgetReached ProfileScheduleStatus = "budgetReached"
+)
+
+// scheduleDay returns the day used for the daily match budget
+func scheduleDay(now time.Time) string {
+	return now.In(scheduleLocation).Format("2006-01-02")
+}
+
+// parseWindowTime parses a HH:MM time into the number of minutes since midnight
+func parseWindowTime(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid time, expected HH:MM", value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// Contains returns true if now is within the window
+func (w ProfileActiveWindow) Contains(now time.Time) bool {
+	from, err := parseWindowTime(w.From)
+	if err != nil {
+		return false
+	}
+	to, err := parseWindowTime(w.To)
+	if err != nil {
+		return false
+	}
+
+	now = now.In(scheduleLocation)
+	minute := now.Hour()*60 + now.Minute()
+	weekday := now.Weekday()
+	if to <= from && minute < to {
+		// We are in the part of the window that continues after midnight, so the window started yesterday
+		weekday = (weekday + 6) % 7
+	} else if to <= from {
+		if minute < from {
+			return false
+		}
+	} else if minute < from || minute >= to {
+		return false
+	}
+
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, day := range w.Weekdays {
+		if day == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// ScheduleStatus returns if the profile can match at now and if not why
+func (p *Profile) ScheduleStatus(now time.Time) ProfileScheduleStatus {
+	if !p.Active {
+		return ProfileStatusInactive
+	}
+	if p.ActiveFrom != nil && now.Before(*p.ActiveFrom) {
+		return ProfileStatusScheduled
+	}
+	if p.ActiveUntil != nil && !now.Before(*p.ActiveUntil) {
+		return ProfileStatusExpired
+	}
+	if p.MaxMatchesTotal > 0 && p.MatchCount.Total >= p.MaxMatchesTotal {
+		return ProfileStatusBudgetReached
+	}
+	if p.MaxMatchesPerDay > 0 && p.MatchCount.Day == scheduleDay(now) && p.MatchCount.OnDay >= p.MaxMatchesPerDay {
+		return ProfileStatusDailyBudgetReached
+	}
+	if len(p.ActiveWindows) > 0 {
+		for _, window := range p.ActiveWindows {
+			if window.Contains(now) {
+				return ProfileStatusActive
+			}
+		}
+		return ProfileStatusOutsideWindow
+	}
+	return ProfileStatusActive
+}
+
+// IsScheduledActive returns true if the profile is active and its schedule and match budget allow it to match at now
+func (p *Profile) IsScheduledActive(now time.Time) bool {
+	return p.ScheduleStatus(now) == ProfileStatusActive
+}
+
+// hasMatchBudget returns true if the number of matches of the profile is limited
+func (p *Profile) hasMatchBudget() bool {
+	return p.MaxMatchesPerDay > 0 || p.MaxMatchesTotal > 0
+}
+
+// validateSchedule validates the active period, active windows and match budgets of a profile
+func (p *Profile) validateSchedule(errs *ProfileValidationErrors) {
+	if p.ActiveFrom != nil && p.ActiveUntil != nil && !p.ActiveUntil.After(*p.ActiveFrom) {
+		errs.add("activeUntil", ProfileValidationOutOfRange, "must be after activeFrom")
+	}
+	for idx, window := range p.ActiveWindows {
+		_, err := parseWindowTime(window.From)
+		if err != nil {
+			errs.add(fmt.Sprintf("activeWindows[%d].from", idx), ProfileValidationInvalid, "%s", err.Error())
+		}
+		_, err = parseWindowTime(window.To)
+		if err != nil {
+			errs.add(fmt.Sprintf("activeWindows[%d].to", idx), ProfileValidationInvalid, "%s", err.Error())
+		}
+		for _, day := range window.Weekdays {
+			if day < time.Sunday || day > time.Saturday {
+				errs.add(fmt.Sprintf("activeWindows[%d].weekdays", idx), ProfileValidationOutOfRange, "%d is not a valid weekday, expected 0 (sunday) to 6 (saturday)", day)
+			}
+		}
+	}
+	if p.MaxMatchesPerDay < 0 {
+		errs.add("maxMatchesPerDay", ProfileValidationOutOfRange, "cannot be negative")
+	}
+	if p.MaxMatchesTotal < 0 {
+		errs.add("maxMatchesTotal", ProfileValidationOutOfRange, "cannot be negative")
+	}
+}
+
+// maxMatchBudgetAttempts is the number of times we try to update the match count of a profile when it's changed at the same time by another request
+const maxMatchBudgetAttempts = 5
+
+// ReserveProfileMatch counts a match of a profile with a match budget
+// Returns false if the budget of the profile is already used up, in that case the match should be dropped
+// Profiles without a match budget are not counted and always return true
+func ReserveProfileMatch(conn db.Connection, profile *Profile, now time.Time) (bool, error) {
+	if !profile.hasMatchBudget() {
+		return true, nil
+	}
+
+	conn = db.WithoutTenant(conn)
+	for attempt := 0; attempt < maxMatchBudgetAttempts; attempt++ {
+		current, err := GetProfile(conn, profile.ID)
+		if err != nil {
+			return false, err
+		}
+		if !current.hasMatchBudget() {
+			return true, nil
+		}
+
+		count := current.MatchCount
+		day := scheduleDay(now)
+		if count.Day != day {
+			count.Day = day
+			count.OnDay = 0
+		}
+		if current.MaxMatchesTotal > 0 && count.Total >= current.MaxMatchesTotal ||
+			current.MaxMatchesPerDay > 0 && count.OnDay >= current.MaxMatchesPerDay {
+			return false, nil
+		}
+		count.Total++
+		count.OnDay++
+
+		// Only update the count if nobody else changed it since we read it
+		updated, err := conn.UpdateMany(&Profile{}, bson.M{
+			"_id":              profile.ID,
+			"matchCount.total": current.MatchCount.Total,
+			"matchCount.day":   current.MatchCount.Day,
+			"matchCount.onDay": current.MatchCount.OnDay,
+		}, bson.M{"$set": bson.M{"matchCount": count}})
+		if err != nil {
+			return false, err
+		}
+		if updated == 1 {
+			return true, nil
+		}
+	}
+	return false, errors.New("unable to update the match count of the profile, it's changed too often by other requests")
+}
+
+// ProfileStatusEvent is send to the on match hooks when the schedule status of a profile changes
+type ProfileStatusEvent struct {
+	ProfileID      primitive.ObjectID    `json:"profileId"`
+	ProfileName    string                `json:"profileName"`
+	TenantID       *primitive.ObjectID   `json:"tenantId"`
+	When           time.Time             `json:"when"`
+	PreviousStatus ProfileScheduleStatus `json:"previousStatus"`
+	Status         ProfileScheduleStatus `json:"status"`
+}
+
+// scheduleCheckFilter matches the profiles of which the schedule status might have changed
+// The status of a profile without an active period, active windows or match budget only changes with the active field
+// so these profiles are only checked if their last status doesn't match the active field
+var scheduleCheckFilter = bson.M{"$or": bson.A{
+	bson.M{"active": true, "$or": bson.A{
+		bson.M{"activeFrom": bson.M{"$ne": nil}},
+		bson.M{"activeUntil": bson.M{"$ne": nil}},
+		bson.M{"activeWindows.0": bson.M{"$exists": true}},
+		bson.M{"maxMatchesPerDay": bson.M{"$gt": 0}},
+		bson.M{"maxMatchesTotal": bson.M{"$gt": 0}},
+		bson.M{"lastScheduleStatus": bson.M{"$ne": ProfileStatusActive}},
+	}},
+	bson.M{"active": bson.M{"$ne": true}, "lastScheduleStatus": bson.M{"$ne": ProfileStatusInactive}},
+}}
+
+// scheduleCheckProjection contains the fields needed to check the schedule status of a profile
+var scheduleCheckProjection = bson.M{
+	"_id":                1,
+	"tenantId":           1,
+	"name":               1,
+	"active":             1,
+	"activeFrom":         1,
+	"activeUntil":        1,
+	"activeWindows":      1,
+	"maxMatchesPerDay":   1,
+	"maxMatchesTotal":    1,
+	"matchCount":         1,
+	"lastScheduleStatus": 1,
+}
+
+// CheckProfileSchedules updates the schedule status of all profiles and returns the status changes
+// Changes from or to the inactive status are made manually and not returned, neither are profiles that never had a status before
+// The status is updated only if no other instance of RT-CV updated it first so every change is only returned once
+func CheckProfileSchedules(conn db.Connection, now time.Time) ([]ProfileStatusEvent, error) {
+	conn = db.WithoutTenant(conn)
+	profiles, err := GetProfiles(conn, scheduleCheckFilter, db.FindOptions{Projection: scheduleCheckProjection})
+	if err != nil {
+		return nil, err
+	}
+
+	events := []ProfileStatusEvent{}
+	for _, profile := range profiles {
+		status := profile.ScheduleStatus(now)
+		previousStatus := profile.LastScheduleStatus
+		if previousStatus != nil && *previousStatus == status {
+			continue
+		}
+
+		// A nil filter value matches profiles where the field is not set
+		var previousStatusFilter any
+		if previousStatus != nil {
+			previousStatusFilter = *previousStatus
+		}
+		updated, err := conn.UpdateMany(
+			&Profile{},
+			bson.M{"_id": profile.ID, "lastScheduleStatus": previousStatusFilter},
+			bson.M{"$set": bson.M{"lastScheduleStatus": status}},
+		)
+		if err != nil {
+			return events, err
+		}
+		if updated == 0 || previousStatus == nil || *previousStatus == ProfileStatusInactive || status == ProfileStatusInactive {
+			continue
+		}
+
+		events = append(events, ProfileStatusEvent{
+			ProfileID:      profile.ID,
+			ProfileName:    profile.Name,
+			TenantID:       profile.TenantID,
+			When:           now,
+			PreviousStatus: *previousStatus,
+			Status:         status,
+		})
+	}
+	return events, nil
+}
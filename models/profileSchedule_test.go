@@ -0,0 +1,190 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/tj/assert"
+)
+
+func scheduleTime(t *testing.T, value string) time.Time {
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, scheduleLocation)
+	NoError(t, err)
+	return parsed
+}
+
+func TestProfileActiveWindowContains(t *testing.T) {
+	// 2022-06-06 is a monday
+	workingHours := ProfileActiveWindow{Weekdays: []time.Weekday{time.Monday}, From: "09:00", To: "17:00"}
+	True(t, workingHours.Contains(scheduleTime(t, "2022-06-06 09:00")))
+	True(t, workingHours.Contains(scheduleTime(t, "2022-06-06 16:59")))
+	False(t, workingHours.Contains(scheduleTime(t, "2022-06-06 17:00")))
+	False(t, workingHours.Contains(scheduleTime(t, "2022-06-06 08:59")))
+	False(t, workingHours.Contains(scheduleTime(t, "2022-06-07 12:00")))
+
+	// A window that continues after midnight belongs to the day it started
+	nightShift := ProfileActiveWindow{Weekdays: []time.Weekday{time.Monday}, From: "22:00", To: "06:00"}
+	True(t, nightShift.Contains(scheduleTime(t, "2022-06-06 23:00")))
+	True(t, nightShift.Contains(scheduleTime(t, "2022-06-07 05:59")))
+	False(t, nightShift.Contains(scheduleTime(t, "2022-06-06 05:00")))
+	False(t, nightShift.Contains(scheduleTime(t, "2022-06-07 12:00")))
+
+	everyDay := ProfileActiveWindow{From: "09:00", To: "17:00"}
+	True(t, everyDay.Contains(scheduleTime(t, "2022-06-11 12:00")))
+}
+
+func TestProfileScheduleStatus(t *testing.T) {
+	now := scheduleTime(t, "2022-06-06 12:00")
+	yesterday := now.Add(-24 * time.Hour)
+	tomorrow := now.Add(24 * time.Hour)
+
+	testCases := []struct {
+		name    string
+		profile Profile
+		expect  ProfileScheduleStatus
+	}{
+		{"no schedule", Profile{Active: true}, ProfileStatusActive},
+		{"inactive", Profile{Active: false}, ProfileStatusInactive},
+		{"within active period", Profile{Active: true, ActiveFrom: &yesterday, ActiveUntil: &tomorrow}, ProfileStatusActive},
+		{"before active period", Profile{Active: true, ActiveFrom: &tomorrow}, ProfileStatusScheduled},
+		{"after active period", Profile{Active: true, ActiveUntil: &yesterday}, ProfileStatusExpired},
+		{
+			"outside window",
+			Profile{Active: true, ActiveWindows: []ProfileActiveWindow{{From: "13:00", To: "14:00"}}},
+			ProfileStatusOutsideWindow,
+		},
+		{
+			"budget reached",
+			Profile{Active: true, MaxMatchesTotal: 2, MatchCount: ProfileMatchCount{Total: 2}},
+			ProfileStatusBudgetReached,
+		},
+		{
+			"daily budget reached",
+			Profile{Active: true, MaxMatchesPerDay: 2, MatchCount: ProfileMatchCount{Total: 2, Day: "2022-06-06", OnDay: 2}},
+			ProfileStatusDailyBudgetReached,
+		},
+		{
+			"daily budget of yesterday reached",
+			Profile{Active: true, MaxMatchesPerDay: 2, MatchCount: ProfileMatchCount{Total: 2, Day: "2022-06-05", OnDay: 2}},
+			ProfileStatusActive,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			Equal(t, testCase.expect, testCase.profile.ScheduleStatus(now))
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	scheduleErrors := func(p *Profile) ProfileValidationErrors {
+		errs := ProfileValidationErrors{}
+		p.validateSchedule(&errs)
+		return errs
+	}
+
+	Empty(t, scheduleErrors(&Profile{ActiveWindows: []ProfileActiveWindow{{From: "22:00", To: "06:00"}}}))
+	NotEmpty(t, scheduleErrors(&Profile{ActiveFrom: &now, ActiveUntil: &earlier}))
+	NotEmpty(t, scheduleErrors(&Profile{ActiveWindows: []ProfileActiveWindow{{From: "9", To: "17:00"}}}))
+	NotEmpty(t, scheduleErrors(&Profile{ActiveWindows: []ProfileActiveWindow{{From: "09:00", To: "17:00", Weekdays: []time.Weekday{7}}}}))
+	NotEmpty(t, scheduleErrors(&Profile{MaxMatchesPerDay: -1}))
+}
+
+func TestReserveProfileMatch(t *testing.T) {
+	conn := testingdb.NewDB()
+	profile := &Profile{M: db.NewM(), Active: true, MaxMatchesPerDay: 2, MaxMatchesTotal: 3}
+	err := conn.Insert(profile)
+	NoError(t, err)
+
+	monday := scheduleTime(t, "2022-06-06 12:00")
+	tuesday := scheduleTime(t, "2022-06-07 12:00")
+	for _, expect := range []bool{true, true, false} {
+		reserved, err := ReserveProfileMatch(conn, profile, monday)
+		NoError(t, err)
+		Equal(t, expect, reserved)
+	}
+
+	// The daily budget is reset the next day but the total budget is not
+	for _, expect := range []bool{true, false} {
+		reserved, err := ReserveProfileMatch(conn, profile, tuesday)
+		NoError(t, err)
+		Equal(t, expect, reserved)
+	}
+
+	updatedProfile, err := GetProfile(conn, profile.ID)
+	NoError(t, err)
+	Equal(t, ProfileMatchCount{Total: 3, Day: "2022-06-07", OnDay: 1}, updatedProfile.MatchCount)
+	Equal(t, ProfileStatusBudgetReached, updatedProfile.ScheduleStatus(tuesday))
+
+	// Profiles without a budget are not counted
+	unlimited := &Profile{M: db.NewM(), Active: true}
+	err = conn.Insert(unlimited)
+	NoError(t, err)
+	reserved, err := ReserveProfileMatch(conn, unlimited, monday)
+	NoError(t, err)
+	True(t, reserved)
+}
+
+func TestCheckProfileSchedules(t *testing.T) {
+	conn := testingdb.NewDB()
+	activeFrom := scheduleTime(t, "2022-06-06 12:00")
+	profile := &Profile{M: db.NewM(), Name: "scheduled", Active: true, ActiveFrom: &activeFrom}
+	err := conn.Insert(profile)
+	NoError(t, err)
+
+	// The first check only stores the status
+	events, err := CheckProfileSchedules(conn, activeFrom.Add(-time.Hour))
+	NoError(t, err)
+	Empty(t, events)
+
+	events, err = CheckProfileSchedules(conn, activeFrom.Add(-time.Minute))
+	NoError(t, err)
+	Empty(t, events)
+
+	events, err = CheckProfileSchedules(conn, activeFrom)
+	NoError(t, err)
+	Len(t, events, 1)
+	Equal(t, profile.ID, events[0].ProfileID)
+	Equal(t, ProfileStatusScheduled, events[0].PreviousStatus)
+	Equal(t, ProfileStatusActive, events[0].Status)
+
+	// Manually disabling a profile is not an event
+	updatedProfile, err := GetProfile(conn, profile.ID)
+	NoError(t, err)
+	updatedProfile.Active = false
+	err = conn.UpdateByID(&updatedProfile)
+	NoError(t, err)
+	events, err = CheckProfileSchedules(conn, activeFrom.Add(time.Minute))
+	NoError(t, err)
+	Empty(t, events)
+}
+
+func TestScheduleCheckFilter(t *testing.T) {
+	conn := testingdb.NewDB()
+	activeFrom := scheduleTime(t, "2022-06-06 12:00")
+	active := ProfileStatusActive
+	inactive := ProfileStatusInactive
+
+	scheduled := &Profile{M: db.NewM(), Name: "scheduled", Active: true, ActiveFrom: &activeFrom, LastScheduleStatus: &active}
+	withBudget := &Profile{M: db.NewM(), Name: "budget", Active: true, MaxMatchesTotal: 10, LastScheduleStatus: &active}
+	unchecked := &Profile{M: db.NewM(), Name: "unchecked", Active: true}
+	disabled := &Profile{M: db.NewM(), Name: "disabled", Active: false, LastScheduleStatus: &active}
+	unchanged := &Profile{M: db.NewM(), Name: "unchanged", Active: true, LastScheduleStatus: &active}
+	unchangedDisabled := &Profile{M: db.NewM(), Name: "unchanged disabled", Active: false, ActiveFrom: &activeFrom, LastScheduleStatus: &inactive}
+	err := conn.Insert(scheduled, withBudget, unchecked, disabled, unchanged, unchangedDisabled)
+	NoError(t, err)
+
+	// Only the profiles of which the status can change are checked
+	profiles, err := GetProfiles(conn, scheduleCheckFilter, db.FindOptions{Projection: scheduleCheckProjection})
+	NoError(t, err)
+	names := []string{}
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+	ElementsMatch(t, []string{"scheduled", "budget", "unchecked", "disabled"}, names)
+}
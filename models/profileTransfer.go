@@ -0,0 +1,676 @@
+package models
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProfileExternalIDLabel is the label used to match imported profiles with existing profiles
+const ProfileExternalIDLabel = "externalId"
+
+// MaxProfileImport is the maximum number of profiles that can be imported at once
+const MaxProfileImport = 5000
+
+// ErrProfileImportInvalid is returned by ImportProfiles if one or more profiles are invalid, in that case nothing is imported
+var ErrProfileImportInvalid = errors.New("one or more profiles are invalid, nothing is imported")
+
+// ErrProfileImportTooLarge is returned by ImportProfiles if more than MaxProfileImport profiles are imported at once
+var ErrProfileImportTooLarge = fmt.Errorf("cannot import more than %d profiles at once", MaxProfileImport)
+
+// ExternalID returns the external ID label of the profile, an empty string is returned if the label is not set or not a string
+func (p *Profile) ExternalID() string {
+	externalID, _ := p.Lables[ProfileExternalIDLabel].(string)
+	return strings.TrimSpace(externalID)
+}
+
+// ProfileImportAction is what happened to a profile during an import
+type ProfileImportAction string
+
+const (
+	// ProfileImportCreated means there was no profile with the external ID so a new profile was created
+	ProfileImportCreated ProfileImportAction = "created"
+	// ProfileImportUpdated means the profile with the external ID was replaced by the imported profile
+	ProfileImportUpdated ProfileImportAction = "updated"
+	// ProfileImportUnchanged means the profile with the external ID was already the same as the imported profile
+	ProfileImportUnchanged ProfileImportAction = "unchanged"
+	// ProfileImportInvalid means the imported profile is invalid, see the error of the row
+	ProfileImportInvalid ProfileImportAction = "invalid"
+	// ProfileImportSkipped means the imported profile is valid but not imported because other profiles in the import are invalid
+	ProfileImportSkipped ProfileImportAction = "skipped"
+)
+
+// ProfileImportResult describes the changes made by an import
+type ProfileImportResult struct {
+	Created   int                `json:"created"`
+	Updated   int                `json:"updated"`
+	Unchanged int                `json:"unchanged"`
+	Invalid   int                `json:"invalid"`
+	Rows      []ProfileImportRow `json:"rows"`
+}
+
+// ProfileImportRow is the result of a single imported profile
+type ProfileImportRow struct {
+	Row        int                 `json:"row" description:"The index of the profile in the import starting at 0"`
+	ExternalID string              `json:"externalId"`
+	ProfileID  *primitive.ObjectID `json:"profileId" description:"The ID of the created or updated profile"`
+	Action     ProfileImportAction `json:"action"`
+	Error      string              `json:"error,omitempty"`
+	// Errors is only set if the profile itself is invalid
+	Errors ProfileValidationErrors `json:"errors,omitempty" description:"The validation errors of the profile"`
+}
+
+// ImportProfiles creates or replaces profiles matched on their external ID label
+//
+// All profiles are validated before anything is written, if one of them is invalid ErrProfileImportInvalid is returned together with the per row results and nothing is imported.
+// The match count and schedule status of replaced profiles are kept.
+// The imported profiles and the profiles they replace must be within scope, scope can be nil.
+func ImportProfiles(conn db.Connection, findMissingBranches FindMissingBranches, authorKeyID *primitive.ObjectID, scope *APIKeyScope, profiles []Profile) (ProfileImportResult, error) {
+	result := ProfileImportResult{Rows: make([]ProfileImportRow, len(profiles))}
+	if len(profiles) > MaxProfileImport {
+		return result, ErrProfileImportTooLarge
+	}
+
+	rowErrors := make([]error, len(profiles))
+	rowsByExternalID := map[string]int{}
+	externalIDs := []string{}
+	for idx := range profiles {
+		profile := &profiles[idx]
+		externalID := profile.ExternalID()
+		result.Rows[idx] = ProfileImportRow{Row: idx, ExternalID: externalID}
+
+		if externalID == "" {
+			rowErrors[idx] = fmt.Errorf("labels.%s must be set to a string", ProfileExternalIDLabel)
+			continue
+		}
+		if otherRow, ok := rowsByExternalID[externalID]; ok {
+			rowErrors[idx] = fmt.Errorf("row %d has the same external id", otherRow)
+			continue
+		}
+		rowsByExternalID[externalID] = idx
+		externalIDs = append(externalIDs, externalID)
+
+		err := profile.Validate(conn, findMissingBranches)
+		if err == nil && profile.TenantID != nil && db.TenantOf(conn) == nil {
+			err = CheckTenantExists(conn, *profile.TenantID)
+		}
+		rowErrors[idx] = err
+	}
+
+	if result.markInvalid(rowErrors) {
+		return result, ErrProfileImportInvalid
+	}
+
+	err := conn.WithTransaction(func(tx db.Connection) error {
+		// The transaction function might be retried so start with a clean result
+		result.Created, result.Updated, result.Unchanged = 0, 0, 0
+		rowErrors := append([]error{}, rowErrors...)
+
+		// The existing profiles are looked up inside of the transaction so they cannot change before they are replaced
+		existingProfiles, err := GetProfiles(tx, bson.M{"lables." + ProfileExternalIDLabel: bson.M{"$in": externalIDs}})
+		if err != nil {
+			return err
+		}
+		existingByRow := map[int]Profile{}
+		for _, existing := range existingProfiles {
+			idx, ok := rowsByExternalID[existing.ExternalID()]
+			if !ok {
+				continue
+			}
+			// The external id is unique within a tenant, without a tenant connection profiles of other tenants are also found
+			tenantID := profiles[idx].TenantID
+			if connTenantID := db.TenantOf(tx); connTenantID != nil {
+				tenantID = connTenantID
+			}
+			if !db.SameTenant(existing.TenantID, tenantID) {
+				continue
+			}
+			if !scope.ProfileAllowed(&existing) {
+				rowErrors[idx] = errors.New("the existing profile with this external id is outside the scope of the api key")
+				continue
+			}
+			existingByRow[idx] = existing
+		}
+
+		for idx := range profiles {
+			if rowErrors[idx] != nil {
+				continue
+			}
+			// Check the scope with the ID the profile will have after the import
+			profile := profiles[idx]
+			if existing, ok := existingByRow[idx]; ok {
+				profile.M = existing.M
+			}
+			if !scope.ProfileAllowed(&profile) {
+				rowErrors[idx] = errors.New("profile is outside the scope of the api key")
+			}
+		}
+		if result.markInvalid(rowErrors) {
+			return ErrProfileImportInvalid
+		}
+
+		for idx := range profiles {
+			profile := profiles[idx]
+			row := &result.Rows[idx]
+
+			existing, exists := existingByRow[idx]
+			if !exists {
+				profile.M = db.NewM()
+				profile.MatchCount = ProfileMatchCount{}
+				profile.LastScheduleStatus = nil
+				err := tx.Insert(&profile)
+				if err != nil {
+					return err
+				}
+				err = AddProfileRevision(tx, ProfileRevisionCreated, authorKeyID, nil, profile)
+				if err != nil {
+					return err
+				}
+				row.Action = ProfileImportCreated
+				result.Created++
+				row.ProfileID = &profile.ID
+				continue
+			}
+
+			profile.M = existing.M
+			profile.T = existing.T
+			keepProfessionLeafIDs(profile.DesiredProfessions, existing.DesiredProfessions)
+			keepProfessionLeafIDs(profile.ProfessionExperienced, existing.ProfessionExperienced)
+			profile.MatchCount = existing.MatchCount
+			profile.LastScheduleStatus = existing.LastScheduleStatus
+			row.ProfileID = &profile.ID
+
+			changes, err := diffProfiles(existing, profile)
+			if err != nil {
+				return err
+			}
+			if len(changes) == 0 {
+				row.Action = ProfileImportUnchanged
+				result.Unchanged++
+				continue
+			}
+
+			err = UpdateProfileFields(tx, &profile)
+			if err != nil {
+				return err
+			}
+			err = AddProfileRevision(tx, ProfileRevisionUpdated, authorKeyID, &existing, profile)
+			if err != nil {
+				return err
+			}
+			row.Action = ProfileImportUpdated
+			result.Updated++
+		}
+		return nil
+	})
+	if errors.Is(err, ErrProfileImportInvalid) {
+		return result, err
+	}
+	if err != nil {
+		return ProfileImportResult{}, err
+	}
+	return result, nil
+}
+
+// markInvalid sets the action and errors of the rows with an error, if one of the rows is invalid the other rows are marked as skipped and true is returned
+func (result *ProfileImportResult) markInvalid(rowErrors []error) bool {
+	result.Invalid = 0
+	for idx, err := range rowErrors {
+		if err != nil {
+			result.Invalid++
+			result.Rows[idx].Action = ProfileImportInvalid
+			result.Rows[idx].Error = err.Error()
+			errors.As(err, &result.Rows[idx].Errors)
+		}
+	}
+	if result.Invalid == 0 {
+		return false
+	}
+	for idx := range result.Rows {
+		if rowErrors[idx] == nil {
+			result.Rows[idx].Action = ProfileImportSkipped
+			result.Rows[idx].ProfileID = nil
+		}
+	}
+	return true
+}
+
+// keepProfessionLeafIDs links the imported professions without a matcher tree branch to the branch of the existing profession with the same name
+// The CSV format does not contain the branches so without this every CSV import would unlink the professions
+func keepProfessionLeafIDs(imported, existing []ProfileProfession) {
+	for idx, profession := range imported {
+		if !profession.LeafId.IsZero() {
+			continue
+		}
+		for _, existingProfession := range existing {
+			if existingProfession.Name == profession.Name {
+				imported[idx].LeafId = existingProfession.LeafId
+				break
+			}
+		}
+	}
+}
+
+// The CSV format contains a row per profile, the columns are defined in profileCSVColumns
+// Lists are separated by a |, zip codes are formatted as from-to and the active windows and labels are json
+const profileCSVListSeparator = "|"
+
+type profileCSVColumn struct {
+	name  string
+	write func(p *Profile) string
+	read  func(p *Profile, value string) error
+}
+
+func profileCSVBool(name string, field func(p *Profile) *bool) profileCSVColumn {
+	return profileCSVColumn{
+		name:  name,
+		write: func(p *Profile) string { return strconv.FormatBool(*field(p)) },
+		read: func(p *Profile, value string) error {
+			if value == "" {
+				*field(p) = false
+				return nil
+			}
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a boolean", value)
+			}
+			*field(p) = parsed
+			return nil
+		},
+	}
+}
+
+func profileCSVInt(name string, field func(p *Profile) *int) profileCSVColumn {
+	return profileCSVColumn{
+		name:  name,
+		write: func(p *Profile) string { return strconv.Itoa(*field(p)) },
+		read: func(p *Profile, value string) error {
+			if value == "" {
+				*field(p) = 0
+				return nil
+			}
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", value)
+			}
+			*field(p) = parsed
+			return nil
+		},
+	}
+}
+
+func profileCSVOptionalInt(name string, field func(p *Profile) **int) profileCSVColumn {
+	return profileCSVColumn{
+		name: name,
+		write: func(p *Profile) string {
+			if *field(p) == nil {
+				return ""
+			}
+			return strconv.Itoa(**field(p))
+		},
+		read: func(p *Profile, value string) error {
+			if value == "" {
+				*field(p) = nil
+				return nil
+			}
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", value)
+			}
+			*field(p) = &parsed
+			return nil
+		},
+	}
+}
+
+func profileCSVTime(name string, field func(p *Profile) **time.Time) profileCSVColumn {
+	return profileCSVColumn{
+		name: name,
+		write: func(p *Profile) string {
+			if *field(p) == nil {
+				return ""
+			}
+			return (*field(p)).Format(time.RFC3339)
+		},
+		read: func(p *Profile, value string) error {
+			if value == "" {
+				*field(p) = nil
+				return nil
+			}
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return fmt.Errorf("%q is not a RFC3339 date", value)
+			}
+			*field(p) = &parsed
+			return nil
+		},
+	}
+}
+
+func profileCSVJSON(name string, field func(p *Profile) any) profileCSVColumn {
+	return profileCSVColumn{
+		name: name,
+		write: func(p *Profile) string {
+			value, _ := json.Marshal(field(p))
+			return string(value)
+		},
+		read: func(p *Profile, value string) error {
+			if value == "" {
+				return nil
+			}
+			return json.Unmarshal([]byte(value), field(p))
+		},
+	}
+}
+
+// splitCSVList splits a list column, empty items are ignored
+func splitCSVList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, profileCSVListSeparator) {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func profileCSVNames(name string, write func(p *Profile) []string, read func(p *Profile, names []string)) profileCSVColumn {
+	return profileCSVColumn{
+		name:  name,
+		write: func(p *Profile) string { return strings.Join(write(p), profileCSVListSeparator) },
+		read: func(p *Profile, value string) error {
+			read(p, splitCSVList(value))
+			return nil
+		},
+	}
+}
+
+func professionNames(professions []ProfileProfession) []string {
+	names := make([]string, len(professions))
+	for idx, profession := range professions {
+		names[idx] = profession.Name
+	}
+	return names
+}
+
+func namesToProfessions(names []string) []ProfileProfession {
+	professions := make([]ProfileProfession, len(names))
+	for idx, name := range names {
+		professions[idx] = ProfileProfession{Name: name}
+	}
+	return professions
+}
+
+var profileCSVColumns = []profileCSVColumn{
+	{
+		name:  ProfileExternalIDLabel,
+		write: func(p *Profile) string { return p.ExternalID() },
+		read: func(p *Profile, value string) error {
+			if p.Lables == nil {
+				p.Lables = map[string]any{}
+			}
+			p.Lables[ProfileExternalIDLabel] = value
+			return nil
+		},
+	},
+	{
+		name:  "name",
+		write: func(p *Profile) string { return p.Name },
+		read: func(p *Profile, value string) error {
+			p.Name = value
+			return nil
+		},
+	},
+	profileCSVBool("active", func(p *Profile) *bool { return &p.Active }),
+	profileCSVBool("listsAllowed", func(p *Profile) *bool { return &p.ListsAllowed }),
+	{
+		name: "allowedScrapers",
+		write: func(p *Profile) string {
+			ids := make([]string, len(p.AllowedScrapers))
+			for idx, id := range p.AllowedScrapers {
+				ids[idx] = id.Hex()
+			}
+			return strings.Join(ids, profileCSVListSeparator)
+		},
+		read: func(p *Profile, value string) error {
+			p.AllowedScrapers = []primitive.ObjectID{}
+			for _, item := range splitCSVList(value) {
+				id, err := primitive.ObjectIDFromHex(item)
+				if err != nil {
+					return fmt.Errorf("%q is not a valid id", item)
+				}
+				p.AllowedScrapers = append(p.AllowedScrapers, id)
+			}
+			return nil
+		},
+	},
+	profileCSVBool("mustDesiredProfession", func(p *Profile) *bool { return &p.MustDesiredProfession }),
+	profileCSVNames(
+		"desiredProfessions",
+		func(p *Profile) []string { return professionNames(p.DesiredProfessions) },
+		func(p *Profile, names []string) { p.DesiredProfessions = namesToProfessions(names) },
+	),
+	profileCSVOptionalInt("yearsSinceWork", func(p *Profile) **int { return &p.YearsSinceWork }),
+	profileCSVBool("mustExpProfession", func(p *Profile) *bool { return &p.MustExpProfession }),
+	profileCSVNames(
+		"professionExperienced",
+		func(p *Profile) []string { return professionNames(p.ProfessionExperienced) },
+		func(p *Profile, names []string) { p.ProfessionExperienced = namesToProfessions(names) },
+	),
+	profileCSVBool("mustDriversLicense", func(p *Profile) *bool { return &p.MustDriversLicense }),
+	profileCSVNames(
+		"driversLicenses",
+		func(p *Profile) []string {
+			names := make([]string, len(p.DriversLicenses))
+			for idx, license := range p.DriversLicenses {
+				names[idx] = license.Name
+			}
+			return names
+		},
+		func(p *Profile, names []string) {
+			p.DriversLicenses = make([]ProfileDriversLicense, len(names))
+			for idx, name := range names {
+				p.DriversLicenses[idx] = ProfileDriversLicense{Name: name}
+			}
+		},
+	),
+	profileCSVBool("mustEducationFinished", func(p *Profile) *bool { return &p.MustEducationFinished }),
+	profileCSVBool("mustEducation", func(p *Profile) *bool { return &p.MustEducation }),
+	profileCSVOptionalInt("yearsSinceEducation", func(p *Profile) **int { return &p.YearsSinceEducation }),
+	profileCSVNames(
+		"educations",
+		func(p *Profile) []string {
+			names := make([]string, len(p.Educations))
+			for idx, education := range p.Educations {
+				names[idx] = education.Name
+			}
+			return names
+		},
+		func(p *Profile, names []string) {
+			p.Educations = make([]ProfileEducation, len(names))
+			for idx, name := range names {
+				p.Educations[idx] = ProfileEducation{Name: name}
+			}
+		},
+	),
+	{
+		name: "zipCodes",
+		write: func(p *Profile) string {
+			zipCodes := make([]string, len(p.Zipcodes))
+			for idx, zipCode := range p.Zipcodes {
+				zipCodes[idx] = fmt.Sprintf("%d-%d", zipCode.From, zipCode.To)
+			}
+			return strings.Join(zipCodes, profileCSVListSeparator)
+		},
+		read: func(p *Profile, value string) error {
+			p.Zipcodes = []ProfileDutchZipcode{}
+			for _, item := range splitCSVList(value) {
+				from, to, found := strings.Cut(item, "-")
+				if !found {
+					return fmt.Errorf("%q is not a zip code range formatted as from-to", item)
+				}
+				fromNr, fromErr := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+				toNr, toErr := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+				if fromErr != nil || toErr != nil {
+					return fmt.Errorf("%q is not a zip code range formatted as from-to", item)
+				}
+				p.Zipcodes = append(p.Zipcodes, ProfileDutchZipcode{From: uint16(fromNr), To: uint16(toNr)})
+			}
+			return nil
+		},
+	},
+	profileCSVNames(
+		"sendMail",
+		func(p *Profile) []string {
+			emails := make([]string, len(p.OnMatch.SendMail))
+			for idx, sendMail := range p.OnMatch.SendMail {
+				emails[idx] = sendMail.Email
+			}
+			return emails
+		},
+		func(p *Profile, emails []string) {
+			p.OnMatch.SendMail = make([]ProfileSendEmailData, len(emails))
+			for idx, email := range emails {
+				p.OnMatch.SendMail[idx] = ProfileSendEmailData{Email: email}
+			}
+		},
+	),
+	profileCSVTime("activeFrom", func(p *Profile) **time.Time { return &p.ActiveFrom }),
+	profileCSVTime("activeUntil", func(p *Profile) **time.Time { return &p.ActiveUntil }),
+	profileCSVJSON("activeWindows", func(p *Profile) any { return &p.ActiveWindows }),
+	profileCSVInt("maxMatchesPerDay", func(p *Profile) *int { return &p.MaxMatchesPerDay }),
+	profileCSVInt("maxMatchesTotal", func(p *Profile) *int { return &p.MaxMatchesTotal }),
+	{
+		// The external ID has its own column
+		name: "labels",
+		write: func(p *Profile) string {
+			labels := map[string]any{}
+			for key, value := range p.Lables {
+				if key != ProfileExternalIDLabel {
+					labels[key] = value
+				}
+			}
+			if len(labels) == 0 {
+				return ""
+			}
+			value, _ := json.Marshal(labels)
+			return string(value)
+		},
+		read: func(p *Profile, value string) error {
+			if value == "" {
+				return nil
+			}
+			labels := map[string]any{}
+			err := json.Unmarshal([]byte(value), &labels)
+			if err != nil {
+				return errors.New("must be a json object")
+			}
+			if p.Lables == nil {
+				p.Lables = map[string]any{}
+			}
+			for key, labelValue := range labels {
+				if _, ok := p.Lables[key]; !ok || key != ProfileExternalIDLabel {
+					p.Lables[key] = labelValue
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// WriteProfilesCSV writes profiles in the CSV format
+func WriteProfilesCSV(w io.Writer, profiles []Profile) error {
+	csvWriter := csv.NewWriter(w)
+	header := make([]string, len(profileCSVColumns))
+	for idx, column := range profileCSVColumns {
+		header[idx] = column.name
+	}
+	err := csvWriter.Write(header)
+	if err != nil {
+		return err
+	}
+
+	record := make([]string, len(profileCSVColumns))
+	for idx := range profiles {
+		for columnIdx, column := range profileCSVColumns {
+			record[columnIdx] = column.write(&profiles[idx])
+		}
+		err = csvWriter.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// ReadProfilesCSV reads profiles from the CSV format
+// The columns can be in any order and only the externalId and name columns are required, missing columns get their zero value
+func ReadProfilesCSV(r io.Reader) ([]Profile, error) {
+	csvReader := csv.NewReader(r)
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return []Profile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]profileCSVColumn, len(header))
+	seen := map[string]bool{}
+outer:
+	for idx, name := range header {
+		name = strings.TrimSpace(name)
+		for _, column := range profileCSVColumns {
+			if strings.EqualFold(column.name, name) {
+				if seen[column.name] {
+					return nil, fmt.Errorf("duplicate column %q", name)
+				}
+				seen[column.name] = true
+				columns[idx] = column
+				continue outer
+			}
+		}
+		return nil, fmt.Errorf("unknown column %q", name)
+	}
+	for _, required := range []string{ProfileExternalIDLabel, "name"} {
+		if !seen[required] {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	profiles := []Profile{}
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := csvReader.FieldPos(0)
+
+		profile := Profile{Lables: map[string]any{}}
+		for idx, column := range columns {
+			err = column.read(&profile, strings.TrimSpace(record[idx]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d column %s: %s", line, column.name, err.Error())
+			}
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
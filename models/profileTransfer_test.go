@@ -0,0 +1,164 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/tj/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestProfilesCSV(t *testing.T) {
+	yearsSinceWork := 3
+	activeFrom := time.Date(2022, 6, 6, 12, 0, 0, 0, time.UTC)
+	profiles := []Profile{{
+		Name:               "Developer, Groningen",
+		Active:             true,
+		DesiredProfessions: []ProfileProfession{{Name: "Go developer"}, {Name: "Frontend developer"}},
+		YearsSinceWork:     &yearsSinceWork,
+		DriversLicenses:    []ProfileDriversLicense{{Name: "B"}},
+		Educations:         []ProfileEducation{},
+		Zipcodes:           []ProfileDutchZipcode{{From: 9000, To: 9999}},
+		OnMatch:            ProfileOnMatch{SendMail: []ProfileSendEmailData{{Email: "info@example.com"}}},
+		ActiveFrom:         &activeFrom,
+		ActiveWindows:      []ProfileActiveWindow{{Weekdays: []time.Weekday{time.Monday}, From: "09:00", To: "17:00"}},
+		MaxMatchesPerDay:   5,
+		Lables:             map[string]any{ProfileExternalIDLabel: "a", "customer": "b"},
+	}}
+
+	csvData := bytes.NewBuffer(nil)
+	err := WriteProfilesCSV(csvData, profiles)
+	NoError(t, err)
+
+	readProfiles, err := ReadProfilesCSV(csvData)
+	NoError(t, err)
+	Len(t, readProfiles, 1)
+	changes, err := diffProfiles(profiles[0], readProfiles[0])
+	NoError(t, err)
+	Empty(t, changes)
+
+	// Only the external id and name columns are required
+	readProfiles, err = ReadProfilesCSV(strings.NewReader("name,externalId,zipCodes\nfoo,b,1000-2000|3000-4000\n"))
+	NoError(t, err)
+	Len(t, readProfiles, 1)
+	Equal(t, "b", readProfiles[0].ExternalID())
+	Equal(t, []ProfileDutchZipcode{{From: 1000, To: 2000}, {From: 3000, To: 4000}}, readProfiles[0].Zipcodes)
+
+	_, err = ReadProfilesCSV(strings.NewReader("name\nfoo\n"))
+	Error(t, err)
+	_, err = ReadProfilesCSV(strings.NewReader("name,externalId,foo\nfoo,b,c\n"))
+	Error(t, err)
+	_, err = ReadProfilesCSV(strings.NewReader("name,externalId,active\nfoo,b,maybe\n"))
+	Error(t, err)
+}
+
+func TestImportProfiles(t *testing.T) {
+	conn := testingdb.NewDB()
+	conn.RegisterEntries(&Profile{})
+	leafID := primitive.NewObjectID()
+	existing := &Profile{
+		M:                  db.NewM(),
+		Name:               "existing",
+		DesiredProfessions: []ProfileProfession{{Name: "Chauffeur", LeafId: leafID}},
+		MatchCount:         ProfileMatchCount{Total: 3},
+		Lables:             map[string]any{ProfileExternalIDLabel: "a"},
+	}
+	err := conn.Insert(existing)
+	NoError(t, err)
+
+	// Nothing should be imported if one of the profiles is invalid
+	result, err := ImportProfiles(conn, missingBranches(), nil, nil, []Profile{
+		{Name: "updated", Lables: map[string]any{ProfileExternalIDLabel: "a"}},
+		{Name: "", Lables: map[string]any{ProfileExternalIDLabel: "b"}},
+		{Name: "no external id"},
+	})
+	Equal(t, ErrProfileImportInvalid, err)
+	Equal(t, 2, result.Invalid)
+	Equal(t, ProfileImportSkipped, result.Rows[0].Action)
+	Equal(t, ProfileImportInvalid, result.Rows[1].Action)
+	Equal(t, ProfileImportInvalid, result.Rows[2].Action)
+	profiles, err := GetProfiles(conn, nil)
+	NoError(t, err)
+	Len(t, profiles, 1)
+	Equal(t, "existing", profiles[0].Name)
+
+	newProfiles := []Profile{
+		{
+			Name:               "updated",
+			DesiredProfessions: []ProfileProfession{{Name: "Chauffeur"}},
+			Lables:             map[string]any{ProfileExternalIDLabel: "a"},
+		},
+		{Name: "new", Lables: map[string]any{ProfileExternalIDLabel: "b"}},
+	}
+	result, err = ImportProfiles(conn, missingBranches(), nil, nil, newProfiles)
+	NoError(t, err)
+	Equal(t, 1, result.Created)
+	Equal(t, 1, result.Updated)
+	Equal(t, existing.ID, *result.Rows[0].ProfileID)
+
+	updated, err := GetProfile(conn, existing.ID)
+	NoError(t, err)
+	Equal(t, "updated", updated.Name)
+	Equal(t, leafID, updated.DesiredProfessions[0].LeafId, "the profession should stay linked to the matcher tree")
+	Equal(t, 3, updated.MatchCount.Total, "the match count should be kept")
+	revisions, err := GetProfileRevisions(conn, existing.ID)
+	NoError(t, err)
+	Len(t, revisions, 1)
+	Equal(t, ProfileRevisionUpdated, revisions[0].Action)
+
+	// Importing the same profiles again should not change anything
+	result, err = ImportProfiles(conn, missingBranches(), nil, nil, newProfiles)
+	NoError(t, err)
+	Equal(t, 2, result.Unchanged)
+	profiles, err = GetProfiles(conn, nil)
+	NoError(t, err)
+	Len(t, profiles, 2)
+	// The external id must be unique
+	err = conn.Insert(&Profile{M: db.NewM(), Name: "copy", Lables: map[string]any{ProfileExternalIDLabel: "a"}})
+	True(t, mongo.IsDuplicateKeyError(err))
+}
+
+func TestImportProfilesExternalIDPerTenant(t *testing.T) {
+	conn := testingdb.NewDB()
+	conn.RegisterEntries(&Profile{})
+	tenantA := &Tenant{M: db.NewM(), Name: "a"}
+	tenantB := &Tenant{M: db.NewM(), Name: "b"}
+	existing := &Profile{
+		M:      db.NewM(),
+		T:      db.T{TenantID: &tenantA.ID},
+		Name:   "tenant a",
+		Lables: map[string]any{ProfileExternalIDLabel: "a"},
+	}
+	err := conn.Insert(tenantA, tenantB)
+	NoError(t, err)
+	err = conn.Insert(existing)
+	NoError(t, err)
+
+	// Another tenant can use the same external id without replacing the profile of the first tenant
+	result, err := ImportProfiles(db.WithTenant(conn, tenantB.ID), missingBranches(), nil, nil, []Profile{
+		{Name: "tenant b", Lables: map[string]any{ProfileExternalIDLabel: "a"}},
+	})
+	NoError(t, err)
+	Equal(t, 1, result.Created)
+
+	// Without a tenant connection the profile is matched on the tenant of the imported profile
+	result, err = ImportProfiles(conn, missingBranches(), nil, nil, []Profile{
+		{T: db.T{TenantID: &tenantA.ID}, Name: "tenant a updated", Lables: map[string]any{ProfileExternalIDLabel: "a"}},
+	})
+	NoError(t, err)
+	Equal(t, 1, result.Updated)
+	Equal(t, existing.ID, *result.Rows[0].ProfileID)
+
+	profiles, err := GetProfiles(conn, nil)
+	NoError(t, err)
+	names := []string{}
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+	ElementsMatch(t, []string{"tenant a updated", "tenant b"}, names)
+}
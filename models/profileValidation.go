@@ -0,0 +1,247 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/helpers/jsonHelpers"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProfileValidationCode tells what kind of validation error a profile has
+type ProfileValidationCode string
+
+const (
+	// ProfileValidationRequired means the field must be set
+	ProfileValidationRequired ProfileValidationCode = "required"
+	// ProfileValidationInvalid means the field does not have a valid value
+	ProfileValidationInvalid ProfileValidationCode = "invalid"
+	// ProfileValidationOutOfRange means the number or date is too low or too high
+	ProfileValidationOutOfRange ProfileValidationCode = "outOfRange"
+	// ProfileValidationUnknown means the field refers to something that does not exist, like an api key, matcher tree branch or drivers license
+	ProfileValidationUnknown ProfileValidationCode = "unknown"
+)
+
+// ProfileValidationError is a problem with a single field of a profile
+type ProfileValidationError struct {
+	Field   string                `json:"field" description:"The json path of the field, for example zipCodes[1].from"`
+	Code    ProfileValidationCode `json:"code"`
+	Message string                `json:"message"`
+}
+
+// ProfileValidationErrors contains all the problems found while validating a profile
+type ProfileValidationErrors []ProfileValidationError
+
+// Error implements the error interface
+func (e ProfileValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for idx, err := range e {
+		messages[idx] = err.Field + ": " + err.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// add adds a validation error
+func (e *ProfileValidationErrors) add(field string, code ProfileValidationCode, message string, args ...any) {
+	*e = append(*e, ProfileValidationError{Field: field, Code: code, Message: fmt.Sprintf(message, args...)})
+}
+
+// err returns nil if there are no validation errors, this prevents returning a non nil error interface containing an empty list
+func (e ProfileValidationErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// FindMissingBranches returns the ids that are not a branch of the matcher tree
+// This is matcher.FindMissingBranches, it's passed to the validation as the matcher package depends on this package
+type FindMissingBranches func(conn db.Connection, ids []primitive.ObjectID) ([]primitive.ObjectID, error)
+
+var emailRegex = regexp.MustCompile(
+	"^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@" +
+		"[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?" +
+		"(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$",
+)
+
+// Validate checks all fields of a profile
+// If the profile is invalid ProfileValidationErrors is returned with all the problems found, other errors are database errors
+func (p *Profile) Validate(conn db.Connection, findMissingBranches FindMissingBranches) error {
+	errs, err := p.validate(conn, findMissingBranches)
+	if err != nil {
+		return err
+	}
+	return errs.err()
+}
+
+// ValidateChanges checks all fields of an edited profile like Validate but ignores the problems the profile already had before it was edited
+// This keeps profiles editable that were saved before a validation rule existed or that became invalid later, for example because a matcher tree branch was removed
+func (p *Profile) ValidateChanges(conn db.Connection, findMissingBranches FindMissingBranches, old *Profile) error {
+	errs, err := p.validate(conn, findMissingBranches)
+	if err != nil || len(errs) == 0 {
+		return err
+	}
+	oldErrs, err := old.validate(conn, findMissingBranches)
+	if err != nil {
+		return err
+	}
+
+	existing := map[ProfileValidationError]int{}
+	for _, oldErr := range oldErrs {
+		existing[oldErr]++
+	}
+	introduced := ProfileValidationErrors{}
+	for _, validationErr := range errs {
+		if existing[validationErr] > 0 {
+			existing[validationErr]--
+			continue
+		}
+		introduced = append(introduced, validationErr)
+	}
+	return introduced.err()
+}
+
+// validate returns all the problems found in the profile, the error is only set for database errors
+func (p *Profile) validate(conn db.Connection, findMissingBranches FindMissingBranches) (ProfileValidationErrors, error) {
+	errs := ProfileValidationErrors{}
+
+	if strings.TrimSpace(p.Name) == "" {
+		errs.add("name", ProfileValidationRequired, "must be set")
+	}
+
+	if len(p.AllowedScrapers) > 0 {
+		unknownKeys, err := unknownAPIKeys(conn, p.AllowedScrapers)
+		if err != nil {
+			return nil, err
+		}
+		for idx, id := range p.AllowedScrapers {
+			if unknownKeys[id] {
+				errs.add(fmt.Sprintf("allowedScrapers[%d]", idx), ProfileValidationUnknown, "unknown api key id %s", id.Hex())
+			}
+		}
+	}
+
+	if p.MustDesiredProfession && len(p.DesiredProfessions) == 0 {
+		errs.add("desiredProfessions", ProfileValidationRequired, "must contain at least one profession if mustDesiredProfession is true")
+	}
+	if p.YearsSinceWork != nil && *p.YearsSinceWork < 0 {
+		errs.add("yearsSinceWork", ProfileValidationOutOfRange, "cannot be negative")
+	}
+	if p.MustExpProfession && len(p.ProfessionExperienced) == 0 {
+		errs.add("professionExperienced", ProfileValidationRequired, "must contain at least one profession if mustExpProfession is true")
+	}
+	err := p.validateProfessions(conn, findMissingBranches, &errs)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.MustDriversLicense && len(p.DriversLicenses) == 0 {
+		errs.add("driversLicenses", ProfileValidationRequired, "must contain at least one drivers license if mustDriversLicense is true")
+	}
+	for idx, license := range p.DriversLicenses {
+		field := fmt.Sprintf("driversLicenses[%d].name", idx)
+		normalized := strings.ToUpper(strings.ReplaceAll(license.Name, " ", ""))
+		if normalized == "" {
+			errs.add(field, ProfileValidationRequired, "must be set")
+		} else if !isKnownDriversLicense(normalized) {
+			errs.add(field, ProfileValidationUnknown, "%q is not an EU drivers license", license.Name)
+		}
+	}
+
+	if p.MustEducation && len(p.Educations) == 0 {
+		errs.add("educations", ProfileValidationRequired, "must contain at least one education if mustEducation is true")
+	}
+	if p.MustEducationFinished && len(p.Educations) == 0 {
+		errs.add("educations", ProfileValidationRequired, "must contain at least one education if mustEducationFinished is true")
+	}
+	for idx, education := range p.Educations {
+		if strings.TrimSpace(education.Name) == "" {
+			errs.add(fmt.Sprintf("educations[%d].name", idx), ProfileValidationRequired, "must be set")
+		}
+	}
+	if p.YearsSinceEducation != nil && *p.YearsSinceEducation < 0 {
+		errs.add("yearsSinceEducation", ProfileValidationOutOfRange, "cannot be negative")
+	}
+
+	for idx, zipCode := range p.Zipcodes {
+		field := fmt.Sprintf("zipCodes[%d]", idx)
+		if zipCode.From < 1000 || zipCode.From > 9999 {
+			errs.add(field+".from", ProfileValidationOutOfRange, "must be a number between 1000 and 9999")
+		}
+		if zipCode.To < 1000 || zipCode.To > 9999 {
+			errs.add(field+".to", ProfileValidationOutOfRange, "must be a number between 1000 and 9999")
+		}
+		if zipCode.From > zipCode.To {
+			errs.add(field, ProfileValidationInvalid, "from cannot be higher than to")
+		}
+	}
+
+	for idx, mail := range p.OnMatch.SendMail {
+		if len(mail.Email) < 3 || len(mail.Email) > 254 || !emailRegex.MatchString(mail.Email) {
+			errs.add(fmt.Sprintf("onMatch.sendMail[%d].email", idx), ProfileValidationInvalid, "invalid email address")
+		}
+	}
+
+	p.validateSchedule(&errs)
+
+	return errs, nil
+}
+
+// validateProfessions checks the desired and experienced professions
+func (p *Profile) validateProfessions(conn db.Connection, findMissingBranches FindMissingBranches, errs *ProfileValidationErrors) error {
+	lists := []struct {
+		field       string
+		professions []ProfileProfession
+	}{
+		{"desiredProfessions", p.DesiredProfessions},
+		{"professionExperienced", p.ProfessionExperienced},
+	}
+
+	leafIDs := []primitive.ObjectID{}
+	for _, list := range lists {
+		for idx, profession := range list.professions {
+			if strings.TrimSpace(profession.Name) == "" {
+				errs.add(fmt.Sprintf("%s[%d].name", list.field, idx), ProfileValidationRequired, "must be set")
+			}
+			if !profession.LeafId.IsZero() {
+				leafIDs = append(leafIDs, profession.LeafId)
+			}
+		}
+	}
+	if len(leafIDs) == 0 {
+		return nil
+	}
+
+	missing, err := findMissingBranches(conn, leafIDs)
+	if err != nil {
+		return err
+	}
+	missingIDs := map[primitive.ObjectID]bool{}
+	for _, id := range missing {
+		missingIDs[id] = true
+	}
+	for _, list := range lists {
+		for idx, profession := range list.professions {
+			if missingIDs[profession.LeafId] {
+				errs.add(fmt.Sprintf("%s[%d].LeafId", list.field, idx), ProfileValidationUnknown, "unknown matcher tree branch %s", profession.LeafId.Hex())
+			}
+		}
+	}
+	return nil
+}
+
+// isKnownDriversLicense returns true if the normalized drivers license is an EU drivers license
+func isKnownDriversLicense(normalized string) bool {
+	if len(normalized) > len(jsonHelpers.DriversLicense{}) {
+		return false
+	}
+	license := jsonHelpers.NewDriversLicense(normalized)
+	for _, known := range jsonHelpers.DriversLicenses {
+		if known == license {
+			return true
+		}
+	}
+	return false
+}
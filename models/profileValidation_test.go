@@ -0,0 +1,92 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/testingdb"
+	. "github.com/tj/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// missingBranches returns a FindMissingBranches that reports the given ids as missing
+func missingBranches(missing ...primitive.ObjectID) FindMissingBranches {
+	return func(_ db.Connection, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+		result := []primitive.ObjectID{}
+		for _, id := range ids {
+			for _, missingID := range missing {
+				if id == missingID {
+					result = append(result, id)
+				}
+			}
+		}
+		return result, nil
+	}
+}
+
+func TestProfileValidate(t *testing.T) {
+	conn := testingdb.NewDB()
+	removedBranch := primitive.NewObjectID()
+
+	valid := Profile{
+		Name:                  "valid",
+		MustDesiredProfession: true,
+		DesiredProfessions:    []ProfileProfession{{Name: "Chauffeur"}},
+		DriversLicenses:       []ProfileDriversLicense{{Name: "c e"}},
+		Zipcodes:              []ProfileDutchZipcode{{From: 1000, To: 1000}},
+		OnMatch:               ProfileOnMatch{SendMail: []ProfileSendEmailData{{Email: "info@example.com"}}},
+	}
+	NoError(t, valid.Validate(conn, missingBranches(removedBranch)))
+
+	invalid := Profile{
+		MustDesiredProfession: true,
+		AllowedScrapers:       []primitive.ObjectID{primitive.NewObjectID()},
+		ProfessionExperienced: []ProfileProfession{{Name: "Chauffeur", LeafId: removedBranch}},
+		Educations:            []ProfileEducation{{Name: ""}},
+		OnMatch:               ProfileOnMatch{SendMail: []ProfileSendEmailData{{Email: "no email"}}},
+		MaxMatchesTotal:       -1,
+	}
+	err := invalid.Validate(conn, missingBranches(removedBranch))
+	validationErrors, ok := err.(ProfileValidationErrors)
+	True(t, ok)
+	Equal(t, []string{
+		"name",
+		"allowedScrapers[0]",
+		"desiredProfessions",
+		"professionExperienced[0].LeafId",
+		"educations[0].name",
+		"onMatch.sendMail[0].email",
+		"maxMatchesTotal",
+	}, func() []string {
+		fields := []string{}
+		for _, validationErr := range validationErrors {
+			fields = append(fields, validationErr.Field)
+		}
+		return fields
+	}())
+	Contains(t, err.Error(), "name: must be set, ")
+}
+
+func TestProfileValidateChanges(t *testing.T) {
+	conn := testingdb.NewDB()
+	removedBranch := primitive.NewObjectID()
+	findMissingBranches := missingBranches(removedBranch)
+
+	// A profile linked to a removed matcher tree branch
+	old := Profile{
+		Name:               "old",
+		Active:             true,
+		DesiredProfessions: []ProfileProfession{{Name: "Chauffeur", LeafId: removedBranch}},
+	}
+	Error(t, old.Validate(conn, findMissingBranches))
+
+	// Edits that do not fix the existing problems are still allowed
+	edited := old
+	edited.Active = false
+	NoError(t, edited.ValidateChanges(conn, findMissingBranches, &old))
+
+	// New problems are reported
+	edited.Name = ""
+	err := edited.ValidateChanges(conn, findMissingBranches, &old)
+	Equal(t, ProfileValidationErrors{{Field: "name", Code: ProfileValidationRequired, Message: "must be set"}}, err)
+}
@@ -2,10 +2,13 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/migrations"
 	"github.com/script-development/RT-CV/db/testingdb"
 	. "github.com/tj/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func testProfilesSetupDB(t *testing.T) *testingdb.TestConnection {
@@ -62,3 +65,76 @@ func TestGetListsProfiles(t *testing.T) {
 	NoError(t, err)
 	Len(t, profiles, 1)
 }
+
+func TestProfileFilterPredicates(t *testing.T) {
+	d := testProfilesSetupDB(t)
+	err := d.Insert(
+		&Profile{M: db.NewM(), Active: true},                                                                  // active profile without anything to match on
+		&Profile{M: db.NewM(), Active: true, ListsAllowed: true},                                              // lists profile without zip codes
+		&Profile{M: db.NewM(), Active: true, DriversLicenses: []ProfileDriversLicense{{Name: "B"}}},           // active profile with only a drivers license
+		&Profile{M: db.NewM(), Active: true, Educations: []ProfileEducation{{Name: "x"}}, ListsAllowed: true}, // lists profile without zip codes
+	)
+	NoError(t, err)
+
+	// The predicates should match the same profiles as the database filters
+	allProfiles, err := GetProfiles(d, nil)
+	NoError(t, err)
+	matchProfiles, err := GetActualMatchActiveProfiles(d)
+	NoError(t, err)
+	listsProfiles, err := GetListsProfiles(d)
+	NoError(t, err)
+
+	contains := func(profiles []Profile, id primitive.ObjectID) bool {
+		for _, profile := range profiles {
+			if profile.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	for _, profile := range allProfiles {
+		Equal(t, contains(matchProfiles, profile.ID), profile.IsActualMatchActive())
+		Equal(t, contains(listsProfiles, profile.ID), profile.IsListsProfile())
+	}
+}
+
+func TestUpdateProfileFields(t *testing.T) {
+	testingDB := testingdb.NewDB()
+	activeUntil := time.Now().Add(time.Hour)
+	stored := Profile{
+		M:           db.NewM(),
+		Name:        "old",
+		ActiveUntil: &activeUntil,
+		MatchCount:  ProfileMatchCount{Total: 3},
+	}
+	err := testingDB.Insert(&stored)
+	NoError(t, err)
+
+	// A profile read before the match count was changed
+	edited := stored
+	edited.MatchCount = ProfileMatchCount{}
+	edited.Name = "new"
+	edited.ActiveUntil = nil
+
+	err = UpdateProfileFields(testingDB, &edited)
+	NoError(t, err)
+
+	result, err := GetProfile(testingDB, stored.ID)
+	NoError(t, err)
+	Equal(t, "new", result.Name)
+	Nil(t, result.ActiveUntil)
+	Equal(t, 3, result.MatchCount.Total)
+}
+
+func TestMigrations(t *testing.T) {
+	d := testProfilesSetupDB(t)
+
+	// The migrations should be applicable to the testing database
+	results, err := migrations.Run(d, Migrations, false)
+	NoError(t, err)
+	Len(t, results, len(Migrations))
+
+	profiles, err := GetActualMatchActiveProfiles(d)
+	NoError(t, err)
+	Len(t, profiles, 1)
+}
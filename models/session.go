@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Session is created when a dashboard user logs in
+//
+// A session has 2 tokens:
+// - A short lived signed access token that is send with every request
+// - A long lived refresh token that can be used once to obtain a new access and refresh token
+//
+// Only the hash of the refresh token is stored
+type Session struct {
+	db.M      `bson:",inline"`
+	UserID    primitive.ObjectID `json:"userId" bson:"userId"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt" description:"When the refresh token expires, every refresh extends this"`
+	Revoked   bool               `json:"revoked" bson:"revoked"`
+	IP        string             `json:"ip" bson:"ip"`
+	UserAgent string             `json:"userAgent" bson:"userAgent"`
+
+	RefreshTokenHash string `json:"-" bson:"refreshTokenHash"`
+	// PreviousRefreshTokenHash is used to detect reuse of an already used refresh token
+	// If that happens the refresh token was probably stolen and we revoke the session
+	PreviousRefreshTokenHash string `json:"-" bson:"previousRefreshTokenHash"`
+}
+
+// CollectionName returns the collection name of the Session
+func (*Session) CollectionName() string {
+	return "sessions"
+}
+
+// Indexes implements db.Entry
+func (*Session) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.M{"userId": 1}},
+		{Keys: bson.M{"refreshTokenHash": 1}},
+		{Keys: bson.M{"previousRefreshTokenHash": 1}},
+	}
+}
+
+// Active returns true if the session is not revoked and not expired
+func (s *Session) Active() bool {
+	return !s.Revoked && time.Now().Before(s.ExpiresAt)
+}
+
+// GetSession returns a session by id
+func GetSession(conn db.Connection, id primitive.ObjectID) (Session, error) {
+	session := Session{}
+	err := conn.FindOne(&session, bson.M{"_id": id})
+	return session, err
+}
+
+// GetUserSessions returns all sessions of a user that are not yet expired or revoked
+func GetUserSessions(conn db.Connection, userID primitive.ObjectID) ([]Session, error) {
+	sessions := []Session{}
+	err := conn.Find(&Session{}, &sessions, bson.M{
+		"userId":    userID,
+		"revoked":   false,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	})
+	return sessions, err
+}
+
+// RevokeUserSessions revokes all active sessions of a user
+func RevokeUserSessions(conn db.Connection, userID primitive.ObjectID) ([]Session, error) {
+	sessions, err := GetUserSessions(conn, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range sessions {
+		sessions[idx].Revoked = true
+		err = conn.UpdateByID(&sessions[idx])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sessions, nil
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/script-development/RT-CV/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Tenant is a customer of this RT-CV instance
+// API keys, profiles, on match hooks and dashboard users can belong to a tenant
+// Entries of a tenant can only be accessed by keys of the same tenant, keys without a tenant can access everything
+type Tenant struct {
+	db.M `bson:",inline"`
+	Name string `json:"name"`
+}
+
+// CollectionName returns the collection name of the Tenant
+func (*Tenant) CollectionName() string {
+	return "tenants"
+}
+
+// Indexes implements db.Entry
+func (*Tenant) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.M{"name": 1}, Options: options.Index().SetUnique(true)},
+	}
+}
+
+// Validate validates the tenant
+func (t *Tenant) Validate() error {
+	if t.Name == "" {
+		return errors.New("name must be set")
+	}
+	return nil
+}
+
+// GetTenants returns all tenants
+func GetTenants(conn db.Connection) ([]Tenant, error) {
+	tenants := []Tenant{}
+	err := conn.Find(&Tenant{}, &tenants, nil)
+	return tenants, err
+}
+
+// GetTenant returns a tenant by id
+func GetTenant(conn db.Connection, id primitive.ObjectID) (Tenant, error) {
+	tenant := Tenant{}
+	err := conn.FindOne(&tenant, bson.M{"_id": id})
+	return tenant, err
+}
+
+// CheckTenantExists returns an error if there is no tenant with the id
+func CheckTenantExists(conn db.Connection, id primitive.ObjectID) error {
+	_, err := GetTenant(conn, id)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("unknown tenant id %s", id.Hex())
+	}
+	return err
+}
+
+// CheckTenantIsEmpty returns an error if there are still entries that belong to the tenant
+func CheckTenantIsEmpty(conn db.Connection, id primitive.ObjectID) error {
+	conn = db.WithoutTenant(conn)
+	filter := bson.M{"tenantId": id}
+	for _, entry := range []db.TenantEntry{&APIKey{}, &Profile{}, &OnMatchHook{}, &DashboardUser{}} {
+		count, err := conn.Count(entry, filter)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("tenant still has %d %s, remove them first", count, entry.CollectionName())
+		}
+	}
+	return nil
+}
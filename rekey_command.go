@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db/mongo/backup"
+)
+
+const rekeyUsage = `Usage: rt-cv rekey [flags]
+
+Re-encrypts all backups in the backup storage that are not encrypted with the current backup key ($MONGODB_BACKUP_KEY).
+The old keys the backups are encrypted with should be in $MONGODB_BACKUP_OLD_KEYS as a comma separated list.
+Once all backups are re-encrypted the old keys can be removed.
+
+Flags:
+`
+
+const rekeyExitCodes = `
+Exit codes:
+  0  all backups are encrypted with the current key, or the dry run succeeded
+  1  re-encrypting a backup failed
+  2  invalid arguments or backup keys
+  3  a backup cannot be decrypted with the backup keys
+`
+
+// rekeyCommand runs the rekey subcommand and returns the exit code
+func rekeyCommand(args []string) int {
+	flags := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	dryRun := flags.Bool("dryRun", false, "only list the backups that would be re-encrypted")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), rekeyUsage)
+		flags.PrintDefaults()
+		fmt.Fprint(flags.Output(), rekeyExitCodes)
+	}
+
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	loadEnv()
+
+	options := backup.StartScheduleOptionsFromEnv()
+	keyring, err := options.Keyring()
+	if err != nil {
+		log.WithError(err).Error("Invalid backup keys")
+		return exitUsage
+	}
+	storage, err := options.CreateStorage()
+	if err != nil {
+		log.WithError(err).Error("Unable to open the backup storage")
+		return exitFailed
+	}
+
+	results, err := backup.Rekey(storage, keyring, *dryRun)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tKEY ID\tRE-ENCRYPTED")
+	for _, result := range results {
+		keyID := result.KeyID
+		if keyID == "" {
+			keyID = "-"
+		}
+		rekeyed := "no"
+		if result.Rekeyed && *dryRun {
+			rekeyed = "pending"
+		} else if result.Rekeyed {
+			rekeyed = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.Name, keyID, rekeyed)
+	}
+	w.Flush()
+
+	if err != nil {
+		log.WithError(err).Error("Re-encrypting the backups failed")
+		return exitCodeOf(err)
+	}
+	fmt.Printf("\nCurrent key id: %s\n", keyring.Current().ID)
+	return exitOK
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/db"
+	"github.com/script-development/RT-CV/db/mongo/backup"
+	"github.com/script-development/RT-CV/helpers/crypto"
+)
+
+// Exit codes of the subcommands
+const (
+	exitOK            = 0
+	exitFailed        = 1
+	exitUsage         = 2
+	exitInvalidBackup = 3
+)
+
+const restoreUsage = `Usage: rt-cv restore [flags] <backup name>
+
+Restores a backup from the backup storage into the database.
+The database and backup storage are configured using the same env variables as the server.
+When using the file database (FILE_DB_PATH) the server must be stopped first, the command refuses to run while the server uses the database.
+
+Flags:
+`
+
+const restoreExitCodes = `
+Exit codes:
+  0  the backup was restored, or the dry run succeeded
+  1  restoring the backup failed
+  2  invalid arguments or a selected collection is not in the backup
+  3  the backup does not exist or cannot be read with the backup keys
+`
+
+// exitCodeOf returns the exit code for an error returned by the backup package
+func exitCodeOf(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, backup.ErrInvalidBackup), errors.Is(err, crypto.ErrUnknownKey):
+		return exitInvalidBackup
+	case errors.Is(err, backup.ErrUnknownCollection):
+		return exitUsage
+	default:
+		return exitFailed
+	}
+}
+
+// restoreCommand runs the restore subcommand and returns the exit code
+func restoreCommand(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	collections := flags.String("collections", "", "comma separated list of collections to restore, by default all collections are restored")
+	dryRun := flags.Bool("dryRun", false, "only report the per collection changes without modifying the database")
+	target := flags.String("target", "", "restore into another database for inspection, for MongoDB a database name and for the file database a directory")
+	list := flags.Bool("list", false, "list the backups in the backup storage and exit")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), restoreUsage)
+		flags.PrintDefaults()
+		fmt.Fprint(flags.Output(), restoreExitCodes)
+	}
+
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+
+	options := backup.RestoreOptions{
+		DryRun: *dryRun,
+		Target: *target,
+	}
+	if *collections != "" {
+		for _, collection := range strings.Split(*collections, ",") {
+			collection = strings.TrimSpace(collection)
+			if collection != "" {
+				options.Collections = append(options.Collections, collection)
+			}
+		}
+	}
+
+	if !*list && flags.NArg() != 1 {
+		fmt.Fprintln(flags.Output(), "expected exactly one backup name")
+		flags.Usage()
+		return exitUsage
+	}
+
+	loadEnv()
+
+	if *list {
+		return listBackups(os.Stdout)
+	}
+
+	dbConn, useTestingDB := connectToDB()
+	if useTestingDB {
+		log.Error("Restoring a backup is not supported in the testing database")
+		return exitUsage
+	}
+
+	return runRestore(dbConn, flags.Arg(0), options)
+}
+
+// runRestore restores a backup, prints the report and returns the exit code
+func runRestore(dbConn db.Connection, backupName string, options backup.RestoreOptions) int {
+	report, err := backup.Restore(dbConn, backupName, backup.StartScheduleOptionsFromEnv(), options)
+	if err != nil {
+		log.WithError(err).Error("Restoring the backup failed")
+		return exitCodeOf(err)
+	}
+
+	printRestoreReport(os.Stdout, report)
+	return exitOK
+}
+
+func printRestoreReport(out io.Writer, report backup.RestoreReport) {
+	if report.DryRun {
+		fmt.Fprintf(out, "Dry run of restoring %s, the database was not modified\n\n", report.Backup)
+	} else {
+		fmt.Fprintf(out, "Restored %s\n\n", report.Backup)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tBACKUP\tDATABASE\tADDED\tCHANGED\tREMOVED\tUNCHANGED")
+	for _, c := range report.Collections {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", c.Name, c.Documents, c.LiveDocuments, c.Added, c.Changed, c.Removed, c.Unchanged)
+	}
+	w.Flush()
+}
+
+// listBackups prints the backups in the backup storage and returns the exit code
+func listBackups(out io.Writer) int {
+	storage, err := backup.StartScheduleOptionsFromEnv().CreateStorage()
+	if err != nil {
+		log.WithError(err).Error("Unable to open the backup storage")
+		return exitFailed
+	}
+	backups, err := storage.List()
+	if err != nil {
+		log.WithError(err).Error("Unable to list the backups")
+		return exitFailed
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tLAST MODIFIED")
+	for _, b := range backups {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", b.Name, b.Size, b.LastModified.Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+	return exitOK
+}
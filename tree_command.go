@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/script-development/RT-CV/models/matcher"
+)
+
+const treeUsage = `Usage: rt-cv tree check [flags]
+
+Checks the matcher tree for references to branches that do not exist, cycles, orphans and duplicate titles.
+The database is configured using the same env variables as the server.
+When using the file database (FILE_DB_PATH) the server must be stopped first, the command refuses to run while the server uses the database.
+
+Flags:
+`
+
+const treeExitCodes = `
+Exit codes:
+  0  no problems found or all problems are repaired
+  1  the check or repair failed
+  2  invalid arguments
+  4  problems found, use -repair to repair them
+`
+
+// exitTreeProblems is returned when the matcher tree check found problems that are not repaired
+const exitTreeProblems = 4
+
+// treeCommand runs the tree subcommand and returns the exit code
+func treeCommand(args []string) int {
+	flags := flag.NewFlagSet("tree", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "repair the found problems, duplicate branches are merged into the first branch with the title")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), treeUsage)
+		flags.PrintDefaults()
+		fmt.Fprint(flags.Output(), treeExitCodes)
+	}
+
+	if len(args) == 0 || args[0] != "check" {
+		flags.Usage()
+		return exitUsage
+	}
+	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	loadEnv()
+
+	dbConn, useTestingDB := connectToDB()
+	if useTestingDB {
+		log.Error("Checking the matcher tree is not supported in the testing database")
+		return exitUsage
+	}
+
+	report, err := matcher.CheckIntegrity(dbConn, *repair)
+	if err != nil {
+		log.WithError(err).Error("Checking the matcher tree failed")
+		return exitFailed
+	}
+
+	printIntegrityReport(os.Stdout, report)
+	if !report.Ok() && !report.Repaired {
+		return exitTreeProblems
+	}
+	return exitOK
+}
+
+func printIntegrityReport(out io.Writer, report matcher.IntegrityReport) {
+	if report.Ok() {
+		fmt.Fprintln(out, "No problems found")
+		return
+	}
+
+	action := func(repairAction string) string {
+		if report.Repaired {
+			return " (" + repairAction + ")"
+		}
+		return ""
+	}
+
+	for _, ref := range report.DanglingReferences {
+		fmt.Fprintf(out, "dangling reference: %s references the missing branch %s%s\n", ref.BranchID.Hex(), ref.SubBranchID.Hex(), action("removed"))
+	}
+	for _, ref := range report.Cycles {
+		fmt.Fprintf(out, "cycle: %s references %s%s\n", ref.BranchID.Hex(), ref.SubBranchID.Hex(), action("removed"))
+	}
+	for _, id := range report.Orphans {
+		fmt.Fprintf(out, "orphan: %s can't be reached from the root of the tree%s\n", id.Hex(), action("cycle removed"))
+	}
+	for _, duplicate := range report.DuplicateTitles {
+		parent := "the root"
+		if duplicate.ParentID != nil {
+			parent = duplicate.ParentID.Hex()
+		}
+		ids := make([]string, len(duplicate.BranchIDs))
+		for idx, id := range duplicate.BranchIDs {
+			ids[idx] = id.Hex()
+		}
+		fmt.Fprintf(out, "duplicate title: %q is used multiple times by the sub branches of %s: %s%s\n", duplicate.Title, parent, strings.Join(ids, ", "), action("merged"))
+	}
+}